@@ -1,66 +1,178 @@
 package main
 
 import (
+	"app/internal/authz"
 	"context"
+	"errors"
 	"fmt"
+	"html"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
 )
 
-// Handlers for accessing documents
-// Parameters will mention the
-// Based on the identity; extract from session cookie ..
-
-func demoHandler(w http.ResponseWriter, r *http.Request) {
-	// Show login screen or if logged in .. then documents able to be accessed ..
-	// Ask for owner approval .. pending ..
-	// See public doc ..
-	c, err := r.Cookie("ID")
+// renderPendingApprovers shows what is waiting in the user's inbox in the
+// tenant; plus inboxes of anyone the user is covering for ..
+func renderPendingApprovers(ctx context.Context, tenant, user, csrf string) string {
+	result := "<h3><strong>PENDING APPROVERS</strong></h3>"
+	inbox, err := gw.PendingApprovals(ctx, tenant, user)
 	if err != nil {
-		if err == http.ErrNoCookie {
-			http.Redirect(w, r, "/demo/login/", http.StatusFound)
-			return
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+		fmt.Println("INBOX-ERR: ", err)
+		return result + "<div>Inbox unavailable</div>"
+	}
+	approvers := []string{user}
+	covering := make([]string, 0, len(inbox.Covering))
+	for approver := range inbox.Covering {
+		covering = append(covering, approver)
+	}
+	sort.Strings(covering)
+	approvers = append(approvers, covering...)
+	for _, approver := range approvers {
+		st := inbox
+		if approver != user {
+			st, err = gw.PendingApprovals(ctx, tenant, approver)
+			if err != nil {
+				fmt.Println("INBOX-ERR: ", err)
+				continue
+			}
+			// Delegation may have ended since ..
+			if st.DelegateTo != user || time.Now().After(st.DelegateUntil) {
+				continue
+			}
+			result += "<h4>Covering for " + html.EscapeString(approver) + "</h4>"
+		}
+		result += "<div>"
+		if len(st.Items) == 0 {
+			result += "Nothing waiting<br/>"
+		}
+		for _, item := range st.Items {
+			result += "<strong>" + html.EscapeString(item.Requester) + "</strong> wants " +
+				html.EscapeString(item.DocID) + " - " + html.EscapeString(item.Reason) + " " +
+				postButton("/demo/inbox/", url.Values{"action": {authz.InboxApprove}, "approver": {approver}, "item": {item.ID}}, "Approve", csrf) + " " +
+				postButton("/demo/inbox/", url.Values{"action": {authz.InboxReject}, "approver": {approver}, "item": {item.ID}}, "Reject", csrf) + "<br/>"
+		}
+		if len(st.Items) > 0 {
+			result += postButton("/demo/inbox/", url.Values{"action": {authz.InboxApproveAll}, "approver": {approver}}, "Approve All", csrf) + " " +
+				postButton("/demo/inbox/", url.Values{"action": {authz.InboxRejectAll}, "approver": {approver}}, "Reject All", csrf) + "<br/>"
 		}
+		result += "</div>"
 	}
-	//fmt.Println("ID:", c.Value)
-	// List policies related to user??
-	fmt.Fprintf(w, "Goodbye Cruel World!! See ya %s", c.Value)
-
-	// Document Lists
-
-	// Pending Approvers ..
+	if inbox.DelegateTo != "" {
+		result += "<div>Delegated to " + html.EscapeString(inbox.DelegateTo) +
+			" until " + inbox.DelegateUntil.Format(time.RFC822) + " " +
+			postButton("/demo/inbox/", url.Values{"action": {authz.InboxUndelegate}}, "End", csrf) + "</div>"
+	}
+	return result
+}
 
+// inboxHandler acts on the approver inbox; WorkflowID: approver-<orgID>/<username>
+func inboxHandler(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	sess := currentSession(r)
+	// Acting on own inbox unless covering for someone ..
+	approver := sess.UserID
+	if r.FormValue("approver") != "" {
+		approver = r.FormValue("approver")
+	}
+	cmd := authz.InboxCommand{
+		Actor:  sess.UserID,
+		ItemID: r.FormValue("item"),
+		Reason: r.FormValue("reason"),
+	}
+	switch r.FormValue("action") {
+	case authz.InboxApprove, authz.InboxReject, authz.InboxApproveAll, authz.InboxRejectAll, authz.InboxUndelegate:
+		cmd.Op = r.FormValue("action")
+	case authz.InboxDelegate:
+		// action=delegate&to=bob&hours=48
+		hours, herr := strconv.Atoi(r.FormValue("hours"))
+		if herr != nil || hours <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		cmd.Op = authz.InboxDelegate
+		cmd.DelegateTo = r.FormValue("to")
+		cmd.Until = time.Now().Add(time.Duration(hours) * time.Hour)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	serr := gw.SignalInbox(r.Context(), callerTenant(r), approver, cmd)
+	if serr != nil {
+		fmt.Println("INBOX-ERR: ", serr)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/demo/", http.StatusFound)
 	return
 }
 
+// documentHandler is the page side of the document API; same checks ..
+// action=view&doc=secret/secretz.doc
+// action=approve|reject&doc=..&user=mleow[&minutes=60] (POST, owner only)
 func documentHandler(w http.ResponseWriter, r *http.Request) {
-	// Get our Demo Workflow ..
-	wfr := c.GetWorkflow(context.Background(), orgID, "")
-	wfr.GetRunID()
-
-	// WorkflowID: <username>-approver
-	// WorkflowID: <docID>
+	// Org workflow is brought up on demand by the gateway ..
+	// WorkflowID: approver-<orgID>/<username>
+	// WorkflowID: doc-<orgID>/<docID> .. see authz.DocumentWorkflow
+	sess := currentSession(r)
+	c := callerFrom(r)
 
 	// Check if action is happening ... after done redirect back ..
-	q := r.URL.Query()
-	if q.Has("action") {
-
-		switch q.Get("action") {
-		case "approve":
-			// Redirect back it itself?
-
-		case "reject":
-			// Redirect back to top level??
+	if action := r.FormValue("action"); action != "" {
+		doc := r.FormValue("doc")
+		switch action {
+		case "approve", "reject":
+			if !requirePost(w, r) {
+				return
+			}
+			op := authz.OpApprove
+			if action == "reject" {
+				op = authz.OpReject
+			}
+			var d time.Duration
+			if minutes, merr := strconv.Atoi(r.FormValue("minutes")); merr == nil && minutes > 0 {
+				d = time.Duration(minutes) * time.Minute
+			}
+			if doc == "" || r.FormValue("user") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			err := decideDocumentAccess(r.Context(), c, doc, op, r.FormValue("user"), d, r.FormValue("reason"))
+			if err != nil {
+				http.Error(w, err.Error(), docErrorStatus(err))
+				return
+			}
+			http.Redirect(w, r, "/demo/document/", http.StatusFound)
+			return
 
 		case "view":
-		// If no document .. BadRequest
-		// Check if got viewer access or not ..
-		// if yes, show secrets .. else naughty! can for access
+			if doc == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, d, err := viewDocument(r.Context(), c, doc)
+			if errors.Is(err, errDocStepUp) {
+				// Allowed with a step-up; go get one ..
+				http.Redirect(w, r, stepUpURL(r), http.StatusFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), docErrorStatus(err))
+				return
+			}
+			fmt.Fprint(w, "<html><h3><strong>"+html.EscapeString(doc)+"</strong></h3><div>"+
+				html.EscapeString(d.Content)+"</div><small>v"+strconv.Itoa(d.Version)+" by "+
+				html.EscapeString(d.Author)+"</small></html>")
+			return
 
 		case "kil":
-			err := c.SignalWorkflow(context.Background(), orgID, "", "terminateSignal", true)
+			if !requirePost(w, r) {
+				return
+			}
+			err := gw.StopOrg(context.Background(), orgID)
 			if err != nil {
 				fmt.Println("ERR: ", err)
 				w.WriteHeader(http.StatusInternalServerError)
@@ -71,9 +183,43 @@ func documentHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	// If owner; have the grant link ..
 
-	// Show Workflow current status under care??
-	fmt.Fprintf(w, "Nothing to see here .. docs")
+	// What can be seen; and what is waiting on the owner ..
+	docs, err := as.ListDocuments(r.Context(), c.ID, "viewer")
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	result := "<html><h3><strong>DOCUMENTS</strong></h3><div>"
+	if len(docs) == 0 {
+		result += "Nothing to see here .. docs<br/>"
+	}
+	sort.Strings(docs)
+	for _, doc := range docs {
+		result += `<a href="/demo/document/?action=view&doc=` + url.QueryEscape(doc) + `">` + html.EscapeString(doc) + "</a><br/>"
+		st, serr := loadDocument(r.Context(), callerTenant(r), doc)
+		if serr != nil || st.Doc.Owner != sess.UserID {
+			continue
+		}
+		for _, user := range sortedPending(st.Pending) {
+			result += "&nbsp; <strong>" + html.EscapeString(user) + "</strong> wants it - " + html.EscapeString(st.Pending[user]) + " " +
+				postButton("/demo/document/", url.Values{"action": {"approve"}, "doc": {doc}, "user": {user}}, "Approve", sess.CSRFToken) + " " +
+				postButton("/demo/document/", url.Values{"action": {"reject"}, "doc": {doc}, "user": {user}}, "Reject", sess.CSRFToken) + "<br/>"
+		}
+		if !st.Archived {
+			result += renderShareLinks(r.Context(), doc, sess.CSRFToken)
+		}
+	}
+	result += "</div></html>"
+	fmt.Fprint(w, result)
 	return
 }
+
+func sortedPending(pending map[string]string) []string {
+	users := make([]string, 0, len(pending))
+	for user := range pending {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	return users
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"app/internal/authz"
+	"app/internal/identity"
+	"context"
+	"errors"
+	"fmt"
+	"go.temporal.io/api/serviceerror"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Admin console; tenant admins only. Pages are html/template + htmx like the
+// batch executor; every panel is a partial the tabs swap in ..
+// GET  /demo/admin/                                   -> the console
+// GET  /demo/admin/tuples?user=&relation=&object=&token=
+// POST /demo/admin/tuples action=add|remove&user=..&relation=..&object=..
+// GET  /demo/admin/models
+// GET  /demo/admin/grants
+// POST /demo/admin/grants doc=..&user=..               -> ends a temporary grant
+// GET  /demo/admin/matrix?relation=viewer
+
+const (
+	adminPageSize = 25
+	// Matrix is members x documents checks; keep it to what one page can ask ..
+	adminMatrixMax = 20
+)
+
+var (
+	errNotTenantAdmin = errors.New("tenant admins only")
+	errOutsideTenant  = errors.New("not in your tenant")
+	errTenancyTuple   = errors.New("org roles + document tenancy are changed from the org page and the document itself")
+	// Owner, classification + policy tuples follow the document's own state ..
+	errEntityTuple = errors.New("only viewer and editor shares can be changed on a document")
+)
+
+// adminRelations are what the matrix can show ..
+var adminRelations = []string{"viewer", "editor", "owner"}
+
+// callerTenant is the tenant the caller is signed in to ..
+func callerTenant(r *http.Request) string {
+	if p, ok := identity.PrincipalFrom(r.Context()); ok && p.TenantID != "" {
+		return p.TenantID
+	}
+	return orgID
+}
+
+// requireTenantAdmin is requireSession + admin on the caller's tenant; no
+// answer from OpenFGA is no ..
+func requireTenantAdmin(h http.HandlerFunc) http.Handler {
+	return requireSession(func(w http.ResponseWriter, r *http.Request) {
+		if adminOf(w, r, callerTenant(r)) {
+			h(w, r)
+		}
+	})
+}
+
+// adminOf is whether the session is an admin of tenant; if not the answer
+// has been written ..
+func adminOf(w http.ResponseWriter, r *http.Request, tenant string) bool {
+	sess := currentSession(r)
+	if sess.Impersonating() {
+		// Support acting as someone never gets their admin ..
+		http.Error(w, errNotTenantAdmin.Error(), http.StatusForbidden)
+		return false
+	}
+	ok, err := as.CheckOrg(r.Context(), sess.UserID, "admin", tenant)
+	if err != nil {
+		fmt.Println("ADMIN-ERR: ", err)
+		http.Error(w, "authorization unavailable", http.StatusBadGateway)
+		return false
+	}
+	if !ok {
+		http.Error(w, errNotTenantAdmin.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// tenantScope answers whether objects belong to the tenant; documents are
+// looked up once per request ..
+type tenantScope struct {
+	tenant string
+	docs   map[string]bool
+}
+
+func newTenantScope(tenant string) *tenantScope {
+	return &tenantScope{tenant: tenant, docs: map[string]bool{}}
+}
+
+// owns is whether object is the tenant's; anything else (other tenants,
+// links, types we do not know) stays hidden ..
+func (s *tenantScope) owns(object string) (bool, error) {
+	typ, id, _ := strings.Cut(object, ":")
+	switch typ {
+	case "organization":
+		return id == s.tenant, nil
+	case "group":
+		return strings.HasPrefix(id, s.tenant+"/"), nil
+	case "document":
+		if ok, seen := s.docs[id]; seen {
+			return ok, nil
+		}
+		tuples, _, err := as.ReadTuples("", "org", object, "", 10)
+		if err != nil {
+			return false, err
+		}
+		ok := false
+		for _, t := range tuples {
+			ok = ok || t.User == authz.OrgObject(s.tenant)
+		}
+		s.docs[id] = ok
+		return ok, nil
+	}
+	return false, nil
+}
+
+// tenantDocuments is every document with the tenant's org tuple ..
+func tenantDocuments(tenant string) ([]string, error) {
+	var docs []string
+	token := ""
+	for {
+		tuples, next, err := as.ReadTuples(authz.OrgObject(tenant), "org", "document:", token, 100)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tuples {
+			docs = append(docs, strings.TrimPrefix(t.Object, "document:"))
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	sort.Strings(docs)
+	return docs, nil
+}
+
+// auditAdmin records a console change; the tuples themselves have no history ..
+func auditAdmin(ctx context.Context, tenant, actor, action, object string, detail map[string]string) {
+	err := auditLog.Record(ctx, authz.AuditEvent{
+		OrgID:  tenant,
+		Actor:  actor,
+		Action: action,
+		Object: object,
+		Detail: detail,
+	})
+	if err != nil {
+		fmt.Println("AUDIT-ERR: ", err)
+	}
+}
+
+// adminHandler is the console; the panels load themselves ..
+func adminHandler(w http.ResponseWriter, r *http.Request) {
+	sess := currentSession(r)
+	render(w, "admin.html", map[string]interface{}{
+		"Tenant":    callerTenant(r),
+		"User":      sess.UserID,
+		"CSRFField": identity.CSRFField,
+		"CSRFToken": sess.CSRFToken,
+	})
+}
+
+type tupleFilter struct {
+	User, Relation, Object string
+}
+
+type tuplesPanel struct {
+	Filter tupleFilter
+	Tuples []authz.Tuple
+	// Next is the continuation token; empty on the last page ..
+	Next    string
+	Message string
+	Error   string
+}
+
+// adminTuplesHandler browses tuples; admins add + remove them too ..
+func adminTuplesHandler(w http.ResponseWriter, r *http.Request) {
+	sess := currentSession(r)
+	tenant := callerTenant(r)
+	scope := newTenantScope(tenant)
+	panel := tuplesPanel{Filter: tupleFilter{
+		User:     strings.TrimSpace(r.FormValue("user")),
+		Relation: strings.TrimSpace(r.FormValue("relation")),
+		Object:   strings.TrimSpace(r.FormValue("object")),
+	}}
+
+	if r.Method == http.MethodPost {
+		t := authz.Tuple{User: panel.Filter.User, Relation: panel.Filter.Relation, Object: panel.Filter.Object}
+		if err := changeTuple(r.Context(), scope, sess.UserID, r.FormValue("action"), t); err != nil {
+			panel.Error = err.Error()
+		} else {
+			panel.Message = r.FormValue("action") + " " + t.User + " " + t.Relation + " " + t.Object
+		}
+	} else if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	tuples, next, err := as.ReadTuples(panel.Filter.User, panel.Filter.Relation, panel.Filter.Object, r.FormValue("token"), adminPageSize)
+	if err != nil {
+		panel.Error = "tuples unavailable: " + err.Error()
+	}
+	panel.Next = next
+	for _, t := range tuples {
+		ok, err := scope.owns(t.Object)
+		if err != nil {
+			panel.Error = "tuples unavailable: " + err.Error()
+			panel.Tuples = nil
+			break
+		}
+		if ok {
+			panel.Tuples = append(panel.Tuples, t)
+		}
+	}
+	render(w, "admin_tuples.html", panel)
+}
+
+// changeTuple adds or removes one tuple on a tenant object; documents go
+// through their entity so its grants + history stay right ..
+func changeTuple(ctx context.Context, scope *tenantScope, actor, action string, t authz.Tuple) error {
+	if t.User == "" || t.Relation == "" || t.Object == "" {
+		return errors.New("user, relation and object are all needed")
+	}
+	if strings.HasPrefix(t.Object, "organization:") || t.Relation == "org" {
+		return errTenancyTuple
+	}
+	ok, err := scope.owns(t.Object)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s: %w", t.Object, errOutsideTenant)
+	}
+	if doc, isDoc := strings.CutPrefix(t.Object, "document:"); isDoc {
+		if err := changeDocumentShare(ctx, scope.tenant, actor, action, doc, t); err != nil {
+			return err
+		}
+		auditAdmin(ctx, scope.tenant, actor, "tuple."+action, t.Object, map[string]string{"user": t.User, "relation": t.Relation})
+		return nil
+	}
+	switch action {
+	case "add":
+		err = as.WriteTuples(ctx, []authz.Tuple{t})
+	case "remove":
+		err = as.DeleteTuples([]authz.Tuple{t})
+	default:
+		return errors.New("unknown action " + action)
+	}
+	if err != nil {
+		return err
+	}
+	auditAdmin(ctx, scope.tenant, actor, "tuple."+action, t.Object, map[string]string{"user": t.User, "relation": t.Relation})
+	return nil
+}
+
+// changeDocumentShare is the console's add / remove on a document as an
+// admin share or revoke; answers with what the entity made of it ..
+func changeDocumentShare(ctx context.Context, tenant, actor, action, doc string, t authz.Tuple) error {
+	if t.Relation != "viewer" && t.Relation != "editor" {
+		return errEntityTuple
+	}
+	var op string
+	switch action {
+	case "add":
+		op = authz.OpAdminShare
+	case "remove":
+		op = authz.OpAdminRevoke
+	default:
+		return errors.New("unknown action " + action)
+	}
+	event, err := gw.CommandDocument(ctx, tenant, doc, authz.DocumentCommand{
+		Op:       op,
+		Actor:    actor,
+		User:     strings.TrimPrefix(t.User, "user:"),
+		Relation: t.Relation,
+		Reason:   "admin console",
+	})
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) {
+		return fmt.Errorf("%s: %w", doc, errDocNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	if !event.Accepted {
+		return errors.New(event.Detail)
+	}
+	return nil
+}
+
+// adminModelsHandler is every model version, newest (the one in use) first ..
+func adminModelsHandler(w http.ResponseWriter, r *http.Request) {
+	models, err := as.AuthorizationModels(r.Context())
+	data := map[string]interface{}{"Models": models}
+	if err != nil {
+		data["Error"] = "models unavailable: " + err.Error()
+	}
+	render(w, "admin_models.html", data)
+}
+
+type tempGrant struct {
+	Doc, User string
+	Until     time.Time
+}
+
+// adminGrantsHandler lists the tenant's live temporary grants; a POST ends
+// one through the document entity ..
+func adminGrantsHandler(w http.ResponseWriter, r *http.Request) {
+	sess := currentSession(r)
+	tenant := callerTenant(r)
+	data := map[string]interface{}{}
+
+	if r.Method == http.MethodPost {
+		if err := adminRevokeGrant(r.Context(), tenant, sess.UserID, r.FormValue("doc"), r.FormValue("user")); err != nil {
+			data["Error"] = err.Error()
+		} else {
+			// The entity takes commands in order; it may still show for a moment ..
+			data["Message"] = "Revoke sent for " + r.FormValue("user") + " on " + r.FormValue("doc")
+		}
+	} else if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	docs, err := tenantDocuments(tenant)
+	if err != nil {
+		data["Error"] = "documents unavailable: " + err.Error()
+	}
+	var grants []tempGrant
+	now := time.Now()
+	for _, doc := range docs {
+		st, err := loadDocument(r.Context(), tenant, doc)
+		if err != nil {
+			continue
+		}
+		for user, until := range st.TempGrants {
+			if until.After(now) {
+				grants = append(grants, tempGrant{Doc: doc, User: user, Until: until})
+			}
+		}
+	}
+	sort.Slice(grants, func(i, j int) bool { return grants[i].Until.Before(grants[j].Until) })
+	data["Grants"] = grants
+	render(w, "admin_grants.html", data)
+}
+
+// adminRevokeGrant sends the entity an admin revoke; only for a grant that
+// is there and temporary ..
+func adminRevokeGrant(ctx context.Context, tenant, actor, doc, user string) error {
+	if doc == "" || user == "" {
+		return errors.New("doc and user are needed")
+	}
+	ok, err := newTenantScope(tenant).owns("document:" + doc)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s: %w", doc, errOutsideTenant)
+	}
+	st, err := loadDocument(ctx, tenant, doc)
+	if err != nil {
+		return err
+	}
+	if _, ok := st.TempGrants[user]; !ok {
+		return errors.New(user + " has no temporary grant on " + doc)
+	}
+	return gw.SignalDocument(ctx, st.OrgID, doc, authz.DocumentCommand{
+		Op:     authz.OpAdminRevoke,
+		Actor:  actor,
+		User:   user,
+		Reason: "admin console",
+	})
+}
+
+// adminMFAHandler resets a member's MFA after an admin checked who is
+// asking; never their own ..
+func adminMFAHandler(w http.ResponseWriter, r *http.Request) {
+	data := map[string]interface{}{}
+	if r.Method == http.MethodPost {
+		sess := currentSession(r)
+		user := strings.TrimSpace(r.FormValue("user"))
+		if err := adminResetMFA(r.Context(), callerTenant(r), sess.UserID, user, strings.TrimSpace(r.FormValue("reason"))); err != nil {
+			data["Error"] = err.Error()
+		} else {
+			data["Message"] = "MFA reset for " + user + "; they enroll again on their next step-up"
+		}
+	} else if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	render(w, "admin_mfa.html", data)
+}
+
+func adminResetMFA(ctx context.Context, tenant, actor, user, reason string) error {
+	if user == "" || reason == "" {
+		return errors.New("user and reason are needed")
+	}
+	if user == actor {
+		return errors.New("another admin has to reset your own MFA")
+	}
+	role, err := orgs.Role(ctx, tenant, user)
+	if err != nil {
+		return err
+	}
+	if role == "" {
+		return fmt.Errorf("%s: %w", user, errOutsideTenant)
+	}
+	enrolled, err := mfa.Enrolled(ctx, user)
+	if err != nil {
+		return err
+	}
+	if !enrolled {
+		return fmt.Errorf("%s: %w", user, identity.ErrMFANotEnrolled)
+	}
+	if err := mfa.Reset(ctx, user); err != nil {
+		return err
+	}
+	auditAdmin(ctx, tenant, actor, "mfa.reset", authz.Subject(user), map[string]string{"reason": reason})
+	return nil
+}
+
+type matrixRow struct {
+	User  string
+	Cells []string
+}
+
+// adminMatrixHandler is who of the members has relation on which document ..
+func adminMatrixHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := callerTenant(r)
+	relation := r.FormValue("relation")
+	if relation == "" {
+		relation = "viewer"
+	}
+	data := map[string]interface{}{"Relation": relation, "Relations": adminRelations}
+	known := false
+	for _, rel := range adminRelations {
+		known = known || rel == relation
+	}
+	if !known {
+		data["Error"] = "relation must be one of " + strings.Join(adminRelations, ", ")
+		render(w, "admin_matrix.html", data)
+		return
+	}
+
+	members, err := orgs.Members(r.Context(), tenant)
+	if err != nil {
+		data["Error"] = "members unavailable: " + err.Error()
+		render(w, "admin_matrix.html", data)
+		return
+	}
+	docs, err := tenantDocuments(tenant)
+	if err != nil {
+		data["Error"] = "documents unavailable: " + err.Error()
+		render(w, "admin_matrix.html", data)
+		return
+	}
+	if len(members) > adminMatrixMax || len(docs) > adminMatrixMax {
+		data["Truncated"] = true
+		members = members[:min(len(members), adminMatrixMax)]
+		docs = docs[:min(len(docs), adminMatrixMax)]
+	}
+	var rows []matrixRow
+	for _, m := range members {
+		row := matrixRow{User: m.User}
+		for _, doc := range docs {
+			ok, err := as.Check(r.Context(), m.User, relation, doc)
+			switch {
+			case err != nil:
+				row.Cells = append(row.Cells, "?")
+			case ok:
+				row.Cells = append(row.Cells, "YES")
+			default:
+				row.Cells = append(row.Cells, "NO")
+			}
+		}
+		rows = append(rows, row)
+	}
+	data["Docs"] = docs
+	data["Rows"] = rows
+	render(w, "admin_matrix.html", data)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"app/internal/authzrpc/authzv1"
+	"app/internal/identity"
+	"app/internal/org"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Handlers for tenant API keys + the machine facing API they unlock ..
+
+// Old key keeps working this long after a rotate ..
+const apiKeyRotateGrace = 24 * time.Hour
+
+var apiKeyScopes = []string{identity.ScopeAuthzCheck, identity.ScopeAuthzGrant, identity.ScopeBatchExecute, identity.ScopeSCIMProvision,
+	identity.ScopeDocumentsRead, identity.ScopeDocumentsWrite}
+
+// apiKeyScopeRoles is the org relation whoever issues (or rotates) a key
+// must hold for each scope; a key never does more than its issuer could ..
+var apiKeyScopeRoles = map[string]string{
+	identity.ScopeAuthzCheck:     org.RoleMember,
+	identity.ScopeDocumentsRead:  org.RoleMember,
+	identity.ScopeDocumentsWrite: org.RoleMember,
+	identity.ScopeAuthzGrant:     org.RoleAdmin,
+	identity.ScopeBatchExecute:   org.RoleAdmin,
+	// Provisioning makes and removes members + admins ..
+	identity.ScopeSCIMProvision: org.RoleOwner,
+}
+
+var errScopeNotHeld = errors.New("scope not held by issuer")
+
+// issuerHolds is nil if the caller may hand out every one of scopes in the
+// tenant; unknown scopes are refused rather than dropped ..
+func issuerHolds(r *http.Request, tenant string, scopes []string) error {
+	p, _ := identity.PrincipalFrom(r.Context())
+	for _, s := range scopes {
+		role, ok := apiKeyScopeRoles[s]
+		if !ok {
+			return fmt.Errorf("unknown scope %q", s)
+		}
+		if !p.HasScope(s) {
+			return fmt.Errorf("%w: %s", errScopeNotHeld, s)
+		}
+		held, err := as.CheckOrg(r.Context(), p.ID, role, tenant)
+		if err != nil {
+			return err
+		}
+		if !held {
+			return fmt.Errorf("%w: %s needs %s", errScopeNotHeld, s, role)
+		}
+	}
+	return nil
+}
+
+// apiKeysHandler lists, issues, rotates and revokes the tenant's keys;
+// tenant admins only ..
+func apiKeysHandler(w http.ResponseWriter, r *http.Request) {
+	sess := currentSession(r)
+	tenant := callerTenant(r)
+	result := "<html><h3><strong>API KEYS " + html.EscapeString(tenant) + "</strong></h3>"
+
+	if action := r.FormValue("action"); action != "" {
+		if !requirePost(w, r) {
+			return
+		}
+		var token string
+		var key identity.APIKey
+		var err error
+		switch action {
+		case "issue":
+			scopes := r.PostForm["scope"]
+			if err = issuerHolds(r, tenant, scopes); err == nil {
+				token, key, err = apiKeys.Issue(r.Context(), tenant, r.FormValue("name"), sess.UserID, scopes)
+			}
+		case "rotate":
+			// The new token is the caller's to copy; they must hold what it carries ..
+			var old identity.APIKey
+			if old, err = apiKeys.Get(r.Context(), tenant, r.FormValue("id")); err == nil {
+				err = issuerHolds(r, tenant, old.Scopes)
+			}
+			if err == nil {
+				// Old key keeps its membership; it stops authenticating after the grace ..
+				token, key, err = apiKeys.Rotate(r.Context(), tenant, r.FormValue("id"), sess.UserID, apiKeyRotateGrace)
+			}
+		case "revoke":
+			err = apiKeys.Revoke(r.Context(), tenant, r.FormValue("id"))
+			if err == nil {
+				if rerr := as.RemoveOrgRole(tenant, "service:"+r.FormValue("id"), org.RoleMember); rerr != nil {
+					fmt.Println("APIKEY-ERR: ", rerr)
+				}
+			}
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, errScopeNotHeld) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			fmt.Println("APIKEY-ERR: ", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if key.ID != "" {
+			// The service is a member of the org, so checks on it are scoped too ..
+			if aerr := as.AddOrgRole(tenant, "service:"+key.ID, org.RoleMember); aerr != nil {
+				fmt.Println("APIKEY-ERR: ", aerr)
+			}
+		}
+		if token != "" {
+			// Only chance to see it ..
+			result += "<div>New key; copy it now, it is not shown again: <code>" + html.EscapeString(token) + "</code></div>"
+		}
+	}
+
+	keys, err := apiKeys.List(r.Context(), tenant)
+	if err != nil {
+		fmt.Println("APIKEY-ERR: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	result += "<div>"
+	now := time.Now()
+	for _, k := range keys {
+		result += "<strong>" + html.EscapeString(k.Name) + "</strong> " + identity.APIKeyPrefix + html.EscapeString(k.ID) +
+			"_... [" + html.EscapeString(strings.Join(k.Scopes, " ")) + "] by " + html.EscapeString(k.CreatedBy)
+		if !k.LastUsedAt.IsZero() {
+			result += " last used " + k.LastUsedAt.Format(time.RFC822)
+		}
+		switch {
+		case !k.RevokedAt.IsZero():
+			result += " - revoked<br/>"
+			continue
+		case !k.Active(now):
+			result += " - expired<br/>"
+			continue
+		case !k.ExpiresAt.IsZero():
+			result += " - expires " + k.ExpiresAt.Format(time.RFC822)
+		}
+		result += " " + postButton("/demo/apikeys/", url.Values{"action": {"rotate"}, "id": {k.ID}}, "Rotate", sess.CSRFToken) +
+			" " + postButton("/demo/apikeys/", url.Values{"action": {"revoke"}, "id": {k.ID}}, "Revoke", sess.CSRFToken) + "<br/>"
+	}
+	result += `</div><form method="post" action="/demo/apikeys/">` +
+		`<input type="hidden" name="action" value="issue"/>` +
+		`<input type="hidden" name="` + identity.CSRFField + `" value="` + html.EscapeString(sess.CSRFToken) + `"/>` +
+		`<input name="name" placeholder="Key name"/> `
+	for _, s := range apiKeyScopes {
+		result += `<label><input type="checkbox" name="scope" value="` + s + `"/>` + s + `</label> `
+	}
+	result += `<button type="submit">Issue</button></form></html>`
+	fmt.Fprint(w, result)
+	return
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// rpcStatus is the HTTP status for an AuthzService error ..
+func rpcStatus(err error) int {
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.FailedPrecondition:
+		return http.StatusConflict
+	case codes.Unavailable:
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+// apiCheckHandler ?relation=viewer&document=secret/x.doc[&user=bob];
+// without user it checks the calling service itself. Same as the gRPC
+// Check: documents + users of the caller's tenant only ..
+func apiCheckHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	relation := q.Get("relation")
+	if relation == "" {
+		relation = "viewer"
+	}
+	doc := q.Get("document")
+	res, err := authzAPI.Check(r.Context(), &authzv1.CheckRequest{User: q.Get("user"), Relation: relation, Document: doc})
+	if err != nil {
+		writeJSON(w, rpcStatus(err), map[string]string{"error": status.Convert(err).Message()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"user":     res.GetUser(),
+		"relation": relation,
+		"document": doc,
+		"allowed":  res.GetAllowed(),
+	})
+}
+
+// grantRequest is the body of POST /api/v1/grants ..
+type grantRequest struct {
+	Document string `json:"document"`
+	User     string `json:"user"`
+	Relation string `json:"relation"`
+	Reason   string `json:"reason"`
+}
+
+// apiGrantHandler is the gRPC Grant: an admin share by the calling service,
+// for as long as the key's issuer is still an admin of the tenant ..
+func apiGrantHandler(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	var req grantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "document and user are required"})
+		return
+	}
+	res, err := authzAPI.Grant(r.Context(), &authzv1.GrantRequest{
+		Document: req.Document,
+		User:     req.User,
+		Relation: req.Relation,
+		Reason:   req.Reason,
+	})
+	if err != nil {
+		writeJSON(w, rpcStatus(err), map[string]string{"error": status.Convert(err).Message()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": res.GetStatus()})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"app/internal/authz"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Handlers for break-glass emergency access; tenant documents only. Asking
+// needs break_glass on the tenant, deciding is the owner or tenant security ..
+// WorkflowID: breakglass-<orgID>/<docID>#<requester>
+
+// Errors the break-glass page answers with ..
+var (
+	errNotBreakGlass = errors.New("not eligible for break-glass in this tenant")
+	errNotRatifier   = errors.New("only the document owner or tenant security can decide")
+)
+
+// breakGlassAllowed is whether user holds relation on the tenant; no answer
+// from OpenFGA is no ..
+func breakGlassAllowed(w http.ResponseWriter, r *http.Request, relation, tenant string, denied error) bool {
+	ok, err := as.CheckOrg(r.Context(), currentSession(r).UserID, relation, tenant)
+	if err != nil {
+		fmt.Println("BREAKGLASS-ERR: ", err)
+		http.Error(w, "authorization unavailable", http.StatusBadGateway)
+		return false
+	}
+	if !ok {
+		http.Error(w, denied.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func breakGlassHandler(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	sess := currentSession(r)
+	if sess.Impersonating() {
+		// Support acting as someone never gets their emergency access ..
+		http.Error(w, errNotBreakGlass.Error(), http.StatusForbidden)
+		return
+	}
+	doc := r.FormValue("doc")
+	if doc == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	tenant := callerTenant(r)
+	// Only the tenant's documents; others are not found ..
+	ok, err := newTenantScope(tenant).owns("document:" + doc)
+	if err != nil {
+		fmt.Println("BREAKGLASS-ERR: ", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	if !ok {
+		http.Error(w, errDocNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	switch r.FormValue("action") {
+	case "request":
+		// action=request&doc=secret/salary.doc&incident=INC-42&justification=..&minutes=60
+		input := authz.BreakGlassInput{
+			OrgID:         tenant,
+			DocID:         doc,
+			Requester:     sess.UserID,
+			Justification: r.FormValue("justification"),
+			IncidentRef:   r.FormValue("incident"),
+		}
+		if input.Justification == "" || input.IncidentRef == "" {
+			http.Error(w, "justification and incident are required", http.StatusBadRequest)
+			return
+		}
+		if !breakGlassAllowed(w, r, "break_glass", tenant, errNotBreakGlass) {
+			return
+		}
+		// Capped at authz.MaxBreakGlass ..
+		if minutes, merr := strconv.Atoi(r.FormValue("minutes")); merr == nil && minutes > 0 {
+			input.Duration = min(time.Duration(minutes)*time.Minute, authz.MaxBreakGlass)
+		}
+		// Owner gets told; ask the document entity who that is ..
+		st, serr := loadDocument(r.Context(), tenant, doc)
+		if serr != nil {
+			fmt.Println("BREAKGLASS-ERR: ", serr)
+			http.Error(w, serr.Error(), docErrorStatus(serr))
+			return
+		}
+		input.Owner = st.Doc.Owner
+		we, berr := gw.StartBreakGlass(r.Context(), input)
+		if berr != nil {
+			fmt.Println("BREAKGLASS-ERR: ", berr)
+			http.Error(w, "break-glass already active or failed to start", http.StatusConflict)
+			return
+		}
+		fmt.Println("Break-glass started ID:", we.GetID(), "RunID:", we.GetRunID())
+	case "ratify", "deny":
+		// action=ratify&doc=secret/salary.doc&requester=mleow
+		// The owner or tenant security; never the requester (the workflow refuses that) ..
+		st, serr := loadDocument(r.Context(), tenant, doc)
+		if serr != nil {
+			fmt.Println("BREAKGLASS-ERR: ", serr)
+			http.Error(w, serr.Error(), docErrorStatus(serr))
+			return
+		}
+		if st.Doc.Owner != sess.UserID && !breakGlassAllowed(w, r, "security", tenant, errNotRatifier) {
+			return
+		}
+		err := gw.DecideBreakGlass(r.Context(), tenant, doc, r.FormValue("requester"), authz.BreakGlassDecision{
+			Actor:   sess.UserID,
+			Ratify:  r.FormValue("action") == "ratify",
+			Comment: r.FormValue("comment"),
+		})
+		if err != nil {
+			fmt.Println("BREAKGLASS-ERR: ", err)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, "/demo/", http.StatusFound)
+	return
+}
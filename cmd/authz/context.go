@@ -0,0 +1,68 @@
+package main
+
+import (
+	"app/internal/authz"
+	"app/internal/identity"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"time"
+)
+
+// Request context for ABAC; every request carries where it came from + when,
+// and every check passes it on to the request_context condition ..
+
+// trustedProxies may set X-Forwarded-For; TRUSTED_PROXIES=10.0.0.0/8,127.0.0.1
+var trustedProxies []netip.Prefix
+
+// contextPolicies put documents under the condition by prefix; CONTEXT_POLICIES
+// is a JSON list of authz.ContextPolicy ..
+var contextPolicies []authz.ContextPolicy
+
+// demoContextPolicies; secret/ is office (or this machine) only, in office
+// hours, from a trusted device ..
+var demoContextPolicies = []authz.ContextPolicy{
+	{
+		Name:                 "secret-office",
+		Prefix:               "secret/",
+		CIDRs:                []string{"10.0.0.0/8", "192.168.0.0/16", "127.0.0.0/8", "::1/128"},
+		Timezone:             "Asia/Kuala_Lumpur",
+		StartHour:            8,
+		EndHour:              19,
+		WeekdaysOnly:         true,
+		RequireTrustedDevice: true,
+	},
+}
+
+func setupRequestContext() {
+	var err error
+	trustedProxies, err = identity.ParsePrefixes(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalln("Bad TRUSTED_PROXIES", err)
+	}
+	contextPolicies = demoContextPolicies
+	if raw := os.Getenv("CONTEXT_POLICIES"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &contextPolicies); err != nil {
+			log.Fatalln("Bad CONTEXT_POLICIES", err)
+		}
+	}
+}
+
+// withRequestContext is outermost; sessions add the device after ..
+func withRequestContext(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(authz.WithRequestContext(r.Context(), authz.RequestContext{
+			IPAddress: identity.ClientIP(r, trustedProxies),
+			Time:      time.Now(),
+		})))
+	})
+}
+
+// withDevice adds the session's device posture ..
+func withDevice(r *http.Request, s identity.Session) *http.Request {
+	rc, _ := authz.RequestContextFrom(r.Context())
+	rc.DeviceTrusted = s.DeviceTrusted
+	return r.WithContext(authz.WithRequestContext(r.Context(), rc))
+}
@@ -2,65 +2,27 @@ package main
 
 import (
 	"app/internal/authz"
+	"app/internal/identity"
 	"context"
 	"fmt"
 	"net/http"
+	"sort"
 )
 
-func renderDefault() string {
-	result := `
-<html>
-<h3><strong>ACCESS MATRIX</strong></h3>
-<div>
-`
-	// Get current model
-	// Test access for all the users .. print out the report ..
-	// Grant .. and check ...
-	// Print link here ...
-	users := []string{"bob", "mleow"}
-	docs := []string{"public/welcome.doc", "secret/secretz.doc"}
-	for _, user := range users {
-		for _, doc := range docs {
-			result += "<strong>" + user + "</strong> " + doc
-			ok, _ := as.CanViewDocument(user, doc)
-			if ok {
-				result += " - YES "
-			} else {
-				result += " - NO "
-			}
-			result += "<br/>"
-		}
-	}
-	result += `
-</div>
-<div>
-<p>
-	<a href="/demo/debug/">Main</a><br/>
-	<a href="/demo/debug/?action=temp">Grant Temp Access</a><br/>
-	<a href="/demo/debug/?action=kil">Terminate</a><br/>
-</p>
-</div>
-</html>
-`
-
-	return result
-}
-
 func debugAccessHandler(w http.ResponseWriter, r *http.Request) {
-	// Get our Demo Workflow ..
-	wfr := c.GetWorkflow(context.Background(), orgID, "")
-	wfr.GetRunID()
-
+	// Org workflow is brought up on demand by the gateway ..
 	// WorkflowID: <username>-approver
 	// WorkflowID: <docID>
 
 	// Check if action is happening ... after done redirect back ..
-	q := r.URL.Query()
-	if q.Has("action") {
-		switch q.Get("action") {
+	if action := r.FormValue("action"); action != "" {
+		if !requirePost(w, r) {
+			return
+		}
+		switch action {
 		case "temp":
 			// Temp access for 2 mins??
-			err := c.SignalWorkflow(context.Background(), orgID, "", "actionSignal", authz.Actions{
+			err := gw.SendActions(context.Background(), orgID, authz.Actions{
 				TempElevated: true,
 			})
 			if err != nil {
@@ -69,7 +31,7 @@ func debugAccessHandler(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 		case "kil":
-			err := c.SignalWorkflow(context.Background(), orgID, "", "terminateSignal", true)
+			err := gw.StopOrg(context.Background(), orgID)
 			if err != nil {
 				fmt.Println("KIL-ERR: ", err)
 				w.WriteHeader(http.StatusInternalServerError)
@@ -80,9 +42,19 @@ func debugAccessHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	result := renderDefault()
-	// Remove it .. check in 30s
-
-	fmt.Fprintf(w, result)
-	return
+	// What the caller can see; the whole tenant is in the admin console ..
+	sess := currentSession(r)
+	data := map[string]interface{}{
+		"User":      sess.UserID,
+		"CSRFField": identity.CSRFField,
+		"CSRFToken": sess.CSRFToken,
+	}
+	docs, err := as.ListDocuments(r.Context(), sess.UserID, "viewer")
+	if err != nil {
+		data["Error"] = "documents unavailable"
+	}
+	sort.Strings(docs)
+	data["Docs"] = docs
+	data["Admin"], _ = as.CheckOrg(r.Context(), sess.UserID, "admin", callerTenant(r))
+	render(w, "debug.html", data)
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"app/internal/docstore"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"log"
+	"os"
+)
+
+// Document content lives in the docstore; the document workflows only ever
+// carry IDs + the version they are at. Sealed per document, with the data
+// keys wrapped by the org's key in the KMS ..
+
+var (
+	docs docstore.DocumentStore
+	// docKeys rotates + shreds the org keys docs is sealed with ..
+	docKeys *docstore.EncryptedStore
+)
+
+// setupDocumentStore keeps content in Postgres when DOCUMENT_STORE=postgres
+// (DATABASE_URL); files under DOCUMENT_DIR otherwise. Org keys are files
+// under KMS_DIR; dev only ..
+func setupDocumentStore() {
+	var inner docstore.DocumentStore
+	var keys docstore.KeyStore
+	switch os.Getenv("DOCUMENT_STORE") {
+	case "postgres":
+		pool, err := pgxpool.New(context.Background(), os.Getenv("DATABASE_URL"))
+		if err != nil {
+			log.Fatalln("Unable to connect document store", err)
+		}
+		ps, err := docstore.NewPostgresStore(context.Background(), pool)
+		if err != nil {
+			log.Fatalln("Unable to create document store", err)
+		}
+		pk, err := docstore.NewPostgresKeyStore(context.Background(), pool)
+		if err != nil {
+			log.Fatalln("Unable to create document key store", err)
+		}
+		inner, keys = ps, pk
+	default:
+		dir := os.Getenv("DOCUMENT_DIR")
+		if dir == "" {
+			dir = "documents"
+		}
+		fs, err := docstore.NewFileStore(dir)
+		if err != nil {
+			log.Fatalln("Unable to create document store", err)
+		}
+		fk, err := docstore.NewFileKeyStore(dir + "-keys")
+		if err != nil {
+			log.Fatalln("Unable to create document key store", err)
+		}
+		inner, keys = fs, fk
+	}
+	kmsDir := os.Getenv("KMS_DIR")
+	if kmsDir == "" {
+		kmsDir = "kms"
+	}
+	kms, err := docstore.NewLocalKMS(kmsDir)
+	if err != nil {
+		log.Fatalln("Unable to create KMS", err)
+	}
+	docKeys = docstore.NewEncryptedStore(inner, keys, kms)
+	docs = docKeys
+}
+
+// demoContent is what the demo documents start with ..
+var demoContent = map[string]string{
+	"public/welcome.doc": "All Open!",
+	"secret/secretz.doc": "Secretz",
+	"secret/salary.doc":  "Lotsa Moolah!!",
+}
+
+// seedContent writes the first version; already there from an earlier run
+// is fine. Returns the version it is at ..
+func seedContent(ctx context.Context, org, doc, author string) (int, error) {
+	d, err := docs.Create(ctx, org, doc, demoContent[doc], author)
+	if errors.Is(err, docstore.ErrExists) {
+		d, err = docs.Get(ctx, doc)
+		// IDs are global; never hand back another org's content ..
+		if err == nil && d.OrgID != org {
+			return 0, fmt.Errorf("%s: %w", doc, docstore.ErrExists)
+		}
+	}
+	return d.Version, err
+}
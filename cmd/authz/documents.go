@@ -0,0 +1,535 @@
+package main
+
+import (
+	"app/internal/authz"
+	"app/internal/docstore"
+	"app/internal/identity"
+	"app/internal/openapi"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.temporal.io/api/serviceerror"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Document API; JSON over the document entities. Same checks as the pages:
+// viewing goes through CanViewDocument, changing through CanEditDocument and
+// decisions are the owner's. Bearer API key (documents:read / write) or the
+// session cookie + CSRF header ..
+// GET  /api/v1/documents                      -> what the caller can view
+// GET  /api/v1/documents/{id}                 -> with content; ETag
+// PUT  /api/v1/documents/{id}                 -> new version; If-Match required
+// GET  /api/v1/documents/{id}:versions        -> history; who wrote what when
+// POST /api/v1/documents/{id}:request-access  -> ask the owner
+// POST /api/v1/documents/{id}:approve|reject  -> owner decides a request
+// GET  /api/v1/openapi.json                   -> spec of the above
+// IDs have slashes in them (secret/x.doc); they go in the path as they are ..
+
+const documentsPath = "/api/v1/documents"
+
+var (
+	errDocNotFound  = errors.New("document not found")
+	errDocForbidden = errors.New("no access; request it from the owner")
+	errDocStepUp    = errors.New("step-up required")
+	errDocConflict  = errors.New("conflict")
+	// Writes must say which version they are on top of ..
+	errDocMatchRequired = errors.New("If-Match with the document's ETag is required")
+	errDocPrecondition  = errors.New("document changed since it was read; fetch it again")
+	// The org's key is destroyed; the content is gone for good ..
+	errDocShredded = errors.New("document content destroyed")
+)
+
+func docErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errDocNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errDocForbidden), errors.Is(err, errDocStepUp):
+		return http.StatusForbidden
+	case errors.Is(err, errDocConflict):
+		return http.StatusConflict
+	case errors.Is(err, errDocMatchRequired):
+		return http.StatusPreconditionRequired
+	case errors.Is(err, errDocPrecondition):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, errDocShredded):
+		return http.StatusGone
+	}
+	return http.StatusBadGateway
+}
+
+// docCaller is who is acting on documents; only the tenant's are found ..
+type docCaller struct {
+	ID        string
+	Tenant    string
+	RecentMFA bool
+}
+
+// callerFrom; the session if there is one, else the API key's service ..
+func callerFrom(r *http.Request) docCaller {
+	if s, ok := identity.SessionFrom(r.Context()); ok {
+		return docCaller{ID: s.UserID, Tenant: callerTenant(r), RecentMFA: mfaRecent(s)}
+	}
+	p, _ := identity.PrincipalFrom(r.Context())
+	return docCaller{ID: p.ID, Tenant: callerTenant(r)}
+}
+
+// loadDocument is the tenant's entity state; never started or not created is 404 ..
+func loadDocument(ctx context.Context, tenant, doc string) (authz.DocumentState, error) {
+	st, err := gw.DocumentState(ctx, tenant, doc)
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) {
+		return st, errDocNotFound
+	}
+	if err != nil {
+		return st, err
+	}
+	if !st.Created {
+		return st, errDocNotFound
+	}
+	return st, nil
+}
+
+// storeError in terms of the API; deleted is gone as far as callers go ..
+func storeError(err error) error {
+	switch {
+	case errors.Is(err, docstore.ErrNotFound), errors.Is(err, docstore.ErrDeleted):
+		return errDocNotFound
+	case errors.Is(err, docstore.ErrPrecondition):
+		return errDocPrecondition
+	case errors.Is(err, docstore.ErrKeyDestroyed):
+		return errDocShredded
+	}
+	return err
+}
+
+// canView; with the step-up the session has, if any ..
+func canView(ctx context.Context, c docCaller, doc string) (bool, error) {
+	if c.RecentMFA {
+		return as.CheckWithMFA(ctx, c.ID, "viewer", doc, true)
+	}
+	return as.CanViewDocument(ctx, c.ID, doc)
+}
+
+// authorizeView is the entity's state once the caller is allowed to view ..
+func authorizeView(ctx context.Context, c docCaller, doc string) (authz.DocumentState, error) {
+	st, err := loadDocument(ctx, c.Tenant, doc)
+	if err != nil {
+		return st, err
+	}
+	ok, err := canView(ctx, c, doc)
+	if err != nil {
+		return st, err
+	}
+	if !ok {
+		// Would a step-up do it? Then say so ..
+		if !c.RecentMFA {
+			if stepUp, _ := as.CheckWithMFA(ctx, c.ID, "viewer", doc, true); stepUp {
+				return st, errDocStepUp
+			}
+		}
+		return st, errDocForbidden
+	}
+	return st, nil
+}
+
+// viewDocument is the state + latest content from the docstore ..
+func viewDocument(ctx context.Context, c docCaller, doc string) (authz.DocumentState, docstore.Document, error) {
+	st, err := authorizeView(ctx, c, doc)
+	if err != nil {
+		return st, docstore.Document{}, err
+	}
+	d, err := docs.Get(ctx, doc)
+	return st, d, storeError(err)
+}
+
+// updateDocument writes a new version on top of ifMatch; then tells the
+// entity which version it is at ..
+func updateDocument(ctx context.Context, c docCaller, doc, content, ifMatch string) (docstore.Document, error) {
+	if ifMatch == "" {
+		return docstore.Document{}, errDocMatchRequired
+	}
+	st, err := loadDocument(ctx, c.Tenant, doc)
+	if err != nil {
+		return docstore.Document{}, err
+	}
+	if st.Archived {
+		return docstore.Document{}, fmt.Errorf("%w: document is archived", errDocConflict)
+	}
+	ok, err := as.CanEditDocument(ctx, c.ID, doc)
+	if err != nil {
+		return docstore.Document{}, err
+	}
+	if !ok {
+		return docstore.Document{}, errDocForbidden
+	}
+	d, err := docs.Update(ctx, doc, content, c.ID, ifMatch)
+	if err != nil {
+		return d, storeError(err)
+	}
+	return d, gw.SignalDocument(ctx, st.OrgID, doc, authz.DocumentCommand{
+		Op:      authz.OpUpdate,
+		Actor:   c.ID,
+		Version: d.Version,
+	})
+}
+
+func requestDocumentAccess(ctx context.Context, c docCaller, doc, reason string) error {
+	st, err := loadDocument(ctx, c.Tenant, doc)
+	if err != nil {
+		return err
+	}
+	if st.Archived {
+		return fmt.Errorf("%w: document is archived", errDocConflict)
+	}
+	if _, ok := st.Grants[c.ID]; ok || st.Doc.Owner == c.ID {
+		return fmt.Errorf("%w: already has access", errDocConflict)
+	}
+	if _, ok := st.Pending[c.ID]; ok {
+		return fmt.Errorf("%w: request already pending", errDocConflict)
+	}
+	return gw.SignalDocument(ctx, st.OrgID, doc, authz.DocumentCommand{
+		Op:     authz.OpRequestAccess,
+		Actor:  c.ID,
+		Reason: reason,
+	})
+}
+
+// withdrawDocumentRequest takes back the caller's own pending request ..
+func withdrawDocumentRequest(ctx context.Context, c docCaller, doc string) error {
+	st, err := loadDocument(ctx, c.Tenant, doc)
+	if err != nil {
+		return err
+	}
+	if _, ok := st.Pending[c.ID]; !ok {
+		return fmt.Errorf("%w: no pending request", errDocConflict)
+	}
+	return gw.SignalDocument(ctx, st.OrgID, doc, authz.DocumentCommand{
+		Op:    authz.OpWithdraw,
+		Actor: c.ID,
+	})
+}
+
+// decideDocumentAccess is approve or reject of user's pending request; the
+// owner's call ..
+func decideDocumentAccess(ctx context.Context, c docCaller, doc, op, user string, d time.Duration, reason string) error {
+	st, err := loadDocument(ctx, c.Tenant, doc)
+	if err != nil {
+		return err
+	}
+	if st.Archived {
+		return fmt.Errorf("%w: document is archived", errDocConflict)
+	}
+	if st.Doc.Owner == "" || st.Doc.Owner != c.ID {
+		return fmt.Errorf("%w: only the owner decides", errDocForbidden)
+	}
+	if _, ok := st.Pending[user]; !ok {
+		return fmt.Errorf("%w: no pending request from %s", errDocConflict, user)
+	}
+	return gw.SignalDocument(ctx, st.OrgID, doc, authz.DocumentCommand{
+		Op:       op,
+		Actor:    c.ID,
+		User:     user,
+		Duration: d,
+		Reason:   reason,
+	})
+}
+
+// JSON bodies; the spec is made from these ..
+type documentSummary struct {
+	ID string `json:"id"`
+}
+
+type documentList struct {
+	Documents []documentSummary `json:"documents"`
+}
+
+type documentBody struct {
+	ID             string `json:"id"`
+	Owner          string `json:"owner"`
+	Classification string `json:"classification"`
+	Content        string `json:"content"`
+	Version        int    `json:"version"`
+	// Author of this version ..
+	Author    string    `json:"author"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Archived  bool      `json:"archived"`
+	// ContextPolicy it is under, if any ..
+	Policy string `json:"policy,omitempty"`
+}
+
+type documentUpdate struct {
+	Content string `json:"content"`
+}
+
+// versionBody is one version without its content ..
+type versionBody struct {
+	Version   int       `json:"version"`
+	ETag      string    `json:"etag"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type versionList struct {
+	Versions []versionBody `json:"versions"`
+}
+
+type accessRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+type accessDecision struct {
+	User string `json:"user"`
+	// Minutes makes an approval temporary; 0 is standing ..
+	Minutes int    `json:"minutes,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// submitted; the entity applies commands in order, its history has the outcome ..
+type submitted struct {
+	Status string `json:"status"`
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := docErrorStatus(err)
+	msg := err.Error()
+	if status == http.StatusBadGateway {
+		fmt.Println("DOCUMENT-API-ERR: ", err)
+		msg = "document service unavailable"
+	}
+	writeJSON(w, status, openapi.ErrorBody{Error: msg})
+}
+
+// decodeBody; anything but the expected JSON is a 400 ..
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeJSON(w, http.StatusBadRequest, openapi.ErrorBody{Error: "invalid JSON body"})
+		return false
+	}
+	return true
+}
+
+func apiListDocuments(w http.ResponseWriter, r *http.Request, _ string) {
+	docs, err := as.ListDocuments(r.Context(), callerFrom(r).ID, "viewer")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	out := documentList{Documents: make([]documentSummary, 0, len(docs))}
+	for _, id := range docs {
+		out.Documents = append(out.Documents, documentSummary{ID: id})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func apiGetDocument(w http.ResponseWriter, r *http.Request, id string) {
+	st, d, err := viewDocument(r.Context(), callerFrom(r), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("ETag", d.ETag)
+	writeJSON(w, http.StatusOK, documentBody{
+		ID:             id,
+		Owner:          st.Doc.Owner,
+		Classification: st.Classification,
+		Content:        d.Content,
+		Version:        d.Version,
+		Author:         d.Author,
+		UpdatedAt:      d.UpdatedAt,
+		Archived:       st.Archived,
+		Policy:         st.Policy,
+	})
+}
+
+func apiUpdateDocument(w http.ResponseWriter, r *http.Request, id string) {
+	var body documentUpdate
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	d, err := updateDocument(r.Context(), callerFrom(r), id, body.Content, r.Header.Get("If-Match"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("ETag", d.ETag)
+	writeJSON(w, http.StatusOK, versionBody{Version: d.Version, ETag: d.ETag, Author: d.Author, CreatedAt: d.UpdatedAt})
+}
+
+func apiListVersions(w http.ResponseWriter, r *http.Request, id string) {
+	if _, err := authorizeView(r.Context(), callerFrom(r), id); err != nil {
+		writeError(w, err)
+		return
+	}
+	versions, err := docs.Versions(r.Context(), id)
+	if err != nil {
+		writeError(w, storeError(err))
+		return
+	}
+	out := versionList{Versions: make([]versionBody, 0, len(versions))}
+	for _, v := range versions {
+		out.Versions = append(out.Versions, versionBody{Version: v.Version, ETag: v.ETag, Author: v.Author, CreatedAt: v.CreatedAt})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func apiRequestAccess(w http.ResponseWriter, r *http.Request, id string) {
+	var body accessRequest
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	if err := requestDocumentAccess(r.Context(), callerFrom(r), id, body.Reason); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, submitted{Status: "submitted"})
+}
+
+func apiDecide(op string) func(http.ResponseWriter, *http.Request, string) {
+	return func(w http.ResponseWriter, r *http.Request, id string) {
+		var body accessDecision
+		if !decodeBody(w, r, &body) {
+			return
+		}
+		if body.User == "" || body.Minutes < 0 {
+			writeJSON(w, http.StatusBadRequest, openapi.ErrorBody{Error: "user is required"})
+			return
+		}
+		err := decideDocumentAccess(r.Context(), callerFrom(r), id, op, body.User,
+			time.Duration(body.Minutes)*time.Minute, body.Reason)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, submitted{Status: "submitted"})
+	}
+}
+
+// documentRoute is a handler plus what the spec says about it ..
+type documentRoute struct {
+	openapi.Operation
+	// relation the caller needs on {id}; checked before the handler ..
+	relation string
+	handler  func(w http.ResponseWriter, r *http.Request, id string)
+}
+
+var idParam = []openapi.Param{{Name: "id", In: "path", Description: "Document ID e.g. secret/salary.doc"}}
+
+var ifMatchParams = append(append([]openapi.Param{}, idParam...),
+	openapi.Param{Name: "If-Match", In: "header", Description: "ETag of the version being replaced", Required: true})
+
+// Errors any authenticated route can give ..
+var authErrors = []int{http.StatusUnauthorized, http.StatusForbidden}
+
+func withErrors(codes ...int) []int {
+	return append(append([]int{}, authErrors...), codes...)
+}
+
+var documentRoutes = []documentRoute{
+	{openapi.Operation{
+		Method: http.MethodGet, Path: documentsPath, ID: "listDocuments",
+		Summary: "Documents the caller can view", Scope: identity.ScopeDocumentsRead,
+		Response: documentList{}, Errors: withErrors(http.StatusBadGateway),
+	}, "", apiListDocuments},
+	{openapi.Operation{
+		Method: http.MethodGet, Path: documentsPath + "/{id}", ID: "getDocument",
+		Summary: "Document with its content; needs viewer", Scope: identity.ScopeDocumentsRead,
+		Params: idParam, Response: documentBody{}, Errors: withErrors(http.StatusNotFound, http.StatusGone, http.StatusBadGateway),
+	}, "viewer", apiGetDocument},
+	{openapi.Operation{
+		Method: http.MethodPut, Path: documentsPath + "/{id}", ID: "updateDocument",
+		Summary: "Write a new version of the content; needs editor", Scope: identity.ScopeDocumentsWrite,
+		Params: ifMatchParams, Request: documentUpdate{}, Response: versionBody{},
+		Errors: withErrors(http.StatusBadRequest, http.StatusNotFound, http.StatusConflict,
+			http.StatusGone, http.StatusPreconditionFailed, http.StatusPreconditionRequired, http.StatusBadGateway),
+	}, "editor", apiUpdateDocument},
+	{openapi.Operation{
+		Method: http.MethodGet, Path: documentsPath + "/{id}:versions", ID: "listDocumentVersions",
+		Summary: "Every version with its author, oldest first; needs viewer", Scope: identity.ScopeDocumentsRead,
+		Params: idParam, Response: versionList{}, Errors: withErrors(http.StatusNotFound, http.StatusGone, http.StatusBadGateway),
+	}, "viewer", apiListVersions},
+	{openapi.Operation{
+		Method: http.MethodPost, Path: documentsPath + "/{id}:request-access", ID: "requestDocumentAccess",
+		Summary: "Ask the owner for viewer access", Scope: identity.ScopeDocumentsWrite,
+		Params: idParam, Request: accessRequest{}, Response: submitted{}, Status: http.StatusAccepted,
+		Errors: withErrors(http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusBadGateway),
+	}, "", apiRequestAccess},
+	{openapi.Operation{
+		Method: http.MethodPost, Path: documentsPath + "/{id}:approve", ID: "approveDocumentAccess",
+		Summary: "Owner approves a pending request", Scope: identity.ScopeDocumentsWrite,
+		Params: idParam, Request: accessDecision{}, Response: submitted{}, Status: http.StatusAccepted,
+		Errors: withErrors(http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusBadGateway),
+	}, "owner", apiDecide(authz.OpApprove)},
+	{openapi.Operation{
+		Method: http.MethodPost, Path: documentsPath + "/{id}:reject", ID: "rejectDocumentAccess",
+		Summary: "Owner rejects a pending request", Scope: identity.ScopeDocumentsWrite,
+		Params: idParam, Request: accessDecision{}, Response: submitted{}, Status: http.StatusAccepted,
+		Errors: withErrors(http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusBadGateway),
+	}, "owner", apiDecide(authz.OpReject)},
+}
+
+// matchDocumentRoute turns the URL path into the route's template + the ID
+// e.g. /api/v1/documents/secret/x.doc:approve -> {id}:approve, secret/x.doc ..
+func matchDocumentRoute(path string) (string, string) {
+	rest := strings.TrimPrefix(strings.TrimPrefix(path, documentsPath), "/")
+	if rest == "" {
+		return documentsPath, ""
+	}
+	template := documentsPath + "/{id}"
+	if i := strings.LastIndex(rest, ":"); i > 0 && !strings.Contains(rest[i:], "/") {
+		return template + rest[i:], rest[:i]
+	}
+	return template, rest
+}
+
+// documentsAPI picks the route, then authenticates for its scope ..
+func documentsAPI(w http.ResponseWriter, r *http.Request) {
+	template, id := matchDocumentRoute(r.URL.Path)
+	var allowed []string
+	for _, route := range documentRoutes {
+		if route.Path != template {
+			continue
+		}
+		if route.Method != r.Method {
+			allowed = append(allowed, route.Method)
+			continue
+		}
+		var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route.handler(w, r, id)
+		})
+		if route.relation != "" {
+			r.SetPathValue("id", id)
+			handler = apiGuard.Require(route.relation, authz.DocumentFromPath("id"))(handler)
+		}
+		if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			apiKeys.Require(route.Scope, handler).ServeHTTP(w, r)
+			return
+		}
+		requireSessionAPI(handler.ServeHTTP).ServeHTTP(w, r)
+		return
+	}
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeJSON(w, http.StatusMethodNotAllowed, openapi.ErrorBody{Error: "method not allowed"})
+		return
+	}
+	writeJSON(w, http.StatusNotFound, openapi.ErrorBody{Error: "no such route"})
+}
+
+// openAPIHandler serves the spec made from documentRoutes ..
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	ops := make([]openapi.Operation, 0, len(documentRoutes))
+	for _, route := range documentRoutes {
+		ops = append(ops, route.Operation)
+	}
+	writeJSON(w, http.StatusOK, openapi.Spec(openapi.Info{
+		Title:   "GopherLab Documents",
+		Version: "1",
+		Description: "Document IDs contain slashes and go in the path as is. " +
+			"Writes are accepted and applied in order by the document's workflow.",
+	}, "session", ops))
+}
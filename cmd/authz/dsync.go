@@ -0,0 +1,178 @@
+package main
+
+import (
+	"app/internal/authz"
+	"app/internal/identity"
+	"app/internal/org"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Handlers for directory sync (WorkOS style) joiner / mover / leaver events ..
+// POST /webhooks/dsync   -> signed events from the directory
+// /demo/lifecycle/?user= -> status; the approver accepts a leaver's documents
+// WorkflowID: lifecycle-<org>-<user>
+
+// Everyone who joins gets these; team groups come on top ..
+var dsyncBaselineGroups = []string{"everyone"}
+
+// dsyncWebhook is nil (not mounted) without DSYNC_WEBHOOK_SECRET ..
+var dsyncWebhook *identity.DirectoryWebhook
+
+func setupDirectorySync() {
+	secret := os.Getenv("DSYNC_WEBHOOK_SECRET")
+	if secret == "" {
+		fmt.Println("DSYNC: no DSYNC_WEBHOOK_SECRET; directory webhooks disabled")
+		return
+	}
+	dsyncWebhook = &identity.DirectoryWebhook{Secret: []byte(secret), Handle: handleDirectoryEvent}
+}
+
+// dsyncTenant maps the directory's organization to ours; only DSYNC_ORGANIZATION_ID
+// if set, else everything is the demo org ..
+func dsyncTenant(organizationID string) string {
+	if want := os.Getenv("DSYNC_ORGANIZATION_ID"); want != "" && organizationID != want {
+		return ""
+	}
+	return orgID
+}
+
+// dsyncUserOK; a username that is not a plain user is acknowledged and
+// dropped, a retry would not change it ..
+func dsyncUserOK(u identity.DirectoryUser) bool {
+	if err := identity.ValidUserID(u.UserID()); err != nil {
+		fmt.Println("DSYNC-ERR: ", u.ID, err)
+		return false
+	}
+	return true
+}
+
+func handleDirectoryEvent(ctx context.Context, ev identity.DirectoryEvent) error {
+	switch ev.Event {
+	case identity.DirectoryGroupUserAdded, identity.DirectoryGroupUserRemoved:
+		var m identity.DirectoryGroupMembership
+		if err := json.Unmarshal(ev.Data, &m); err != nil {
+			return nil
+		}
+		tenant := dsyncTenant(m.User.OrganizationID)
+		if tenant == "" || !dsyncUserOK(m.User) {
+			return nil
+		}
+		group := authz.GroupID(tenant, m.Group.Name)
+		if ev.Event == identity.DirectoryGroupUserAdded {
+			return as.AddGroupMember(group, m.User.UserID())
+		}
+		if err := as.RemoveGroupMember(group, m.User.UserID()); err != nil {
+			fmt.Println("DSYNC-GROUP-ERR: ", err)
+		}
+		return nil
+	case identity.DirectoryUserCreated, identity.DirectoryUserUpdated, identity.DirectoryUserDeleted:
+	default:
+		// Acknowledge; otherwise it is retried forever ..
+		return nil
+	}
+	var u identity.DirectoryUser
+	if err := json.Unmarshal(ev.Data, &u); err != nil {
+		return nil
+	}
+	tenant := dsyncTenant(u.OrganizationID)
+	if tenant == "" || !dsyncUserOK(u) {
+		return nil
+	}
+	manager, _, _ := strings.Cut(u.Attribute("manager"), "@")
+	if identity.ValidUserID(manager) != nil {
+		manager = ""
+	}
+	le := authz.LifecycleEvent{
+		ID:      ev.ID,
+		Team:    u.Attribute("department"),
+		Manager: manager,
+		At:      ev.CreatedAt,
+	}
+	switch {
+	case ev.Event == identity.DirectoryUserDeleted || !u.Active():
+		le.Kind = authz.LifecycleLeaver
+	case ev.Event == identity.DirectoryUserCreated:
+		le.Kind = authz.LifecycleJoiner
+	default:
+		le.Kind = authz.LifecycleMover
+	}
+	fmt.Println("DSYNC: ", ev.Event, u.UserID(), "->", le.Kind, le.Team)
+	// Org membership follows the directory; a leaver is out before the rest ..
+	switch le.Kind {
+	case authz.LifecycleJoiner, authz.LifecycleMover:
+		if err := orgs.EnsureMember(ctx, tenant, u.UserID(), org.RoleMember); err != nil {
+			return err
+		}
+	case authz.LifecycleLeaver:
+		if err := orgs.Evict(ctx, tenant, u.UserID()); err != nil {
+			fmt.Println("DSYNC-ORG-ERR: ", err)
+		}
+	}
+	return gw.SignalLifecycle(ctx, authz.LifecycleInput{
+		OrgID:          tenant,
+		User:           u.UserID(),
+		BaselineGroups: dsyncBaselineGroups,
+	}, le)
+}
+
+// lifecycleHandler shows a user's lifecycle to them, their approver or an org
+// admin; the approver accepts or declines their documents here ..
+func lifecycleHandler(w http.ResponseWriter, r *http.Request) {
+	sess := currentSession(r)
+	user := r.FormValue("user")
+	if user == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if action := r.FormValue("action"); action != "" {
+		if !requirePost(w, r) {
+			return
+		}
+		err := gw.DecideLifecycle(r.Context(), orgID, user, authz.LifecycleDecision{
+			Actor:   sess.UserID,
+			Accept:  action == "accept",
+			Comment: r.FormValue("comment"),
+		})
+		if err != nil {
+			fmt.Println("LIFECYCLE-ERR: ", err)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.Redirect(w, r, "/demo/lifecycle/?user="+url.QueryEscape(user), http.StatusFound)
+		return
+	}
+	st, err := gw.LifecycleStatus(r.Context(), orgID, user)
+	if err != nil {
+		fmt.Println("LIFECYCLE-ERR: ", err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	// Team, manager and steps are HR's; the user, their approver + admins only ..
+	if sess.UserID != user && sess.UserID != st.Approver && !adminOf(w, r, orgID) {
+		return
+	}
+	result := "<html><h3><strong>LIFECYCLE " + html.EscapeString(user) + "</strong></h3><div>" +
+		"Status: " + html.EscapeString(st.Status) + " Team: " + html.EscapeString(st.Team) +
+		" Manager: " + html.EscapeString(st.Manager) + "<br/>"
+	if len(st.Transfer) > 0 {
+		result += "Documents waiting for a new owner: " + html.EscapeString(strings.Join(st.Transfer, ", ")) +
+			" (approver " + html.EscapeString(st.Approver) + ")<br/>"
+		if st.Approver == sess.UserID {
+			result += postButton("/demo/lifecycle/", url.Values{"user": {user}, "action": {"accept"}}, "Accept ownership", sess.CSRFToken) +
+				" " + postButton("/demo/lifecycle/", url.Values{"user": {user}, "action": {"decline"}}, "Decline", sess.CSRFToken) + "<br/>"
+		}
+	}
+	result += "</div><div>"
+	for _, step := range st.Steps {
+		result += html.EscapeString(step) + "<br/>"
+	}
+	result += "</div></html>"
+	fmt.Fprint(w, result)
+}
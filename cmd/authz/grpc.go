@@ -0,0 +1,38 @@
+package main
+
+import (
+	"app/internal/authzrpc"
+	"fmt"
+	"google.golang.org/grpc"
+	"net"
+	"os"
+)
+
+// authzAPI answers the gRPC service and the HTTP API alike, so both keep
+// to the caller's tenant the same way ..
+var authzAPI *authzrpc.Server
+
+// AuthzService for other teams' services; API keys as on the HTTP API,
+// method policies in proto/authz/v1/authz.proto. GRPC_ADDR default :8082 ..
+func serveGRPC() *grpc.Server {
+	addr := os.Getenv("GRPC_ADDR")
+	if addr == "" {
+		addr = ":8082"
+	}
+	authzAPI = authzrpc.NewServer(as, gw)
+	srv := authzrpc.NewGRPCServer(authzAPI, authzrpc.Interceptor{
+		Authenticate: authzrpc.APIKeys(apiKeys),
+		Checker:      as,
+	})
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		fmt.Println("gRPC listen error:", err)
+		return srv
+	}
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			fmt.Println("gRPC server error:", err)
+		}
+	}()
+	return srv
+}
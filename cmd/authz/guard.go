@@ -0,0 +1,64 @@
+package main
+
+import (
+	"app/internal/authz"
+	"app/internal/openapi"
+	"fmt"
+	"net/http"
+)
+
+// Declarative checks; routes say the relation + where the document is and
+// the middleware checks before the handler runs. Handlers may still check
+// more (owner in the entity, archived ..) but never less ..
+
+// guard is for pages; a step-up that would help sends the user off to MFA ..
+var guard authz.Authorizer
+
+// apiGuard is guard with JSON errors ..
+var apiGuard authz.Authorizer
+
+func setupGuard() {
+	guard = authz.Authorizer{
+		Checker:   as,
+		MFAMaxAge: mfaMaxAge,
+		Log:       logDecision,
+		Deny: func(w http.ResponseWriter, r *http.Request, d authz.Decision) {
+			if d.StepUp && r.Method == http.MethodGet {
+				http.Redirect(w, r, stepUpURL(r), http.StatusFound)
+				return
+			}
+			http.Error(w, decisionMessage(d), authz.DecisionStatus(d))
+		},
+	}
+	apiGuard = guard
+	apiGuard.Deny = func(w http.ResponseWriter, r *http.Request, d authz.Decision) {
+		writeJSON(w, authz.DecisionStatus(d), openapi.ErrorBody{Error: decisionMessage(d)})
+	}
+}
+
+// logDecision; denials + failures only, allows are the normal case ..
+func logDecision(r *http.Request, d authz.Decision) {
+	if d.Allowed {
+		return
+	}
+	fmt.Println("AUTHZ-DENY:", r.Method, r.URL.Path, "caller", d.Caller, d.Relation, d.Document, "step-up", d.StepUp, "err", d.Err)
+}
+
+// decisionMessage in the same words as the handlers' own errors ..
+func decisionMessage(d authz.Decision) string {
+	switch {
+	case d.StepUp:
+		return errDocStepUp.Error()
+	case d.Err != nil && authz.DecisionStatus(d) == http.StatusBadGateway:
+		return "authorization unavailable"
+	case d.Err != nil:
+		return d.Err.Error()
+	}
+	return errDocForbidden.Error()
+}
+
+// authedFor is requireSession plus the relation on the document object
+// picks out ..
+func authedFor(relation string, object authz.ObjectFunc, h http.HandlerFunc) http.Handler {
+	return requireSession(guard.Require(relation, object)(h).ServeHTTP)
+}
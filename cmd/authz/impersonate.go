@@ -0,0 +1,216 @@
+package main
+
+import (
+	"app/internal/authz"
+	"app/internal/identity"
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Support impersonation; support staff (support relation on the org) see
+// what a member sees, for a ticket, for a while. Read-only unless they also
+// have support_write; every request is audited with both identities ..
+// /demo/impersonate/ -> start (user, ticket, minutes, write) or stop
+
+const defaultImpersonation = 30 * time.Minute
+
+// ticketPattern is a support / incident reference e.g. SUP-1234 ..
+var ticketPattern = regexp.MustCompile(`^[A-Z][A-Z0-9]*-[0-9]+$`)
+
+// Unsafe requests a read-only impersonation can still make ..
+var impersonationAlways = []string{"/demo/impersonate/", "/demo/logout/"}
+
+// Never while impersonating, even with write; the user's own credentials
+// and org admin stay theirs ..
+var impersonationNever = []string{"/demo/mfa/", "/demo/apikeys/", "/demo/org/", "/demo/breakglass/", "/demo/saml/"}
+
+func hasPathPrefix(path string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// impersonationBlocked is why the request may not go through; empty if it may ..
+func impersonationBlocked(r *http.Request, s identity.Session) string {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || hasPathPrefix(r.URL.Path, impersonationAlways) {
+		return ""
+	}
+	if hasPathPrefix(r.URL.Path, impersonationNever) {
+		return "not allowed while impersonating"
+	}
+	if !s.ImpersonationWrite {
+		return "impersonation is read-only"
+	}
+	return ""
+}
+
+func auditImpersonation(ctx context.Context, action string, s identity.Session, detail map[string]string) {
+	detail["ticket"] = s.Ticket
+	detail["impersonator"] = s.Impersonator
+	detail["user"] = s.UserID
+	err := auditLog.Record(ctx, authz.AuditEvent{
+		OrgID:  orgID,
+		Actor:  s.Impersonator,
+		Action: action,
+		Object: authz.Subject(s.UserID),
+		Detail: detail,
+	})
+	if err != nil {
+		fmt.Println("AUDIT-ERR: ", err)
+	}
+}
+
+// guardImpersonation audits the request and refuses what support may not
+// do; the page gets the banner ..
+func guardImpersonation(w http.ResponseWriter, r *http.Request, s identity.Session) (http.ResponseWriter, bool) {
+	why := impersonationBlocked(r, s)
+	auditImpersonation(r.Context(), "impersonation.request", s, map[string]string{
+		"method":  r.Method,
+		"path":    r.URL.Path,
+		"blocked": why,
+	})
+	if why != "" {
+		fmt.Println("IMPERSONATION-BLOCKED: ", s.Impersonator, "as", s.UserID, r.Method, r.URL.Path)
+		http.Error(w, why, http.StatusForbidden)
+		return w, false
+	}
+	return &bannerWriter{ResponseWriter: w, banner: impersonationBanner(s)}, true
+}
+
+func impersonationBanner(s identity.Session) string {
+	mode := "read-only"
+	if s.ImpersonationWrite {
+		mode = "read-write"
+	}
+	return `<div style="background:#fc3;padding:4px">` +
+		html.EscapeString(s.Impersonator) + " is impersonating <strong>" + html.EscapeString(s.UserID) + "</strong> for " +
+		html.EscapeString(s.Ticket) + " (" + mode + ") until " + s.ImpersonationEnds.Format("15:04") + " " +
+		postButton("/demo/impersonate/", url.Values{"action": {"stop"}}, "Stop", s.CSRFToken) + "</div>"
+}
+
+// bannerWriter puts the banner ahead of any HTML body ..
+type bannerWriter struct {
+	http.ResponseWriter
+	banner string
+	done   bool
+}
+
+func (b *bannerWriter) Write(p []byte) (int, error) {
+	if !b.done {
+		b.done = true
+		if ct := b.Header().Get("Content-Type"); ct == "" || strings.HasPrefix(ct, "text/html") {
+			if _, err := io.WriteString(b.ResponseWriter, b.banner); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return b.ResponseWriter.Write(p)
+}
+
+// canImpersonate; support on the org, and target a member who is not
+// support themselves ..
+func canImpersonate(ctx context.Context, actor, target string, write bool) (bool, error) {
+	relation := "support"
+	if write {
+		relation = "support_write"
+	}
+	ok, err := as.CheckOrg(ctx, actor, relation, orgID)
+	if err != nil || !ok {
+		return false, err
+	}
+	role, err := orgs.Role(ctx, orgID, target)
+	if err != nil || role == "" {
+		return false, err
+	}
+	staff, err := as.CheckOrg(ctx, target, "support", orgID)
+	return !staff, err
+}
+
+func impersonateHandler(w http.ResponseWriter, r *http.Request) {
+	sess := currentSession(r)
+	switch r.FormValue("action") {
+	case "":
+	case "start":
+		if !requirePost(w, r) {
+			return
+		}
+		target := r.FormValue("user")
+		ticket := strings.TrimSpace(r.FormValue("ticket"))
+		write := r.FormValue("write") == "on"
+		if !ticketPattern.MatchString(ticket) {
+			http.Error(w, "ticket reference required e.g. SUP-1234", http.StatusBadRequest)
+			return
+		}
+		ok, err := canImpersonate(r.Context(), sess.UserID, target, write)
+		if err != nil {
+			fmt.Println("IMPERSONATION-ERR: ", err)
+		}
+		if !ok || sess.Impersonating() {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		ttl := defaultImpersonation
+		if minutes, merr := strconv.Atoi(r.FormValue("minutes")); merr == nil && minutes > 0 {
+			ttl = time.Duration(minutes) * time.Minute
+		}
+		s, err := sessions.Impersonate(r.Context(), w, r, sess, identity.User{ID: target}, identity.Impersonation{
+			Ticket:     ticket,
+			AllowWrite: write,
+			TTL:        ttl,
+		})
+		if err != nil {
+			fmt.Println("IMPERSONATION-ERR: ", err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		auditImpersonation(r.Context(), "impersonation.start", s, map[string]string{
+			"write": strconv.FormatBool(write),
+			"ends":  s.ImpersonationEnds.UTC().Format(time.RFC3339),
+		})
+		fmt.Println("IMPERSONATION: ", sess.UserID, "as", target, "for", ticket)
+		http.Redirect(w, r, "/demo/", http.StatusFound)
+		return
+	case "stop":
+		if !requirePost(w, r) {
+			return
+		}
+		if sess.Impersonating() {
+			auditImpersonation(r.Context(), "impersonation.stop", sess, map[string]string{})
+		}
+		// Back as yourself means logging in as yourself ..
+		sessions.Destroy(r.Context(), w, r)
+		http.Redirect(w, r, "/demo/login/", http.StatusSeeOther)
+		return
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	result := "<html><h3><strong>IMPERSONATE</strong></h3><div>"
+	if sess.Impersonating() {
+		fmt.Fprint(w, result+"Already impersonating "+html.EscapeString(sess.UserID)+"</div></html>")
+		return
+	}
+	if ok, _ := as.CheckOrg(r.Context(), sess.UserID, "support", orgID); !ok {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	result += `<form method="post" action="/demo/impersonate/">` +
+		`<input type="hidden" name="action" value="start"/>` +
+		`<input type="hidden" name="` + identity.CSRFField + `" value="` + html.EscapeString(sess.CSRFToken) + `"/>` +
+		`<input name="user" placeholder="Username"/> <input name="ticket" placeholder="SUP-1234"/> ` +
+		`<input name="minutes" placeholder="30"/> <label><input type="checkbox" name="write"/> Allow changes</label> ` +
+		`<button type="submit">Impersonate</button></form></div></html>`
+	fmt.Fprint(w, result)
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"app/internal/authz"
+	"app/internal/identity"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"html"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Share links; the owner hands out a URL instead of naming users. The link
+// workflow keeps link:<id>#holder a viewer of the document until it ends;
+// opening the link makes the caller a holder for that one check only. Still
+// org members only; the model says so ..
+// POST /demo/links/ action=create&doc=..&minutes=60[&password=..][&views=3]  (owner)
+// POST /demo/links/ action=revoke&doc=..&link=..                            (owner)
+// GET  /demo/link/?id=..&key=..   -> the document; a password form first if set
+// POST /demo/link/ id, key, password
+
+var (
+	errLinkNotFound = errors.New("link not found")
+	errLinkPassword = errors.New("wrong password")
+)
+
+// newLinkSecret is 32 random bytes URL safe ..
+func newLinkSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// ownedDocument is the entity's state if c owns doc ..
+func ownedDocument(ctx context.Context, c docCaller, doc string) (authz.DocumentState, error) {
+	st, err := loadDocument(ctx, c.Tenant, doc)
+	if err != nil {
+		return st, err
+	}
+	if st.Doc.Owner != c.ID {
+		return st, errDocForbidden
+	}
+	if st.Archived {
+		return st, fmt.Errorf("%w: document is archived", errDocConflict)
+	}
+	return st, nil
+}
+
+// documentLinks is the IDs of every link holding viewer on doc; ended links
+// lose the tuple so only live ones + those finishing up show ..
+func documentLinks(doc string) ([]string, error) {
+	var ids []string
+	token := ""
+	for {
+		tuples, next, err := as.ReadTuples("", "viewer", "document:"+doc, token, 100)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tuples {
+			if id, ok := strings.CutPrefix(t.User, "link:"); ok {
+				ids = append(ids, strings.TrimSuffix(id, "#holder"))
+			}
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// renderShareLinks is the owner's links on doc + a form for a new one ..
+func renderShareLinks(ctx context.Context, doc, csrf string) string {
+	result := ""
+	ids, err := documentLinks(doc)
+	if err != nil {
+		fmt.Println("LINK-ERR: ", err)
+		return "&nbsp; Links unavailable<br/>"
+	}
+	for _, id := range ids {
+		st, err := gw.ShareLinkState(ctx, id)
+		if err != nil {
+			fmt.Println("LINK-ERR: ", err)
+			continue
+		}
+		views := strconv.Itoa(st.Views)
+		if st.Link.MaxViews > 0 {
+			views += "/" + strconv.Itoa(st.Link.MaxViews)
+		}
+		result += "&nbsp; Link <code>" + html.EscapeString(id[:8]) + "</code> " + views + " views, until " +
+			st.Link.ExpiresAt.Format(time.RFC822)
+		if st.Link.PasswordHash != "" {
+			result += ", password"
+		}
+		if st.Ended != "" {
+			result += " - " + html.EscapeString(st.Ended) + "<br/>"
+			continue
+		}
+		result += " " + postButton("/demo/links/", url.Values{"action": {"revoke"}, "doc": {doc}, "link": {id}}, "Revoke", csrf) + "<br/>"
+	}
+	result += `&nbsp; <form method="post" action="/demo/links/" style="display:inline">` +
+		`<input type="hidden" name="action" value="create"/>` +
+		`<input type="hidden" name="doc" value="` + html.EscapeString(doc) + `"/>` +
+		`<input type="hidden" name="` + identity.CSRFField + `" value="` + html.EscapeString(csrf) + `"/>` +
+		`<input name="minutes" value="60" size="5"/> min ` +
+		`<input name="views" placeholder="max views" size="8"/> ` +
+		`<input name="password" type="password" placeholder="password (optional)"/> ` +
+		`<button type="submit">Share link</button></form><br/>`
+	return result
+}
+
+// linksHandler is the owner creating + revoking links ..
+func linksHandler(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	c := callerFrom(r)
+	doc := r.FormValue("doc")
+	if doc == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	st, err := ownedDocument(r.Context(), c, doc)
+	if err != nil {
+		http.Error(w, err.Error(), docErrorStatus(err))
+		return
+	}
+
+	switch r.FormValue("action") {
+	case "create":
+		minutes, merr := strconv.Atoi(r.FormValue("minutes"))
+		if merr != nil || minutes <= 0 || time.Duration(minutes)*time.Minute > authz.MaxLinkLifetime {
+			http.Error(w, "minutes must be 1 to "+strconv.Itoa(int(authz.MaxLinkLifetime/time.Minute)), http.StatusBadRequest)
+			return
+		}
+		views := 0
+		if v := r.FormValue("views"); v != "" {
+			if views, merr = strconv.Atoi(v); merr != nil || views < 0 {
+				http.Error(w, "views must be a number", http.StatusBadRequest)
+				return
+			}
+		}
+		id, err := newLinkSecret()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		key, err := newLinkSecret()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		link := authz.ShareLink{
+			ID:        id,
+			OrgID:     st.OrgID,
+			DocID:     doc,
+			Owner:     c.ID,
+			Relation:  "viewer",
+			ExpiresAt: time.Now().Add(time.Duration(minutes) * time.Minute),
+			KeyHash:   authz.LinkKeyHash(key),
+			MaxViews:  views,
+		}
+		if pw := r.FormValue("password"); pw != "" {
+			hash, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			link.PasswordHash = string(hash)
+		}
+		if err := gw.StartShareLink(r.Context(), link); err != nil {
+			fmt.Println("LINK-ERR: ", err)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		// The key is only ever shown here ..
+		u := demoBaseURL + "/demo/link/?" + url.Values{"id": {id}, "key": {key}}.Encode()
+		fmt.Fprint(w, "<html><h3><strong>Share link for "+html.EscapeString(doc)+"</strong></h3><div>"+
+			"<input readonly size=\"120\" value=\""+html.EscapeString(u)+"\"/><br/>"+
+			"Copy it now; it is not shown again. <a href=\"/demo/document/\">Back</a></div></html>")
+		return
+
+	case "revoke":
+		id := r.FormValue("link")
+		ls, err := gw.ShareLinkState(r.Context(), id)
+		if err != nil || ls.Link.DocID != doc {
+			http.Error(w, errLinkNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		if err := gw.RevokeShareLink(r.Context(), id, c.ID); err != nil {
+			fmt.Println("LINK-ERR: ", err)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		http.Redirect(w, r, "/demo/document/", http.StatusFound)
+		return
+	}
+	w.WriteHeader(http.StatusBadRequest)
+}
+
+// auditLink records what the link workflow does not see; failed opens ..
+func auditLink(ctx context.Context, action, actor string, link authz.ShareLink, why string) {
+	err := auditLog.Record(ctx, authz.AuditEvent{
+		OrgID:  link.OrgID,
+		Actor:  actor,
+		Action: action,
+		Object: "document:" + link.DocID,
+		Detail: map[string]string{"link": link.ID, "reason": why},
+	})
+	if err != nil {
+		fmt.Println("AUDIT-ERR: ", err)
+	}
+}
+
+func linkPasswordForm(id, key, csrf string) string {
+	return `<form method="post" action="/demo/link/">` +
+		`<input type="hidden" name="id" value="` + html.EscapeString(id) + `"/>` +
+		`<input type="hidden" name="key" value="` + html.EscapeString(key) + `"/>` +
+		`<input type="hidden" name="` + identity.CSRFField + `" value="` + html.EscapeString(csrf) + `"/>` +
+		`<input name="password" type="password" placeholder="password"/> ` +
+		`<button type="submit">Open</button></form>`
+}
+
+// linkHandler opens a link: key, password, the document still there and
+// the caller allowed as a holder; only then does the view count ..
+func linkHandler(w http.ResponseWriter, r *http.Request) {
+	sess := currentSession(r)
+	c := callerFrom(r)
+	id, key := r.FormValue("id"), r.FormValue("key")
+	if id == "" || key == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ls, err := gw.ShareLinkState(r.Context(), id)
+	if err != nil || authz.LinkKeyHash(key) != ls.Link.KeyHash {
+		// Unknown and wrong key look the same ..
+		http.Error(w, errLinkNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	link := ls.Link
+	if ls.Ended != "" || !time.Now().Before(link.ExpiresAt) {
+		auditLink(r.Context(), "link.refused", c.ID, link, "ended")
+		http.Error(w, authz.ErrLinkEnded.Error(), http.StatusGone)
+		return
+	}
+	if link.PasswordHash != "" {
+		if r.Method != http.MethodPost {
+			fmt.Fprint(w, "<html><h3><strong>This link needs a password</strong></h3><div>"+
+				linkPasswordForm(id, key, sess.CSRFToken)+"</div></html>")
+			return
+		}
+		if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(r.FormValue("password"))) != nil {
+			auditLink(r.Context(), "link.refused", c.ID, link, "password")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "<html><h3><strong>"+errLinkPassword.Error()+"</strong></h3><div>"+
+				linkPasswordForm(id, key, sess.CSRFToken)+"</div></html>")
+			return
+		}
+	}
+
+	st, err := loadDocument(r.Context(), link.OrgID, link.DocID)
+	if err == nil && st.Archived {
+		err = errDocNotFound
+	}
+	if err != nil {
+		http.Error(w, err.Error(), docErrorStatus(err))
+		return
+	}
+	ok, err := as.CheckLink(r.Context(), c.ID, id, link.Relation, link.DocID, c.RecentMFA)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	if !ok {
+		auditLink(r.Context(), "link.refused", c.ID, link, "not allowed")
+		http.Error(w, errDocForbidden.Error(), http.StatusForbidden)
+		return
+	}
+	used, err := gw.UseShareLink(r.Context(), id, authz.LinkUse{User: c.ID, Key: key})
+	if err != nil {
+		// Raced the last view or the expiry ..
+		fmt.Println("LINK-ERR: ", err)
+		http.Error(w, authz.ErrLinkEnded.Error(), http.StatusGone)
+		return
+	}
+	d, err := docs.Get(r.Context(), link.DocID)
+	if err = storeError(err); err != nil {
+		http.Error(w, err.Error(), docErrorStatus(err))
+		return
+	}
+	result := "<html><h3><strong>" + html.EscapeString(link.DocID) + "</strong></h3><div>" +
+		html.EscapeString(d.Content) + "</div><small>v" + strconv.Itoa(d.Version) + " by " +
+		html.EscapeString(d.Author) + "; shared by " + html.EscapeString(link.Owner) + ", view " + strconv.Itoa(used.Views)
+	if link.MaxViews > 0 {
+		result += " of " + strconv.Itoa(link.MaxViews)
+	}
+	fmt.Fprint(w, result+"</small></html>")
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"app/internal/authz"
+	"app/internal/codec"
+	"app/internal/identity"
 	"context"
 	"fmt"
 	"github.com/davecgh/go-spew/spew"
 	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/workflow"
 	"log"
 	"net/http"
 	"os"
@@ -20,12 +23,30 @@ func main() {
 
 var c client.Client
 var as authz.AuthStore
+var gw authz.Gateway
+var auditLog authz.AuditLog
 
 func init() {
 	// Singleton to OpenFGA; to be used by Workers too ..
 	apiURL := os.Getenv("FGA_API_URL")
 	// Load Store
 	as = authz.NewAuthStore(apiURL)
+	// Append-only audit trail ..
+	auditPath := os.Getenv("AUDIT_LOG_PATH")
+	if auditPath == "" {
+		auditPath = "audit.log"
+	}
+	auditLog = authz.NewFileAuditLog(auditPath)
+	// SSO; falls back to the mock IdP ..
+	setupLogin()
+	setupStores()
+	setupSAML()
+	setupSCIM()
+	setupOrgs()
+	setupDirectorySync()
+	setupRequestContext()
+	setupDocumentStore()
+	setupGuard()
 	//as.InitDemo("")
 }
 
@@ -34,7 +55,7 @@ func Run() {
 	// Create the Server using the new ServeMux
 	server := &http.Server{
 		Addr:    ":8888",
-		Handler: NewRouter(),
+		Handler: withRequestContext(NewRouter()),
 	}
 	// Running the HTTP server in a go routine
 	go func() {
@@ -45,18 +66,32 @@ func Run() {
 	// END HTTP Server ================>
 
 	// Create the Temporal client
-	var err error
-	c, err = client.NewLazyClient(client.Options{})
+	// Payloads are encrypted before they reach history ..
+	payloads, err := codec.FromEnv()
+	if err != nil {
+		log.Fatalln("Unable to create payload codec", err)
+	}
+	dc := codec.DataConverter(payloads)
+	// Caller's principal rides along into workflows + activities ..
+	c, err = client.NewLazyClient(client.Options{
+		DataConverter:      dc,
+		ContextPropagators: []workflow.ContextPropagator{identity.PrincipalPropagator{DataConverter: dc}},
+	})
 	if err != nil {
 		spew.Dump(err)
 		log.Fatalln("Unable to create Temporal client", err)
 	}
 	defer c.Close()
+	// All access to org workflows goes via the gateway ..
+	gw = authz.NewGateway(c, TQ, demoOrgInput)
+	// Same checks over gRPC; needs the gateway for grants ..
+	rpc := serveGRPC()
+	defer rpc.GracefulStop()
 
 	// Setup the Sanity Test Scenario ..
 	go SetupSimpleWorkflow(c)
 	// Actual Demo Scenario ..
-	go SetupActionWorkflow(c)
+	go SetupActionWorkflow(gw)
 
 	// Running the Temporal Worker in a go routine ..
 	go SetupTemporalWorker(c)
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"app/internal/identity"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP step-up; sensitive documents (confidential and up) need an MFA this
+// recent, passed to OpenFGA as a contextual tuple on every check ..
+// /demo/mfa/?next= -> enroll, or verify a code and go back to next
+
+const mfaMaxAge = 10 * time.Minute
+
+var mfa *identity.MFAManager
+
+// mfaRecent is whether checks for this session carry the mfa tuple ..
+func mfaRecent(sess identity.Session) bool {
+	return sess.MFARecent(time.Now(), mfaMaxAge)
+}
+
+// safeNext only goes back into this app; never //evil.example ..
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/demo/"
+	}
+	return next
+}
+
+// stepUpURL is the step-up page coming back to r afterwards ..
+func stepUpURL(r *http.Request) string {
+	return "/demo/mfa/?next=" + url.QueryEscape(r.URL.RequestURI())
+}
+
+func mfaCodeForm(action, next, label, csrf string) string {
+	return `<form method="post" action="/demo/mfa/">` +
+		`<input type="hidden" name="action" value="` + action + `"/>` +
+		`<input type="hidden" name="next" value="` + html.EscapeString(next) + `"/>` +
+		`<input type="hidden" name="` + identity.CSRFField + `" value="` + html.EscapeString(csrf) + `"/>` +
+		`<input name="code" autocomplete="one-time-code" placeholder="123456"/> ` +
+		`<button type="submit">` + html.EscapeString(label) + `</button></form>`
+}
+
+func mfaHandler(w http.ResponseWriter, r *http.Request) {
+	sess := currentSession(r)
+	next := safeNext(r.FormValue("next"))
+	result := "<html><h3><strong>MFA " + html.EscapeString(sess.UserID) + "</strong></h3><div>"
+
+	switch r.FormValue("action") {
+	case "":
+	case "enroll":
+		if !requirePost(w, r) {
+			return
+		}
+		secret, uri, err := mfa.Enroll(r.Context(), sess.UserID, sess.Email)
+		if err != nil {
+			fmt.Println("MFA-ERR: ", err)
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		result += "Add this to your authenticator app; it is not shown again.<br/>" +
+			"Key: <code>" + html.EscapeString(secret) + "</code><br/>" +
+			"Link: <code>" + html.EscapeString(uri) + "</code><br/>" +
+			mfaCodeForm("confirm", next, "Confirm", sess.CSRFToken) + "</div></html>"
+		fmt.Fprint(w, result)
+		return
+	case "confirm":
+		if !requirePost(w, r) {
+			return
+		}
+		codes, err := mfa.Confirm(r.Context(), sess.UserID, r.FormValue("code"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		// Proved it just now ..
+		if _, err := sessions.MarkMFA(r.Context(), sess); err != nil {
+			fmt.Println("MFA-ERR: ", err)
+		}
+		result += "Recovery codes; each works once if the phone is lost. Keep them safe:<br/><code>" +
+			html.EscapeString(strings.Join(codes, " ")) + "</code><br/>" +
+			`<a href="` + html.EscapeString(next) + `">Continue</a></div></html>`
+		fmt.Fprint(w, result)
+		return
+	case "verify":
+		if !requirePost(w, r) {
+			return
+		}
+		err := mfa.Verify(r.Context(), sess.UserID, r.FormValue("code"))
+		switch {
+		case errors.Is(err, identity.ErrMFALocked):
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		case err != nil:
+			fmt.Println("MFA-FAIL: ", sess.UserID, err)
+			http.Error(w, "invalid code", http.StatusUnauthorized)
+			return
+		}
+		if _, err := sessions.MarkMFA(r.Context(), sess); err != nil {
+			fmt.Println("MFA-ERR: ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, next, http.StatusFound)
+		return
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	enrolled, err := mfa.Enrolled(r.Context(), sess.UserID)
+	if err != nil {
+		fmt.Println("MFA-ERR: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !enrolled {
+		result += "This needs a second factor; set up an authenticator app first.<br/>" +
+			postButton("/demo/mfa/", url.Values{"action": {"enroll"}, "next": {next}}, "Set up", sess.CSRFToken)
+	} else {
+		if mfaRecent(sess) {
+			result += "Verified at " + sess.MFAAt.Format(time.RFC822) + "<br/>"
+		}
+		left, _ := mfa.RecoveryCodesLeft(r.Context(), sess.UserID)
+		result += "Code from your app, or a recovery code (" + fmt.Sprint(left) + " left)<br/>" +
+			mfaCodeForm("verify", next, "Verify", sess.CSRFToken)
+	}
+	result += "</div></html>"
+	fmt.Fprint(w, result)
+}
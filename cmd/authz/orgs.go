@@ -0,0 +1,292 @@
+package main
+
+import (
+	"app/internal/authz"
+	"app/internal/identity"
+	"app/internal/org"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// Organizations; members, roles + invitations. Membership is also the
+// organization tuples every document check is scoped by ..
+// /demo/org/?org=                    -> members, invite, change roles, remove
+//                                       owners rotate or destroy the document key
+// /demo/org/invitation/?token=       -> accept or decline an invitation
+
+var orgs *org.Manager
+
+// setupOrgs keeps orgs in Postgres when ORG_STORE=postgres (DATABASE_URL);
+// memory otherwise. ORG_INVITE_KEY (base64) signs invitation links; without
+// it links die with the process ..
+func setupOrgs() {
+	var repo org.Repository
+	switch os.Getenv("ORG_STORE") {
+	case "postgres":
+		pool, err := pgxpool.New(context.Background(), os.Getenv("DATABASE_URL"))
+		if err != nil {
+			log.Fatalln("Unable to connect org store", err)
+		}
+		pr, err := org.NewPostgresRepository(context.Background(), pool)
+		if err != nil {
+			log.Fatalln("Unable to create org store", err)
+		}
+		repo = pr
+	default:
+		repo = org.NewMemoryRepository()
+	}
+	key, _ := base64.StdEncoding.DecodeString(os.Getenv("ORG_INVITE_KEY"))
+	orgs = org.NewManager(repo, as, key)
+}
+
+// seedDemoOrg makes the demo org with its users; the model has to be in
+// first or the organization tuples are refused ..
+func seedDemoOrg() {
+	ctx := context.Background()
+	if err := as.DemoPrepareModel("../../openfga/models/direct-access.json"); err != nil {
+		fmt.Println("ORG-ERR: unable to load model", err)
+	}
+	if _, err := orgs.CreateOrg(ctx, orgID, orgID, "mleow"); err != nil && !errors.Is(err, org.ErrConflict) {
+		fmt.Println("ORG-ERR: ", err)
+		return
+	}
+	for _, user := range demoOrgInput(orgID).Users {
+		if err := orgs.EnsureMember(ctx, orgID, user, org.RoleMember); err != nil {
+			fmt.Println("ORG-ERR: ", user, err)
+		}
+	}
+	// alice is support; can impersonate members read-only ..
+	if err := as.AddOrgRole(orgID, "alice", "support"); err != nil {
+		fmt.Println("ORG-ERR: ", err)
+	}
+	// bob may break glass; mleow owns the org so is security too ..
+	if err := as.AddOrgRole(orgID, "bob", "break_glass"); err != nil {
+		fmt.Println("ORG-ERR: ", err)
+	}
+}
+
+func orgErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, org.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, org.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, org.ErrConflict), errors.Is(err, org.ErrLastOwner):
+		return http.StatusConflict
+	case errors.Is(err, org.ErrInvalidInvitation):
+		return http.StatusGone
+	}
+	return http.StatusBadRequest
+}
+
+// orgHandler shows the org; admins invite, change roles and remove ..
+func orgHandler(w http.ResponseWriter, r *http.Request) {
+	sess := currentSession(r)
+	id := r.FormValue("org")
+	if id == "" {
+		id = orgID
+	}
+	self := "/demo/org/?org=" + url.QueryEscape(id)
+	result := "<html><h3><strong>ORG " + html.EscapeString(id) + "</strong></h3>"
+
+	if action := r.FormValue("action"); action != "" {
+		if !requirePost(w, r) {
+			return
+		}
+		var err error
+		switch action {
+		case "create":
+			var o org.Organization
+			o, err = orgs.CreateOrg(r.Context(), r.FormValue("id"), r.FormValue("name"), sess.UserID)
+			self = "/demo/org/?org=" + url.QueryEscape(o.ID)
+		case "invite":
+			var token string
+			_, token, err = orgs.Invite(r.Context(), id, sess.UserID, r.FormValue("email"), r.FormValue("role"))
+			if err == nil {
+				// No mail yet; whoever invites passes the link on ..
+				link := demoBaseURL + "/demo/org/invitation/?token=" + url.QueryEscape(token)
+				result += "<div>Invitation link for " + html.EscapeString(r.FormValue("email")) +
+					": <code>" + html.EscapeString(link) + "</code></div>"
+			}
+		case "role":
+			err = orgs.SetRole(r.Context(), id, sess.UserID, r.FormValue("user"), r.FormValue("role"))
+		case "remove":
+			err = orgs.RemoveMember(r.Context(), id, sess.UserID, r.FormValue("user"))
+		case "revoke":
+			err = orgs.RevokeInvitation(r.Context(), id, sess.UserID, r.FormValue("invitation"))
+		case "rotate-key", "shred":
+			err = orgDocumentKey(r.Context(), id, sess.UserID, action, r.FormValue("confirm"))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			fmt.Println("ORG-ERR: ", err)
+			http.Error(w, err.Error(), orgErrorStatus(err))
+			return
+		}
+		if action != "invite" {
+			http.Redirect(w, r, self, http.StatusFound)
+			return
+		}
+	}
+
+	role, err := orgs.Role(r.Context(), id, sess.UserID)
+	if err != nil || role == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	members, err := orgs.Members(r.Context(), id)
+	if err != nil {
+		fmt.Println("ORG-ERR: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	admin := role == org.RoleOwner || role == org.RoleAdmin
+	result += "<div>You are " + html.EscapeString(role) + "<br/>"
+	for _, m := range members {
+		result += "<strong>" + html.EscapeString(m.User) + "</strong> " + html.EscapeString(m.Role)
+		if m.User == sess.UserID {
+			result += " " + postButton("/demo/org/", url.Values{"org": {id}, "action": {"remove"}, "user": {m.User}}, "Leave", sess.CSRFToken)
+		} else if admin {
+			for _, to := range []string{org.RoleOwner, org.RoleAdmin, org.RoleMember} {
+				if to != m.Role {
+					result += " " + postButton("/demo/org/", url.Values{"org": {id}, "action": {"role"}, "user": {m.User}, "role": {to}}, "Make "+to, sess.CSRFToken)
+				}
+			}
+			result += " " + postButton("/demo/org/", url.Values{"org": {id}, "action": {"remove"}, "user": {m.User}}, "Remove", sess.CSRFToken)
+		}
+		result += "<br/>"
+	}
+	result += "</div>"
+	if admin {
+		pending, perr := orgs.Invitations(r.Context(), id)
+		if perr != nil {
+			fmt.Println("ORG-ERR: ", perr)
+		}
+		result += "<div>"
+		for _, inv := range pending {
+			result += "Invited " + html.EscapeString(inv.Email) + " as " + html.EscapeString(inv.Role) +
+				" by " + html.EscapeString(inv.InvitedBy) + " until " + inv.ExpiresAt.Format("2006-01-02 15:04") + " " +
+				postButton("/demo/org/", url.Values{"org": {id}, "action": {"revoke"}, "invitation": {inv.ID}}, "Revoke", sess.CSRFToken) + "<br/>"
+		}
+		result += `</div><form method="post" action="/demo/org/">` +
+			`<input type="hidden" name="org" value="` + html.EscapeString(id) + `"/>` +
+			`<input type="hidden" name="action" value="invite"/>` +
+			`<input type="hidden" name="` + identity.CSRFField + `" value="` + html.EscapeString(sess.CSRFToken) + `"/>` +
+			`<input name="email" placeholder="Email"/> <select name="role">` +
+			`<option>member</option><option>admin</option><option>owner</option></select> ` +
+			`<button type="submit">Invite</button></form>`
+	}
+	if role == org.RoleOwner {
+		result += "<div>Document key: " +
+			postButton("/demo/org/", url.Values{"org": {id}, "action": {"rotate-key"}}, "Rotate", sess.CSRFToken) +
+			`<form method="post" action="/demo/org/">` +
+			`<input type="hidden" name="org" value="` + html.EscapeString(id) + `"/>` +
+			`<input type="hidden" name="action" value="shred"/>` +
+			`<input type="hidden" name="` + identity.CSRFField + `" value="` + html.EscapeString(sess.CSRFToken) + `"/>` +
+			`<input name="confirm" placeholder="Type the org ID"/> ` +
+			`<button type="submit">Destroy; all documents become unreadable</button></form></div>`
+	}
+	result += `<form method="post" action="/demo/org/">` +
+		`<input type="hidden" name="action" value="create"/>` +
+		`<input type="hidden" name="` + identity.CSRFField + `" value="` + html.EscapeString(sess.CSRFToken) + `"/>` +
+		`<input name="id" placeholder="New org ID"/> <input name="name" placeholder="Name"/> ` +
+		`<button type="submit">Create org</button></form></html>`
+	fmt.Fprint(w, result)
+}
+
+// orgDocumentKey rotates or destroys (crypto-shreds) the org's document key;
+// owners only and shredding needs the org ID typed in ..
+func orgDocumentKey(ctx context.Context, id, actor, action, confirm string) error {
+	role, err := orgs.Role(ctx, id, actor)
+	if err != nil {
+		return err
+	}
+	if role != org.RoleOwner {
+		return fmt.Errorf("%w: only owners manage the document key", org.ErrForbidden)
+	}
+	detail := map[string]string{}
+	switch action {
+	case "rotate-key":
+		version, rerr := docKeys.Rotate(ctx, id)
+		if rerr != nil {
+			return rerr
+		}
+		detail["version"] = strconv.Itoa(version)
+	case "shred":
+		if confirm != id {
+			return errors.New("type the org ID to confirm")
+		}
+		if err := docKeys.Shred(ctx, id); err != nil {
+			return err
+		}
+	}
+	err = auditLog.Record(ctx, authz.AuditEvent{
+		OrgID:  id,
+		Actor:  actor,
+		Action: "documentkey." + action,
+		Object: authz.OrgObject(id),
+		Detail: detail,
+	})
+	if err != nil {
+		fmt.Println("AUDIT-ERR: ", err)
+	}
+	return nil
+}
+
+// invitationHandler; the invited user (matched on their login's email)
+// accepts or declines ..
+func invitationHandler(w http.ResponseWriter, r *http.Request) {
+	sess := currentSession(r)
+	token := r.FormValue("token")
+	if action := r.FormValue("action"); action != "" {
+		if !requirePost(w, r) {
+			return
+		}
+		var err error
+		var id string
+		switch action {
+		case "accept":
+			var m org.Membership
+			m, err = orgs.Accept(r.Context(), token, sess.UserID, sess.Email)
+			id = m.OrgID
+		case "decline":
+			err = orgs.Decline(r.Context(), token, sess.UserID, sess.Email)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			fmt.Println("ORG-ERR: ", err)
+			http.Error(w, err.Error(), orgErrorStatus(err))
+			return
+		}
+		if id != "" {
+			http.Redirect(w, r, "/demo/org/?org="+url.QueryEscape(id), http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, "<html>Invitation declined</html>")
+		return
+	}
+	inv, err := orgs.Invitation(r.Context(), token)
+	if err != nil {
+		http.Error(w, "invitation is no longer valid", orgErrorStatus(err))
+		return
+	}
+	fmt.Fprint(w, "<html><h3><strong>INVITATION</strong></h3><div>"+
+		html.EscapeString(inv.InvitedBy)+" invited "+html.EscapeString(inv.Email)+" to "+html.EscapeString(inv.OrgID)+
+		" as "+html.EscapeString(inv.Role)+"<br/>"+
+		postButton("/demo/org/invitation/", url.Values{"token": {token}, "action": {"accept"}}, "Accept", sess.CSRFToken)+" "+
+		postButton("/demo/org/invitation/", url.Values{"token": {token}, "action": {"decline"}}, "Decline", sess.CSRFToken)+
+		"</div></html>")
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// Pages made from templates/; html/template does the escaping. Older pages
+// still build strings with html.EscapeString ..
+
+//go:embed templates/*
+var templateFS embed.FS
+
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"when": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format(time.RFC822)
+	},
+}).ParseFS(templateFS, "templates/*.html"))
+
+// render writes one of the templates; a half written page is all we can do
+// if it fails so just log it ..
+func render(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := templates.ExecuteTemplate(w, name, data); err != nil {
+		fmt.Println("TEMPLATE-ERR: ", name, err)
+	}
+}
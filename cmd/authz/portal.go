@@ -0,0 +1,213 @@
+package main
+
+import (
+	"app/internal/authz"
+	"app/internal/identity"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Self-service portal; what the user can open, what they could ask for, how
+// their asks are going and what access they had. Asking goes to the owner
+// through the document entity + the owner's inbox like the API does ..
+// GET  /demo/
+// POST /demo/ action=request&doc=..&reason=..
+// POST /demo/ action=withdraw&doc=..
+
+// portalHistoryMax; older access history is still in the entity ..
+const portalHistoryMax = 50
+
+type portalDoc struct {
+	ID             string
+	Owner          string
+	Classification string
+	// How the user has it e.g. owner, viewer, temporary ..
+	How   string
+	Until time.Time
+	// Pending is a request of the user's waiting on the owner ..
+	Pending bool
+}
+
+type portalRequest struct {
+	Doc, Owner, Reason string
+	Since              time.Time
+}
+
+type portalEvent struct {
+	authz.AccessEvent
+	Doc string
+}
+
+// accessWords is a history entry for the user; it is about them ..
+var accessWords = map[string]string{
+	authz.OpShare:           "shared with you",
+	authz.OpRequestAccess:   "you asked",
+	authz.OpApprove:         "granted",
+	authz.OpReject:          "refused",
+	authz.OpWithdraw:        "request withdrawn",
+	authz.OpTempGrant:       "granted for a while",
+	authz.OpRevoke:          "revoked",
+	authz.OpAdminRevoke:     "revoked by an admin",
+	authz.OpEmergencyGrant:  "emergency access",
+	authz.OpEmergencyRevoke: "emergency access ended",
+	"expire":                "expired",
+}
+
+// howAccess is why user can open it; the entity only knows direct grants,
+// groups + the org come from the model ..
+func howAccess(st authz.DocumentState, user string) (string, time.Time) {
+	switch {
+	case st.Doc.Owner == user:
+		return "owner", time.Time{}
+	case !st.TempGrants[user].IsZero():
+		return "temporary", st.TempGrants[user]
+	case !st.Emergency[user].IsZero():
+		return "emergency", st.Emergency[user]
+	case st.Grants[user] != "":
+		return st.Grants[user], time.Time{}
+	}
+	return "via group or org", time.Time{}
+}
+
+// requestedAt is when user last asked; zero if the history has moved on ..
+func requestedAt(history []authz.AccessEvent, user string) time.Time {
+	for _, e := range authz.AccessHistoryOf(history, user) {
+		if e.Op == authz.OpRequestAccess {
+			return e.At
+		}
+	}
+	return time.Time{}
+}
+
+// demoHandler is the portal ..
+func demoHandler(w http.ResponseWriter, r *http.Request) {
+	sess := currentSession(r)
+	c := callerFrom(r)
+	data := map[string]interface{}{
+		"User":      sess.UserID,
+		"CSRFField": identity.CSRFField,
+		"CSRFToken": sess.CSRFToken,
+	}
+
+	if action := r.FormValue("action"); action != "" {
+		if !requirePost(w, r) {
+			return
+		}
+		doc := r.FormValue("doc")
+		if doc == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var err error
+		switch action {
+		case "request":
+			if r.FormValue("reason") == "" {
+				http.Error(w, "say why you need it", http.StatusBadRequest)
+				return
+			}
+			err = portalRequestAccess(r, c, doc, r.FormValue("reason"))
+		case "withdraw":
+			err = withdrawDocumentRequest(r.Context(), c, doc)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), docErrorStatus(err))
+			return
+		}
+		http.Redirect(w, r, "/demo/", http.StatusFound)
+		return
+	}
+
+	viewable := map[string]bool{}
+	docs, err := as.ListDocuments(r.Context(), c.ID, "viewer")
+	if err != nil {
+		fmt.Println("PORTAL-ERR: ", err)
+		data["Error"] = "documents unavailable"
+	}
+	for _, doc := range docs {
+		viewable[doc] = true
+	}
+	tenantDocs, err := tenantDocuments(callerTenant(r))
+	if err != nil {
+		fmt.Println("PORTAL-ERR: ", err)
+		data["Error"] = "documents unavailable"
+	}
+	// Plus the tenant's documents they can not open yet ..
+	for _, doc := range tenantDocs {
+		if !viewable[doc] {
+			docs = append(docs, doc)
+		}
+	}
+	sort.Strings(docs)
+
+	var accessible, discoverable []portalDoc
+	var requests []portalRequest
+	var history []portalEvent
+	for _, doc := range docs {
+		st, err := loadDocument(r.Context(), c.Tenant, doc)
+		if err != nil {
+			if viewable[doc] {
+				accessible = append(accessible, portalDoc{ID: doc, How: "viewer"})
+			}
+			continue
+		}
+		pd := portalDoc{ID: doc, Owner: st.Doc.Owner, Classification: st.Classification}
+		_, pd.Pending = st.Pending[c.ID]
+		switch {
+		case viewable[doc]:
+			pd.How, pd.Until = howAccess(st, c.ID)
+			accessible = append(accessible, pd)
+		case authz.Discoverable(st):
+			discoverable = append(discoverable, pd)
+		}
+		if pd.Pending {
+			requests = append(requests, portalRequest{
+				Doc:    doc,
+				Owner:  st.Doc.Owner,
+				Reason: st.Pending[c.ID],
+				Since:  requestedAt(st.History, c.ID),
+			})
+		}
+		for _, e := range authz.AccessHistoryOf(st.History, c.ID) {
+			history = append(history, portalEvent{AccessEvent: e, Doc: doc})
+		}
+	}
+	sort.SliceStable(history, func(i, j int) bool { return history[i].At.After(history[j].At) })
+	if len(history) > portalHistoryMax {
+		history = history[:portalHistoryMax]
+	}
+	data["Accessible"] = accessible
+	data["Discoverable"] = discoverable
+	data["Requests"] = requests
+	data["History"] = history
+	data["Words"] = accessWords
+	// Inbox is still built as a string; it escapes what it prints ..
+	data["Approvals"] = template.HTML(renderPendingApprovers(r.Context(), c.Tenant, sess.UserID, sess.CSRFToken))
+	data["Admin"], _ = as.CheckOrg(r.Context(), sess.UserID, "admin", callerTenant(r))
+	render(w, "portal.html", data)
+}
+
+// portalRequestAccess is requestDocumentAccess for documents the portal
+// lists; unlisted ones are not found rather than forbidden ..
+func portalRequestAccess(r *http.Request, c docCaller, doc, reason string) error {
+	st, err := loadDocument(r.Context(), c.Tenant, doc)
+	if err != nil {
+		return err
+	}
+	if !authz.Discoverable(st) {
+		return errDocNotFound
+	}
+	ok, err := newTenantScope(callerTenant(r)).owns("document:" + doc)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errDocNotFound
+	}
+	return requestDocumentAccess(r.Context(), c, doc, reason)
+}
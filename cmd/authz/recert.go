@@ -0,0 +1,78 @@
+package main
+
+import (
+	"app/internal/authz"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Handlers for access recertification; owners keep or revoke grants ..
+// WorkflowID: recert-<orgID>-<scheduled time>
+
+const recertCron = "0 9 1 */3 *" // Quarterly ..
+const recertDeadline = time.Hour * 24 * 14
+
+func recertHandler(w http.ResponseWriter, r *http.Request) {
+	sess := currentSession(r)
+	campaignID, err := gw.RunningRecert(r.Context(), orgID)
+	if err != nil {
+		fmt.Println("RECERT-ERR: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if campaignID == "" {
+		fmt.Fprint(w, "<html>No access review running</html>")
+		return
+	}
+
+	if action := r.FormValue("action"); action != "" {
+		if !requirePost(w, r) {
+			return
+		}
+		switch action {
+		case authz.RecertKeep, authz.RecertRevoke:
+			err := gw.DecideRecert(r.Context(), campaignID, authz.RecertDecision{
+				Actor:  sess.UserID,
+				ItemID: r.FormValue("item"),
+				Keep:   action == authz.RecertKeep,
+			})
+			if err != nil {
+				fmt.Println("RECERT-ERR: ", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, "/demo/recert/", http.StatusFound)
+			return
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	report, err := gw.RecertStatus(r.Context(), campaignID)
+	if err != nil {
+		fmt.Println("RECERT-ERR: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	result := "<html><h3><strong>ACCESS REVIEW " + html.EscapeString(campaignID) + "</strong></h3>" +
+		"<div>Unanswered grants are revoked at " + report.Deadline.Format(time.RFC1123) + "</div><div>"
+	for _, item := range report.Items {
+		if item.Owner != sess.UserID {
+			continue
+		}
+		result += html.EscapeString(item.User) + " " + html.EscapeString(item.Relation) + " " + html.EscapeString(item.DocID)
+		if item.Outcome != "" {
+			result += " - " + html.EscapeString(item.Outcome) + "<br/>"
+			continue
+		}
+		result += " " + postButton("/demo/recert/", url.Values{"action": {authz.RecertKeep}, "item": {item.ID}}, "Keep", sess.CSRFToken) +
+			" " + postButton("/demo/recert/", url.Values{"action": {authz.RecertRevoke}, "item": {item.ID}}, "Revoke", sess.CSRFToken) + "<br/>"
+	}
+	result += "</div></html>"
+	fmt.Fprint(w, result)
+	return
+}
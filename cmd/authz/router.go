@@ -1,6 +1,10 @@
 package main
 
-import "net/http"
+import (
+	"app/internal/authz"
+	"app/internal/identity"
+	"net/http"
+)
 
 func NewRouter() *http.ServeMux {
 	// Create a new ServeMux
@@ -19,11 +23,59 @@ func NewRouter() *http.ServeMux {
 
 	// Attach handler function to the ServeMux
 	mux.HandleFunc("/", defaultHandler)
-	mux.HandleFunc("/demo/", demoHandler)
-	mux.HandleFunc("/demo/debug/", debugAccessHandler)
-	mux.HandleFunc("/demo/document/", documentHandler)
+	// Needs a session; POSTs need the CSRF token too ..
+	authed := requireSession
+	mux.Handle("/demo/", authed(demoHandler))
+	mux.Handle("/demo/debug/", authed(debugAccessHandler))
+	mux.Handle("/demo/document/", authed(documentHandler))
+	mux.Handle("/demo/inbox/", authed(inboxHandler))
+	mux.Handle("/demo/breakglass/", authed(breakGlassHandler))
+	mux.Handle("/demo/recert/", authed(recertHandler))
+	mux.Handle("/demo/logout/", authed(logoutHandler))
+	mux.Handle("/demo/lifecycle/", authed(lifecycleHandler))
+	mux.Handle("/demo/org/", authed(orgHandler))
+	mux.Handle("/demo/mfa/", authed(mfaHandler))
+	mux.Handle("/demo/impersonate/", authed(impersonateHandler))
+	mux.Handle("/demo/org/invitation/", authed(invitationHandler))
+	mux.Handle("/demo/links/", authedFor("owner", authz.DocumentFromQuery("doc"), linksHandler))
+	// Share links; still a signed in org member ..
+	mux.Handle("/demo/link/", authed(linkHandler))
+	// Admin console; tenant admins only ..
+	mux.Handle("/demo/admin/", requireTenantAdmin(adminHandler))
+	mux.Handle("/demo/admin/tuples", requireTenantAdmin(adminTuplesHandler))
+	mux.Handle("/demo/admin/models", requireTenantAdmin(adminModelsHandler))
+	mux.Handle("/demo/admin/grants", requireTenantAdmin(adminGrantsHandler))
+	mux.Handle("/demo/admin/matrix", requireTenantAdmin(adminMatrixHandler))
+	mux.Handle("/demo/admin/mfa", requireTenantAdmin(adminMFAHandler))
+	mux.Handle("/demo/apikeys/", requireTenantAdmin(apiKeysHandler))
 	mux.HandleFunc("/demo/login/", loginHandler)
-	mux.HandleFunc("/demo/logout/", logoutHandler)
+	mux.HandleFunc("/demo/login/start", loginStartHandler)
+	mux.HandleFunc("/demo/login/callback", loginCallbackHandler)
+	// Per tenant SAML; config page checks the session itself ..
+	mux.HandleFunc("/demo/saml/", samlHandler)
+
+	// Machine clients; bearer API key with the scope ..
+	mux.Handle("/api/v1/check", apiKeys.Require(identity.ScopeAuthzCheck, http.HandlerFunc(apiCheckHandler)))
+	mux.Handle("/api/v1/grants", apiKeys.Require(identity.ScopeAuthzGrant, http.HandlerFunc(apiGrantHandler)))
+	// Documents; API key or session, per route scopes ..
+	mux.HandleFunc(documentsPath, documentsAPI)
+	mux.HandleFunc(documentsPath+"/", documentsAPI)
+	mux.HandleFunc("/api/v1/openapi.json", openAPIHandler)
+	// IdP provisioning; tenant comes from the key ..
+	mux.Handle("/scim/v2/", apiKeys.Require(identity.ScopeSCIMProvision, http.StripPrefix("/scim/v2", scimServer)))
+
+	// Directory events; the signature is the auth ..
+	if dsyncWebhook != nil {
+		mux.Handle("/webhooks/dsync", dsyncWebhook)
+	}
+
+	// Offline IdP when no real one is configured ..
+	if mockIdP != nil {
+		mux.Handle("/mockidp/", http.StripPrefix("/mockidp", mockIdP))
+	}
+	if mockSAMLIdP != nil {
+		mux.Handle("/mocksaml/", http.StripPrefix("/mocksaml", mockSAMLIdP))
+	}
 
 	return mux
 }
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"app/internal/authz"
+	"app/internal/identity"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Handlers for per tenant SAML SSO ..
+// /demo/saml/<tenant>/login    -> AuthnRequest to the tenant's IdP
+// /demo/saml/<tenant>/acs      -> IdP posts the assertion here
+// /demo/saml/<tenant>/metadata -> our SP metadata for the IdP admin
+// /demo/saml/<tenant>/config   -> import the IdP's metadata (tenant admins)
+
+var samlMu sync.Mutex
+var samlTenants = map[string]*identity.SAMLServiceProvider{}
+
+// One SP signing key for the process; every tenant's metadata carries its cert ..
+var samlKey *rsa.PrivateKey
+var samlCert *x509.Certificate
+
+// mockSAMLIdP stands in for the demo tenant's IdP ..
+var mockSAMLIdP *identity.MockSAMLIdP
+
+func setupSAML() {
+	samlKey, samlCert = identity.NewSAMLSigningKey("authz-demo-sp")
+	mockSAMLIdP = identity.NewMockSAMLIdP(demoBaseURL+"/mocksaml",
+		identity.MockUser{Username: "mleow", Email: "mleow@gopherlab.example", Name: "Michael Leow", Groups: []string{"engineering", "admins"}},
+		identity.MockUser{Username: "bob", Email: "bob@gopherlab.example", Name: "Bob", Groups: []string{"finance"}},
+	)
+	md, err := identity.ParseIdPMetadata(mockSAMLIdP.Metadata())
+	if err != nil {
+		panic(err)
+	}
+	// The mock's users are the demo org's members by username ..
+	configureSAMLTenant(orgID, md, identity.SAMLAttributeMap{Username: "uid"})
+}
+
+func configureSAMLTenant(tenant string, md identity.IdPMetadata, attrs identity.SAMLAttributeMap) *identity.SAMLServiceProvider {
+	base := demoBaseURL + "/demo/saml/" + tenant
+	sp := identity.NewSAMLServiceProvider(identity.SAMLConfig{
+		TenantID:   tenant,
+		EntityID:   base + "/metadata",
+		ACSURL:     base + "/acs",
+		IdP:        md,
+		Attributes: attrs,
+		Key:        samlKey,
+		Cert:       samlCert,
+	})
+	samlMu.Lock()
+	samlTenants[tenant] = sp
+	samlMu.Unlock()
+	return sp
+}
+
+func samlHandler(w http.ResponseWriter, r *http.Request) {
+	tenant, op, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/demo/saml/"), "/")
+	samlMu.Lock()
+	sp := samlTenants[tenant]
+	samlMu.Unlock()
+	if op == "config" {
+		// Whoever sets the IdP decides who logs in to the tenant ..
+		requireSession(func(w http.ResponseWriter, r *http.Request) {
+			if adminOf(w, r, tenant) {
+				samlConfigHandler(w, r, tenant, sp)
+			}
+		}).ServeHTTP(w, r)
+		return
+	}
+	if sp == nil {
+		http.NotFound(w, r)
+		return
+	}
+	switch op {
+	case "login":
+		sp.StartLogin(w, r, "/demo/")
+	case "metadata":
+		w.Header().Set("Content-Type", "application/samlmetadata+xml")
+		w.Write(sp.Metadata())
+	case "acs":
+		user, returnTo, err := sp.ACS(r)
+		if err != nil {
+			fmt.Println("SAML-ERR: ", err)
+			http.Error(w, "login failed", http.StatusUnauthorized)
+			return
+		}
+		fmt.Println("LOGIN: ", user.ID, "via", user.Provider, "groups", user.Groups)
+		// Before the groups; they are tuples on the user too ..
+		if err := identity.ValidUserID(user.ID); err != nil {
+			http.Error(w, "login refused: "+err.Error(), http.StatusForbidden)
+			return
+		}
+		// A tenant's IdP only vouches for the tenant's own members ..
+		role, err := orgs.Role(r.Context(), tenant, user.ID)
+		if err != nil {
+			fmt.Println("LOGIN-ERR: ", err)
+			http.Error(w, "org store unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if role == "" {
+			http.Error(w, "login refused: not a member of "+tenant, http.StatusForbidden)
+			return
+		}
+		// IdP is the source of truth for group membership ..
+		groups := make([]string, 0, len(user.Groups))
+		for _, g := range user.Groups {
+			groups = append(groups, authz.GroupID(tenant, g))
+		}
+		if gerr := as.SyncGroupMembership(tenant, user.ID, groups); gerr != nil {
+			fmt.Println("SAML-GROUPS-ERR: ", gerr)
+		}
+		if _, err := sessions.Create(r.Context(), w, r, user); err != nil {
+			fmt.Println("LOGIN-ERR: ", err)
+			http.Error(w, "session store unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		http.Redirect(w, r, returnTo, http.StatusSeeOther)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// samlConfigHandler shows the SP details; POST imports IdP metadata ..
+func samlConfigHandler(w http.ResponseWriter, r *http.Request, tenant string, sp *identity.SAMLServiceProvider) {
+	sess := currentSession(r)
+	if r.Method == http.MethodPost {
+		md, err := identity.ParseIdPMetadata([]byte(r.PostFormValue("metadata")))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sp = configureSAMLTenant(tenant, md, identity.SAMLAttributeMap{})
+		fmt.Println("SAML: ", sess.UserID, "configured tenant", tenant, "IdP", md.EntityID)
+		auditAdmin(r.Context(), tenant, sess.UserID, "saml.configure", authz.OrgObject(tenant), map[string]string{"idp": md.EntityID})
+	}
+	result := "<html><h3><strong>SAML SSO " + html.EscapeString(tenant) + "</strong></h3><div>"
+	if sp != nil {
+		cfg := sp.Config()
+		result += "SP Entity ID: " + html.EscapeString(cfg.EntityID) + "<br/>" +
+			"ACS URL: " + html.EscapeString(cfg.ACSURL) + "<br/>" +
+			`<a href="/demo/saml/` + html.EscapeString(tenant) + `/metadata">SP metadata</a><br/>` +
+			"IdP: " + html.EscapeString(cfg.IdP.EntityID) + "<br/>"
+	} else {
+		result += "Not configured<br/>"
+	}
+	result += `</div><form method="post" action="/demo/saml/` + html.EscapeString(tenant) + `/config">` +
+		`<input type="hidden" name="` + identity.CSRFField + `" value="` + html.EscapeString(sess.CSRFToken) + `"/>` +
+		`<textarea name="metadata" rows="10" cols="80" placeholder="IdP metadata XML"></textarea><br/>` +
+		`<button type="submit">Import</button></form></html>`
+	fmt.Fprint(w, result)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"app/internal/authz"
+	"app/internal/identity"
+	"app/internal/scim"
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"log"
+	"os"
+)
+
+// SCIM provisioning; the tenant's IdP pushes users + groups with an API key
+// carrying scim:provision ..
+
+var scimRepo scim.Repository
+var scimServer *scim.Server
+
+// setupSCIM keeps identities in Postgres when SCIM_STORE=postgres (DATABASE_URL);
+// memory otherwise ..
+func setupSCIM() {
+	switch os.Getenv("SCIM_STORE") {
+	case "postgres":
+		pool, err := pgxpool.New(context.Background(), os.Getenv("DATABASE_URL"))
+		if err != nil {
+			log.Fatalln("Unable to connect SCIM store", err)
+		}
+		repo, err := scim.NewPostgresRepository(context.Background(), pool)
+		if err != nil {
+			log.Fatalln("Unable to create SCIM store", err)
+		}
+		scimRepo = repo
+	default:
+		scimRepo = scim.NewMemoryRepository()
+	}
+	scimServer = scim.NewServer(scimRepo, fgaProvisioner{}, demoBaseURL+"/scim/v2")
+}
+
+// fgaProvisioner turns SCIM changes into group tuples + deprovision workflows ..
+type fgaProvisioner struct{}
+
+func (fgaProvisioner) MembershipChanged(ctx context.Context, tenant, group string, added, removed []string) error {
+	id := authz.GroupID(tenant, group)
+	for _, user := range added {
+		if err := as.AddGroupMember(id, user); err != nil {
+			return err
+		}
+	}
+	for _, user := range removed {
+		// Already gone is fine; a retried request replays the same removes ..
+		if err := as.RemoveGroupMember(id, user); err != nil {
+			fmt.Println("SCIM-GROUP-ERR: ", group, user, err)
+		}
+	}
+	fmt.Println("SCIM: ", tenant, group, "added", added, "removed", removed)
+	return nil
+}
+
+func (fgaProvisioner) Deprovision(ctx context.Context, tenant, user string) error {
+	actor := "scim"
+	if p, ok := identity.PrincipalFrom(ctx); ok {
+		actor = p.ID
+	}
+	fmt.Println("SCIM: ", tenant, "deprovision", user, "by", actor)
+	if err := orgs.Evict(ctx, tenant, user); err != nil {
+		fmt.Println("SCIM-ORG-ERR: ", err)
+	}
+	return gw.StartDeprovision(ctx, authz.DeprovisionInput{
+		OrgID:  tenant,
+		User:   user,
+		Actor:  actor,
+		Reason: "deprovisioned by SCIM",
+	})
+}
+
+// scimUsers is the tenant's active provisioned users; nil until the IdP has
+// pushed any ..
+func scimUsers(tenant string) []string {
+	users, err := scimRepo.ListUsers(context.Background(), tenant)
+	if err != nil {
+		fmt.Println("SCIM-ERR: ", err)
+		return nil
+	}
+	var active []string
+	for _, u := range users {
+		if u.Active {
+			active = append(active, u.TupleUser())
+		}
+	}
+	return active
+}
@@ -1,54 +1,218 @@
 package main
 
 import (
+	"app/internal/identity"
+	"context"
+	"encoding/base64"
 	"fmt"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"html"
+	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
 )
 
 // Handlers to handle session ..
 
-// Login - show link for mleow, bob
-// redirect back to demo ..
-func loginHandler(w http.ResponseWriter, r *http.Request) {
+// Where we are reachable; the mock IdP issuer and callback hang off this ..
+const demoBaseURL = "http://localhost:8888"
+
+var loginRP *identity.RelyingParty
+
+// mockIdP is only set when no real IdP is configured ..
+var mockIdP *identity.MockIdP
 
-	// Settign login .. overrides
-	q := r.URL.Query()
-	if q.Has("ID") {
-		// Set Session cookie ...
-		http.SetCookie(w, &http.Cookie{
-			Name:   "ID",
-			Path:   "/",
-			Value:  q.Get("ID"),
-			MaxAge: 60 * 60, // 3600s == 1h
+var sessions *identity.SessionManager
+var apiKeys *identity.APIKeyManager
+
+// setupStores picks the KV for sessions + API keys from SESSION_STORE; memory
+// is the default: postgres (DATABASE_URL) or cloudflare (CF_* vars) survive restarts ..
+func setupStores() {
+	var store identity.Store
+	switch os.Getenv("SESSION_STORE") {
+	case "postgres":
+		pool, err := pgxpool.New(context.Background(), os.Getenv("DATABASE_URL"))
+		if err != nil {
+			log.Fatalln("Unable to connect session store", err)
+		}
+		ps, err := identity.NewPostgresStore(context.Background(), pool, "")
+		if err != nil {
+			log.Fatalln("Unable to create session store", err)
+		}
+		store = ps
+	case "cloudflare":
+		store = identity.NewCloudflareKVStore(identity.CloudflareKVConfig{
+			AccountID:   os.Getenv("CF_ACCOUNT_ID"),
+			NamespaceID: os.Getenv("CF_KV_NAMESPACE_ID"),
+			APIToken:    os.Getenv("CF_API_TOKEN"),
 		})
-		http.Redirect(w, r, "/demo/", http.StatusFound)
-		return
+	default:
+		store = identity.NewMemoryStore()
+	}
+	// Same key across replicas or cookies from one are junk to the other ..
+	key, _ := base64.StdEncoding.DecodeString(os.Getenv("SESSION_KEY"))
+	sessions = identity.NewSessionManager(store, identity.SessionConfig{
+		CookieName:      "session",
+		Key:             key,
+		IdleTimeout:     30 * time.Minute,
+		AbsoluteTimeout: 8 * time.Hour,
+		Secure:          strings.HasPrefix(demoBaseURL, "https://"),
+		LoginURL:        "/demo/login/",
+	})
+	apiKeys = identity.NewAPIKeyManager(store)
+	mfa = identity.NewMFAManager(store, "GopherLab Authz")
+}
+
+// requireSession needs a login; the user also becomes the Principal so it
+// flows into workflow starts the same way an API key's service does.
+// Impersonated sessions carry the support user along too ..
+func requireSession(h http.HandlerFunc) http.Handler {
+	return sessions.Require(sessionPrincipal(h))
+}
+
+// requireSessionAPI is requireSession for JSON; 401 rather than the login page ..
+func requireSessionAPI(h http.HandlerFunc) http.Handler {
+	return sessions.RequireAPI(sessionPrincipal(h))
+}
+
+func sessionPrincipal(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s := currentSession(r)
+		r = withDevice(r, s)
+		// Support acting as the user; audited, maybe read-only ..
+		if s.Impersonating() {
+			var ok bool
+			if w, ok = guardImpersonation(w, r, s); !ok {
+				return
+			}
+		}
+		h(w, r.WithContext(identity.WithPrincipal(r.Context(), identity.Principal{
+			TenantID:     orgID,
+			ID:           s.UserID,
+			Kind:         "user",
+			Impersonator: s.Impersonator,
+		})))
 	}
+}
+
+// currentSession is set by sessions.Require on every route behind it ..
+func currentSession(r *http.Request) identity.Session {
+	s, _ := identity.SessionFrom(r.Context())
+	return s
+}
+
+// postButton is a one button form; state changes are never plain links ..
+func postButton(action string, values url.Values, label, csrf string) string {
+	result := `<form method="post" action="` + html.EscapeString(action) + `" style="display:inline">`
+	for k, vs := range values {
+		for _, v := range vs {
+			result += `<input type="hidden" name="` + html.EscapeString(k) + `" value="` + html.EscapeString(v) + `"/>`
+		}
+	}
+	result += `<input type="hidden" name="` + identity.CSRFField + `" value="` + html.EscapeString(csrf) + `"/>` +
+		`<button type="submit">` + html.EscapeString(label) + `</button></form>`
+	return result
+}
+
+// requirePost refuses state changes over GET ..
+func requirePost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
 
-	// If get this far .. then default page .. ignore any cookie ..
-	// DEBUG
-	//spew.Dump(r.Cookies())
-	//_, err := r.Cookie("ID")
-	//if err != nil {
-	//	if errors.Is(err, http.ErrNoCookie) {
-	//		// Output link to login here ..
-	//		fmt.Fprintf(w, "Choose Login users ...")
-	//		return
-	//	}
-	//}
+// setupLogin picks the IdP: WorkOS AuthKit, any OIDC issuer, else the
+// in-process mock IdP mounted at /mockidp/ so the demo runs offline.
+// The mock lets anyone log in as anyone, so it needs MOCK_IDP=1 ..
+func setupLogin() {
+	redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+	if redirectURL == "" {
+		redirectURL = demoBaseURL + "/demo/login/callback"
+	}
+	if os.Getenv("WORKOS_CLIENT_ID") != "" {
+		loginRP = identity.NewRelyingParty(identity.NewWorkOSProvider(identity.WorkOSConfig{
+			ClientID:    os.Getenv("WORKOS_CLIENT_ID"),
+			APIKey:      os.Getenv("WORKOS_API_KEY"),
+			RedirectURL: redirectURL,
+		}))
+		return
+	}
+	cfg := identity.OIDCConfig{
+		Issuer:       os.Getenv("OIDC_ISSUER"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  redirectURL,
+	}
+	if cfg.Issuer == "" {
+		if os.Getenv("MOCK_IDP") != "1" {
+			log.Fatalln("No IdP configured: set OIDC_ISSUER or WORKOS_CLIENT_ID (MOCK_IDP=1 for local runs)")
+		}
+		fmt.Println("MOCK_IDP set; anyone can log in as any demo user!!")
+		cfg.Issuer = demoBaseURL + "/mockidp"
+		cfg.ClientID = "authz-demo"
+		cfg.ClientSecret = "mock-secret" // Only ever talks to itself ..
+		mockIdP = identity.NewMockIdP(cfg.Issuer, cfg.ClientID, cfg.ClientSecret,
+			identity.MockUser{Username: "mleow", Email: "mleow@example.com", Name: "Michael Leow", DeviceTrusted: true},
+			identity.MockUser{Username: "bob", Email: "bob@example.com", Name: "Bob"},
+			identity.MockUser{Username: "alice", Email: "alice@example.com", Name: "Alice"},
+		)
+		cfg.UserIDs = mockIdP.UserIDs()
+	}
+	loginRP = identity.NewRelyingParty(identity.NewOIDCProvider(cfg))
+}
 
+// Login - show link to SSO; a session only ever starts after a verified login ..
+func loginHandler(w http.ResponseWriter, r *http.Request) {
 	s := `
 <html>
 	<body>
 	<demoHandler>Demo Login</demoHandler>
 	<div>
-		<a href="/demo/login/?ID=mleow">Login mleow</a><br/>
-		<a href="/demo/login/?ID=bob">Login bob</a><br/>
+		<a href="/demo/login/start">Login with %s</a><br/>
+		<a href="/demo/saml/%s/login">Login with %s SAML SSO</a><br/>
 	</div>
 	</body>
 </html>
 `
-	fmt.Fprintf(w, s)
+	fmt.Fprintf(w, s, loginRP.Provider().Name(), orgID, orgID)
+	return
+}
+
+// loginStartHandler sends the browser off to the IdP ..
+func loginStartHandler(w http.ResponseWriter, r *http.Request) {
+	loginRP.StartLogin(w, r, "/demo/")
+}
+
+// loginCallbackHandler is where the IdP sends the browser back to ..
+func loginCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	user, returnTo, err := loginRP.Callback(w, r)
+	if err != nil {
+		fmt.Println("LOGIN-ERR: ", err)
+		http.Error(w, "login failed", http.StatusUnauthorized)
+		return
+	}
+	// Only ever go back somewhere local ..
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") {
+		returnTo = "/demo/"
+	}
+	fmt.Println("LOGIN: ", user.ID, "via", user.Provider, "sub", user.Subject)
+	if err := identity.ValidUserID(user.ID); err != nil {
+		http.Error(w, "login refused: "+err.Error(), http.StatusForbidden)
+		return
+	}
+	// New session ID every login; any old one is dropped ..
+	if _, err := sessions.Create(r.Context(), w, r, user); err != nil {
+		fmt.Println("LOGIN-ERR: ", err)
+		http.Error(w, "session store unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	http.Redirect(w, r, returnTo, http.StatusFound)
 	return
 }
 
@@ -56,15 +220,12 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 // Ask for owner approval .. pending ..
 // See public doc ..
 
-// Logout - clear session ..
+// Logout - kill session server side; not just the cookie ..
 func logoutHandler(w http.ResponseWriter, r *http.Request) {
-	// Unset Session cookie ...
-	http.SetCookie(w, &http.Cookie{
-		Name:   "ID",
-		Path:   "/",
-		Value:  "",
-		MaxAge: -1, // Delete cookie now ..
-	})
+	if !requirePost(w, r) {
+		return
+	}
+	sessions.Destroy(r.Context(), w, r)
 	http.Redirect(w, r, "/demo/login/", http.StatusSeeOther)
 	return
 }
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"go.temporal.io/sdk/client"
 	"log"
+	"strings"
 )
 
 const TQ = "example-task-queue"
@@ -17,14 +18,12 @@ func SetupSimpleWorkflow(c client.Client) {
 	orgID := "CrabLab"
 	docsInit := []authz.Document{
 		authz.Document{
-			ID:      "public/welcome.doc",
-			Owner:   "",
-			Content: "All Open!",
+			ID:    "public/welcome.doc",
+			Owner: "",
 		},
 		authz.Document{
-			ID:      "secret/secretz.doc",
-			Owner:   "bob",
-			Content: "Secretz",
+			ID:    "secret/secretz.doc",
+			Owner: "bob",
 		},
 	}
 	// DEBUG
@@ -62,67 +61,74 @@ func SetupSimpleWorkflow(c client.Client) {
 	return
 }
 
-// SetupActionWorkflow demos an action happening .. and signalling ..
-func SetupActionWorkflow(c client.Client) {
-	// Start Workflow for Org GopherLab
-	// With below combos ..
-	orgID := "GopherLab"
+// demoOrgInput is the seed for any org brought up lazily by the gateway ..
+func demoOrgInput(orgID string) authz.WFDemoInput {
 	docsInit := []authz.Document{
 		authz.Document{
-			ID:      "public/welcome.doc",
-			Owner:   "",
-			Content: "All Open!",
+			ID:    "public/welcome.doc",
+			Owner: "",
 		},
 		authz.Document{
-			ID:      "secret/secretz.doc",
-			Owner:   "bob",
-			Content: "Secretz",
+			ID:    "secret/secretz.doc",
+			Owner: "bob",
 		},
 		authz.Document{
-			ID:      "secret/salary.doc",
-			Owner:   "mleow",
-			Content: "Lotsa Moolah!!",
+			ID:    "secret/salary.doc",
+			Owner: "mleow",
 		},
 	}
-	usersInit := []string{"bob", "mleow"}
-	// DEBUG
-	//spew.Dump(docsInit)
+	// Provisioned users if the IdP has pushed any; else the demo pair ..
+	usersInit := scimUsers(orgID)
+	if len(usersInit) == 0 {
+		usersInit = []string{"bob", "mleow"}
+	}
+	return authz.WFDemoInput{
+		Name:  orgID,
+		Users: usersInit,
+		Docs:  docsInit,
+	}
+}
 
+// SetupActionWorkflow demos an action happening .. and signalling ..
+// Goes via the gateway so a request arriving first is not a problem ..
+func SetupActionWorkflow(gw authz.Gateway) {
 	fmt.Println("Start Temporal Workflow ==> ActionWorkflow")
-	// Start the workflow - ActionWorkflow
-	workflowOptions := client.StartWorkflowOptions{
-		ID:        orgID,
-		TaskQueue: TQ,
-	}
-	we, err := c.ExecuteWorkflow(context.Background(), workflowOptions,
-		authz.ActionWorkflow,
-		authz.WFDemoInput{
-			Users: usersInit,
-			Docs:  docsInit,
-		})
+	// Documents are scoped to the org; members need to be in before any check ..
+	seedDemoOrg()
+	// Start the workflow - ActionWorkflow; or get back the running one ..
+	we, err := gw.EnsureOrg(context.Background(), orgID)
 	if err != nil {
 		log.Fatalln("Unable to execute workflow", err)
 	}
-	fmt.Print("Started workflow for Org ", orgID, " RunID: ", we.GetRunID())
-	// Below for DEBUG of flow; shoul all be triggred in the handler !!!
-	// =================================>
-	//// Delay to send action signal ..
-	//time.Sleep(time.Second * 15)
-	//saerr := c.SignalWorkflow(context.Background(), orgID, we.GetRunID(), "actionSignal", authz.Actions{
-	//	GetAdminElevated: true,
-	//})
-	//if saerr != nil {
-	//	log.Fatalln("Unable to signal workflow", saerr)
-	//}
-	//// Delay ,.. then terminate ...
-	//time.Sleep(time.Second * 30)
-	//fmt.Println("AFTER 30s!!! =====>> ****")
-	//serr := c.SignalWorkflow(context.Background(), orgID, we.GetRunID(), "terminateSignal", authz.Actions{
-	//	GetAdminElevated: true,
-	//})
-	//if serr != nil {
-	//	log.Fatalln("Unable to signal workflow", serr)
-	//}
+	fmt.Println("Started workflow for Org ", orgID, " ID: ", we.GetID(), " RunID: ", we.GetRunID())
 
+	// Each document gets its own entity workflow; WorkflowID: doc-<orgID>/<docID>
+	for _, doc := range demoOrgInput(orgID).Docs {
+		class := "secret"
+		if strings.HasPrefix(doc.ID, "public/") {
+			class = "public"
+		}
+		// Content first; the workflow only gets told the version ..
+		version, err := seedContent(context.Background(), orgID, doc.ID, doc.Owner)
+		if err != nil {
+			fmt.Println("Unable to store content for", doc.ID, "ERR:", err)
+			continue
+		}
+		err = gw.SignalDocument(context.Background(), orgID, doc.ID, authz.DocumentCommand{
+			Op:             authz.OpCreate,
+			Actor:          doc.Owner,
+			Version:        version,
+			Classification: class,
+		})
+		if err != nil {
+			fmt.Println("Unable to start document workflow for", doc.ID, "ERR:", err)
+		}
+	}
+
+	// Periodic access reviews for the tenant ..
+	rerr := gw.EnsureRecertSchedule(context.Background(), orgID, recertCron, recertDeadline)
+	if rerr != nil {
+		fmt.Println("Unable to schedule recertification ERR:", rerr)
+	}
 	return
 }
@@ -2,9 +2,14 @@ package main
 
 import (
 	"app/internal/authz"
+	"app/internal/notify"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
 	"go.temporal.io/sdk/client"
 	"go.temporal.io/sdk/worker"
+	"log"
 	"os"
 	"os/signal"
 	"syscall"
@@ -18,12 +23,32 @@ func SetupTemporalWorker(c client.Client) {
 	// If do not rgister Workflow + activity .. it will just be "hanging" ...
 	w.RegisterWorkflow(authz.SimpleWorkflow)
 	w.RegisterWorkflow(authz.ActionWorkflow)
+	w.RegisterWorkflow(authz.DocumentWorkflow)
+	w.RegisterWorkflow(authz.ApproverInboxWorkflow)
+	w.RegisterWorkflow(authz.BreakGlassWorkflow)
+	w.RegisterWorkflow(authz.RecertificationWorkflow)
+	w.RegisterWorkflow(authz.DeprovisionWorkflow)
+	w.RegisterWorkflow(authz.LifecycleWorkflow)
+	w.RegisterWorkflow(authz.ShareLinkWorkflow)
 	w.RegisterActivity(authz.GreetActivity)
+	// NOTIFY_* picks the channels; without any it prints ..
+	notifier, err := notify.FromEnv()
+	if err != nil {
+		log.Fatalln("Unable to set up notifications", err)
+	}
 	// Important: How to register activities with deps ..
-	activities := &authz.Activities{as}
+	activities := &authz.Activities{
+		As:           as,
+		Gateway:      gw,
+		Audit:        auditLog,
+		ReportSigner: reportSigningKey(),
+		Policies:     contextPolicies,
+		Docs:         docs,
+		Notifier:     notifier,
+	}
 	w.RegisterActivity(activities)
 
-	err := w.Start()
+	err = w.Start()
 	if err != nil {
 		fmt.Println("Worker error:", err)
 	}
@@ -40,3 +65,16 @@ func SetupTemporalWorker(c client.Client) {
 	fmt.Println("Stopping Temporal Worker...")
 	w.Stop()
 }
+
+// reportSigningKey is the Ed25519 seed (base64) for signing recertification reports ..
+func reportSigningKey() ed25519.PrivateKey {
+	seed, err := base64.StdEncoding.DecodeString(os.Getenv("REPORT_SIGNING_KEY"))
+	if err == nil && len(seed) == ed25519.SeedSize {
+		return ed25519.NewKeyFromSeed(seed)
+	}
+	// Dev only; reports will not verify across restarts ..
+	fmt.Println("REPORT_SIGNING_KEY not set; using an ephemeral key!!")
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	fmt.Println("Report verification key:", base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)))
+	return key
+}
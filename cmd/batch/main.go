@@ -12,6 +12,8 @@ import (
 
 	"app/internal/batch"
 	"app/internal/batch/service"
+	"app/internal/codec"
+	"app/internal/notify"
 
 	"go.temporal.io/sdk/client"
 	"go.temporal.io/sdk/worker"
@@ -31,13 +33,25 @@ func Run() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	// Payloads (script inputs + output) are encrypted before they reach history
+	payloads, err := codec.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to create payload codec: %v", err)
+	}
+
 	// Create Temporal client
-	c, err := client.NewLazyClient(client.Options{})
+	c, err := client.NewLazyClient(client.Options{DataConverter: codec.DataConverter(payloads)})
 	if err != nil {
 		log.Fatalf("Failed to create Temporal client: %v", err)
 	}
 	defer c.Close()
 
+	// Script failures reach whoever asked via NotifyOnFailure
+	notifier, err := notify.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to set up notifications: %v", err)
+	}
+
 
 
 	// Create worker variable to track worker instance
@@ -53,6 +67,7 @@ func Run() {
 		w.RegisterActivity(batch.Scenario1b)
 		w.RegisterActivity(batch.Scenario2a)
 		w.RegisterActivity(batch.Scenario2b)
+		w.RegisterActivity(&notify.Activities{Service: notifier})
 
 		// Register Nexus service
 		if err := service.RegisterNexusService(w); err != nil {
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"app/internal/codec"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Codec server for the Temporal UI; decodes payloads for operators only.
+// Point the UI's codec endpoint here and have it pass the access token ..
+// PAYLOAD_KEYS       same keys as the workers (see codec.ParseKeyring)
+// PAYLOAD_DEV_KEY    1 to use the dev key when PAYLOAD_KEYS is unset; local only
+// CODEC_TOKENS       bearer tokens of operators; comma separated
+// CODEC_ORIGINS      UI origins; default http://localhost:8233
+// CODEC_ADDR         default :8081
+func main() {
+	fmt.Println("Temporal payload codec server ..")
+	c, err := codec.FromEnv()
+	if err != nil {
+		log.Fatalln("Unable to create payload codec", err)
+	}
+	tokens := split(os.Getenv("CODEC_TOKENS"))
+	if len(tokens) == 0 {
+		fmt.Println("CODEC_TOKENS not set; nobody can decode!!")
+	}
+	origins := split(os.Getenv("CODEC_ORIGINS"))
+	if len(origins) == 0 {
+		origins = []string{"http://localhost:8233"}
+	}
+	addr := os.Getenv("CODEC_ADDR")
+	if addr == "" {
+		addr = ":8081"
+	}
+	server := &http.Server{
+		Addr:    addr,
+		Handler: codec.Server(c, codec.ServerOptions{Tokens: tokens, Origins: origins}),
+	}
+	log.Println("Codec server on", addr)
+	if err := server.ListenAndServe(); err != nil {
+		log.Fatalln("Server error:", err)
+	}
+}
+
+func split(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
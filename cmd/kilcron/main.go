@@ -1,6 +1,7 @@
 package main
 
 import (
+	"app/internal/codec"
 	"context"
 	"fmt"
 	"github.com/davecgh/go-spew/spew"
@@ -49,8 +50,12 @@ func Run() {
 	}()
 	// END HTTP Server ================>
 	// Create the Temporal client
-	var err error
-	c, err = client.NewLazyClient(client.Options{})
+	// Payloads are encrypted before they reach history ..
+	payloads, err := codec.FromEnv()
+	if err != nil {
+		log.Fatalln("Unable to create payload codec", err)
+	}
+	c, err = client.NewLazyClient(client.Options{DataConverter: codec.DataConverter(payloads)})
 	if err != nil {
 		spew.Dump(err)
 		log.Fatalln("Unable to create Temporal client", err)
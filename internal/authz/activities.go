@@ -1,13 +1,32 @@
 package authz
 
 import (
+	"app/internal/docstore"
+	"app/internal/notify"
 	"context"
+	"crypto/ed25519"
+	"errors"
 	"fmt"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"strings"
 	"time"
 )
 
 type Activities struct {
 	As AuthStore
+	// Gateway lets activities reach other entity workflows ..
+	Gateway Gateway
+	// Audit is where every sensitive step is written ..
+	Audit AuditLog
+	// ReportSigner signs recertification reports for auditors ..
+	ReportSigner ed25519.PrivateKey
+	// Policies put documents under request context conditions by prefix ..
+	Policies []ContextPolicy
+	// Docs holds the content; workflows only ever see the IDs ..
+	Docs docstore.DocumentStore
+	// Notifier sends NotifyActivity's messages; the log if nil ..
+	Notifier *notify.Service
 }
 
 // GreetActivity .. is dummy activity ..
@@ -44,3 +63,241 @@ func (a *Activities) RemoveAccessActivity(ctx context.Context, user, document st
 	}
 	return nil
 }
+
+// AccessChange is a single tuple change requested by an entity workflow ..
+type AccessChange struct {
+	User     string
+	Relation string
+	Document string
+}
+
+// GrantAccessActivity writes the relation tuple ..
+func (a *Activities) GrantAccessActivity(ctx context.Context, change AccessChange) error {
+	fmt.Println("Inside GrantAccessActivity ..", change.User, change.Relation, change.Document)
+	return a.As.AddRelationship(change.User, change.Relation, change.Document)
+}
+
+// ClaimDocumentActivity refuses a create for an ID another org already has;
+// document IDs are global in OpenFGA and the docstore alike ..
+func (a *Activities) ClaimDocumentActivity(ctx context.Context, orgID, document string) error {
+	tuples, _, err := a.As.ReadTuples("", "org", "document:"+document, "", 10)
+	if err != nil {
+		return err
+	}
+	for _, t := range tuples {
+		if t.User != OrgObject(orgID) {
+			return temporal.NewNonRetryableApplicationError("document ID is taken by another org", "DocumentTakenError", nil)
+		}
+	}
+	return nil
+}
+
+// RestrictDocumentActivity puts the document under the policy covering it;
+// returns the policy name, empty if none does ..
+func (a *Activities) RestrictDocumentActivity(ctx context.Context, document string) (string, error) {
+	policy, ok := PolicyFor(a.Policies, document)
+	if !ok {
+		return "", nil
+	}
+	fmt.Println("Inside RestrictDocumentActivity ..", document, policy.Name)
+	return policy.Name, a.As.RestrictDocument(document, policy)
+}
+
+// DeleteContentActivity soft deletes the content of an archived document;
+// the versions stay for audit. Already gone is done ..
+func (a *Activities) DeleteContentActivity(ctx context.Context, document, actor string) error {
+	fmt.Println("Inside DeleteContentActivity ..", document, actor)
+	if a.Docs == nil {
+		return nil
+	}
+	err := a.Docs.Delete(ctx, document, actor, "")
+	if errors.Is(err, docstore.ErrDeleted) || errors.Is(err, docstore.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// RevokeAccessActivity deletes the relation tuple ..
+func (a *Activities) RevokeAccessActivity(ctx context.Context, change AccessChange) error {
+	fmt.Println("Inside RevokeAccessActivity ..", change.User, change.Relation, change.Document)
+	return a.As.RemoveRelationship(change.User, change.Relation, change.Document)
+}
+
+// GroupChange is one group membership tuple; Group is from GroupID ..
+type GroupChange struct {
+	Group string
+	User  string
+}
+
+// AddGroupMemberActivity writes the member tuple ..
+func (a *Activities) AddGroupMemberActivity(ctx context.Context, change GroupChange) error {
+	fmt.Println("Inside AddGroupMemberActivity ..", change.User, change.Group)
+	return a.As.AddGroupMember(change.Group, change.User)
+}
+
+// RemoveGroupMemberActivity deletes the member tuple ..
+func (a *Activities) RemoveGroupMemberActivity(ctx context.Context, change GroupChange) error {
+	fmt.Println("Inside RemoveGroupMemberActivity ..", change.User, change.Group)
+	if err := a.As.RemoveGroupMember(change.Group, change.User); err != nil {
+		// Most likely already gone; nothing more to do ..
+		fmt.Println("Error removing group member. ERR:", err)
+	}
+	return nil
+}
+
+// SignalInboxActivity delivers the command to the approver's inbox; starting it if needed ..
+func (a *Activities) SignalInboxActivity(ctx context.Context, orgID, approver string, cmd InboxCommand) error {
+	return a.Gateway.SignalInbox(ctx, orgID, approver, cmd)
+}
+
+// NotifyActivity tells a person something on the channels they picked;
+// Temporal retries, the key stops a retry sending twice ..
+func (a *Activities) NotifyActivity(ctx context.Context, n Notification) error {
+	if a.Notifier == nil {
+		fmt.Println("NOTIFY:", n.To, "SUBJECT:", n.Subject, "BODY:", n.Body)
+		return nil
+	}
+	return notify.Deliver(ctx, a.Notifier, notify.Message{
+		Key:     n.Key,
+		To:      n.To,
+		Kind:    n.Kind,
+		Data:    n.Data,
+		Subject: n.Subject,
+		Body:    n.Body,
+	})
+}
+
+// RecordAuditActivity writes the event to the audit log; stamped with the calling workflow ..
+func (a *Activities) RecordAuditActivity(ctx context.Context, e AuditEvent) error {
+	info := activity.GetInfo(ctx)
+	e.WorkflowID = info.WorkflowExecution.ID
+	e.RunID = info.WorkflowExecution.RunID
+	if a.Audit == nil {
+		fmt.Println("AUDIT:", e.Action, e.Actor, e.Object, e.Detail)
+		return nil
+	}
+	return a.Audit.Record(ctx, e)
+}
+
+// ListGrantsActivity finds every non-owner viewer / editor grant on the
+// org's documents ..
+func (a *Activities) ListGrantsActivity(ctx context.Context, orgID string) ([]Grant, error) {
+	docs, err := a.As.OrgDocuments(orgID)
+	if err != nil {
+		return nil, err
+	}
+	var tuples []Tuple
+	for i, doc := range docs {
+		page, err := a.As.DocumentTuples(doc)
+		if err != nil {
+			return nil, err
+		}
+		tuples = append(tuples, page...)
+		activity.RecordHeartbeat(ctx, i)
+	}
+	owners := map[string]string{}
+	for _, t := range tuples {
+		if t.Relation == "owner" {
+			owners[t.Object] = strings.TrimPrefix(t.User, "user:")
+		}
+	}
+	var grants []Grant
+	for _, t := range tuples {
+		if t.Relation != "viewer" && t.Relation != "editor" {
+			continue
+		}
+		owner, ok := owners[t.Object]
+		user := strings.TrimPrefix(t.User, "user:")
+		// Nobody to ask for unowned docs; owner's own access is not under review ..
+		if !ok || user == owner {
+			continue
+		}
+		grants = append(grants, Grant{
+			DocID:    strings.TrimPrefix(t.Object, "document:"),
+			User:     user,
+			Relation: t.Relation,
+			Owner:    owner,
+		})
+	}
+	return grants, nil
+}
+
+// ListUserTuplesActivity is what the user holds directly in the org; its
+// documents + groups. Other orgs' are theirs to deprovision ..
+func (a *Activities) ListUserTuplesActivity(ctx context.Context, orgID, user string) ([]Tuple, error) {
+	var tuples []Tuple
+	for _, objectType := range []string{"document:", "group:"} {
+		token := ""
+		for {
+			page, next, err := a.As.ReadTuples(Subject(user), "", objectType, token, 100)
+			if err != nil {
+				return nil, err
+			}
+			tuples = append(tuples, page...)
+			activity.RecordHeartbeat(ctx, len(tuples))
+			if next == "" {
+				break
+			}
+			token = next
+		}
+	}
+	docs, err := a.As.OrgDocuments(orgID)
+	if err != nil {
+		return nil, err
+	}
+	return orgTuples(tuples, orgID, docs), nil
+}
+
+// orgTuples keeps those on orgDocs and on the org's groups ..
+func orgTuples(tuples []Tuple, orgID string, orgDocs []string) []Tuple {
+	ours := make(map[string]bool, len(orgDocs))
+	for _, doc := range orgDocs {
+		ours["document:"+doc] = true
+	}
+	kept := []Tuple{}
+	for _, t := range tuples {
+		if ours[t.Object] || strings.HasPrefix(t.Object, "group:"+orgID+"/") {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// RemoveTupleActivity deletes one tuple; already gone is fine ..
+func (a *Activities) RemoveTupleActivity(ctx context.Context, t Tuple) error {
+	err := a.As.DeleteTuples([]Tuple{t})
+	if err != nil {
+		fmt.Println("Error removing tuple. ERR:", err)
+	}
+	return nil
+}
+
+// PendingRequestsActivity finds documents where the user is still waiting on approval.
+// Asks the entity of each of the org's documents; requests only live there ..
+func (a *Activities) PendingRequestsActivity(ctx context.Context, orgID, user string) ([]string, error) {
+	docs, err := a.As.OrgDocuments(orgID)
+	if err != nil {
+		return nil, err
+	}
+	var pending []string
+	for i, doc := range docs {
+		st, err := a.Gateway.DocumentState(ctx, orgID, doc)
+		activity.RecordHeartbeat(ctx, i)
+		if err != nil {
+			// Not running (archived) means nothing pending there ..
+			continue
+		}
+		if _, ok := st.Pending[user]; ok {
+			pending = append(pending, doc)
+		}
+	}
+	return pending, nil
+}
+
+// SignReportActivity signs the campaign report; key never goes near workflow history ..
+func (a *Activities) SignReportActivity(ctx context.Context, report CampaignReport) (SignedReport, error) {
+	if len(a.ReportSigner) != ed25519.PrivateKeySize {
+		return SignedReport{}, fmt.Errorf("no report signing key configured")
+	}
+	return SignReport(a.ReportSigner, report)
+}
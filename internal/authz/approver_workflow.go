@@ -0,0 +1,388 @@
+package authz
+
+import (
+	"app/internal/notify"
+	"fmt"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signal + query understood by ApproverInboxWorkflow ..
+const (
+	InboxSignal = "inboxCommand"
+	InboxQuery  = "pendingApprovals"
+)
+
+// Ops for an InboxCommand ..
+const (
+	InboxRoute      = "route"
+	InboxWithdraw   = "withdraw"
+	InboxApprove    = "approve"
+	InboxReject     = "reject"
+	InboxApproveAll = "approveAll"
+	InboxRejectAll  = "rejectAll"
+	InboxDelegate   = "delegate"
+	InboxUndelegate = "undelegate"
+	// InboxCovering tells the delegate's inbox whom it is covering for ..
+	InboxCovering = "covering"
+)
+
+// Reminders go out this often for anything still waiting ..
+const defaultReminderInterval = time.Hour * 24
+
+// InboxItem is one access request waiting on the approver ..
+type InboxItem struct {
+	ID          string // <docID>#<requester>
+	OrgID       string
+	DocID       string
+	Requester   string
+	Reason      string
+	RequestedAt time.Time
+	Reminded    int
+}
+
+// InboxCommand is a single change asked of the inbox ..
+type InboxCommand struct {
+	Op     string
+	Actor  string
+	ItemID string
+	Item   *InboxItem // For route ..
+	// For approve; temporary grant if set ..
+	Duration time.Duration
+	// For delegate / covering ..
+	DelegateTo string
+	From       string
+	Until      time.Time
+	Reason     string
+}
+
+// InboxState is everything the inbox owns; also the query result ..
+type InboxState struct {
+	Approver      string
+	Items         []InboxItem
+	DelegateTo    string
+	DelegateUntil time.Time
+	// Approvers this inbox is standing in for; approver -> until ..
+	Covering map[string]time.Time
+}
+
+// ApproverInput starts (or continues) the inbox workflow ..
+type ApproverInput struct {
+	OrgID            string
+	Approver         string
+	ReminderInterval time.Duration
+	State            *InboxState
+}
+
+// Notification is a message to a person; delivered by NotifyActivity.
+// A Kind picks a notify template filled from Data, else Subject + Body ..
+type Notification struct {
+	To      string
+	Subject string
+	Body    string
+	Kind    string
+	Data    map[string]string
+	// Key dedupes retries; NotifyActivity's own ID if empty ..
+	Key string
+}
+
+// ApproverWorkflowID is the deterministic WorkflowID for the user's inbox
+// in the org; usernames are only unique within one ..
+func ApproverWorkflowID(orgID, user string) string {
+	return "approver-" + orgID + "/" + user
+}
+
+func inboxItemID(docID, requester string) string {
+	return docID + "#" + requester
+}
+
+// ApproverInboxWorkflow collects access requests routed to one approver
+// from any of the org's documents; WorkflowID: approver-<orgID>/<username>
+func ApproverInboxWorkflow(ctx workflow.Context, input ApproverInput) error {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("ApproverInboxWorkflow started", "Approver", input.Approver)
+
+	st := input.State
+	if st == nil {
+		st = &InboxState{Approver: input.Approver}
+	}
+	if st.Covering == nil {
+		st.Covering = map[string]time.Time{}
+	}
+	if input.ReminderInterval <= 0 {
+		input.ReminderInterval = defaultReminderInterval
+	}
+
+	err := workflow.SetQueryHandler(ctx, InboxQuery, func() (InboxState, error) {
+		return *st, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: time.Second * 10,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumAttempts:    5,
+		},
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+	in := &approverInbox{ctx: ctx, orgID: input.OrgID, st: st}
+
+	commandChan := workflow.GetSignalChannel(ctx, InboxSignal)
+	selector := workflow.NewSelector(ctx)
+	selector.AddReceive(commandChan, func(c workflow.ReceiveChannel, more bool) {
+		var cmd InboxCommand
+		c.Receive(ctx, &cmd)
+		if err := in.handle(cmd); err != nil {
+			logger.Warn("Inbox command rejected", "Op", cmd.Op, "Error", err)
+		}
+	})
+	// Reminders on a timer; re-armed each time it fires ..
+	var armReminder func()
+	armReminder = func() {
+		timer := workflow.NewTimer(ctx, input.ReminderInterval)
+		selector.AddFuture(timer, func(f workflow.Future) {
+			if f.Get(ctx, nil) != nil {
+				// Cancelled ..
+				return
+			}
+			in.remind(input.ReminderInterval)
+			armReminder()
+		})
+	}
+	armReminder()
+	// Operator can cancel the inbox; whatever is left is dropped ..
+	var cancelled bool
+	selector.AddReceive(ctx.Done(), func(c workflow.ReceiveChannel, more bool) {
+		cancelled = true
+	})
+
+	for !cancelled {
+		selector.Select(ctx)
+		info := workflow.GetInfo(ctx)
+		if info.GetContinueAsNewSuggested() || info.GetCurrentHistoryLength() > maxDocumentHistoryLength {
+			for selector.HasPending() {
+				selector.Select(ctx)
+			}
+			logger.Info("ApproverInboxWorkflow continuing as new", "Approver", input.Approver)
+			return workflow.NewContinueAsNewError(ctx, ApproverInboxWorkflow, ApproverInput{
+				OrgID:            input.OrgID,
+				Approver:         input.Approver,
+				ReminderInterval: input.ReminderInterval,
+				State:            st,
+			})
+		}
+	}
+	logger.Info("ApproverInboxWorkflow cancelled", "Approver", input.Approver, "Dropped", len(st.Items))
+	return nil
+}
+
+// approverInbox is the in-workflow handler of commands ..
+type approverInbox struct {
+	ctx   workflow.Context
+	orgID string
+	st    *InboxState
+}
+
+func (in *approverInbox) handle(cmd InboxCommand) error {
+	switch cmd.Op {
+	case InboxRoute:
+		return in.route(cmd)
+	case InboxWithdraw:
+		in.remove(cmd.ItemID)
+		return nil
+	case InboxApprove, InboxReject:
+		if err := in.requireActor(cmd); err != nil {
+			return err
+		}
+		return in.decide(cmd, cmd.ItemID)
+	case InboxApproveAll, InboxRejectAll:
+		if err := in.requireActor(cmd); err != nil {
+			return err
+		}
+		for _, item := range append([]InboxItem(nil), in.st.Items...) {
+			if err := in.decide(cmd, item.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	case InboxDelegate:
+		return in.delegate(cmd)
+	case InboxUndelegate:
+		return in.undelegate(cmd)
+	case InboxCovering:
+		if cmd.Until.IsZero() {
+			delete(in.st.Covering, cmd.From)
+		} else {
+			in.st.Covering[cmd.From] = cmd.Until
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown op %q", cmd.Op)
+}
+
+// delegated is true while someone is standing in for the approver ..
+func (in *approverInbox) delegated() bool {
+	return in.st.DelegateTo != "" && workflow.Now(in.ctx).Before(in.st.DelegateUntil)
+}
+
+func (in *approverInbox) requireActor(cmd InboxCommand) error {
+	if cmd.Actor == in.st.Approver {
+		return nil
+	}
+	if in.delegated() && cmd.Actor == in.st.DelegateTo {
+		return nil
+	}
+	return fmt.Errorf("%s cannot act on the inbox of %s", cmd.Actor, in.st.Approver)
+}
+
+func (in *approverInbox) route(cmd InboxCommand) error {
+	if cmd.Item == nil {
+		return fmt.Errorf("nothing to route")
+	}
+	item := *cmd.Item
+	item.ID = inboxItemID(item.DocID, item.Requester)
+	for _, existing := range in.st.Items {
+		if existing.ID == item.ID {
+			return nil
+		}
+	}
+	if item.RequestedAt.IsZero() {
+		item.RequestedAt = workflow.Now(in.ctx)
+	}
+	in.st.Items = append(in.st.Items, item)
+	in.notify(Notification{
+		Subject: "Access requested: " + item.DocID,
+		Body:    fmt.Sprintf("%s asks for access to %s: %s", item.Requester, item.DocID, item.Reason),
+		Kind:    notify.KindAccessRequested,
+		Data:    map[string]string{"doc": item.DocID, "requester": item.Requester, "reason": item.Reason},
+	})
+	return nil
+}
+
+func (in *approverInbox) remove(itemID string) (InboxItem, bool) {
+	for i, item := range in.st.Items {
+		if item.ID == itemID {
+			in.st.Items = append(in.st.Items[:i], in.st.Items[i+1:]...)
+			return item, true
+		}
+	}
+	return InboxItem{}, false
+}
+
+// decide forwards the decision to the document entity, which owns the tuples ..
+func (in *approverInbox) decide(cmd InboxCommand, itemID string) error {
+	item, ok := in.remove(itemID)
+	if !ok {
+		return fmt.Errorf("no pending item %s", itemID)
+	}
+	op := OpApprove
+	if cmd.Op == InboxReject || cmd.Op == InboxRejectAll {
+		op = OpReject
+	}
+	reason := cmd.Reason
+	if cmd.Actor != in.st.Approver {
+		reason = fmt.Sprintf("%s on behalf of %s. %s", cmd.Actor, in.st.Approver, cmd.Reason)
+	}
+	err := workflow.SignalExternalWorkflow(in.ctx, DocumentWorkflowID(item.OrgID, item.DocID), "", DocumentSignal, DocumentCommand{
+		Op:       op,
+		Actor:    in.st.Approver,
+		User:     item.Requester,
+		Duration: cmd.Duration,
+		Reason:   reason,
+	}).Get(in.ctx, nil)
+	if err != nil {
+		// Document is gone (archived); nothing left to decide ..
+		workflow.GetLogger(in.ctx).Warn("Document did not take decision", "DocID", item.DocID, "Error", err)
+	}
+	return nil
+}
+
+func (in *approverInbox) delegate(cmd InboxCommand) error {
+	if cmd.Actor != in.st.Approver {
+		return fmt.Errorf("only %s can delegate their inbox", in.st.Approver)
+	}
+	if cmd.DelegateTo == "" || cmd.DelegateTo == in.st.Approver {
+		return fmt.Errorf("invalid delegate %q", cmd.DelegateTo)
+	}
+	if !cmd.Until.After(workflow.Now(in.ctx)) {
+		return fmt.Errorf("delegation must end in the future")
+	}
+	// Previous delegate no longer covers ..
+	if in.st.DelegateTo != "" && in.st.DelegateTo != cmd.DelegateTo {
+		in.tellCovering(in.st.DelegateTo, time.Time{})
+	}
+	in.st.DelegateTo = cmd.DelegateTo
+	in.st.DelegateUntil = cmd.Until
+	in.tellCovering(cmd.DelegateTo, cmd.Until)
+	return nil
+}
+
+func (in *approverInbox) undelegate(cmd InboxCommand) error {
+	if cmd.Actor != in.st.Approver {
+		return fmt.Errorf("only %s can end delegation", in.st.Approver)
+	}
+	if in.st.DelegateTo != "" {
+		in.tellCovering(in.st.DelegateTo, time.Time{})
+	}
+	in.st.DelegateTo = ""
+	in.st.DelegateUntil = time.Time{}
+	return nil
+}
+
+// tellCovering lets the delegate's own inbox know; zero until means stop ..
+func (in *approverInbox) tellCovering(delegate string, until time.Time) {
+	var a *Activities
+	err := workflow.ExecuteActivity(in.ctx, a.SignalInboxActivity, in.orgID, delegate, InboxCommand{
+		Op:    InboxCovering,
+		From:  in.st.Approver,
+		Until: until,
+	}).Get(in.ctx, nil)
+	if err != nil {
+		workflow.GetLogger(in.ctx).Warn("Unable to tell delegate", "Delegate", delegate, "Error", err)
+	}
+}
+
+// remind nudges whoever is handling the inbox about old items ..
+func (in *approverInbox) remind(interval time.Duration) {
+	now := workflow.Now(in.ctx)
+	var stale []string
+	for i, item := range in.st.Items {
+		if now.Sub(item.RequestedAt) >= interval {
+			in.st.Items[i].Reminded++
+			stale = append(stale, item.DocID+" for "+item.Requester)
+		}
+	}
+	if len(stale) == 0 {
+		return
+	}
+	in.notify(Notification{
+		Subject: fmt.Sprintf("Reminder: %d access requests waiting", len(stale)),
+		Body:    fmt.Sprintf("Still waiting on %s: %v", in.st.Approver, stale),
+		Kind:    notify.KindAccessReminder,
+		Data: map[string]string{
+			"count":    strconv.Itoa(len(stale)),
+			"approver": in.st.Approver,
+			"items":    strings.Join(stale, ", "),
+		},
+	})
+}
+
+// notify goes to the delegate while they are covering ..
+func (in *approverInbox) notify(n Notification) {
+	n.To = in.st.Approver
+	if in.delegated() {
+		n.To = in.st.DelegateTo
+	}
+	var a *Activities
+	err := workflow.ExecuteActivity(in.ctx, a.NotifyActivity, n).Get(in.ctx, nil)
+	if err != nil {
+		workflow.GetLogger(in.ctx).Warn("NotifyActivity failed", "Error", err)
+	}
+}
@@ -0,0 +1,82 @@
+package authz
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/testsuite"
+	"testing"
+	"time"
+)
+
+func TestApproverInboxWorkflow(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+
+	var a *Activities
+	env.RegisterActivity(a)
+	var notified []Notification
+	env.OnActivity(a.NotifyActivity, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, n Notification) error {
+			notified = append(notified, n)
+			return nil
+		})
+	var covering []InboxCommand
+	env.OnActivity(a.SignalInboxActivity, mock.Anything, "GopherLab", "alice", mock.Anything).Return(
+		func(_ context.Context, _, _ string, cmd InboxCommand) error {
+			covering = append(covering, cmd)
+			return nil
+		})
+	var decisions []DocumentCommand
+	env.OnSignalExternalWorkflow(mock.Anything, mock.Anything, "", DocumentSignal, mock.Anything).Return(
+		func(_, workflowID, _, _ string, arg interface{}) error {
+			decisions = append(decisions, arg.(DocumentCommand))
+			return nil
+		})
+
+	signal := func(delay time.Duration, cmd InboxCommand) {
+		env.RegisterDelayedCallback(func() {
+			env.SignalWorkflow(InboxSignal, cmd)
+		}, delay)
+	}
+	signal(time.Minute, InboxCommand{Op: InboxRoute, Item: &InboxItem{DocID: "secret/secretz.doc", Requester: "mleow", Reason: "audit"}})
+	signal(time.Minute*2, InboxCommand{Op: InboxRoute, Item: &InboxItem{DocID: "secret/salary.doc", Requester: "carol"}})
+	// Not bob's delegate yet; refused ..
+	signal(time.Minute*3, InboxCommand{Op: InboxApproveAll, Actor: "alice"})
+	signal(time.Minute*4, InboxCommand{Op: InboxDelegate, Actor: "bob", DelegateTo: "alice", Until: env.Now().Add(time.Hour * 72)})
+	// Items are a day old at the 48h reminder; goes to alice while covering ..
+	signal(time.Hour*49, InboxCommand{Op: InboxApproveAll, Actor: "alice", Reason: "looks fine"})
+
+	env.RegisterDelayedCallback(func() {
+		v, err := env.QueryWorkflow(InboxQuery)
+		assert.NoError(t, err)
+		var st InboxState
+		assert.NoError(t, v.Get(&st))
+		assert.Empty(t, st.Items)
+		assert.Equal(t, "alice", st.DelegateTo)
+		env.CancelWorkflow()
+	}, time.Hour*50)
+
+	env.ExecuteWorkflow(ApproverInboxWorkflow, ApproverInput{OrgID: "GopherLab", Approver: "bob"})
+
+	assert.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+
+	if assert.Len(t, decisions, 2) {
+		assert.Equal(t, OpApprove, decisions[0].Op)
+		assert.Equal(t, "bob", decisions[0].Actor)
+		assert.Equal(t, "mleow", decisions[0].User)
+		assert.Contains(t, decisions[0].Reason, "alice on behalf of bob")
+		assert.Equal(t, "carol", decisions[1].User)
+	}
+	if assert.Len(t, covering, 1) {
+		assert.Equal(t, InboxCovering, covering[0].Op)
+		assert.Equal(t, "bob", covering[0].From)
+	}
+	// Two routed + one reminder ..
+	if assert.Len(t, notified, 3) {
+		assert.Equal(t, "bob", notified[0].To)
+		assert.Equal(t, "alice", notified[2].To)
+		assert.Contains(t, notified[2].Subject, "Reminder")
+	}
+}
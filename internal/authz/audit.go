@@ -0,0 +1,115 @@
+package authz
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditEvent is one line in the audit log ..
+type AuditEvent struct {
+	At     time.Time
+	OrgID  string
+	Actor  string
+	Action string // e.g. breakglass.granted
+	Object string // e.g. document:secret/salary.doc
+	Detail map[string]string
+	// Where it came from; blank when not from a workflow ..
+	WorkflowID string
+	RunID      string
+}
+
+// AuditLog is append-only; nothing gets changed once written ..
+type AuditLog interface {
+	Record(ctx context.Context, e AuditEvent) error
+	// Events returns everything matching; in the order written ..
+	Events(ctx context.Context, match func(AuditEvent) bool) ([]AuditEvent, error)
+}
+
+// FileAuditLog appends JSON lines to a file; good enough for dev + shipping to a SIEM ..
+type FileAuditLog struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileAuditLog writes to path; created on first Record ..
+func NewFileAuditLog(path string) *FileAuditLog {
+	return &FileAuditLog{path: path}
+}
+
+func (l *FileAuditLog) Record(ctx context.Context, e AuditEvent) error {
+	if e.At.IsZero() {
+		e.At = time.Now().UTC()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
+
+func (l *FileAuditLog) Events(ctx context.Context, match func(AuditEvent) bool) ([]AuditEvent, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var events []AuditEvent
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, err
+		}
+		if match == nil || match(e) {
+			events = append(events, e)
+		}
+	}
+	return events, scanner.Err()
+}
+
+// MemoryAuditLog is for tests + demos ..
+type MemoryAuditLog struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (l *MemoryAuditLog) Record(ctx context.Context, e AuditEvent) error {
+	if e.At.IsZero() {
+		e.At = time.Now().UTC()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, e)
+	return nil
+}
+
+func (l *MemoryAuditLog) Events(ctx context.Context, match func(AuditEvent) bool) ([]AuditEvent, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var events []AuditEvent
+	for _, e := range l.events {
+		if match == nil || match(e) {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
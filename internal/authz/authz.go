@@ -1,13 +1,19 @@
 package authz
 
 import (
+	"app/internal/identity"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/davecgh/go-spew/spew"
 	openfga "github.com/openfga/go-sdk"
 	. "github.com/openfga/go-sdk/client"
 	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
 )
 
 type AuthStore struct {
@@ -93,16 +99,45 @@ func (a AuthStore) removeTuple(body ClientDeleteTuplesBody) error {
 	return nil
 }
 
-func (a AuthStore) hasAccess(user, relation, document string) (bool, error) {
+// Subject is the OpenFGA user for an ID; plain usernames are user:<ID>,
+// anything already typed (e.g. service:<keyID>) is passed through. Only for
+// IDs this code typed or usernames through identity.ValidUserID; callers
+// use SubjectFor ..
+func Subject(id string) string {
+	if strings.Contains(id, ":") {
+		return id
+	}
+	return "user:" + id
+}
+
+// SubjectFor is the OpenFGA user for the caller; typed by its Kind, never by
+// what its ID looks like ..
+func SubjectFor(p identity.Principal) string {
+	if p.Kind == "service" {
+		return "service:" + strings.TrimPrefix(p.ID, "service:")
+	}
+	return "user:" + p.ID
+}
+
+// hasAccess; recentMFA goes in as the contextual mfa tuple that sensitive
+// documents need; it is never stored. The request context from ctx always
+// goes in for documents under a ContextPolicy ..
+func (a AuthStore) hasAccess(ctx context.Context, user, relation, document string, recentMFA bool, extra ...ClientContextualTupleKey) (bool, error) {
 	// Opts empty; uses the latest model ..
 	opts := ClientCheckOptions{}
-	data, cerr := a.client.Check(context.Background()).Body(ClientCheckRequest{
-		User:     "user:" + user,
+	reqCtx := checkContext(ctx)
+	body := ClientCheckRequest{
+		User:     Subject(user),
 		Relation: relation,
 		Object:   "document:" + document,
-		//Context:          nil,
-		//ContextualTuples: []ClientTupleKey{}, // Like dynamic stuff .. MFA clicked ..
-	}).Options(opts).Execute()
+		Context:  &reqCtx,
+	}
+	body.ContextualTuples = extra
+	if recentMFA && strings.HasPrefix(body.User, "user:") {
+		body.ContextualTuples = append(body.ContextualTuples,
+			ClientContextualTupleKey{User: Subject(user), Relation: "mfa", Object: "document:" + document})
+	}
+	data, cerr := a.client.Check(ctx).Body(body).Options(opts).Execute()
 	// Any unexpected view ..
 	if cerr != nil {
 		fmt.Println("ERR: ", cerr.Error())
@@ -120,37 +155,353 @@ func (a AuthStore) hasAccess(user, relation, document string) (bool, error) {
 	return false, nil
 }
 
-func (a AuthStore) CanViewDocument(user, document string) (bool, error) {
-	return a.hasAccess(user, "viewer", document)
+// CanViewDocument; ctx carries the caller's RequestContext ..
+func (a AuthStore) CanViewDocument(ctx context.Context, user, document string) (bool, error) {
+	return a.hasAccess(ctx, user, "viewer", document, false)
+}
+
+func (a AuthStore) CanEditDocument(ctx context.Context, user, document string) (bool, error) {
+	return a.hasAccess(ctx, user, "editor", document, false)
+}
+
+// Check is for callers that pick the relation e.g. the API ..
+func (a AuthStore) Check(ctx context.Context, user, relation, document string) (bool, error) {
+	return a.hasAccess(ctx, user, relation, document, false)
+}
+
+// CheckWithMFA is Check for a user who stepped up recently; services can not
+// so for them it is the same as Check ..
+func (a AuthStore) CheckWithMFA(ctx context.Context, user, relation, document string, recentMFA bool) (bool, error) {
+	return a.hasAccess(ctx, user, relation, document, recentMFA)
 }
 
-func (a AuthStore) CanEditDocument(user, document string) (bool, error) {
-	return a.hasAccess(user, "editor", document)
+// CheckLink is CheckWithMFA for user holding share link linkID; holding it
+// is a contextual tuple so it only counts for this check ..
+func (a AuthStore) CheckLink(ctx context.Context, user, linkID, relation, document string, recentMFA bool) (bool, error) {
+	return a.hasAccess(ctx, user, relation, document, recentMFA,
+		ClientContextualTupleKey{User: Subject(user), Relation: "holder", Object: LinkObject(linkID)})
+}
+
+// ListDocuments is every document ID user has the relation on; same request
+// context as the checks ..
+func (a AuthStore) ListDocuments(ctx context.Context, user, relation string) ([]string, error) {
+	reqCtx := checkContext(ctx)
+	data, err := a.client.ListObjects(ctx).Body(ClientListObjectsRequest{
+		User:     Subject(user),
+		Relation: relation,
+		Type:     "document",
+		Context:  &reqCtx,
+	}).Execute()
+	if err != nil {
+		fmt.Println("ERR: ", err.Error())
+		return nil, err
+	}
+	docs := make([]string, 0, len(data.GetObjects()))
+	for _, object := range data.GetObjects() {
+		docs = append(docs, strings.TrimPrefix(object, "document:"))
+	}
+	return docs, nil
+}
+
+// RestrictDocument puts the document under the policy: restricted for every
+// user + service, open only where the request_context condition holds ..
+func (a AuthStore) RestrictDocument(document string, policy ContextPolicy) error {
+	cond := policy.conditionContext()
+	var keys []ClientTupleKey
+	for _, wildcard := range []string{"user:*", "service:*"} {
+		keys = append(keys,
+			ClientTupleKey{User: wildcard, Relation: "restricted", Object: "document:" + document},
+			ClientTupleKey{User: wildcard, Relation: "context_ok", Object: "document:" + document,
+				Condition: &openfga.RelationshipCondition{Name: ContextCondition, Context: &cond}},
+		)
+	}
+	return a.addTuple(keys)
 }
 
 func (a AuthStore) AddViewRelationship(user, document string) error {
 	// TODO: What further valdiations??
 	// This can add conditions ..
-	t := []ClientTupleKey{
-		{
-			User:     "user:" + user,
-			Relation: "viewer",
-			Object:   "document:" + document,
-		},
-	}
-	return a.addTuple(t)
+	return a.AddRelationship(user, "viewer", document)
 }
 
 func (a AuthStore) RemoveViewRelationship(user, document string) error {
 	// TODO: What further valdiations??
-	t := []ClientTupleKeyWithoutCondition{
-		{
-			User:     "user:" + user,
-			Relation: "viewer",
-			Object:   "document:" + document,
-		},
+	return a.RemoveRelationship(user, "viewer", document)
+}
+
+// AddRelationship grants user the relation on the document; already there
+// is done, any other failure comes back ..
+func (a AuthStore) AddRelationship(user, relation, document string) error {
+	err := a.WriteTuples(context.Background(), []Tuple{{
+		User:     Subject(user),
+		Relation: relation,
+		Object:   "document:" + document,
+	}})
+	if tupleNoop(err) {
+		return nil
 	}
-	return a.removeTuple(t)
+	return err
+}
+
+// RemoveRelationship takes away the relation on the document; already gone
+// is done, any other failure comes back ..
+func (a AuthStore) RemoveRelationship(user, relation, document string) error {
+	err := a.DeleteTuples([]Tuple{{
+		User:     Subject(user),
+		Relation: relation,
+		Object:   "document:" + document,
+	}})
+	if tupleNoop(err) {
+		return nil
+	}
+	return err
+}
+
+// tupleNoop is OpenFGA refusing a write because it is already in place; the
+// tuple exists (write) or does not (delete) ..
+func tupleNoop(err error) bool {
+	var verr openfga.FgaApiValidationError
+	if !errors.As(err, &verr) || verr.ResponseCode() != openfga.WRITE_FAILED_DUE_TO_INVALID_INPUT {
+		return false
+	}
+	msg := verr.Error() + string(verr.Body())
+	return strings.Contains(msg, "already exists") || strings.Contains(msg, "does not exist")
+}
+
+// AddGroupMember puts user in group (an ID from GroupID) ..
+func (a AuthStore) AddGroupMember(group, user string) error {
+	return a.addTuple([]ClientTupleKey{
+		{User: Subject(user), Relation: "member", Object: "group:" + group},
+	})
+}
+
+// RemoveGroupMember takes user out of group ..
+func (a AuthStore) RemoveGroupMember(group, user string) error {
+	return a.removeTuple([]ClientTupleKeyWithoutCondition{
+		{User: Subject(user), Relation: "member", Object: "group:" + group},
+	})
+}
+
+// OrgObject is the OpenFGA object for an organization; documents point at it
+// with their org relation ..
+func OrgObject(org string) string {
+	return "organization:" + org
+}
+
+// AddOrgRole gives user (or service:<keyID>) the role (owner, admin, member)
+// in the organization; the model makes owners admins and admins members ..
+func (a AuthStore) AddOrgRole(org, user, role string) error {
+	return a.addTuple([]ClientTupleKey{
+		{User: Subject(user), Relation: role, Object: OrgObject(org)},
+	})
+}
+
+// RemoveOrgRole takes the role away ..
+func (a AuthStore) RemoveOrgRole(org, user, role string) error {
+	return a.removeTuple([]ClientTupleKeyWithoutCondition{
+		{User: Subject(user), Relation: role, Object: OrgObject(org)},
+	})
+}
+
+// CheckOrg is whether user has the relation on the organization e.g.
+// support ..
+func (a AuthStore) CheckOrg(ctx context.Context, user, relation, org string) (bool, error) {
+	data, err := a.client.Check(ctx).Body(ClientCheckRequest{
+		User:     Subject(user),
+		Relation: relation,
+		Object:   OrgObject(org),
+	}).Execute()
+	if err != nil {
+		fmt.Println("ERR: ", err.Error())
+		return false, err
+	}
+	return data.GetAllowed(), nil
+}
+
+// DeleteTuples removes tuples exactly as ReadTuples returned them ..
+func (a AuthStore) DeleteTuples(tuples []Tuple) error {
+	if len(tuples) == 0 {
+		return nil
+	}
+	keys := make([]ClientTupleKeyWithoutCondition, 0, len(tuples))
+	for _, t := range tuples {
+		keys = append(keys, ClientTupleKeyWithoutCondition{User: t.User, Relation: t.Relation, Object: t.Object})
+	}
+	return a.removeTuple(keys)
+}
+
+// WriteTuples stores tuples as given; unlike the helpers above a failure,
+// an existing tuple included, comes back to the caller ..
+func (a AuthStore) WriteTuples(ctx context.Context, tuples []Tuple) error {
+	if len(tuples) == 0 {
+		return nil
+	}
+	keys := make([]ClientTupleKey, 0, len(tuples))
+	for _, t := range tuples {
+		keys = append(keys, ClientTupleKey{User: t.User, Relation: t.Relation, Object: t.Object})
+	}
+	_, err := a.client.WriteTuples(ctx).Body(keys).Options(ClientWriteOptions{}).Execute()
+	if err != nil {
+		fmt.Println("ERR: ", err.Error())
+	}
+	return err
+}
+
+// GroupID namespaces an IdP group under its tenant; OpenFGA IDs can not
+// carry spaces, '#' or ':' so those become '_' ..
+func GroupID(tenant, name string) string {
+	clean := strings.Map(func(r rune) rune {
+		switch {
+		case r == '#' || r == ':' || unicode.IsSpace(r):
+			return '_'
+		}
+		return r
+	}, name)
+	return tenant + "/" + clean
+}
+
+// SyncGroupMembership makes user a member of exactly these groups (IDs from
+// GroupID) among the tenant's groups; used when the IdP tells us at login ..
+func (a AuthStore) SyncGroupMembership(tenant, user string, groups []string) error {
+	want := map[string]bool{}
+	for _, g := range groups {
+		want["group:"+g] = true
+	}
+	have := map[string]bool{}
+	token := ""
+	for {
+		tuples, next, err := a.ReadTuples(Subject(user), "member", "group:", token, 100)
+		if err != nil {
+			return err
+		}
+		for _, t := range tuples {
+			// Other tenants' groups are not ours to touch ..
+			if strings.HasPrefix(t.Object, "group:"+tenant+"/") {
+				have[t.Object] = true
+			}
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	var adds []ClientTupleKey
+	for _, g := range sortedKeys(want) {
+		if !have[g] {
+			adds = append(adds, ClientTupleKey{User: Subject(user), Relation: "member", Object: g})
+		}
+	}
+	var removes []ClientTupleKeyWithoutCondition
+	for _, g := range sortedKeys(have) {
+		if !want[g] {
+			removes = append(removes, ClientTupleKeyWithoutCondition{User: Subject(user), Relation: "member", Object: g})
+		}
+	}
+	if len(adds) > 0 {
+		if err := a.addTuple(adds); err != nil {
+			return err
+		}
+	}
+	if len(removes) > 0 {
+		return a.removeTuple(removes)
+	}
+	return nil
+}
+
+// Tuple is a relationship as stored in OpenFGA ..
+type Tuple struct {
+	User      string
+	Relation  string
+	Object    string
+	Condition string
+	Timestamp time.Time
+}
+
+// ReadTuples pages through stored tuples; empty filters match all.
+// Object can be just the type e.g. "document:" but then user is required ..
+func (a AuthStore) ReadTuples(user, relation, object, continuationToken string, pageSize int32) ([]Tuple, string, error) {
+	body := ClientReadRequest{}
+	if user != "" {
+		body.User = openfga.PtrString(user)
+	}
+	if relation != "" {
+		body.Relation = openfga.PtrString(relation)
+	}
+	if object != "" {
+		body.Object = openfga.PtrString(object)
+	}
+	opts := ClientReadOptions{}
+	if continuationToken != "" {
+		opts.ContinuationToken = openfga.PtrString(continuationToken)
+	}
+	if pageSize > 0 {
+		opts.PageSize = openfga.PtrInt32(pageSize)
+	}
+	data, err := a.client.Read(context.Background()).Body(body).Options(opts).Execute()
+	if err != nil {
+		fmt.Println("ERR: ", err.Error())
+		return nil, "", err
+	}
+	tuples := make([]Tuple, 0, len(data.GetTuples()))
+	for _, t := range data.GetTuples() {
+		key := t.GetKey()
+		tuple := Tuple{
+			User:      key.GetUser(),
+			Relation:  key.GetRelation(),
+			Object:    key.GetObject(),
+			Timestamp: t.GetTimestamp(),
+		}
+		if cond, ok := key.GetConditionOk(); ok && cond != nil {
+			tuple.Condition = cond.GetName()
+		}
+		tuples = append(tuples, tuple)
+	}
+	return tuples, data.GetContinuationToken(), nil
+}
+
+// readAll is every page of ReadTuples ..
+func (a AuthStore) readAll(user, relation, object string) ([]Tuple, error) {
+	var tuples []Tuple
+	token := ""
+	for {
+		page, next, err := a.ReadTuples(user, relation, object, token, 100)
+		if err != nil {
+			return nil, err
+		}
+		tuples = append(tuples, page...)
+		if next == "" {
+			return tuples, nil
+		}
+		token = next
+	}
+}
+
+// OrgDocuments is the IDs of every document with the org's tuple ..
+func (a AuthStore) OrgDocuments(org string) ([]string, error) {
+	tuples, err := a.readAll(OrgObject(org), "org", "document:")
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]string, 0, len(tuples))
+	for _, t := range tuples {
+		docs = append(docs, strings.TrimPrefix(t.Object, "document:"))
+	}
+	sort.Strings(docs)
+	return docs, nil
+}
+
+// DocumentInOrg is whether the document carries the org's tuple ..
+func (a AuthStore) DocumentInOrg(ctx context.Context, document, org string) (bool, error) {
+	tuples, _, err := a.ReadTuples(OrgObject(org), "org", "document:"+document, "", 1)
+	if err != nil {
+		return false, err
+	}
+	return len(tuples) > 0, nil
+}
+
+// DocumentTuples is every tuple stored on the document ..
+func (a AuthStore) DocumentTuples(doc string) ([]Tuple, error) {
+	return a.readAll("", "", "document:"+doc)
 }
 
 func (a AuthStore) InitDemo(demoModelPath string) error {
@@ -1,6 +1,8 @@
 package authz
 
 import (
+	"app/internal/identity"
+	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
 )
@@ -20,3 +22,20 @@ func TestCheckPermission(t *testing.T) {
 		})
 	}
 }
+
+func TestSubject(t *testing.T) {
+	assert.Equal(t, "user:bob", Subject("bob"))
+	assert.Equal(t, "service:0a1b", Subject("service:0a1b"))
+	assert.Equal(t, "group:acme/finance#member", Subject("group:acme/finance#member"))
+
+	// Principals are typed by kind, never by what their ID looks like ..
+	assert.Equal(t, "user:bob", SubjectFor(identity.Principal{ID: "bob", Kind: "user"}))
+	assert.Equal(t, "service:0a1b", SubjectFor(identity.Principal{ID: "0a1b", Kind: "service"}))
+	assert.Equal(t, "service:0a1b", SubjectFor(identity.Principal{ID: "service:0a1b", Kind: "service"}))
+	assert.Equal(t, "user:service:0a1b", SubjectFor(identity.Principal{ID: "service:0a1b", Kind: "user"}))
+}
+
+func TestGroupID(t *testing.T) {
+	assert.Equal(t, "acme/finance", GroupID("acme", "finance"))
+	assert.Equal(t, "acme/Domain_Admins_x_y", GroupID("acme", "Domain Admins#x:y"))
+}
@@ -0,0 +1,214 @@
+package authz
+
+import (
+	"fmt"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+	"time"
+)
+
+// Signal + query understood by BreakGlassWorkflow ..
+const (
+	BreakGlassSignal = "breakGlassDecision"
+	BreakGlassQuery  = "breakGlassStatus"
+)
+
+// Break-glass never outlives this; longer asks are cut down to it ..
+const MaxBreakGlass = time.Hour * 4
+
+// Defaults when the request does not say ..
+const (
+	defaultBreakGlassDuration     = time.Hour
+	defaultBreakGlassRatifyWindow = time.Minute * 15
+	defaultSecurityContact        = "security"
+)
+
+// BreakGlassInput is an emergency access request; justification + incident are mandatory ..
+type BreakGlassInput struct {
+	OrgID         string
+	DocID         string
+	Owner         string
+	Requester     string
+	Justification string
+	IncidentRef   string
+	// How long access lasts if ratified ..
+	Duration time.Duration
+	// Second person must ratify within this or access is pulled ..
+	RatifyWindow    time.Duration
+	SecurityContact string
+}
+
+// BreakGlassDecision is the second person ratifying (or denying) ..
+type BreakGlassDecision struct {
+	Actor   string
+	Ratify  bool
+	Comment string
+}
+
+// BreakGlassStatus is the query result ..
+type BreakGlassStatus struct {
+	State      string // granted, ratified, revoked, expired
+	GrantedAt  time.Time
+	Until      time.Time
+	RatifiedBy string
+	Steps      []string
+}
+
+// BreakGlassWorkflowID is deterministic so the same person cannot stack
+// requests; per org like DocumentWorkflowID. User IDs never have a '#' ..
+func BreakGlassWorkflowID(orgID, docID, requester string) string {
+	return "breakglass-" + orgID + "/" + docID + "#" + requester
+}
+
+// BreakGlassWorkflow grants emergency access at once; dual control means a second
+// person has to ratify within the window or it is revoked again ..
+func BreakGlassWorkflow(ctx workflow.Context, input BreakGlassInput) (BreakGlassStatus, error) {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("BreakGlassWorkflow started", "DocID", input.DocID, "Requester", input.Requester)
+
+	status := BreakGlassStatus{}
+	err := workflow.SetQueryHandler(ctx, BreakGlassQuery, func() (BreakGlassStatus, error) {
+		return status, nil
+	})
+	if err != nil {
+		return status, err
+	}
+
+	if input.Requester == "" || input.DocID == "" {
+		return status, temporal.NewNonRetryableApplicationError("requester and document are required", "InvalidInputError", nil)
+	}
+	if input.Justification == "" || input.IncidentRef == "" {
+		return status, temporal.NewNonRetryableApplicationError("justification and incident reference are required", "InvalidInputError", nil)
+	}
+	if input.Duration <= 0 {
+		input.Duration = defaultBreakGlassDuration
+	}
+	if input.Duration > MaxBreakGlass {
+		input.Duration = MaxBreakGlass
+	}
+	if input.RatifyWindow <= 0 {
+		input.RatifyWindow = defaultBreakGlassRatifyWindow
+	}
+	if input.SecurityContact == "" {
+		input.SecurityContact = defaultSecurityContact
+	}
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: time.Second * 10,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumAttempts:    10,
+		},
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+	var a *Activities
+
+	// step is one entry in both our status + the audit log ..
+	step := func(action, actor string, detail map[string]string) {
+		if detail == nil {
+			detail = map[string]string{}
+		}
+		detail["incident"] = input.IncidentRef
+		status.Steps = append(status.Steps, fmt.Sprintf("%s %s by %s", workflow.Now(ctx).Format(time.RFC3339), action, actor))
+		err := workflow.ExecuteActivity(ctx, a.RecordAuditActivity, AuditEvent{
+			At:     workflow.Now(ctx),
+			OrgID:  input.OrgID,
+			Actor:  actor,
+			Action: action,
+			Object: "document:" + input.DocID,
+			Detail: detail,
+		}).Get(ctx, nil)
+		if err != nil {
+			logger.Error("RecordAuditActivity failed", "Action", action, "Error", err)
+		}
+	}
+	notify := func(to, subject, body string) {
+		if to == "" {
+			return
+		}
+		err := workflow.ExecuteActivity(ctx, a.NotifyActivity, Notification{To: to, Subject: subject, Body: body}).Get(ctx, nil)
+		if err != nil {
+			logger.Error("NotifyActivity failed", "To", to, "Error", err)
+		}
+	}
+	// Document entity owns the tuples; it does the actual grant + revoke ..
+	tellDocument := func(op string) error {
+		return workflow.SignalExternalWorkflow(ctx, DocumentWorkflowID(input.OrgID, input.DocID), "", DocumentSignal, DocumentCommand{
+			Op:       op,
+			Actor:    input.Requester,
+			User:     input.Requester,
+			Duration: input.Duration,
+			Reason:   input.IncidentRef,
+		}).Get(ctx, nil)
+	}
+
+	step("breakglass.requested", input.Requester, map[string]string{"justification": input.Justification})
+	if err := tellDocument(OpEmergencyGrant); err != nil {
+		step("breakglass.failed", input.Requester, map[string]string{"error": err.Error()})
+		return status, err
+	}
+	status.State = "granted"
+	status.GrantedAt = workflow.Now(ctx)
+	status.Until = status.GrantedAt.Add(input.Duration)
+	step("breakglass.granted", input.Requester, map[string]string{"until": status.Until.Format(time.RFC3339)})
+
+	body := fmt.Sprintf("%s used break-glass on %s for incident %s: %s. Must be ratified within %s.",
+		input.Requester, input.DocID, input.IncidentRef, input.Justification, input.RatifyWindow)
+	notify(input.Owner, "Break-glass access to "+input.DocID, body)
+	notify(input.SecurityContact, "Break-glass access to "+input.DocID, body)
+
+	// Wait for a second person; the requester ratifying themselves does not count ..
+	decisionChan := workflow.GetSignalChannel(ctx, BreakGlassSignal)
+	ratifyCtx, cancelTimer := workflow.WithCancel(ctx)
+	timer := workflow.NewTimer(ratifyCtx, input.RatifyWindow)
+	var decision *BreakGlassDecision
+	timedOut := false
+	for decision == nil && !timedOut {
+		selector := workflow.NewSelector(ctx)
+		selector.AddReceive(decisionChan, func(c workflow.ReceiveChannel, more bool) {
+			var d BreakGlassDecision
+			c.Receive(ctx, &d)
+			if d.Actor == "" || d.Actor == input.Requester {
+				step("breakglass.ratify_refused", d.Actor, map[string]string{"reason": "dual control needs a second person"})
+				return
+			}
+			decision = &d
+		})
+		selector.AddFuture(timer, func(f workflow.Future) {
+			timedOut = true
+		})
+		selector.Select(ctx)
+	}
+	cancelTimer()
+
+	if decision == nil || !decision.Ratify {
+		actor, action := "system", "breakglass.unratified"
+		if decision != nil {
+			actor, action = decision.Actor, "breakglass.denied"
+		}
+		if err := tellDocument(OpEmergencyRevoke); err != nil {
+			logger.Error("Emergency revoke failed", "Error", err)
+		}
+		status.State = "revoked"
+		step(action, actor, nil)
+		step("breakglass.revoked", "system", nil)
+		notify(input.SecurityContact, "Break-glass revoked on "+input.DocID,
+			fmt.Sprintf("Access for %s (incident %s) was revoked: %s", input.Requester, input.IncidentRef, action))
+		return status, nil
+	}
+
+	status.State = "ratified"
+	status.RatifiedBy = decision.Actor
+	step("breakglass.ratified", decision.Actor, map[string]string{"comment": decision.Comment})
+
+	// Document entity expires the grant on its own timer; just close out the record ..
+	if wait := status.Until.Sub(workflow.Now(ctx)); wait > 0 {
+		if err := workflow.Sleep(ctx, wait); err != nil {
+			return status, err
+		}
+	}
+	status.State = "expired"
+	step("breakglass.expired", "system", nil)
+	return status, nil
+}
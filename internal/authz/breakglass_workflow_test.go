@@ -0,0 +1,137 @@
+package authz
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/testsuite"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func breakGlassEnv(t *testing.T) (*testsuite.TestWorkflowEnvironment, *[]AuditEvent, *[]DocumentCommand) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+
+	var a *Activities
+	env.RegisterActivity(a)
+	audit := &[]AuditEvent{}
+	env.OnActivity(a.RecordAuditActivity, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, e AuditEvent) error {
+			*audit = append(*audit, e)
+			return nil
+		})
+	env.OnActivity(a.NotifyActivity, mock.Anything, mock.Anything).Return(nil)
+	sent := &[]DocumentCommand{}
+	env.OnSignalExternalWorkflow(mock.Anything, DocumentWorkflowID("GopherLab", "secret/salary.doc"), "", DocumentSignal, mock.Anything).Return(
+		func(_, _, _, _ string, arg interface{}) error {
+			*sent = append(*sent, arg.(DocumentCommand))
+			return nil
+		})
+	return env, audit, sent
+}
+
+func auditActions(events []AuditEvent) []string {
+	actions := make([]string, 0, len(events))
+	for _, e := range events {
+		actions = append(actions, e.Action)
+	}
+	return actions
+}
+
+var breakGlassRequest = BreakGlassInput{
+	OrgID:         "GopherLab",
+	DocID:         "secret/salary.doc",
+	Owner:         "mleow",
+	Requester:     "bob",
+	Justification: "payroll outage",
+	IncidentRef:   "INC-42",
+	Duration:      time.Hour,
+	RatifyWindow:  time.Minute * 10,
+}
+
+func TestBreakGlassRevokedWhenNotRatified(t *testing.T) {
+	env, audit, sent := breakGlassEnv(t)
+	// Requester cannot ratify themselves ..
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(BreakGlassSignal, BreakGlassDecision{Actor: "bob", Ratify: true})
+	}, time.Minute)
+
+	env.ExecuteWorkflow(BreakGlassWorkflow, breakGlassRequest)
+	assert.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+
+	var status BreakGlassStatus
+	assert.NoError(t, env.GetWorkflowResult(&status))
+	assert.Equal(t, "revoked", status.State)
+	if assert.Len(t, *sent, 2) {
+		assert.Equal(t, OpEmergencyGrant, (*sent)[0].Op)
+		assert.Equal(t, OpEmergencyRevoke, (*sent)[1].Op)
+	}
+	assert.Equal(t, []string{
+		"breakglass.requested", "breakglass.granted", "breakglass.ratify_refused",
+		"breakglass.unratified", "breakglass.revoked",
+	}, auditActions(*audit))
+	assert.Equal(t, "INC-42", (*audit)[0].Detail["incident"])
+}
+
+func TestBreakGlassRatifiedBySecondPerson(t *testing.T) {
+	env, audit, sent := breakGlassEnv(t)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(BreakGlassSignal, BreakGlassDecision{Actor: "alice", Ratify: true, Comment: "confirmed"})
+	}, time.Minute*5)
+
+	env.ExecuteWorkflow(BreakGlassWorkflow, breakGlassRequest)
+	assert.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+
+	var status BreakGlassStatus
+	assert.NoError(t, env.GetWorkflowResult(&status))
+	assert.Equal(t, "expired", status.State)
+	assert.Equal(t, "alice", status.RatifiedBy)
+	assert.Len(t, *sent, 1)
+	assert.Equal(t, []string{
+		"breakglass.requested", "breakglass.granted", "breakglass.ratified", "breakglass.expired",
+	}, auditActions(*audit))
+}
+
+func TestBreakGlassCapped(t *testing.T) {
+	env, _, sent := breakGlassEnv(t)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(BreakGlassSignal, BreakGlassDecision{Actor: "alice", Ratify: true})
+	}, time.Minute)
+	input := breakGlassRequest
+	input.Duration = time.Hour * 24 * 21
+
+	env.ExecuteWorkflow(BreakGlassWorkflow, input)
+	assert.NoError(t, env.GetWorkflowError())
+	var status BreakGlassStatus
+	assert.NoError(t, env.GetWorkflowResult(&status))
+	assert.Equal(t, MaxBreakGlass, status.Until.Sub(status.GrantedAt))
+	if assert.Len(t, *sent, 1) {
+		assert.Equal(t, MaxBreakGlass, (*sent)[0].Duration)
+	}
+}
+
+func TestBreakGlassNeedsIncident(t *testing.T) {
+	env, _, sent := breakGlassEnv(t)
+	input := breakGlassRequest
+	input.IncidentRef = ""
+	env.ExecuteWorkflow(BreakGlassWorkflow, input)
+	assert.Error(t, env.GetWorkflowError())
+	assert.Empty(t, *sent)
+}
+
+func TestFileAuditLog(t *testing.T) {
+	l := NewFileAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+	ctx := context.Background()
+	assert.NoError(t, l.Record(ctx, AuditEvent{Actor: "bob", Action: "a"}))
+	assert.NoError(t, l.Record(ctx, AuditEvent{Actor: "mleow", Action: "b"}))
+	events, err := l.Events(ctx, func(e AuditEvent) bool { return e.Actor == "mleow" })
+	assert.NoError(t, err)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "b", events[0].Action)
+		assert.False(t, events[0].At.IsZero())
+	}
+}
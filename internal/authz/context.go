@@ -0,0 +1,107 @@
+package authz
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Request context for ABAC; documents under a ContextPolicy are only open
+// from the right network, at the right time, on a trusted device. Checks
+// pass these to the request_context condition in the model ..
+
+// ContextCondition is the condition name in the model ..
+const ContextCondition = "request_context"
+
+// RequestContext is what the caller's request says about where it came from ..
+type RequestContext struct {
+	IPAddress netip.Addr
+	Time      time.Time
+	// DeviceTrusted is the session's device posture; services never are ..
+	DeviceTrusted bool
+}
+
+type requestContextKey struct{}
+
+// WithRequestContext is set by the HTTP layer on every request ..
+func WithRequestContext(ctx context.Context, rc RequestContext) context.Context {
+	return context.WithValue(ctx, requestContextKey{}, rc)
+}
+
+// RequestContextFrom is the request's context if the HTTP layer set one ..
+func RequestContextFrom(ctx context.Context) (RequestContext, bool) {
+	rc, ok := ctx.Value(requestContextKey{}).(RequestContext)
+	return rc, ok
+}
+
+// checkContext is the condition context for a check. Without a request
+// (workers, demo setup) it fails closed: no address matches an office
+// range and the device is untrusted ..
+func checkContext(ctx context.Context) map[string]interface{} {
+	rc, _ := RequestContextFrom(ctx)
+	ip := "0.0.0.0"
+	if rc.IPAddress.IsValid() {
+		ip = rc.IPAddress.String()
+	}
+	now := rc.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	return map[string]interface{}{
+		"ip_address":     ip,
+		"current_time":   now.UTC().Format(time.RFC3339),
+		"device_trusted": rc.DeviceTrusted,
+	}
+}
+
+// ContextPolicy restricts every document under Prefix e.g. secret/ ..
+type ContextPolicy struct {
+	Name   string `json:"name"`
+	Prefix string `json:"prefix"`
+	// CIDRs the caller must be in; empty is anywhere ..
+	CIDRs []string `json:"cidrs"`
+	// Hours are [StartHour, EndHour) in Timezone (IANA); 0-24 is all day ..
+	Timezone     string `json:"timezone"`
+	StartHour    int    `json:"start_hour"`
+	EndHour      int    `json:"end_hour"`
+	WeekdaysOnly bool   `json:"weekdays_only"`
+	// RequireTrustedDevice also shuts out services ..
+	RequireTrustedDevice bool `json:"require_trusted_device"`
+}
+
+// conditionContext is the policy half of the condition; stored on the tuple ..
+func (p ContextPolicy) conditionContext() map[string]interface{} {
+	tz := p.Timezone
+	if tz == "" {
+		tz = "UTC"
+	}
+	end := p.EndHour
+	if end == 0 {
+		end = 24
+	}
+	cidrs := make([]interface{}, 0, len(p.CIDRs))
+	for _, c := range p.CIDRs {
+		cidrs = append(cidrs, c)
+	}
+	return map[string]interface{}{
+		"cidrs":          cidrs,
+		"timezone":       tz,
+		"start_hour":     p.StartHour,
+		"end_hour":       end,
+		"weekdays_only":  p.WeekdaysOnly,
+		"require_device": p.RequireTrustedDevice,
+	}
+}
+
+// PolicyFor is the most specific policy covering the document; false if none ..
+func PolicyFor(policies []ContextPolicy, document string) (ContextPolicy, bool) {
+	var best ContextPolicy
+	found := false
+	for _, p := range policies {
+		if strings.HasPrefix(document, p.Prefix) && (!found || len(p.Prefix) > len(best.Prefix)) {
+			best, found = p, true
+		}
+	}
+	return best, found
+}
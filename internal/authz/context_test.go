@@ -0,0 +1,46 @@
+package authz
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestPolicyFor(t *testing.T) {
+	policies := []ContextPolicy{
+		{Name: "secret", Prefix: "secret/"},
+		{Name: "board", Prefix: "secret/board/"},
+	}
+	p, ok := PolicyFor(policies, "secret/board/minutes.doc")
+	assert.True(t, ok)
+	assert.Equal(t, "board", p.Name)
+	p, ok = PolicyFor(policies, "secret/salary.doc")
+	assert.True(t, ok)
+	assert.Equal(t, "secret", p.Name)
+	_, ok = PolicyFor(policies, "public/plan.doc")
+	assert.False(t, ok)
+
+	c := ContextPolicy{CIDRs: []string{"10.0.0.0/8"}, StartHour: 8}.conditionContext()
+	assert.Equal(t, "UTC", c["timezone"])
+	assert.Equal(t, 24, c["end_hour"], "zero end is all day")
+	assert.Equal(t, []interface{}{"10.0.0.0/8"}, c["cidrs"])
+}
+
+func TestCheckContext(t *testing.T) {
+	// Nothing from a request; no office, no device ..
+	c := checkContext(context.Background())
+	assert.Equal(t, "0.0.0.0", c["ip_address"])
+	assert.Equal(t, false, c["device_trusted"])
+
+	at := time.Date(2024, 7, 1, 9, 30, 0, 0, time.FixedZone("MYT", 8*3600))
+	c = checkContext(WithRequestContext(context.Background(), RequestContext{
+		IPAddress:     netip.MustParseAddr("10.1.2.3"),
+		Time:          at,
+		DeviceTrusted: true,
+	}))
+	assert.Equal(t, "10.1.2.3", c["ip_address"])
+	assert.Equal(t, "2024-07-01T01:30:00Z", c["current_time"])
+	assert.Equal(t, true, c["device_trusted"])
+}
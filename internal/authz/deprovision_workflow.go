@@ -0,0 +1,142 @@
+package authz
+
+import (
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+	"strings"
+	"time"
+)
+
+// DeprovisionInput is a user leaving the org; usually from the SCIM feed ..
+type DeprovisionInput struct {
+	OrgID  string
+	User   string
+	Actor  string
+	Reason string
+}
+
+// DeprovisionResult is what was taken away ..
+type DeprovisionResult struct {
+	Revoked   []Tuple
+	Withdrawn []string
+	// Owner tuples stay so the document is not orphaned; an admin transfers them ..
+	Owned []string
+}
+
+// DeprovisionWorkflowID is one per user; a repeat while running is a no-op ..
+func DeprovisionWorkflowID(orgID, user string) string {
+	return "deprovision-" + orgID + "-" + user
+}
+
+// DeprovisionWorkflow strips every tuple the user holds in the org, withdraws
+// their pending requests and closes their approver inbox ..
+func DeprovisionWorkflow(ctx workflow.Context, input DeprovisionInput) (DeprovisionResult, error) {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("DeprovisionWorkflow started", "OrgID", input.OrgID, "User", input.User)
+
+	result := DeprovisionResult{}
+	if input.User == "" {
+		return result, temporal.NewNonRetryableApplicationError("user is required", "InvalidInputError", nil)
+	}
+	if input.Actor == "" {
+		input.Actor = "system"
+	}
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+		HeartbeatTimeout:    time.Second * 30,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumAttempts:    10,
+		},
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+	var a *Activities
+
+	audit := func(action, object string, detail map[string]string) {
+		if detail == nil {
+			detail = map[string]string{}
+		}
+		detail["reason"] = input.Reason
+		err := workflow.ExecuteActivity(ctx, a.RecordAuditActivity, AuditEvent{
+			At:     workflow.Now(ctx),
+			OrgID:  input.OrgID,
+			Actor:  input.Actor,
+			Action: action,
+			Object: object,
+			Detail: detail,
+		}).Get(ctx, nil)
+		if err != nil {
+			logger.Error("RecordAuditActivity failed", "Action", action, "Error", err)
+		}
+	}
+	tellDocument := func(doc string, cmd DocumentCommand) error {
+		return workflow.SignalExternalWorkflow(ctx, DocumentWorkflowID(input.OrgID, doc), "", DocumentSignal, cmd).Get(ctx, nil)
+	}
+
+	audit("deprovision.started", "user:"+input.User, nil)
+
+	var tuples []Tuple
+	if err := workflow.ExecuteActivity(ctx, a.ListUserTuplesActivity, input.OrgID, input.User).Get(ctx, &tuples); err != nil {
+		return result, err
+	}
+	// Owned documents keep all the owner's tuples until they are transferred ..
+	owned := map[string]bool{}
+	for _, t := range tuples {
+		if t.Relation == "owner" {
+			owned[t.Object] = true
+			result.Owned = append(result.Owned, strings.TrimPrefix(t.Object, "document:"))
+		}
+	}
+	for _, t := range tuples {
+		if owned[t.Object] {
+			continue
+		}
+		if doc, ok := strings.CutPrefix(t.Object, "document:"); ok {
+			// Let the entity drop it too so its state + history agree ..
+			err := tellDocument(doc, DocumentCommand{Op: OpRevoke, Actor: input.User, User: input.User, Reason: "deprovisioned"})
+			if err != nil {
+				logger.Warn("Document not running; removing tuple directly", "Doc", doc, "Error", err)
+			}
+		}
+		if err := workflow.ExecuteActivity(ctx, a.RemoveTupleActivity, t).Get(ctx, nil); err != nil {
+			return result, err
+		}
+		result.Revoked = append(result.Revoked, t)
+		audit("deprovision.revoked", t.Object, map[string]string{"relation": t.Relation})
+	}
+
+	var pending []string
+	if err := workflow.ExecuteActivity(ctx, a.PendingRequestsActivity, input.OrgID, input.User).Get(ctx, &pending); err != nil {
+		return result, err
+	}
+	for _, doc := range pending {
+		err := tellDocument(doc, DocumentCommand{Op: OpWithdraw, Actor: input.User, User: input.User, Reason: "deprovisioned"})
+		if err != nil {
+			logger.Error("Withdraw failed", "Doc", doc, "Error", err)
+			continue
+		}
+		result.Withdrawn = append(result.Withdrawn, doc)
+		audit("deprovision.withdrawn", "document:"+doc, nil)
+	}
+
+	// Requests waiting on them go back to the requesters; then close the inbox ..
+	err := workflow.SignalExternalWorkflow(ctx, ApproverWorkflowID(input.OrgID, input.User), "", InboxSignal, InboxCommand{
+		Op:     InboxRejectAll,
+		Actor:  input.User,
+		Reason: "approver deprovisioned",
+	}).Get(ctx, nil)
+	if err == nil {
+		if err := workflow.RequestCancelExternalWorkflow(ctx, ApproverWorkflowID(input.OrgID, input.User), "").Get(ctx, nil); err != nil {
+			logger.Warn("Inbox cancel failed", "Error", err)
+		}
+		audit("deprovision.inbox_closed", "user:"+input.User, nil)
+	}
+
+	for _, doc := range result.Owned {
+		audit("deprovision.owner_orphaned", "document:"+doc, nil)
+	}
+	audit("deprovision.completed", "user:"+input.User, nil)
+	return result, nil
+}
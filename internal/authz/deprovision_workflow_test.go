@@ -0,0 +1,108 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/testsuite"
+	"testing"
+)
+
+func TestDeprovisionRevokesTuplesAndWithdrawsRequests(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+
+	var a *Activities
+	env.RegisterActivity(a)
+	audit := &[]AuditEvent{}
+	env.OnActivity(a.RecordAuditActivity, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, e AuditEvent) error {
+			*audit = append(*audit, e)
+			return nil
+		})
+	env.OnActivity(a.ListUserTuplesActivity, mock.Anything, "GopherLab", "bob").Return([]Tuple{
+		{User: "user:bob", Relation: "viewer", Object: "document:public/a.doc"},
+		{User: "user:bob", Relation: "owner", Object: "document:bob/notes.doc"},
+		{User: "user:bob", Relation: "member", Object: "group:GopherLab/finance"},
+	}, nil)
+	removed := &[]Tuple{}
+	env.OnActivity(a.RemoveTupleActivity, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, tuple Tuple) error {
+			*removed = append(*removed, tuple)
+			return nil
+		})
+	env.OnActivity(a.PendingRequestsActivity, mock.Anything, "GopherLab", "bob").Return([]string{"secret/salary.doc"}, nil)
+
+	sent := map[string][]DocumentCommand{}
+	env.OnSignalExternalWorkflow(mock.Anything, mock.Anything, "", DocumentSignal, mock.Anything).Return(
+		func(_, workflowID, _, _ string, arg interface{}) error {
+			sent[workflowID] = append(sent[workflowID], arg.(DocumentCommand))
+			return nil
+		})
+	var inbox []InboxCommand
+	env.OnSignalExternalWorkflow(mock.Anything, ApproverWorkflowID("GopherLab", "bob"), "", InboxSignal, mock.Anything).Return(
+		func(_, _, _, _ string, arg interface{}) error {
+			inbox = append(inbox, arg.(InboxCommand))
+			return nil
+		})
+	env.OnRequestCancelExternalWorkflow(mock.Anything, ApproverWorkflowID("GopherLab", "bob"), "").Return(nil).Once()
+
+	env.ExecuteWorkflow(DeprovisionWorkflow, DeprovisionInput{OrgID: "GopherLab", User: "bob", Actor: "scim", Reason: "left"})
+	assert.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+
+	var result DeprovisionResult
+	assert.NoError(t, env.GetWorkflowResult(&result))
+	assert.Len(t, result.Revoked, 2)
+	assert.Equal(t, []string{"bob/notes.doc"}, result.Owned)
+	assert.Equal(t, []string{"secret/salary.doc"}, result.Withdrawn)
+	// Owner tuple stays ..
+	assert.Equal(t, []string{"document:public/a.doc", "group:GopherLab/finance"}, []string{(*removed)[0].Object, (*removed)[1].Object})
+
+	if assert.Len(t, sent[DocumentWorkflowID("GopherLab", "public/a.doc")], 1) {
+		assert.Equal(t, OpRevoke, sent[DocumentWorkflowID("GopherLab", "public/a.doc")][0].Op)
+	}
+	if assert.Len(t, sent[DocumentWorkflowID("GopherLab", "secret/salary.doc")], 1) {
+		assert.Equal(t, OpWithdraw, sent[DocumentWorkflowID("GopherLab", "secret/salary.doc")][0].Op)
+	}
+	if assert.Len(t, inbox, 1) {
+		assert.Equal(t, InboxRejectAll, inbox[0].Op)
+	}
+	assert.Equal(t, []string{
+		"deprovision.started", "deprovision.revoked", "deprovision.revoked", "deprovision.withdrawn",
+		"deprovision.inbox_closed", "deprovision.owner_orphaned", "deprovision.completed",
+	}, auditActions(*audit))
+	assert.Equal(t, "scim", (*audit)[0].Actor)
+}
+
+func TestDeprovisionWithoutInbox(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+
+	var a *Activities
+	env.RegisterActivity(a)
+	env.OnActivity(a.RecordAuditActivity, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.ListUserTuplesActivity, mock.Anything, "GopherLab", "carol").Return([]Tuple{}, nil)
+	env.OnActivity(a.PendingRequestsActivity, mock.Anything, "GopherLab", "carol").Return([]string{}, nil)
+	env.OnSignalExternalWorkflow(mock.Anything, ApproverWorkflowID("GopherLab", "carol"), "", InboxSignal, mock.Anything).
+		Return(errors.New("workflow not found"))
+
+	env.ExecuteWorkflow(DeprovisionWorkflow, DeprovisionInput{OrgID: "GopherLab", User: "carol"})
+	assert.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+}
+
+func TestOrgTuplesKeepsOtherOrgs(t *testing.T) {
+	tuples := []Tuple{
+		{User: "user:bob", Relation: "viewer", Object: "document:public/a.doc"},
+		{User: "user:bob", Relation: "editor", Object: "document:crab/plan.doc"},
+		{User: "user:bob", Relation: "member", Object: "group:GopherLab/finance"},
+		{User: "user:bob", Relation: "member", Object: "group:CrabLab/finance"},
+		{User: "user:bob", Relation: "member", Object: "group:GopherLabs/finance"},
+	}
+	kept := orgTuples(tuples, "GopherLab", []string{"public/a.doc", "bob/notes.doc"})
+	assert.Equal(t, []Tuple{tuples[0], tuples[2]}, kept)
+	kept = orgTuples(tuples, "CrabLab", []string{"crab/plan.doc"})
+	assert.Equal(t, []Tuple{tuples[1], tuples[3]}, kept)
+}
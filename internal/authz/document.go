@@ -1,6 +1,7 @@
 package authz
 
 import (
+	"context"
 	"fmt"
 	"github.com/davecgh/go-spew/spew"
 	. "github.com/openfga/go-sdk/client"
@@ -8,10 +9,10 @@ import (
 	"strings"
 )
 
+// Document is who owns what; the content is in the docstore ..
 type Document struct {
-	ID      string
-	Owner   string
-	Content string
+	ID    string
+	Owner string
 	// WF Lifecycle??
 }
 
@@ -19,6 +20,7 @@ var as AuthStore
 
 type AuthzDemo struct {
 	as               AuthStore
+	org              string
 	users            []string
 	docs             []Document
 	awaitingApproval []string // WorkflowID for Owner-Docs requested ..
@@ -56,8 +58,21 @@ func (ad AuthzDemo) setupTuples() error {
 	keys := make([]ClientTupleKey, 0)
 	for _, doc := range ad.docs {
 		fmt.Print("DocPath:", doc.ID, " Owner:", doc.Owner)
+		// Relations only count for members of the org ..
+		if ad.org != "" {
+			keys = append(keys, ClientTupleKey{
+				User:     OrgObject(ad.org),
+				Relation: "org",
+				Object:   "document:" + doc.ID,
+			})
+		}
 		// For each doc; set owner as viewer + editor
 		if doc.Owner != "" {
+			keys = append(keys, ClientTupleKey{
+				User:     "user:" + doc.Owner,
+				Relation: "owner",
+				Object:   "document:" + doc.ID,
+			})
 			keys = append(keys, ClientTupleKey{
 				User:     "user:" + doc.Owner,
 				Relation: "editor",
@@ -92,29 +107,15 @@ func (ad AuthzDemo) debugState() {
 }
 
 func (ad AuthzDemo) checkViewerAccess(user, document string) bool {
-	ok, err := ad.as.CanViewDocument(user, document)
+	ok, err := ad.as.CanViewDocument(context.Background(), user, document)
 	if err != nil {
 		fmt.Println("ERR:", err)
 	}
 	return ok
 }
 
-func (ad AuthzDemo) getDocumentContent(user, document string) (string, error) {
-	// Naive implementation first ..
-	for _, doc := range ad.docs {
-		if doc.ID == document {
-			if ad.checkViewerAccess(user, document) {
-				return doc.Content, nil
-
-			}
-			return "", fmt.Errorf("user %s unauthorized viewer of %s", user, document)
-		}
-	}
-	return "", fmt.Errorf("document not found")
-}
-
 func (ad AuthzDemo) checkEditorAccess(user, document string) bool {
-	ok, err := ad.as.CanEditDocument(user, document)
+	ok, err := ad.as.CanEditDocument(context.Background(), user, document)
 	if err != nil {
 		fmt.Println("ERR:", err)
 	}
@@ -0,0 +1,866 @@
+package authz
+
+import (
+	"app/internal/notify"
+	"errors"
+	"fmt"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+	"sort"
+	"time"
+)
+
+// Signal, update + queries understood by DocumentWorkflow ..
+const (
+	DocumentSignal = "documentCommand"
+	// Same commands; the caller waits for the AccessEvent it ended in ..
+	DocumentCommandUpdate = "applyDocumentCommand"
+	DocumentHistoryQuery  = "accessHistory"
+	DocumentStateQuery    = "documentState"
+)
+
+// Ops for a DocumentCommand ..
+const (
+	OpCreate        = "create"
+	OpShare         = "share"
+	OpRequestAccess = "requestAccess"
+	OpApprove       = "approve"
+	OpReject        = "reject"
+	OpTempGrant     = "tempGrant"
+	OpRevoke        = "revoke"
+	OpClassify      = "classify"
+	OpArchive       = "archive"
+	// Requester (or owner) drops an outstanding access request ..
+	OpWithdraw = "withdraw"
+	// Owner hands the document to User; sent for leavers once their manager accepts ..
+	OpTransfer = "transfer"
+	// New content; the caller checked the actor is an editor (CanEditDocument) ..
+	OpUpdate = "update"
+	// Tenant admin ends a grant from the console; the caller checked admin ..
+	OpAdminRevoke = "adminRevoke"
+	// Tenant admin shares from the console; same as share bar the owner check ..
+	OpAdminShare = "adminShare"
+	// Only sent by BreakGlassWorkflow ..
+	OpEmergencyGrant  = "emergencyGrant"
+	OpEmergencyRevoke = "emergencyRevoke"
+	// opExpire only comes from the workflow's own timers ..
+	opExpire = "expire"
+)
+
+// Classifications from least to most restrictive ..
+var classificationRank = map[string]int{
+	"public":       0,
+	"internal":     1,
+	"confidential": 2,
+	"secret":       3,
+}
+
+// Sensitive documents (confidential and up) need a recent MFA on every check;
+// the user:* sensitive tuple marks them in OpenFGA ..
+func sensitive(class string) bool {
+	return classificationRank[class] >= classificationRank["confidential"]
+}
+
+// Discoverable is whether members who can not open the document still see
+// it listed and may ask for it; secret ones stay unlisted ..
+func Discoverable(st DocumentState) bool {
+	return st.Created && !st.Archived && st.Doc.Owner != "" &&
+		classificationRank[st.Classification] < classificationRank["secret"]
+}
+
+// accessOps are the commands that change someone's access or ask for it ..
+var accessOps = map[string]bool{
+	OpShare: true, OpRequestAccess: true, OpApprove: true, OpReject: true, OpWithdraw: true,
+	OpTempGrant: true, OpRevoke: true, OpAdminRevoke: true, OpAdminShare: true, OpEmergencyGrant: true,
+	OpEmergencyRevoke: true, opExpire: true,
+}
+
+// AccessHistoryOf is what happened to user's access, newest first; refused
+// commands changed nothing so are left out ..
+func AccessHistoryOf(history []AccessEvent, user string) []AccessEvent {
+	var events []AccessEvent
+	for i := len(history) - 1; i >= 0; i-- {
+		e := history[i]
+		if e.User == user && e.Accepted && accessOps[e.Op] {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+const anyUser = "user:*"
+
+// grantExpiryWarning is how long before a temporary grant ends the user is
+// told; shorter grants end without one ..
+const grantExpiryWarning = 15 * time.Minute
+
+// Keep the workflow history (and carried over state) bounded ..
+const (
+	maxDocumentHistoryLength = 2000
+	maxAccessEvents          = 500
+)
+
+// DocumentCommand is a single change asked of the document entity ..
+type DocumentCommand struct {
+	Op       string
+	Actor    string // Who is asking ..
+	User     string // Who it is about; defaults to Actor ..
+	Relation string // For share; viewer if empty ..
+	// For tempGrant; also approve when it should be temporary ..
+	Duration       time.Duration
+	Classification string
+	// For create + update; the docstore version the content is now at ..
+	Version int
+	Reason  string
+}
+
+// AccessEvent is one entry in the document's access history ..
+type AccessEvent struct {
+	At       time.Time
+	Op       string
+	Actor    string
+	User     string
+	Relation string
+	Accepted bool
+	Detail   string
+}
+
+// DocumentState is everything the entity workflow owns about the document ..
+type DocumentState struct {
+	OrgID          string
+	Doc            Document
+	Classification string
+	Created        bool
+	Archived       bool
+	// Version of the content in the docstore; the content never comes here ..
+	Version int
+	// Policy is the ContextPolicy it was put under at create; empty if none ..
+	Policy string
+	// Standing non-owner grants; user -> relation ..
+	Grants map[string]string
+	// Temporary viewer grants; user -> expiry ..
+	TempGrants map[string]time.Time
+	// Break-glass viewer grants; user -> expiry. Kept apart so pulling one
+	// never ends a temporary grant the user had anyway ..
+	Emergency map[string]time.Time
+	// Outstanding access requests; user -> reason ..
+	Pending map[string]string
+	History []AccessEvent
+}
+
+// DocumentInput starts (or continues) the entity workflow ..
+type DocumentInput struct {
+	OrgID string
+	DocID string
+	// State carried over on continue-as-new ..
+	State *DocumentState
+}
+
+// DocumentWorkflow owns the lifecycle of one Document; WorkflowID: doc-<orgID>/<docID>
+// All changes to the document's tuples go through here one at a time ..
+func DocumentWorkflow(ctx workflow.Context, input DocumentInput) error {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("DocumentWorkflow started", "DocID", input.DocID)
+
+	st := input.State
+	if st == nil {
+		st = &DocumentState{
+			OrgID: input.OrgID,
+			Doc:   Document{ID: input.DocID},
+		}
+	}
+	// Maps do not survive being empty through the data converter ..
+	if st.Grants == nil {
+		st.Grants = map[string]string{}
+	}
+	if st.TempGrants == nil {
+		st.TempGrants = map[string]time.Time{}
+	}
+	if st.Emergency == nil {
+		st.Emergency = map[string]time.Time{}
+	}
+	if st.Pending == nil {
+		st.Pending = map[string]string{}
+	}
+
+	err := workflow.SetQueryHandler(ctx, DocumentHistoryQuery, func() ([]AccessEvent, error) {
+		return st.History, nil
+	})
+	if err != nil {
+		return err
+	}
+	err = workflow.SetQueryHandler(ctx, DocumentStateQuery, func() (DocumentState, error) {
+		return *st, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	calls := workflow.NewChannel(ctx)
+	err = workflow.SetUpdateHandlerWithOptions(ctx, DocumentCommandUpdate,
+		func(ctx workflow.Context, cmd DocumentCommand) (AccessEvent, error) {
+			var event AccessEvent
+			f, done := workflow.NewFuture(ctx)
+			calls.Send(ctx, documentCall{cmd: cmd, done: done})
+			err := f.Get(ctx, &event)
+			return event, err
+		},
+		workflow.UpdateHandlerOptions{Validator: func(ctx workflow.Context, cmd DocumentCommand) error {
+			if cmd.Actor == "" || cmd.Op == opExpire {
+				return errors.New("command needs an actor")
+			}
+			if st.Archived {
+				return errors.New("document archived")
+			}
+			return nil
+		}})
+	if err != nil {
+		return err
+	}
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: time.Second * 10,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumAttempts:    5,
+		},
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	d := &documentEntity{
+		ctx:     ctx,
+		st:      st,
+		expired: workflow.NewChannel(ctx),
+	}
+	// Timers do not survive continue-as-new; re-arm from state ..
+	for _, user := range sortedKeys(st.TempGrants) {
+		d.armExpiry(user, st.TempGrants[user])
+	}
+	for _, user := range sortedKeys(st.Emergency) {
+		d.armExpiry(user, st.Emergency[user])
+	}
+
+	commandChan := workflow.GetSignalChannel(ctx, DocumentSignal)
+	selector := workflow.NewSelector(ctx)
+	selector.AddReceive(commandChan, func(c workflow.ReceiveChannel, more bool) {
+		var cmd DocumentCommand
+		c.Receive(ctx, &cmd)
+		d.handle(cmd)
+	})
+	selector.AddReceive(calls, func(c workflow.ReceiveChannel, more bool) {
+		var call documentCall
+		c.Receive(ctx, &call)
+		d.handle(call.cmd)
+		call.done.SetValue(st.History[len(st.History)-1])
+	})
+	selector.AddReceive(d.expired, func(c workflow.ReceiveChannel, more bool) {
+		var user string
+		c.Receive(ctx, &user)
+		d.handle(DocumentCommand{Op: opExpire, User: user})
+	})
+
+	for !st.Archived {
+		selector.Select(ctx)
+		info := workflow.GetInfo(ctx)
+		if info.GetContinueAsNewSuggested() || info.GetCurrentHistoryLength() > maxDocumentHistoryLength {
+			// Do not lose what has already arrived ..
+			for selector.HasPending() {
+				selector.Select(ctx)
+			}
+			d.awaitPending()
+			logger.Info("DocumentWorkflow continuing as new", "DocID", input.DocID)
+			return workflow.NewContinueAsNewError(ctx, DocumentWorkflow, DocumentInput{
+				OrgID: st.OrgID,
+				DocID: input.DocID,
+				State: st,
+			})
+		}
+	}
+
+	d.awaitPending()
+	logger.Info("DocumentWorkflow archived", "DocID", input.DocID)
+	return nil
+}
+
+// documentCall is a command that came by update; the main loop applies it
+// in order with the signalled ones and settles done with its event ..
+type documentCall struct {
+	cmd  DocumentCommand
+	done workflow.Settable
+}
+
+// documentEntity is the in-workflow handler of commands ..
+type documentEntity struct {
+	ctx     workflow.Context
+	st      *DocumentState
+	expired workflow.Channel
+	// Decisions still being sent ..
+	notifying int
+}
+
+// awaitPending lets update handlers hand back their event, and decisions
+// go out, before the run ends ..
+func (d *documentEntity) awaitPending() {
+	_ = workflow.Await(d.ctx, func() bool { return d.notifying == 0 && workflow.AllHandlersFinished(d.ctx) })
+}
+
+func (d *documentEntity) handle(cmd DocumentCommand) {
+	if cmd.User == "" {
+		cmd.User = cmd.Actor
+	}
+	if cmd.Op != OpCreate && !d.st.Created {
+		d.record(cmd, false, "document not created")
+		return
+	}
+	var err error
+	switch cmd.Op {
+	case OpCreate:
+		err = d.create(cmd)
+	case OpShare:
+		err = d.share(cmd)
+	case OpRequestAccess:
+		err = d.requestAccess(cmd)
+	case OpApprove:
+		err = d.approve(cmd)
+	case OpReject:
+		err = d.reject(cmd)
+	case OpWithdraw:
+		err = d.withdraw(cmd)
+	case OpTransfer:
+		err = d.transfer(cmd)
+	case OpTempGrant:
+		err = d.tempGrant(cmd)
+	case OpRevoke:
+		err = d.revoke(cmd)
+	case OpAdminRevoke:
+		err = d.dropGrant(cmd.User)
+	case OpAdminShare:
+		err = d.addShare(cmd)
+	case OpUpdate:
+		err = d.update(cmd)
+	case OpClassify:
+		err = d.classify(cmd)
+	case OpArchive:
+		err = d.archive(cmd)
+	case OpEmergencyGrant:
+		err = d.emergencyGrant(cmd)
+	case OpEmergencyRevoke:
+		err = d.emergencyRevoke(cmd)
+	case opExpire:
+		err = d.expire(cmd)
+	default:
+		err = fmt.Errorf("unknown op %q", cmd.Op)
+	}
+	if err != nil {
+		workflow.GetLogger(d.ctx).Warn("Document command rejected", "Op", cmd.Op, "Error", err)
+		d.record(cmd, false, err.Error())
+		return
+	}
+	d.record(cmd, true, cmd.Reason)
+	if cmd.Op == OpRequestAccess {
+		d.routeToApprover(cmd)
+	}
+}
+
+// routeToApprover puts the request in the owner's inbox ..
+func (d *documentEntity) routeToApprover(cmd DocumentCommand) {
+	var a *Activities
+	err := workflow.ExecuteActivity(d.ctx, a.SignalInboxActivity, d.st.OrgID, d.st.Doc.Owner, InboxCommand{
+		Op: InboxRoute,
+		Item: &InboxItem{
+			OrgID:       d.st.OrgID,
+			DocID:       d.st.Doc.ID,
+			Requester:   cmd.User,
+			Reason:      cmd.Reason,
+			RequestedAt: workflow.Now(d.ctx),
+		},
+	}).Get(d.ctx, nil)
+	if err != nil {
+		workflow.GetLogger(d.ctx).Warn("Unable to route to approver", "Owner", d.st.Doc.Owner, "Error", err)
+	}
+}
+
+// clearPending drops the request; and out of the owner's inbox if it was there ..
+func (d *documentEntity) clearPending(user string) {
+	if _, ok := d.st.Pending[user]; !ok {
+		return
+	}
+	delete(d.st.Pending, user)
+	var a *Activities
+	err := workflow.ExecuteActivity(d.ctx, a.SignalInboxActivity, d.st.OrgID, d.st.Doc.Owner, InboxCommand{
+		Op:     InboxWithdraw,
+		ItemID: inboxItemID(d.st.Doc.ID, user),
+	}).Get(d.ctx, nil)
+	if err != nil {
+		workflow.GetLogger(d.ctx).Warn("Unable to withdraw from approver", "Owner", d.st.Doc.Owner, "Error", err)
+	}
+}
+
+func (d *documentEntity) record(cmd DocumentCommand, accepted bool, detail string) {
+	d.st.History = append(d.st.History, AccessEvent{
+		At:       workflow.Now(d.ctx),
+		Op:       cmd.Op,
+		Actor:    cmd.Actor,
+		User:     cmd.User,
+		Relation: cmd.Relation,
+		Accepted: accepted,
+		Detail:   detail,
+	})
+	if len(d.st.History) > maxAccessEvents {
+		d.st.History = d.st.History[len(d.st.History)-maxAccessEvents:]
+	}
+}
+
+func (d *documentEntity) requireOwner(cmd DocumentCommand) error {
+	if d.st.Doc.Owner == "" || cmd.Actor != d.st.Doc.Owner {
+		return fmt.Errorf("%s is not the owner", cmd.Actor)
+	}
+	return nil
+}
+
+func (d *documentEntity) grant(user, relation string) error {
+	var a *Activities
+	return workflow.ExecuteActivity(d.ctx, a.GrantAccessActivity, AccessChange{
+		User:     user,
+		Relation: relation,
+		Document: d.st.Doc.ID,
+	}).Get(d.ctx, nil)
+}
+
+func (d *documentEntity) revokeTuple(user, relation string) error {
+	var a *Activities
+	return workflow.ExecuteActivity(d.ctx, a.RevokeAccessActivity, AccessChange{
+		User:     user,
+		Relation: relation,
+		Document: d.st.Doc.ID,
+	}).Get(d.ctx, nil)
+}
+
+func (d *documentEntity) armExpiry(user string, until time.Time) {
+	workflow.Go(d.ctx, func(ctx workflow.Context) {
+		if warn := until.Add(-grantExpiryWarning).Sub(workflow.Now(ctx)); warn > 0 {
+			_ = workflow.Sleep(ctx, warn)
+			// Revoked or extended since; a newer timer warns if need be ..
+			if d.st.TempGrants[user].Equal(until) {
+				d.notify(ctx, user, notify.KindGrantExpiring, "Access ending: "+d.st.Doc.ID, map[string]string{
+					"doc":   d.st.Doc.ID,
+					"owner": d.st.Doc.Owner,
+					"until": until.Format(time.RFC1123),
+				})
+			}
+		}
+		if wait := until.Sub(workflow.Now(ctx)); wait > 0 {
+			_ = workflow.Sleep(ctx, wait)
+		}
+		d.expired.Send(ctx, user)
+	})
+}
+
+// notify tells user; a failed notification changes nothing about access ..
+func (d *documentEntity) notify(ctx workflow.Context, user, kind, subject string, data map[string]string) {
+	var a *Activities
+	err := workflow.ExecuteActivity(ctx, a.NotifyActivity, Notification{
+		To:      user,
+		Subject: subject,
+		Kind:    kind,
+		Data:    data,
+	}).Get(ctx, nil)
+	if err != nil {
+		workflow.GetLogger(ctx).Warn("NotifyActivity failed", "To", user, "Error", err)
+	}
+}
+
+// decided tells the requester how the owner decided; on the side, so a slow
+// notification never holds up the next command ..
+func (d *documentEntity) decided(cmd DocumentCommand, decision string) {
+	data := map[string]string{"doc": d.st.Doc.ID, "owner": cmd.Actor, "decision": decision}
+	if until, ok := d.st.TempGrants[cmd.User]; ok && decision == "approved" {
+		data["until"] = until.Format(time.RFC1123)
+	}
+	d.notifying++
+	workflow.Go(d.ctx, func(ctx workflow.Context) {
+		defer func() { d.notifying-- }()
+		d.notify(ctx, cmd.User, notify.KindAccessDecided, "Access "+decision+": "+d.st.Doc.ID, data)
+	})
+}
+
+func (d *documentEntity) create(cmd DocumentCommand) error {
+	if d.st.Created {
+		return fmt.Errorf("document already exists")
+	}
+	class := cmd.Classification
+	if class == "" {
+		class = "internal"
+	}
+	if _, ok := classificationRank[class]; !ok {
+		return fmt.Errorf("unknown classification %q", class)
+	}
+	d.st.Doc.Owner = cmd.Actor
+	d.st.Version = cmd.Version
+	d.st.Classification = class
+	// Every relation on it needs org membership too; one org per ID ..
+	var a *Activities
+	if d.st.OrgID != "" {
+		if err := workflow.ExecuteActivity(d.ctx, a.ClaimDocumentActivity, d.st.OrgID, d.st.Doc.ID).Get(d.ctx, nil); err != nil {
+			return err
+		}
+		if err := d.grant(OrgObject(d.st.OrgID), "org"); err != nil {
+			return err
+		}
+	}
+	if sensitive(class) {
+		if err := d.grant(anyUser, "sensitive"); err != nil {
+			return err
+		}
+	}
+	// Network / hours / device rules by where it lives e.g. secret/ ..
+	if err := workflow.ExecuteActivity(d.ctx, a.RestrictDocumentActivity, d.st.Doc.ID).Get(d.ctx, &d.st.Policy); err != nil {
+		return err
+	}
+	// Owner can always see + change it ..
+	if d.st.Doc.Owner != "" {
+		if err := d.grant(d.st.Doc.Owner, "owner"); err != nil {
+			return err
+		}
+		if err := d.grant(d.st.Doc.Owner, "editor"); err != nil {
+			return err
+		}
+		if err := d.grant(d.st.Doc.Owner, "viewer"); err != nil {
+			return err
+		}
+	}
+	d.st.Created = true
+	return nil
+}
+
+func (d *documentEntity) share(cmd DocumentCommand) error {
+	if err := d.requireOwner(cmd); err != nil {
+		return err
+	}
+	return d.addShare(cmd)
+}
+
+// addShare gives cmd.User viewer or editor; who may ask is the caller's ..
+func (d *documentEntity) addShare(cmd DocumentCommand) error {
+	if cmd.User == d.st.Doc.Owner {
+		return fmt.Errorf("%s is the owner", cmd.User)
+	}
+	relation := cmd.Relation
+	if relation == "" {
+		relation = "viewer"
+	}
+	if relation != "viewer" && relation != "editor" {
+		return fmt.Errorf("cannot share as %q", relation)
+	}
+	if err := d.grant(cmd.User, relation); err != nil {
+		return err
+	}
+	d.st.Grants[cmd.User] = relation
+	d.clearPending(cmd.User)
+	return nil
+}
+
+// update records the new version written to the docstore; editors may come
+// from groups the entity does not know about so the check is the caller's ..
+func (d *documentEntity) update(cmd DocumentCommand) error {
+	if cmd.Version <= d.st.Version {
+		return fmt.Errorf("version %d is not newer than %d", cmd.Version, d.st.Version)
+	}
+	d.st.Version = cmd.Version
+	return nil
+}
+
+func (d *documentEntity) requestAccess(cmd DocumentCommand) error {
+	if d.st.Doc.Owner == "" {
+		return fmt.Errorf("no owner to approve access")
+	}
+	if _, ok := d.st.Grants[cmd.User]; ok || cmd.User == d.st.Doc.Owner {
+		return fmt.Errorf("%s already has access", cmd.User)
+	}
+	if _, ok := d.st.Pending[cmd.User]; ok {
+		return fmt.Errorf("%s already has a pending request", cmd.User)
+	}
+	d.st.Pending[cmd.User] = cmd.Reason
+	return nil
+}
+
+func (d *documentEntity) approve(cmd DocumentCommand) error {
+	if err := d.requireOwner(cmd); err != nil {
+		return err
+	}
+	if _, ok := d.st.Pending[cmd.User]; !ok {
+		return fmt.Errorf("no pending request from %s", cmd.User)
+	}
+	d.clearPending(cmd.User)
+	if cmd.Duration > 0 {
+		if err := d.tempGrant(cmd); err != nil {
+			return err
+		}
+		d.decided(cmd, "approved")
+		return nil
+	}
+	if err := d.grant(cmd.User, "viewer"); err != nil {
+		return err
+	}
+	d.st.Grants[cmd.User] = "viewer"
+	d.decided(cmd, "approved")
+	return nil
+}
+
+func (d *documentEntity) reject(cmd DocumentCommand) error {
+	if err := d.requireOwner(cmd); err != nil {
+		return err
+	}
+	if _, ok := d.st.Pending[cmd.User]; !ok {
+		return fmt.Errorf("no pending request from %s", cmd.User)
+	}
+	d.clearPending(cmd.User)
+	d.decided(cmd, "rejected")
+	return nil
+}
+
+func (d *documentEntity) withdraw(cmd DocumentCommand) error {
+	if cmd.Actor != cmd.User {
+		if err := d.requireOwner(cmd); err != nil {
+			return err
+		}
+	}
+	if _, ok := d.st.Pending[cmd.User]; !ok {
+		return fmt.Errorf("no pending request from %s", cmd.User)
+	}
+	d.clearPending(cmd.User)
+	return nil
+}
+
+// transfer makes cmd.User the owner; the old owner keeps nothing and
+// outstanding requests move to the new owner's inbox ..
+func (d *documentEntity) transfer(cmd DocumentCommand) error {
+	if err := d.requireOwner(cmd); err != nil {
+		return err
+	}
+	if cmd.User == "" || cmd.User == d.st.Doc.Owner {
+		return fmt.Errorf("transfer needs a new owner")
+	}
+	old := d.st.Doc.Owner
+	for _, relation := range []string{"owner", "editor", "viewer"} {
+		if err := d.grant(cmd.User, relation); err != nil {
+			return err
+		}
+	}
+	for _, relation := range []string{"owner", "editor", "viewer"} {
+		if err := d.revokeTuple(old, relation); err != nil {
+			return err
+		}
+	}
+	d.clearPending(cmd.User)
+	// Owner tuples cover it now; a pending expiry must not pull the viewer tuple ..
+	delete(d.st.Grants, cmd.User)
+	delete(d.st.TempGrants, cmd.User)
+	delete(d.st.Emergency, cmd.User)
+
+	var a *Activities
+	for _, user := range sortedKeys(d.st.Pending) {
+		err := workflow.ExecuteActivity(d.ctx, a.SignalInboxActivity, d.st.OrgID, old, InboxCommand{
+			Op:     InboxWithdraw,
+			ItemID: inboxItemID(d.st.Doc.ID, user),
+		}).Get(d.ctx, nil)
+		if err != nil {
+			workflow.GetLogger(d.ctx).Warn("Unable to withdraw from approver", "Owner", old, "Error", err)
+		}
+	}
+	d.st.Doc.Owner = cmd.User
+	for _, user := range sortedKeys(d.st.Pending) {
+		d.routeToApprover(DocumentCommand{User: user, Reason: d.st.Pending[user]})
+	}
+	return nil
+}
+
+func (d *documentEntity) tempGrant(cmd DocumentCommand) error {
+	if err := d.requireOwner(cmd); err != nil {
+		return err
+	}
+	if cmd.Duration <= 0 {
+		return fmt.Errorf("temporary grant needs a duration")
+	}
+	if err := d.grant(cmd.User, "viewer"); err != nil {
+		return err
+	}
+	until := workflow.Now(d.ctx).Add(cmd.Duration)
+	d.st.TempGrants[cmd.User] = until
+	d.clearPending(cmd.User)
+	d.armExpiry(cmd.User, until)
+	return nil
+}
+
+// emergencyGrant is break-glass; no owner in the loop but always time-boxed ..
+func (d *documentEntity) emergencyGrant(cmd DocumentCommand) error {
+	if cmd.Reason == "" {
+		return fmt.Errorf("emergency access needs an incident reference")
+	}
+	if cmd.Duration <= 0 {
+		return fmt.Errorf("emergency access needs a duration")
+	}
+	if _, ok := d.st.Emergency[cmd.User]; ok {
+		return fmt.Errorf("%s already has emergency access", cmd.User)
+	}
+	if err := d.grant(cmd.User, "viewer"); err != nil {
+		return err
+	}
+	until := workflow.Now(d.ctx).Add(cmd.Duration)
+	d.st.Emergency[cmd.User] = until
+	d.armExpiry(cmd.User, until)
+	return nil
+}
+
+// emergencyRevoke pulls break-glass access early; e.g. never ratified ..
+func (d *documentEntity) emergencyRevoke(cmd DocumentCommand) error {
+	if _, ok := d.st.Emergency[cmd.User]; !ok {
+		return fmt.Errorf("no emergency grant for %s", cmd.User)
+	}
+	delete(d.st.Emergency, cmd.User)
+	return d.releaseViewer(cmd.User)
+}
+
+func (d *documentEntity) expire(cmd DocumentCommand) error {
+	now := workflow.Now(d.ctx)
+	until, temporary := d.st.TempGrants[cmd.User]
+	temporary = temporary && !until.After(now)
+	until, emergency := d.st.Emergency[cmd.User]
+	emergency = emergency && !until.After(now)
+	if !temporary && !emergency {
+		// Revoked or extended since the timer was set ..
+		return fmt.Errorf("no expired grant for %s", cmd.User)
+	}
+	if temporary {
+		delete(d.st.TempGrants, cmd.User)
+	}
+	if emergency {
+		delete(d.st.Emergency, cmd.User)
+	}
+	return d.releaseViewer(cmd.User)
+}
+
+// releaseViewer drops the viewer tuple unless a viewer share, temporary or
+// emergency grant still needs it; an editor share never had it ..
+func (d *documentEntity) releaseViewer(user string) error {
+	_, temporary := d.st.TempGrants[user]
+	_, emergency := d.st.Emergency[user]
+	if d.st.Grants[user] == "viewer" || temporary || emergency {
+		return nil
+	}
+	return d.revokeTuple(user, "viewer")
+}
+
+func (d *documentEntity) revoke(cmd DocumentCommand) error {
+	// Owner can revoke anyone; anyone can drop their own access ..
+	if cmd.Actor != cmd.User {
+		if err := d.requireOwner(cmd); err != nil {
+			return err
+		}
+	}
+	return d.dropGrant(cmd.User)
+}
+
+// dropGrant takes away whatever user was granted; never the owner's ..
+func (d *documentEntity) dropGrant(user string) error {
+	if user == d.st.Doc.Owner {
+		return fmt.Errorf("cannot revoke the owner")
+	}
+	relation, standing := d.st.Grants[user]
+	_, temporary := d.st.TempGrants[user]
+	if !standing && !temporary {
+		return fmt.Errorf("%s has no grant", user)
+	}
+	// Shares wrote just their relation; temporary grants the viewer tuple.
+	// Break-glass is not a grant to drop here; it keeps the viewer tuple ..
+	if standing && relation != "viewer" {
+		if err := d.revokeTuple(user, relation); err != nil {
+			return err
+		}
+	}
+	if _, emergency := d.st.Emergency[user]; (temporary || relation == "viewer") && !emergency {
+		if err := d.revokeTuple(user, "viewer"); err != nil {
+			return err
+		}
+	}
+	delete(d.st.Grants, user)
+	delete(d.st.TempGrants, user)
+	return nil
+}
+
+// revokeAllShares drops every non-owner grant; each one lands in the history ..
+func (d *documentEntity) revokeAllShares(actor, reason string) error {
+	users := append(sortedKeys(d.st.Grants), sortedKeys(d.st.TempGrants)...)
+	for _, user := range users {
+		cmd := DocumentCommand{Op: OpRevoke, Actor: user, User: user, Reason: reason}
+		if _, ok := d.st.Grants[user]; !ok {
+			if _, ok := d.st.TempGrants[user]; !ok {
+				// Had both; already gone ..
+				continue
+			}
+		}
+		if err := d.revoke(cmd); err != nil {
+			return err
+		}
+		cmd.Actor = actor
+		d.record(cmd, true, reason)
+	}
+	return nil
+}
+
+func (d *documentEntity) classify(cmd DocumentCommand) error {
+	if err := d.requireOwner(cmd); err != nil {
+		return err
+	}
+	rank, ok := classificationRank[cmd.Classification]
+	if !ok {
+		return fmt.Errorf("unknown classification %q", cmd.Classification)
+	}
+	// Tightening means everyone shared has to ask again ..
+	if rank > classificationRank[d.st.Classification] {
+		if err := d.revokeAllShares(cmd.Actor, "classification raised to "+cmd.Classification); err != nil {
+			return err
+		}
+	}
+	switch was, is := sensitive(d.st.Classification), sensitive(cmd.Classification); {
+	case is && !was:
+		if err := d.grant(anyUser, "sensitive"); err != nil {
+			return err
+		}
+	case was && !is:
+		if err := d.revokeTuple(anyUser, "sensitive"); err != nil {
+			return err
+		}
+	}
+	d.st.Classification = cmd.Classification
+	return nil
+}
+
+func (d *documentEntity) archive(cmd DocumentCommand) error {
+	if err := d.requireOwner(cmd); err != nil {
+		return err
+	}
+	if err := d.revokeAllShares(cmd.Actor, "archived"); err != nil {
+		return err
+	}
+	for _, user := range sortedKeys(d.st.Pending) {
+		d.clearPending(user)
+	}
+	var a *Activities
+	if err := workflow.ExecuteActivity(d.ctx, a.DeleteContentActivity, d.st.Doc.ID, cmd.Actor).Get(d.ctx, nil); err != nil {
+		return err
+	}
+	d.st.Archived = true
+	return nil
+}
+
+// sortedKeys so that iterating state stays deterministic on replay ..
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,568 @@
+package authz
+
+import (
+	"app/internal/notify"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"testing"
+	"time"
+)
+
+func TestDocumentWorkflowLifecycle(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+
+	var a *Activities
+	env.RegisterActivity(a)
+	var granted, revoked []AccessChange
+	env.OnActivity(a.GrantAccessActivity, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, change AccessChange) error {
+			granted = append(granted, change)
+			return nil
+		})
+	env.OnActivity(a.RevokeAccessActivity, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, change AccessChange) error {
+			revoked = append(revoked, change)
+			return nil
+		})
+	env.OnActivity(a.ClaimDocumentActivity, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.RestrictDocumentActivity, mock.Anything, "secret/secretz.doc").Return("secret-office", nil)
+	var deleted []string
+	env.OnActivity(a.DeleteContentActivity, mock.Anything, mock.Anything, "bob").Return(
+		func(_ context.Context, document, _ string) error {
+			deleted = append(deleted, document)
+			return nil
+		})
+
+	var routed []InboxCommand
+	env.OnActivity(a.SignalInboxActivity, mock.Anything, "GopherLab", "bob", mock.Anything).Return(
+		func(_ context.Context, _, _ string, cmd InboxCommand) error {
+			routed = append(routed, cmd)
+			return nil
+		})
+	var notified []Notification
+	env.OnActivity(a.NotifyActivity, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, n Notification) error {
+			notified = append(notified, n)
+			return nil
+		})
+
+	signal := func(delay time.Duration, cmd DocumentCommand) {
+		env.RegisterDelayedCallback(func() {
+			env.SignalWorkflow(DocumentSignal, cmd)
+		}, delay)
+	}
+	signal(time.Minute, DocumentCommand{Op: OpCreate, Actor: "bob", Classification: "internal"})
+	// Not the owner; must be refused ..
+	signal(time.Minute*2, DocumentCommand{Op: OpShare, Actor: "mleow", User: "mleow"})
+	signal(time.Minute*3, DocumentCommand{Op: OpRequestAccess, Actor: "mleow", Reason: "audit"})
+	signal(time.Minute*4, DocumentCommand{Op: OpApprove, Actor: "bob", User: "mleow", Duration: time.Hour})
+	// Temp grant expires at ~1h4m ..
+	env.RegisterDelayedCallback(func() {
+		v, err := env.QueryWorkflow(DocumentStateQuery)
+		assert.NoError(t, err)
+		var st DocumentState
+		assert.NoError(t, v.Get(&st))
+		assert.Empty(t, st.TempGrants)
+		assert.Empty(t, st.Pending)
+		assert.Equal(t, "secret-office", st.Policy)
+	}, time.Hour*2)
+	signal(time.Hour*3, DocumentCommand{Op: OpShare, Actor: "bob", User: "alice"})
+	signal(time.Hour*4, DocumentCommand{Op: OpClassify, Actor: "bob", Classification: "secret"})
+	signal(time.Hour*5, DocumentCommand{Op: OpArchive, Actor: "bob"})
+
+	var history []AccessEvent
+	env.RegisterDelayedCallback(func() {
+		v, err := env.QueryWorkflow(DocumentHistoryQuery)
+		assert.NoError(t, err)
+		assert.NoError(t, v.Get(&history))
+	}, time.Hour*4+time.Minute)
+
+	env.ExecuteWorkflow(DocumentWorkflow, DocumentInput{OrgID: "GopherLab", DocID: "secret/secretz.doc"})
+
+	assert.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+
+	assert.Equal(t, []AccessChange{
+		{User: "organization:GopherLab", Relation: "org", Document: "secret/secretz.doc"},
+		{User: "bob", Relation: "owner", Document: "secret/secretz.doc"},
+		{User: "bob", Relation: "editor", Document: "secret/secretz.doc"},
+		{User: "bob", Relation: "viewer", Document: "secret/secretz.doc"},
+		{User: "mleow", Relation: "viewer", Document: "secret/secretz.doc"},
+		{User: "alice", Relation: "viewer", Document: "secret/secretz.doc"},
+		{User: "user:*", Relation: "sensitive", Document: "secret/secretz.doc"},
+	}, granted)
+	assert.Equal(t, []AccessChange{
+		{User: "mleow", Relation: "viewer", Document: "secret/secretz.doc"},
+		{User: "alice", Relation: "viewer", Document: "secret/secretz.doc"},
+	}, revoked)
+	// Content goes with it ..
+	assert.Equal(t, []string{"secret/secretz.doc"}, deleted)
+
+	// Request went to bob's inbox; then withdrawn once approved ..
+	if assert.Len(t, routed, 2) {
+		assert.Equal(t, InboxRoute, routed[0].Op)
+		assert.Equal(t, "mleow", routed[0].Item.Requester)
+		assert.Equal(t, InboxWithdraw, routed[1].Op)
+		assert.Equal(t, "secret/secretz.doc#mleow", routed[1].ItemID)
+	}
+
+	// mleow hears of the approval, then of the grant ending ..
+	if assert.Len(t, notified, 2) {
+		assert.Equal(t, "mleow", notified[0].To)
+		assert.Equal(t, notify.KindAccessDecided, notified[0].Kind)
+		assert.Equal(t, "approved", notified[0].Data["decision"])
+		assert.NotEmpty(t, notified[0].Data["until"])
+		assert.Equal(t, "mleow", notified[1].To)
+		assert.Equal(t, notify.KindGrantExpiring, notified[1].Kind)
+		assert.Equal(t, "bob", notified[1].Data["owner"])
+	}
+
+	ops := make([]string, 0, len(history))
+	for _, e := range history {
+		if e.Accepted {
+			ops = append(ops, e.Op)
+		}
+	}
+	assert.Equal(t, []string{OpCreate, OpRequestAccess, OpApprove, opExpire, OpShare, OpRevoke, OpClassify}, ops)
+	assert.False(t, history[1].Accepted, "non-owner share should be refused")
+}
+
+func TestDocumentWorkflowWithdrawRequest(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+
+	var a *Activities
+	env.RegisterActivity(a)
+	env.OnActivity(a.GrantAccessActivity, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.ClaimDocumentActivity, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.RestrictDocumentActivity, mock.Anything, mock.Anything).Return("", nil)
+	env.OnActivity(a.DeleteContentActivity, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.NotifyActivity, mock.Anything, mock.Anything).Return(nil)
+	var routed []InboxCommand
+	env.OnActivity(a.SignalInboxActivity, mock.Anything, "GopherLab", "bob", mock.Anything).Return(
+		func(_ context.Context, _, _ string, cmd InboxCommand) error {
+			routed = append(routed, cmd)
+			return nil
+		})
+
+	signal := func(delay time.Duration, cmd DocumentCommand) {
+		env.RegisterDelayedCallback(func() {
+			env.SignalWorkflow(DocumentSignal, cmd)
+		}, delay)
+	}
+	signal(time.Minute, DocumentCommand{Op: OpCreate, Actor: "bob", Version: 1})
+	signal(time.Minute*2, DocumentCommand{Op: OpRequestAccess, Actor: "mleow", Reason: "audit"})
+	// Someone else can not withdraw it ..
+	signal(time.Minute*3, DocumentCommand{Op: OpWithdraw, Actor: "alice", User: "mleow"})
+	signal(time.Minute*4, DocumentCommand{Op: OpWithdraw, Actor: "mleow", User: "mleow"})
+	signal(time.Minute*4+time.Second, DocumentCommand{Op: OpUpdate, Actor: "bob", Version: 2})
+	// Behind what is already there; refused ..
+	signal(time.Minute*4+time.Second*2, DocumentCommand{Op: OpUpdate, Actor: "bob", Version: 2})
+	var st DocumentState
+	env.RegisterDelayedCallback(func() {
+		v, err := env.QueryWorkflow(DocumentStateQuery)
+		assert.NoError(t, err)
+		assert.NoError(t, v.Get(&st))
+	}, time.Minute*5)
+	signal(time.Minute*6, DocumentCommand{Op: OpArchive, Actor: "bob"})
+
+	env.ExecuteWorkflow(DocumentWorkflow, DocumentInput{OrgID: "GopherLab", DocID: "public/plan.doc"})
+	assert.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+
+	assert.Empty(t, st.Pending)
+	assert.Equal(t, 2, st.Version)
+	assert.False(t, st.History[len(st.History)-1].Accepted, "stale version should be refused")
+	if assert.Len(t, routed, 2) {
+		assert.Equal(t, InboxWithdraw, routed[1].Op)
+	}
+}
+
+func TestDocumentWorkflowTransfer(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+
+	var a *Activities
+	env.RegisterActivity(a)
+	var granted, revoked []AccessChange
+	env.OnActivity(a.GrantAccessActivity, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, change AccessChange) error {
+			granted = append(granted, change)
+			return nil
+		})
+	env.OnActivity(a.RevokeAccessActivity, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, change AccessChange) error {
+			revoked = append(revoked, change)
+			return nil
+		})
+	env.OnActivity(a.ClaimDocumentActivity, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.RestrictDocumentActivity, mock.Anything, mock.Anything).Return("", nil)
+	env.OnActivity(a.DeleteContentActivity, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.NotifyActivity, mock.Anything, mock.Anything).Return(nil)
+	routed := map[string][]InboxCommand{}
+	env.OnActivity(a.SignalInboxActivity, mock.Anything, "GopherLab", mock.Anything, mock.Anything).Return(
+		func(_ context.Context, _, approver string, cmd InboxCommand) error {
+			routed[approver] = append(routed[approver], cmd)
+			return nil
+		})
+
+	signal := func(delay time.Duration, cmd DocumentCommand) {
+		env.RegisterDelayedCallback(func() {
+			env.SignalWorkflow(DocumentSignal, cmd)
+		}, delay)
+	}
+	signal(time.Minute, DocumentCommand{Op: OpCreate, Actor: "bob"})
+	signal(time.Minute*2, DocumentCommand{Op: OpRequestAccess, Actor: "alice", Reason: "audit"})
+	// Only the owner can hand it over ..
+	signal(time.Minute*3, DocumentCommand{Op: OpTransfer, Actor: "mleow", User: "mleow"})
+	signal(time.Minute*4, DocumentCommand{Op: OpTransfer, Actor: "bob", User: "mleow"})
+	var st DocumentState
+	env.RegisterDelayedCallback(func() {
+		v, err := env.QueryWorkflow(DocumentStateQuery)
+		assert.NoError(t, err)
+		assert.NoError(t, v.Get(&st))
+	}, time.Minute*5)
+	signal(time.Minute*6, DocumentCommand{Op: OpArchive, Actor: "mleow"})
+
+	env.ExecuteWorkflow(DocumentWorkflow, DocumentInput{OrgID: "GopherLab", DocID: "bob/plan.doc"})
+	assert.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+
+	assert.Equal(t, "mleow", st.Doc.Owner)
+	assert.Len(t, granted, 7)
+	assert.Equal(t, []AccessChange{
+		{User: "bob", Relation: "owner", Document: "bob/plan.doc"},
+		{User: "bob", Relation: "editor", Document: "bob/plan.doc"},
+		{User: "bob", Relation: "viewer", Document: "bob/plan.doc"},
+	}, revoked)
+	// Outstanding request follows the document ..
+	if assert.Len(t, routed["bob"], 2) {
+		assert.Equal(t, InboxWithdraw, routed["bob"][1].Op)
+	}
+	// Archiving withdraws it again ..
+	if assert.Len(t, routed["mleow"], 2) {
+		assert.Equal(t, InboxRoute, routed["mleow"][0].Op)
+		assert.Equal(t, "alice", routed["mleow"][0].Item.Requester)
+	}
+}
+
+func TestDocumentWorkflowAdminRevoke(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+
+	var a *Activities
+	env.RegisterActivity(a)
+	var revoked []AccessChange
+	env.OnActivity(a.GrantAccessActivity, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.RevokeAccessActivity, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, change AccessChange) error {
+			revoked = append(revoked, change)
+			return nil
+		})
+	env.OnActivity(a.ClaimDocumentActivity, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.RestrictDocumentActivity, mock.Anything, mock.Anything).Return("", nil)
+	env.OnActivity(a.DeleteContentActivity, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.NotifyActivity, mock.Anything, mock.Anything).Return(nil)
+
+	signal := func(delay time.Duration, cmd DocumentCommand) {
+		env.RegisterDelayedCallback(func() {
+			env.SignalWorkflow(DocumentSignal, cmd)
+		}, delay)
+	}
+	signal(time.Minute, DocumentCommand{Op: OpCreate, Actor: "bob"})
+	signal(time.Minute*2, DocumentCommand{Op: OpTempGrant, Actor: "bob", User: "alice", Duration: time.Hour})
+	// Admin is not the owner; plain revoke is refused, the console's is not ..
+	signal(time.Minute*3, DocumentCommand{Op: OpRevoke, Actor: "mleow", User: "alice"})
+	signal(time.Minute*4, DocumentCommand{Op: OpAdminRevoke, Actor: "mleow", User: "alice"})
+	signal(time.Minute*5, DocumentCommand{Op: OpAdminRevoke, Actor: "mleow", User: "bob"})
+	// Console shares go through here too; never to the owner ..
+	signal(time.Minute*5+time.Second, DocumentCommand{Op: OpAdminShare, Actor: "mleow", User: "carol", Relation: "editor"})
+	signal(time.Minute*5+time.Second*2, DocumentCommand{Op: OpAdminShare, Actor: "mleow", User: "bob", Relation: "viewer"})
+	var st DocumentState
+	var history []AccessEvent
+	env.RegisterDelayedCallback(func() {
+		v, err := env.QueryWorkflow(DocumentStateQuery)
+		assert.NoError(t, err)
+		assert.NoError(t, v.Get(&st))
+		v, err = env.QueryWorkflow(DocumentHistoryQuery)
+		assert.NoError(t, err)
+		assert.NoError(t, v.Get(&history))
+	}, time.Minute*6)
+	// Editor share only ever wrote the editor tuple ..
+	signal(time.Minute*6+time.Second, DocumentCommand{Op: OpAdminRevoke, Actor: "mleow", User: "carol"})
+	signal(time.Minute*7, DocumentCommand{Op: OpArchive, Actor: "bob"})
+
+	env.ExecuteWorkflow(DocumentWorkflow, DocumentInput{OrgID: "GopherLab", DocID: "bob/plan.doc"})
+	assert.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+
+	assert.Empty(t, st.TempGrants)
+	assert.Contains(t, revoked, AccessChange{User: "alice", Relation: "viewer", Document: "bob/plan.doc"})
+	accepted := map[string]bool{}
+	for _, e := range history {
+		accepted[e.Op+":"+e.Actor+":"+e.User] = e.Accepted
+	}
+	assert.False(t, accepted["revoke:mleow:alice"])
+	assert.True(t, accepted["adminRevoke:mleow:alice"])
+	assert.False(t, accepted["adminRevoke:mleow:bob"])
+	assert.True(t, accepted["adminShare:mleow:carol"])
+	assert.False(t, accepted["adminShare:mleow:bob"])
+	assert.Equal(t, map[string]string{"carol": "editor"}, st.Grants)
+	assert.Contains(t, revoked, AccessChange{User: "carol", Relation: "editor", Document: "bob/plan.doc"})
+	assert.NotContains(t, revoked, AccessChange{User: "carol", Relation: "viewer", Document: "bob/plan.doc"})
+}
+
+func TestDocumentWorkflowEmergencyKeepsTempGrant(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+
+	var a *Activities
+	env.RegisterActivity(a)
+	var revoked []AccessChange
+	env.OnActivity(a.GrantAccessActivity, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.RevokeAccessActivity, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, change AccessChange) error {
+			revoked = append(revoked, change)
+			return nil
+		})
+	env.OnActivity(a.ClaimDocumentActivity, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.RestrictDocumentActivity, mock.Anything, mock.Anything).Return("", nil)
+	env.OnActivity(a.DeleteContentActivity, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.NotifyActivity, mock.Anything, mock.Anything).Return(nil)
+
+	signal := func(delay time.Duration, cmd DocumentCommand) {
+		env.RegisterDelayedCallback(func() {
+			env.SignalWorkflow(DocumentSignal, cmd)
+		}, delay)
+	}
+	signal(time.Minute, DocumentCommand{Op: OpCreate, Actor: "bob"})
+	signal(time.Minute*2, DocumentCommand{Op: OpTempGrant, Actor: "bob", User: "alice", Duration: time.Hour * 8})
+	signal(time.Minute*3, DocumentCommand{Op: OpEmergencyGrant, Actor: "alice", User: "alice", Duration: time.Hour, Reason: "INC-1"})
+	signal(time.Minute*4, DocumentCommand{Op: OpEmergencyGrant, Actor: "alice", User: "alice", Duration: time.Hour, Reason: "INC-1"})
+	// Never ratified ..
+	signal(time.Minute*20, DocumentCommand{Op: OpEmergencyRevoke, Actor: "alice", User: "alice", Reason: "INC-1"})
+	var st DocumentState
+	var history []AccessEvent
+	var revokedBefore []AccessChange
+	env.RegisterDelayedCallback(func() {
+		v, err := env.QueryWorkflow(DocumentStateQuery)
+		assert.NoError(t, err)
+		assert.NoError(t, v.Get(&st))
+		v, err = env.QueryWorkflow(DocumentHistoryQuery)
+		assert.NoError(t, err)
+		assert.NoError(t, v.Get(&history))
+		revokedBefore = append(revokedBefore, revoked...)
+	}, time.Hour*2)
+	signal(time.Hour*3, DocumentCommand{Op: OpArchive, Actor: "bob"})
+
+	env.ExecuteWorkflow(DocumentWorkflow, DocumentInput{OrgID: "GopherLab", DocID: "bob/plan.doc"})
+	assert.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+
+	// alice's temporary grant outlives the break-glass; so does its tuple ..
+	assert.Contains(t, st.TempGrants, "alice")
+	assert.Empty(t, st.Emergency)
+	assert.Empty(t, revokedBefore)
+	accepted := []bool{}
+	for _, e := range history {
+		if e.Op == OpEmergencyGrant {
+			accepted = append(accepted, e.Accepted)
+		}
+	}
+	assert.Equal(t, []bool{true, false}, accepted)
+}
+
+func TestDocumentWorkflowCreateTakenID(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+
+	var a *Activities
+	env.RegisterActivity(a)
+	var granted []AccessChange
+	env.OnActivity(a.GrantAccessActivity, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, change AccessChange) error {
+			granted = append(granted, change)
+			return nil
+		})
+	env.OnActivity(a.ClaimDocumentActivity, mock.Anything, "GopherLab", "secret/x.doc").Return(
+		temporal.NewNonRetryableApplicationError("document ID is taken by another org", "DocumentTakenError", nil)).Once()
+	env.OnActivity(a.ClaimDocumentActivity, mock.Anything, "GopherLab", "secret/x.doc").Return(nil)
+	env.OnActivity(a.RestrictDocumentActivity, mock.Anything, mock.Anything).Return("", nil)
+	env.OnActivity(a.DeleteContentActivity, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	signal := func(delay time.Duration, cmd DocumentCommand) {
+		env.RegisterDelayedCallback(func() {
+			env.SignalWorkflow(DocumentSignal, cmd)
+		}, delay)
+	}
+	signal(time.Minute, DocumentCommand{Op: OpCreate, Actor: "bob"})
+	var history []AccessEvent
+	var granting []AccessChange
+	env.RegisterDelayedCallback(func() {
+		v, err := env.QueryWorkflow(DocumentHistoryQuery)
+		assert.NoError(t, err)
+		assert.NoError(t, v.Get(&history))
+		granting = append(granting, granted...)
+	}, time.Minute*2)
+	signal(time.Minute*3, DocumentCommand{Op: OpCreate, Actor: "bob"})
+	signal(time.Minute*4, DocumentCommand{Op: OpArchive, Actor: "bob"})
+
+	env.ExecuteWorkflow(DocumentWorkflow, DocumentInput{OrgID: "GopherLab", DocID: "secret/x.doc"})
+	assert.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+
+	// Nothing written for an ID another org has ..
+	if assert.Len(t, history, 1) {
+		assert.False(t, history[0].Accepted)
+		assert.Contains(t, history[0].Detail, "taken by another org")
+	}
+	assert.Empty(t, granting)
+	assert.NotEmpty(t, granted)
+}
+
+func TestDocumentWorkflowRejectNotifiesOnTheSide(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+
+	var a *Activities
+	env.RegisterActivity(a)
+	env.OnActivity(a.GrantAccessActivity, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.RevokeAccessActivity, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.ClaimDocumentActivity, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.RestrictDocumentActivity, mock.Anything, mock.Anything).Return("", nil)
+	env.OnActivity(a.DeleteContentActivity, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.SignalInboxActivity, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	// Slow channel; the entity must not wait on it ..
+	var notified []Notification
+	env.OnActivity(a.NotifyActivity, mock.Anything, mock.Anything).After(time.Hour).Return(
+		func(_ context.Context, n Notification) error {
+			notified = append(notified, n)
+			return nil
+		})
+
+	signal := func(delay time.Duration, cmd DocumentCommand) {
+		env.RegisterDelayedCallback(func() {
+			env.SignalWorkflow(DocumentSignal, cmd)
+		}, delay)
+	}
+	signal(time.Minute, DocumentCommand{Op: OpCreate, Actor: "bob"})
+	signal(time.Minute*2, DocumentCommand{Op: OpRequestAccess, Actor: "alice", Reason: "audit"})
+	signal(time.Minute*3, DocumentCommand{Op: OpReject, Actor: "bob", User: "alice"})
+	signal(time.Minute*4, DocumentCommand{Op: OpShare, Actor: "bob", User: "carol"})
+	var st DocumentState
+	env.RegisterDelayedCallback(func() {
+		v, err := env.QueryWorkflow(DocumentStateQuery)
+		assert.NoError(t, err)
+		assert.NoError(t, v.Get(&st))
+	}, time.Minute*5)
+	signal(time.Minute*6, DocumentCommand{Op: OpArchive, Actor: "bob"})
+
+	env.ExecuteWorkflow(DocumentWorkflow, DocumentInput{OrgID: "GopherLab", DocID: "bob/plan.doc"})
+	assert.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+
+	assert.Equal(t, "viewer", st.Grants["carol"])
+	// Still sent before the entity finished; no until on a rejection ..
+	if assert.Len(t, notified, 1) {
+		assert.Equal(t, "alice", notified[0].To)
+		assert.Equal(t, "rejected", notified[0].Data["decision"])
+		assert.NotContains(t, notified[0].Data, "until")
+	}
+}
+
+// commandResult implements the SDK's update callbacks ..
+type commandResult struct {
+	event    AccessEvent
+	rejected error
+}
+
+func (r *commandResult) Accept()          {}
+func (r *commandResult) Reject(err error) { r.rejected = err }
+func (r *commandResult) Complete(v interface{}, err error) {
+	if e, ok := v.(AccessEvent); ok && err == nil {
+		r.event = e
+	}
+}
+
+func TestDocumentWorkflowCommandUpdate(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+
+	var a *Activities
+	env.RegisterActivity(a)
+	var granted []AccessChange
+	env.OnActivity(a.GrantAccessActivity, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, change AccessChange) error {
+			granted = append(granted, change)
+			return nil
+		})
+	env.OnActivity(a.RevokeAccessActivity, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.ClaimDocumentActivity, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.RestrictDocumentActivity, mock.Anything, mock.Anything).Return("", nil)
+	env.OnActivity(a.DeleteContentActivity, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	var byOwner, byService, noActor commandResult
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(DocumentSignal, DocumentCommand{Op: OpCreate, Actor: "bob"})
+	}, time.Minute)
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(DocumentCommandUpdate, "1", &byOwner, DocumentCommand{Op: OpShare, Actor: "bob", User: "alice"})
+	}, time.Minute*2)
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(DocumentCommandUpdate, "2", &byService, DocumentCommand{Op: OpShare, Actor: "service:k1", User: "mallory"})
+	}, time.Minute*3)
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(DocumentCommandUpdate, "3", &noActor, DocumentCommand{Op: OpShare, User: "mallory"})
+	}, time.Minute*4)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(DocumentSignal, DocumentCommand{Op: OpArchive, Actor: "bob"})
+	}, time.Minute*5)
+
+	env.ExecuteWorkflow(DocumentWorkflow, DocumentInput{OrgID: "GopherLab", DocID: "bob/plan.doc"})
+	assert.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+
+	// Caller learns the outcome, not just that it was sent ..
+	assert.True(t, byOwner.event.Accepted)
+	assert.Equal(t, "alice", byOwner.event.User)
+	assert.False(t, byService.event.Accepted)
+	assert.Contains(t, byService.event.Detail, "not the owner")
+	assert.Error(t, noActor.rejected)
+	assert.Contains(t, granted, AccessChange{User: "alice", Relation: "viewer", Document: "bob/plan.doc"})
+	assert.NotContains(t, granted, AccessChange{User: "mallory", Relation: "viewer", Document: "bob/plan.doc"})
+}
+
+func TestDiscoverable(t *testing.T) {
+	live := DocumentState{Created: true, Doc: Document{ID: "x.doc", Owner: "bob"}}
+	for class, want := range map[string]bool{"public": true, "internal": true, "confidential": true, "secret": false} {
+		st := live
+		st.Classification = class
+		assert.Equal(t, want, Discoverable(st), class)
+	}
+	archived := live
+	archived.Archived = true
+	assert.False(t, Discoverable(archived))
+	assert.False(t, Discoverable(DocumentState{}))
+}
+
+func TestAccessHistoryOf(t *testing.T) {
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	history := []AccessEvent{
+		{At: at, Op: OpCreate, Actor: "bob", User: "bob", Accepted: true},
+		{At: at.Add(time.Minute), Op: OpRequestAccess, Actor: "alice", User: "alice", Accepted: true},
+		{At: at.Add(2 * time.Minute), Op: OpRequestAccess, Actor: "alice", User: "alice", Accepted: false},
+		{At: at.Add(3 * time.Minute), Op: OpApprove, Actor: "bob", User: "alice", Accepted: true},
+		{At: at.Add(4 * time.Minute), Op: OpTempGrant, Actor: "bob", User: "mleow", Accepted: true},
+		{At: at.Add(5 * time.Minute), Op: opExpire, User: "alice", Accepted: true},
+	}
+	var ops []string
+	for _, e := range AccessHistoryOf(history, "alice") {
+		ops = append(ops, e.Op)
+	}
+	assert.Equal(t, []string{opExpire, OpApprove, OpRequestAccess}, ops)
+	assert.Empty(t, AccessHistoryOf(history, "nobody"))
+}
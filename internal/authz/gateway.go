@@ -0,0 +1,354 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	"time"
+)
+
+// Signal names understood by ActionWorkflow ..
+const (
+	ActionSignal    = "actionSignal"
+	TerminateSignal = "terminateSignal"
+)
+
+// Gateway is the single entry point to the per-tenant workflows.
+// Every call goes to a deterministic WorkflowID per org and lazily brings
+// the org workflow up if it is not running yet, so callers never see
+// a "workflow not found" because of boot ordering ..
+type Gateway struct {
+	client    client.Client
+	taskQueue string
+	// seed returns the initial state for an org being started ..
+	seed func(orgID string) WFDemoInput
+}
+
+// NewGateway wires the gateway; seed can be nil to start empty orgs ..
+func NewGateway(c client.Client, taskQueue string, seed func(orgID string) WFDemoInput) Gateway {
+	if seed == nil {
+		seed = func(orgID string) WFDemoInput {
+			return WFDemoInput{Name: orgID}
+		}
+	}
+	return Gateway{
+		client:    c,
+		taskQueue: taskQueue,
+		seed:      seed,
+	}
+}
+
+// OrgWorkflowID is the deterministic WorkflowID for the org ..
+func OrgWorkflowID(orgID string) string {
+	return "org-" + orgID
+}
+
+func (g Gateway) orgOptions(orgID string) client.StartWorkflowOptions {
+	return client.StartWorkflowOptions{
+		ID:        OrgWorkflowID(orgID),
+		TaskQueue: g.taskQueue,
+		// Org workflow can be cleanly stopped (kil); next request brings it back ..
+		WorkflowIDReusePolicy: enums.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE,
+		// Racing starters must get back the running one; not an error ..
+		WorkflowExecutionErrorWhenAlreadyStarted: false,
+	}
+}
+
+// EnsureOrg starts the org workflow if needed; else returns the running one ..
+func (g Gateway) EnsureOrg(ctx context.Context, orgID string) (client.WorkflowRun, error) {
+	if orgID == "" {
+		return nil, fmt.Errorf("gateway: missing orgID")
+	}
+	return g.client.ExecuteWorkflow(ctx, g.orgOptions(orgID), ActionWorkflow, g.seed(orgID))
+}
+
+// SignalOrg delivers the signal; starting the org workflow atomically if it is not running ..
+func (g Gateway) SignalOrg(ctx context.Context, orgID, signalName string, arg interface{}) error {
+	if orgID == "" {
+		return fmt.Errorf("gateway: missing orgID")
+	}
+	_, err := g.client.SignalWithStartWorkflow(ctx, OrgWorkflowID(orgID), signalName, arg,
+		g.orgOptions(orgID), ActionWorkflow, g.seed(orgID))
+	return err
+}
+
+// SendActions is the common case of signalling Actions to the org ..
+func (g Gateway) SendActions(ctx context.Context, orgID string, actions Actions) error {
+	return g.SignalOrg(ctx, orgID, ActionSignal, actions)
+}
+
+// StopOrg asks the org workflow to persist + finish; no point starting one just to stop it ..
+func (g Gateway) StopOrg(ctx context.Context, orgID string) error {
+	err := g.client.SignalWorkflow(ctx, OrgWorkflowID(orgID), "", TerminateSignal, true)
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) {
+		// Already gone; nothing to do ..
+		return nil
+	}
+	return err
+}
+
+// DocumentWorkflowID is the deterministic WorkflowID for the document entity;
+// the org is in it so one tenant can never reach another's entity. Document
+// IDs are still global (see ClaimDocumentActivity). Org IDs have no slash ..
+func DocumentWorkflowID(orgID, docID string) string {
+	return "doc-" + orgID + "/" + docID
+}
+
+// SignalDocument sends the command to the document entity; starting it if needed ..
+func (g Gateway) SignalDocument(ctx context.Context, orgID, docID string, cmd DocumentCommand) error {
+	if orgID == "" || docID == "" {
+		return fmt.Errorf("gateway: missing org or docID")
+	}
+	opts := client.StartWorkflowOptions{
+		ID:        DocumentWorkflowID(orgID, docID),
+		TaskQueue: g.taskQueue,
+		// Archived documents finish cleanly and stay archived;
+		// only a crashed / terminated entity may be brought back ..
+		WorkflowIDReusePolicy: enums.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE_FAILED_ONLY,
+	}
+	_, err := g.client.SignalWithStartWorkflow(ctx, DocumentWorkflowID(orgID, docID), DocumentSignal, cmd,
+		opts, DocumentWorkflow, DocumentInput{OrgID: orgID, DocID: docID})
+	return err
+}
+
+// CommandDocument applies the command and waits for the outcome; never starts
+// the entity so an unknown document is NotFound. The event says whether the
+// command was accepted ..
+func (g Gateway) CommandDocument(ctx context.Context, orgID, docID string, cmd DocumentCommand) (AccessEvent, error) {
+	var event AccessEvent
+	if orgID == "" || docID == "" {
+		return event, fmt.Errorf("gateway: missing org or docID")
+	}
+	h, err := g.client.UpdateWorkflow(ctx, client.UpdateWorkflowOptions{
+		WorkflowID:   DocumentWorkflowID(orgID, docID),
+		UpdateName:   DocumentCommandUpdate,
+		Args:         []interface{}{cmd},
+		WaitForStage: client.WorkflowUpdateStageCompleted,
+	})
+	if err != nil {
+		return event, err
+	}
+	err = h.Get(ctx, &event)
+	return event, err
+}
+
+// DocumentState asks the document entity for its current state ..
+func (g Gateway) DocumentState(ctx context.Context, orgID, docID string) (DocumentState, error) {
+	var st DocumentState
+	v, err := g.client.QueryWorkflow(ctx, DocumentWorkflowID(orgID, docID), "", DocumentStateQuery)
+	if err != nil {
+		return st, err
+	}
+	err = v.Get(&st)
+	return st, err
+}
+
+// DocumentHistory asks the document entity for its access history ..
+func (g Gateway) DocumentHistory(ctx context.Context, orgID, docID string) ([]AccessEvent, error) {
+	var events []AccessEvent
+	v, err := g.client.QueryWorkflow(ctx, DocumentWorkflowID(orgID, docID), "", DocumentHistoryQuery)
+	if err != nil {
+		return nil, err
+	}
+	err = v.Get(&events)
+	return events, err
+}
+
+// SignalInbox sends the command to the approver's inbox; starting it if needed ..
+func (g Gateway) SignalInbox(ctx context.Context, orgID, approver string, cmd InboxCommand) error {
+	if orgID == "" || approver == "" {
+		return fmt.Errorf("gateway: missing org or approver")
+	}
+	opts := client.StartWorkflowOptions{
+		ID:                    ApproverWorkflowID(orgID, approver),
+		TaskQueue:             g.taskQueue,
+		WorkflowIDReusePolicy: enums.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE,
+	}
+	_, err := g.client.SignalWithStartWorkflow(ctx, ApproverWorkflowID(orgID, approver), InboxSignal, cmd,
+		opts, ApproverInboxWorkflow, ApproverInput{OrgID: orgID, Approver: approver})
+	return err
+}
+
+// PendingApprovals asks the approver's inbox what is waiting; empty if no inbox yet ..
+func (g Gateway) PendingApprovals(ctx context.Context, orgID, approver string) (InboxState, error) {
+	st := InboxState{Approver: approver}
+	v, err := g.client.QueryWorkflow(ctx, ApproverWorkflowID(orgID, approver), "", InboxQuery)
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	err = v.Get(&st)
+	return st, err
+}
+
+// StartBreakGlass kicks off emergency access; one at a time per requester + document ..
+func (g Gateway) StartBreakGlass(ctx context.Context, input BreakGlassInput) (client.WorkflowRun, error) {
+	opts := client.StartWorkflowOptions{
+		ID:        BreakGlassWorkflowID(input.OrgID, input.DocID, input.Requester),
+		TaskQueue: g.taskQueue,
+		// Finished ones may be used again; a running one must not be stacked ..
+		WorkflowIDReusePolicy:                    enums.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE,
+		WorkflowExecutionErrorWhenAlreadyStarted: true,
+	}
+	return g.client.ExecuteWorkflow(ctx, opts, BreakGlassWorkflow, input)
+}
+
+// StartDeprovision strips a departing user's access; a second call while it runs is a no-op ..
+func (g Gateway) StartDeprovision(ctx context.Context, input DeprovisionInput) error {
+	opts := client.StartWorkflowOptions{
+		ID:                    DeprovisionWorkflowID(input.OrgID, input.User),
+		TaskQueue:             g.taskQueue,
+		WorkflowIDReusePolicy: enums.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE,
+	}
+	_, err := g.client.ExecuteWorkflow(ctx, opts, DeprovisionWorkflow, input)
+	return err
+}
+
+// SignalLifecycle hands a directory event to the user's lifecycle; starting it if needed ..
+func (g Gateway) SignalLifecycle(ctx context.Context, input LifecycleInput, ev LifecycleEvent) error {
+	if input.OrgID == "" || input.User == "" {
+		return fmt.Errorf("gateway: missing org or user")
+	}
+	opts := client.StartWorkflowOptions{
+		ID:        LifecycleWorkflowID(input.OrgID, input.User),
+		TaskQueue: g.taskQueue,
+		// Leavers finish; a rehire starts over ..
+		WorkflowIDReusePolicy: enums.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE,
+	}
+	_, err := g.client.SignalWithStartWorkflow(ctx, LifecycleWorkflowID(input.OrgID, input.User), LifecycleSignal, ev,
+		opts, LifecycleWorkflow, input)
+	return err
+}
+
+// DecideLifecycle is the manager (or security) answering a document transfer ..
+func (g Gateway) DecideLifecycle(ctx context.Context, orgID, user string, decision LifecycleDecision) error {
+	return g.client.SignalWorkflow(ctx, LifecycleWorkflowID(orgID, user), "", LifecycleDecisionSignal, decision)
+}
+
+// LifecycleStatus asks the user's lifecycle where it is ..
+func (g Gateway) LifecycleStatus(ctx context.Context, orgID, user string) (LifecycleState, error) {
+	var st LifecycleState
+	v, err := g.client.QueryWorkflow(ctx, LifecycleWorkflowID(orgID, user), "", LifecycleQuery)
+	if err != nil {
+		return st, err
+	}
+	err = v.Get(&st)
+	return st, err
+}
+
+// DecideBreakGlass is the second person ratifying or denying ..
+func (g Gateway) DecideBreakGlass(ctx context.Context, orgID, docID, requester string, decision BreakGlassDecision) error {
+	return g.client.SignalWorkflow(ctx, BreakGlassWorkflowID(orgID, docID, requester), "", BreakGlassSignal, decision)
+}
+
+// RecertScheduleID is the deterministic schedule for the tenant's campaigns ..
+func RecertScheduleID(orgID string) string {
+	return "recert-" + orgID
+}
+
+// EnsureRecertSchedule sets up the tenant's recurring recertification; no-op if it exists.
+// Overlapping campaigns are skipped; a new one only starts once the last is done ..
+func (g Gateway) EnsureRecertSchedule(ctx context.Context, orgID, cron string, deadline time.Duration) error {
+	_, err := g.client.ScheduleClient().Create(ctx, client.ScheduleOptions{
+		ID: RecertScheduleID(orgID),
+		Spec: client.ScheduleSpec{
+			CronExpressions: []string{cron},
+		},
+		Action: &client.ScheduleWorkflowAction{
+			ID:        RecertScheduleID(orgID),
+			Workflow:  RecertificationWorkflow,
+			Args:      []interface{}{RecertInput{OrgID: orgID, Deadline: deadline}},
+			TaskQueue: g.taskQueue,
+		},
+	})
+	if errors.Is(err, temporal.ErrScheduleAlreadyRunning) {
+		return nil
+	}
+	return err
+}
+
+// DecideRecert is the owner keeping or revoking a grant in a running campaign ..
+func (g Gateway) DecideRecert(ctx context.Context, campaignID string, decision RecertDecision) error {
+	return g.client.SignalWorkflow(ctx, campaignID, "", RecertSignal, decision)
+}
+
+// RecertStatus asks a campaign where it is at ..
+func (g Gateway) RecertStatus(ctx context.Context, campaignID string) (CampaignReport, error) {
+	var report CampaignReport
+	v, err := g.client.QueryWorkflow(ctx, campaignID, "", RecertQuery)
+	if err != nil {
+		return report, err
+	}
+	err = v.Get(&report)
+	return report, err
+}
+
+// RunningRecert finds the campaign the schedule currently has running; "" if none ..
+func (g Gateway) RunningRecert(ctx context.Context, orgID string) (string, error) {
+	desc, err := g.client.ScheduleClient().GetHandle(ctx, RecertScheduleID(orgID)).Describe(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, run := range desc.Info.RunningWorkflows {
+		return run.WorkflowID, nil
+	}
+	return "", nil
+}
+
+// StartShareLink sets the link up; IDs are random so never reused ..
+func (g Gateway) StartShareLink(ctx context.Context, link ShareLink) error {
+	opts := client.StartWorkflowOptions{
+		ID:                                       ShareLinkWorkflowID(link.ID),
+		TaskQueue:                                g.taskQueue,
+		WorkflowIDReusePolicy:                    enums.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE,
+		WorkflowExecutionErrorWhenAlreadyStarted: true,
+	}
+	_, err := g.client.ExecuteWorkflow(ctx, opts, ShareLinkWorkflow, link)
+	return err
+}
+
+// ShareLinkState asks the link where it is at; still answers once ended ..
+func (g Gateway) ShareLinkState(ctx context.Context, id string) (LinkState, error) {
+	var st LinkState
+	v, err := g.client.QueryWorkflow(ctx, ShareLinkWorkflowID(id), "", LinkStateQuery)
+	if err != nil {
+		return st, err
+	}
+	err = v.Get(&st)
+	return st, err
+}
+
+// UseShareLink counts a view; refused once the link has ended or the key
+// is wrong ..
+func (g Gateway) UseShareLink(ctx context.Context, id string, use LinkUse) (LinkState, error) {
+	var st LinkState
+	h, err := g.client.UpdateWorkflow(ctx, client.UpdateWorkflowOptions{
+		WorkflowID:   ShareLinkWorkflowID(id),
+		UpdateName:   LinkUseUpdate,
+		Args:         []interface{}{use},
+		WaitForStage: client.WorkflowUpdateStageCompleted,
+	})
+	if err != nil {
+		return st, err
+	}
+	err = h.Get(ctx, &st)
+	return st, err
+}
+
+// RevokeShareLink ends the link now; already ended is fine ..
+func (g Gateway) RevokeShareLink(ctx context.Context, id, actor string) error {
+	err := g.client.SignalWorkflow(ctx, ShareLinkWorkflowID(id), "", LinkRevokeSignal, actor)
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package authz
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/mocks"
+	"testing"
+)
+
+func TestGatewaySignalOrgStartsWithDeterministicID(t *testing.T) {
+	mc := mocks.NewClient(t)
+	gw := NewGateway(mc, "tq", func(orgID string) WFDemoInput {
+		return WFDemoInput{Name: orgID, Users: []string{"bob"}}
+	})
+
+	mc.On("SignalWithStartWorkflow", mock.Anything, "org-GopherLab", ActionSignal,
+		Actions{TempElevated: true},
+		mock.MatchedBy(func(o client.StartWorkflowOptions) bool {
+			return o.ID == "org-GopherLab" && o.TaskQueue == "tq" &&
+				o.WorkflowIDReusePolicy == enums.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE &&
+				!o.WorkflowExecutionErrorWhenAlreadyStarted
+		}),
+		mock.Anything,
+		WFDemoInput{Name: "GopherLab", Users: []string{"bob"}},
+	).Return(&mocks.WorkflowRun{}, nil).Once()
+
+	err := gw.SendActions(context.Background(), "GopherLab", Actions{TempElevated: true})
+	assert.NoError(t, err)
+}
+
+func TestGatewayRejectsMissingOrg(t *testing.T) {
+	gw := NewGateway(mocks.NewClient(t), "tq", nil)
+	assert.Error(t, gw.SignalOrg(context.Background(), "", ActionSignal, nil))
+	_, err := gw.EnsureOrg(context.Background(), "")
+	assert.Error(t, err)
+}
+
+func TestGatewayStopOrgIgnoresMissingWorkflow(t *testing.T) {
+	mc := mocks.NewClient(t)
+	gw := NewGateway(mc, "tq", nil)
+	mc.On("SignalWorkflow", mock.Anything, "org-CrabLab", "", TerminateSignal, true).
+		Return(serviceerror.NewNotFound("gone")).Once()
+
+	assert.NoError(t, gw.StopOrg(context.Background(), "CrabLab"))
+}
+
+func TestGatewaySignalDocumentIsPerOrg(t *testing.T) {
+	mc := mocks.NewClient(t)
+	gw := NewGateway(mc, "tq", nil)
+	cmd := DocumentCommand{Op: OpShare, Actor: "bob", User: "alice"}
+	for _, org := range []string{"GopherLab", "CrabLab"} {
+		mc.On("SignalWithStartWorkflow", mock.Anything, "doc-"+org+"/secret/x.doc", DocumentSignal, cmd,
+			mock.Anything, mock.Anything, DocumentInput{OrgID: org, DocID: "secret/x.doc"},
+		).Return(&mocks.WorkflowRun{}, nil).Once()
+		assert.NoError(t, gw.SignalDocument(context.Background(), org, "secret/x.doc", cmd))
+	}
+	assert.Error(t, gw.SignalDocument(context.Background(), "", "secret/x.doc", cmd))
+}
+
+func TestGatewayInboxIsPerOrg(t *testing.T) {
+	mc := mocks.NewClient(t)
+	gw := NewGateway(mc, "tq", nil)
+	mc.On("SignalWithStartWorkflow", mock.Anything, "approver-GopherLab/bob", InboxSignal, mock.Anything,
+		mock.Anything, mock.Anything, ApproverInput{OrgID: "GopherLab", Approver: "bob"},
+	).Return(&mocks.WorkflowRun{}, nil).Once()
+	mc.On("QueryWorkflow", mock.Anything, "approver-CrabLab/bob", "", InboxQuery).
+		Return(nil, serviceerror.NewNotFound("no inbox")).Once()
+
+	assert.NoError(t, gw.SignalInbox(context.Background(), "GopherLab", "bob", InboxCommand{Op: InboxUndelegate, Actor: "bob"}))
+	// Another tenant's bob has an inbox of their own ..
+	st, err := gw.PendingApprovals(context.Background(), "CrabLab", "bob")
+	assert.NoError(t, err)
+	assert.Empty(t, st.Items)
+}
+
+func TestGatewayBreakGlassIsPerOrg(t *testing.T) {
+	mc := mocks.NewClient(t)
+	gw := NewGateway(mc, "tq", nil)
+	decision := BreakGlassDecision{Actor: "bob", Ratify: true}
+	mc.On("SignalWorkflow", mock.Anything, "breakglass-CrabLab/secret/x.doc#mleow", "", BreakGlassSignal, decision).
+		Return(serviceerror.NewNotFound("no such request")).Once()
+
+	// GopherLab's request is not CrabLab's to ratify ..
+	assert.Equal(t, "breakglass-GopherLab/secret/x.doc#mleow", BreakGlassWorkflowID("GopherLab", "secret/x.doc", "mleow"))
+	assert.Error(t, gw.DecideBreakGlass(context.Background(), "CrabLab", "secret/x.doc", "mleow", decision))
+}
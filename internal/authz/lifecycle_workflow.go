@@ -0,0 +1,380 @@
+package authz
+
+import (
+	"fmt"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+	"strings"
+	"time"
+)
+
+// Signals + query understood by LifecycleWorkflow ..
+const (
+	LifecycleSignal         = "lifecycleEvent"
+	LifecycleDecisionSignal = "lifecycleDecision"
+	LifecycleQuery          = "lifecycleStatus"
+)
+
+// Kinds of LifecycleEvent ..
+const (
+	LifecycleJoiner = "joiner"
+	LifecycleMover  = "mover"
+	LifecycleLeaver = "leaver"
+)
+
+// LifecycleState.Status values ..
+const (
+	lifecycleJoined = "joined"
+	lifecycleLeft   = "left"
+)
+
+const (
+	// Each approver gets this long before it goes up a level ..
+	defaultTransferWindow = time.Hour * 72
+	// Directory webhooks are retried; remember this many event IDs ..
+	maxLifecycleSeen          = 50
+	maxLifecycleSteps         = 200
+	maxLifecycleHistoryLength = 2000
+)
+
+// LifecycleEvent is a joiner / mover / leaver from the directory. Team and
+// Manager are what the directory says now, not the change ..
+type LifecycleEvent struct {
+	ID      string
+	Kind    string
+	Team    string
+	Manager string
+	At      time.Time
+}
+
+// LifecycleDecision is the manager (or security) taking over a leaver's documents ..
+type LifecycleDecision struct {
+	Actor  string
+	Accept bool
+	// Defaults to Actor ..
+	NewOwner string
+	Comment  string
+}
+
+// LifecycleState is the query result; carried over on continue-as-new ..
+type LifecycleState struct {
+	Status  string
+	Team    string
+	Manager string
+	Seen    []string
+	Steps   []string
+	// Leaver's documents waiting for a new owner; Approver decides ..
+	Transfer []string
+	Approver string
+}
+
+// LifecycleInput starts (or continues) the user's lifecycle ..
+type LifecycleInput struct {
+	OrgID string
+	User  string
+	// Everyone in the org joins these groups ..
+	BaselineGroups  []string
+	SecurityContact string
+	TransferWindow  time.Duration
+	State           *LifecycleState
+}
+
+// LifecycleWorkflowID is one per user per org ..
+func LifecycleWorkflowID(orgID, user string) string {
+	return "lifecycle-" + orgID + "-" + user
+}
+
+// LifecycleWorkflow applies directory events for one user in order. Team
+// access is group membership: group:<org>/<team> ..
+func LifecycleWorkflow(ctx workflow.Context, input LifecycleInput) error {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("LifecycleWorkflow started", "OrgID", input.OrgID, "User", input.User)
+
+	if input.User == "" {
+		return temporal.NewNonRetryableApplicationError("user is required", "InvalidInputError", nil)
+	}
+	st := input.State
+	if st == nil {
+		st = &LifecycleState{}
+	}
+	if input.TransferWindow <= 0 {
+		input.TransferWindow = defaultTransferWindow
+	}
+	if input.SecurityContact == "" {
+		input.SecurityContact = defaultSecurityContact
+	}
+
+	err := workflow.SetQueryHandler(ctx, LifecycleQuery, func() (LifecycleState, error) {
+		return *st, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: time.Second * 10,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumAttempts:    10,
+		},
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+	l := &lifecycle{
+		ctx:       ctx,
+		input:     input,
+		st:        st,
+		decisions: workflow.GetSignalChannel(ctx, LifecycleDecisionSignal),
+	}
+
+	events := workflow.GetSignalChannel(ctx, LifecycleSignal)
+	for {
+		var ev LifecycleEvent
+		events.Receive(ctx, &ev)
+		if err := l.handle(ev); err != nil {
+			logger.Warn("Lifecycle event failed", "Kind", ev.Kind, "Error", err)
+			l.step("lifecycle.failed", map[string]string{"kind": ev.Kind, "error": err.Error()})
+		}
+		// Nothing more to do for a leaver; a rehire starts a fresh run ..
+		if st.Status == lifecycleLeft && events.Len() == 0 {
+			logger.Info("LifecycleWorkflow done", "User", input.User)
+			return nil
+		}
+		info := workflow.GetInfo(ctx)
+		if info.GetContinueAsNewSuggested() || info.GetCurrentHistoryLength() > maxLifecycleHistoryLength {
+			for events.ReceiveAsync(&ev) {
+				if err := l.handle(ev); err != nil {
+					logger.Warn("Lifecycle event failed", "Kind", ev.Kind, "Error", err)
+				}
+			}
+			input.State = st
+			return workflow.NewContinueAsNewError(ctx, LifecycleWorkflow, input)
+		}
+	}
+}
+
+// lifecycle is the in-workflow handler of events ..
+type lifecycle struct {
+	ctx       workflow.Context
+	input     LifecycleInput
+	st        *LifecycleState
+	decisions workflow.ReceiveChannel
+}
+
+func (l *lifecycle) handle(ev LifecycleEvent) error {
+	if ev.ID != "" {
+		for _, id := range l.st.Seen {
+			if id == ev.ID {
+				return nil
+			}
+		}
+		l.st.Seen = append(l.st.Seen, ev.ID)
+		if len(l.st.Seen) > maxLifecycleSeen {
+			l.st.Seen = l.st.Seen[len(l.st.Seen)-maxLifecycleSeen:]
+		}
+	}
+	switch ev.Kind {
+	case LifecycleJoiner, LifecycleMover:
+		// Directory order is not guaranteed; an update for someone we never
+		// saw join is a join, a re-sent create is a move ..
+		if l.st.Status != lifecycleJoined {
+			return l.join(ev)
+		}
+		return l.move(ev)
+	case LifecycleLeaver:
+		if l.st.Status == lifecycleLeft {
+			return nil
+		}
+		return l.leave(ev)
+	}
+	return fmt.Errorf("unknown lifecycle event %q", ev.Kind)
+}
+
+// step is one entry in both our status + the audit log ..
+func (l *lifecycle) step(action string, detail map[string]string) {
+	l.st.Steps = append(l.st.Steps, fmt.Sprintf("%s %s", workflow.Now(l.ctx).Format(time.RFC3339), action))
+	if len(l.st.Steps) > maxLifecycleSteps {
+		l.st.Steps = l.st.Steps[len(l.st.Steps)-maxLifecycleSteps:]
+	}
+	var a *Activities
+	err := workflow.ExecuteActivity(l.ctx, a.RecordAuditActivity, AuditEvent{
+		At:     workflow.Now(l.ctx),
+		OrgID:  l.input.OrgID,
+		Actor:  "directory",
+		Action: action,
+		Object: "user:" + l.input.User,
+		Detail: detail,
+	}).Get(l.ctx, nil)
+	if err != nil {
+		workflow.GetLogger(l.ctx).Error("RecordAuditActivity failed", "Action", action, "Error", err)
+	}
+}
+
+func (l *lifecycle) notify(to, subject, body string) {
+	if to == "" {
+		return
+	}
+	var a *Activities
+	err := workflow.ExecuteActivity(l.ctx, a.NotifyActivity, Notification{To: to, Subject: subject, Body: body}).Get(l.ctx, nil)
+	if err != nil {
+		workflow.GetLogger(l.ctx).Error("NotifyActivity failed", "To", to, "Error", err)
+	}
+}
+
+func (l *lifecycle) group(name string, add bool) error {
+	var a *Activities
+	change := GroupChange{Group: GroupID(l.input.OrgID, name), User: l.input.User}
+	if add {
+		return workflow.ExecuteActivity(l.ctx, a.AddGroupMemberActivity, change).Get(l.ctx, nil)
+	}
+	return workflow.ExecuteActivity(l.ctx, a.RemoveGroupMemberActivity, change).Get(l.ctx, nil)
+}
+
+// join hands out the baseline: org wide groups + the team's group ..
+func (l *lifecycle) join(ev LifecycleEvent) error {
+	groups := append([]string(nil), l.input.BaselineGroups...)
+	if ev.Team != "" {
+		groups = append(groups, ev.Team)
+	}
+	for _, g := range groups {
+		if err := l.group(g, true); err != nil {
+			return err
+		}
+	}
+	l.st.Status = lifecycleJoined
+	l.st.Team = ev.Team
+	l.st.Manager = ev.Manager
+	l.step("lifecycle.joined", map[string]string{"team": ev.Team, "groups": strings.Join(groups, ",")})
+	l.notify(ev.Manager, l.input.User+" joined "+ev.Team, l.input.User+" now has baseline access for "+ev.Team+".")
+	return nil
+}
+
+// move swaps the old team's group for the new one ..
+func (l *lifecycle) move(ev LifecycleEvent) error {
+	if ev.Team != l.st.Team {
+		if l.st.Team != "" {
+			if err := l.group(l.st.Team, false); err != nil {
+				return err
+			}
+		}
+		if ev.Team != "" {
+			if err := l.group(ev.Team, true); err != nil {
+				return err
+			}
+		}
+		l.step("lifecycle.moved", map[string]string{"from": l.st.Team, "to": ev.Team})
+		l.notify(ev.Manager, l.input.User+" moved to "+ev.Team,
+			fmt.Sprintf("%s lost %s access and gained %s access.", l.input.User, l.st.Team, ev.Team))
+		l.st.Team = ev.Team
+	}
+	if ev.Manager != l.st.Manager {
+		l.step("lifecycle.manager_changed", map[string]string{"from": l.st.Manager, "to": ev.Manager})
+		l.st.Manager = ev.Manager
+	}
+	return nil
+}
+
+// leave revokes everything at once; owned documents wait for a new owner ..
+func (l *lifecycle) leave(ev LifecycleEvent) error {
+	if ev.Manager != "" {
+		l.st.Manager = ev.Manager
+	}
+	cctx := workflow.WithChildOptions(l.ctx, workflow.ChildWorkflowOptions{
+		WorkflowID: DeprovisionWorkflowID(l.input.OrgID, l.input.User),
+	})
+	var result DeprovisionResult
+	err := workflow.ExecuteChildWorkflow(cctx, DeprovisionWorkflow, DeprovisionInput{
+		OrgID:  l.input.OrgID,
+		User:   l.input.User,
+		Actor:  "directory",
+		Reason: "left the organisation",
+	}).Get(l.ctx, &result)
+	if err != nil {
+		return err
+	}
+	l.st.Status = lifecycleLeft
+	l.st.Team = ""
+	l.step("lifecycle.left", map[string]string{"revoked": fmt.Sprint(len(result.Revoked)), "owned": fmt.Sprint(len(result.Owned))})
+	if len(result.Owned) > 0 {
+		l.transfer(result.Owned)
+	}
+	return nil
+}
+
+// transfer asks the manager to take the documents; no answer (or no) goes to
+// the security contact ..
+func (l *lifecycle) transfer(docs []string) {
+	l.st.Transfer = docs
+	defer func() {
+		l.st.Transfer = nil
+		l.st.Approver = ""
+	}()
+	var approvers []string
+	for _, approver := range []string{l.st.Manager, l.input.SecurityContact} {
+		if approver != "" && approver != l.input.User {
+			approvers = append(approvers, approver)
+		}
+	}
+	for _, approver := range approvers {
+		l.st.Approver = approver
+		l.step("lifecycle.transfer_requested", map[string]string{"approver": approver, "documents": strings.Join(docs, ",")})
+		l.notify(approver, "Documents from "+l.input.User+" need a new owner",
+			fmt.Sprintf("%s has left; accept or decline ownership of %s at /demo/lifecycle/?user=%s within %s.",
+				l.input.User, strings.Join(docs, ", "), l.input.User, l.input.TransferWindow))
+
+		decision := l.await(approver)
+		if decision == nil {
+			l.step("lifecycle.transfer_escalated", map[string]string{"approver": approver, "reason": "no answer"})
+			continue
+		}
+		if !decision.Accept {
+			l.step("lifecycle.transfer_declined", map[string]string{"approver": approver, "comment": decision.Comment})
+			continue
+		}
+		newOwner := decision.NewOwner
+		if newOwner == "" {
+			newOwner = decision.Actor
+		}
+		for _, doc := range docs {
+			err := workflow.SignalExternalWorkflow(l.ctx, DocumentWorkflowID(l.input.OrgID, doc), "", DocumentSignal, DocumentCommand{
+				Op:     OpTransfer,
+				Actor:  l.input.User,
+				User:   newOwner,
+				Reason: "owner left; accepted by " + decision.Actor,
+			}).Get(l.ctx, nil)
+			if err != nil {
+				l.step("lifecycle.transfer_failed", map[string]string{"document": doc, "error": err.Error()})
+				continue
+			}
+			l.step("lifecycle.transferred", map[string]string{"document": doc, "to": newOwner, "by": decision.Actor})
+		}
+		return
+	}
+	l.step("lifecycle.transfer_unresolved", map[string]string{"documents": strings.Join(docs, ",")})
+}
+
+// await waits the window for the approver; anyone else is ignored ..
+func (l *lifecycle) await(approver string) *LifecycleDecision {
+	timerCtx, cancelTimer := workflow.WithCancel(l.ctx)
+	defer cancelTimer()
+	timer := workflow.NewTimer(timerCtx, l.input.TransferWindow)
+	var decision *LifecycleDecision
+	timedOut := false
+	for decision == nil && !timedOut {
+		selector := workflow.NewSelector(l.ctx)
+		selector.AddReceive(l.decisions, func(c workflow.ReceiveChannel, more bool) {
+			var d LifecycleDecision
+			c.Receive(l.ctx, &d)
+			if d.Actor != approver {
+				l.step("lifecycle.decision_refused", map[string]string{"actor": d.Actor, "approver": approver})
+				return
+			}
+			decision = &d
+		})
+		selector.AddFuture(timer, func(f workflow.Future) {
+			timedOut = true
+		})
+		selector.Select(l.ctx)
+	}
+	return decision
+}
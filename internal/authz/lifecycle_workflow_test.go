@@ -0,0 +1,117 @@
+package authz
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/testsuite"
+	"testing"
+	"time"
+)
+
+type lifecycleRecorder struct {
+	added, removed []string
+	audit          []AuditEvent
+	sent           []DocumentCommand
+}
+
+func lifecycleEnv(t *testing.T, owned []string) (*testsuite.TestWorkflowEnvironment, *lifecycleRecorder) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	rec := &lifecycleRecorder{}
+
+	var a *Activities
+	env.RegisterActivity(a)
+	env.OnActivity(a.RecordAuditActivity, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, e AuditEvent) error {
+			rec.audit = append(rec.audit, e)
+			return nil
+		})
+	env.OnActivity(a.NotifyActivity, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.AddGroupMemberActivity, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, c GroupChange) error {
+			rec.added = append(rec.added, c.Group)
+			return nil
+		})
+	env.OnActivity(a.RemoveGroupMemberActivity, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, c GroupChange) error {
+			rec.removed = append(rec.removed, c.Group)
+			return nil
+		})
+	env.RegisterWorkflow(DeprovisionWorkflow)
+	env.OnWorkflow(DeprovisionWorkflow, mock.Anything, mock.Anything).Return(DeprovisionResult{Owned: owned}, nil)
+	env.OnSignalExternalWorkflow(mock.Anything, mock.Anything, "", DocumentSignal, mock.Anything).Return(
+		func(_, _, _, _ string, arg interface{}) error {
+			rec.sent = append(rec.sent, arg.(DocumentCommand))
+			return nil
+		})
+	return env, rec
+}
+
+var lifecycleInput = LifecycleInput{
+	OrgID:          "GopherLab",
+	User:           "bob",
+	BaselineGroups: []string{"everyone"},
+	TransferWindow: time.Hour,
+}
+
+func TestLifecycleJoinerMoverLeaver(t *testing.T) {
+	env, rec := lifecycleEnv(t, []string{"bob/plan.doc"})
+	signal := func(delay time.Duration, name string, arg interface{}) {
+		env.RegisterDelayedCallback(func() {
+			env.SignalWorkflow(name, arg)
+		}, delay)
+	}
+	signal(time.Minute, LifecycleSignal, LifecycleEvent{ID: "evt_1", Kind: LifecycleJoiner, Team: "finance", Manager: "mleow"})
+	// Webhook retry ..
+	signal(time.Minute*2, LifecycleSignal, LifecycleEvent{ID: "evt_1", Kind: LifecycleJoiner, Team: "finance", Manager: "mleow"})
+	signal(time.Minute*3, LifecycleSignal, LifecycleEvent{ID: "evt_2", Kind: LifecycleMover, Team: "engineering", Manager: "alice"})
+	signal(time.Minute*4, LifecycleSignal, LifecycleEvent{ID: "evt_3", Kind: LifecycleLeaver})
+	// Only the manager counts ..
+	signal(time.Minute*10, LifecycleDecisionSignal, LifecycleDecision{Actor: "mallory", Accept: true})
+	var st LifecycleState
+	env.RegisterDelayedCallback(func() {
+		v, err := env.QueryWorkflow(LifecycleQuery)
+		assert.NoError(t, err)
+		assert.NoError(t, v.Get(&st))
+	}, time.Minute*15)
+	signal(time.Minute*20, LifecycleDecisionSignal, LifecycleDecision{Actor: "alice", Accept: true})
+
+	env.ExecuteWorkflow(LifecycleWorkflow, lifecycleInput)
+	assert.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+
+	assert.Equal(t, []string{"GopherLab/everyone", "GopherLab/finance", "GopherLab/engineering"}, rec.added)
+	assert.Equal(t, []string{"GopherLab/finance"}, rec.removed)
+	assert.Equal(t, "alice", st.Approver)
+	assert.Equal(t, []string{"bob/plan.doc"}, st.Transfer)
+	if assert.Len(t, rec.sent, 1) {
+		assert.Equal(t, DocumentCommand{Op: OpTransfer, Actor: "bob", User: "alice", Reason: "owner left; accepted by alice"}, rec.sent[0])
+	}
+	assert.Equal(t, []string{
+		"lifecycle.joined", "lifecycle.moved", "lifecycle.manager_changed", "lifecycle.left",
+		"lifecycle.transfer_requested", "lifecycle.decision_refused", "lifecycle.transferred",
+	}, auditActions(rec.audit))
+}
+
+func TestLifecycleTransferEscalates(t *testing.T) {
+	env, rec := lifecycleEnv(t, []string{"bob/plan.doc"})
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(LifecycleSignal, LifecycleEvent{ID: "evt_9", Kind: LifecycleLeaver, Manager: "mleow"})
+	}, time.Minute)
+	// Manager never answers; security declines ..
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(LifecycleDecisionSignal, LifecycleDecision{Actor: "security", Accept: false, Comment: "archive it"})
+	}, time.Hour+time.Minute*30)
+
+	env.ExecuteWorkflow(LifecycleWorkflow, lifecycleInput)
+	assert.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+
+	assert.Empty(t, rec.sent)
+	assert.Equal(t, []string{
+		"lifecycle.left", "lifecycle.transfer_requested", "lifecycle.transfer_escalated",
+		"lifecycle.transfer_requested", "lifecycle.transfer_declined", "lifecycle.transfer_unresolved",
+	}, auditActions(rec.audit))
+	assert.Equal(t, "security", rec.audit[3].Detail["approver"])
+}
@@ -0,0 +1,214 @@
+package authz
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+	"strconv"
+	"time"
+)
+
+// Update, signal + query understood by ShareLinkWorkflow ..
+const (
+	LinkUseUpdate    = "useLink"
+	LinkRevokeSignal = "revokeLink"
+	LinkStateQuery   = "linkState"
+)
+
+// Why a link stopped working ..
+const (
+	LinkExpired   = "expired"
+	LinkRevoked   = "revoked"
+	LinkExhausted = "exhausted"
+)
+
+// Links never outlive this; owners pick anything up to it ..
+const MaxLinkLifetime = time.Hour * 24 * 30
+
+var (
+	ErrLinkEnded = errors.New("link is no longer valid")
+	ErrLinkKey   = errors.New("link key does not match")
+)
+
+// ShareLink is what the owner set up; the secrets only as hashes ..
+type ShareLink struct {
+	ID    string
+	OrgID string
+	DocID string
+	Owner string
+	// Relation it grants; only viewer for now ..
+	Relation  string
+	ExpiresAt time.Time
+	// KeyHash is the sha256 of the secret in the URL ..
+	KeyHash string
+	// PasswordHash is bcrypt; empty is no password ..
+	PasswordHash string
+	// MaxViews; 0 is unlimited ..
+	MaxViews int
+}
+
+// LinkState is the link plus how it has been used ..
+type LinkState struct {
+	Link      ShareLink
+	Views     int
+	Ended     string // expired, revoked, exhausted; empty while live
+	EndedBy   string
+	EndedAt   time.Time
+	CreatedAt time.Time
+}
+
+// LinkUse is someone opening the link; the caller has checked the password ..
+type LinkUse struct {
+	User string
+	Key  string
+}
+
+// LinkObject is the OpenFGA object of the link; LinkHolders its holders ..
+func LinkObject(id string) string {
+	return "link:" + id
+}
+
+func LinkHolders(id string) string {
+	return LinkObject(id) + "#holder"
+}
+
+// ShareLinkWorkflowID is the link's entity ..
+func ShareLinkWorkflowID(id string) string {
+	return "link-" + id
+}
+
+// LinkKeyHash is what is kept of the URL secret ..
+func LinkKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ShareLinkWorkflow owns one link: the link#holder tuple on the document
+// for as long as it lives; views counted one at a time. Ends on expiry,
+// revoke or the last view; the tuple goes with it ..
+func ShareLinkWorkflow(ctx workflow.Context, link ShareLink) (LinkState, error) {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("ShareLinkWorkflow started", "Link", link.ID, "DocID", link.DocID)
+
+	st := LinkState{Link: link, CreatedAt: workflow.Now(ctx)}
+	err := workflow.SetQueryHandler(ctx, LinkStateQuery, func() (LinkState, error) {
+		return st, nil
+	})
+	if err != nil {
+		return st, err
+	}
+	if link.ID == "" || link.DocID == "" || link.Owner == "" || link.KeyHash == "" {
+		return st, temporal.NewNonRetryableApplicationError("link, document, owner and key are required", "InvalidInputError", nil)
+	}
+	if link.Relation != "viewer" {
+		return st, temporal.NewNonRetryableApplicationError("links only grant viewer", "InvalidInputError", nil)
+	}
+	if max := st.CreatedAt.Add(MaxLinkLifetime); link.ExpiresAt.IsZero() || link.ExpiresAt.After(max) {
+		st.Link.ExpiresAt = max
+	}
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: time.Second * 10,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumAttempts:    10,
+		},
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+	var a *Activities
+
+	// Takes the ctx as the update handler runs on its own ..
+	audit := func(ctx workflow.Context, action, actor string, detail map[string]string) {
+		detail["link"] = link.ID
+		ctx = workflow.WithActivityOptions(ctx, ao)
+		err := workflow.ExecuteActivity(ctx, a.RecordAuditActivity, AuditEvent{
+			At:     workflow.Now(ctx),
+			OrgID:  link.OrgID,
+			Actor:  actor,
+			Action: action,
+			Object: "document:" + link.DocID,
+			Detail: detail,
+		}).Get(ctx, nil)
+		if err != nil {
+			logger.Error("RecordAuditActivity failed", "Action", action, "Error", err)
+		}
+	}
+	end := func(why, by string) {
+		if st.Ended == "" {
+			st.Ended, st.EndedBy, st.EndedAt = why, by, workflow.Now(ctx)
+		}
+	}
+
+	change := AccessChange{User: LinkHolders(link.ID), Relation: link.Relation, Document: link.DocID}
+	if err := workflow.ExecuteActivity(ctx, a.GrantAccessActivity, change).Get(ctx, nil); err != nil {
+		return st, err
+	}
+	audit(ctx, "link.created", link.Owner, map[string]string{
+		"expires":   st.Link.ExpiresAt.Format(time.RFC3339),
+		"password":  strconv.FormatBool(link.PasswordHash != ""),
+		"max_views": strconv.Itoa(link.MaxViews),
+	})
+
+	// A view either counts or is refused; never both ..
+	err = workflow.SetUpdateHandlerWithOptions(ctx, LinkUseUpdate,
+		func(ctx workflow.Context, use LinkUse) (LinkState, error) {
+			// Counted before anything blocks; the next validator sees it ..
+			st.Views++
+			if link.MaxViews > 0 && st.Views >= link.MaxViews {
+				end(LinkExhausted, use.User)
+			}
+			view := st
+			audit(ctx, "link.used", use.User, map[string]string{"view": strconv.Itoa(view.Views)})
+			return view, nil
+		},
+		workflow.UpdateHandlerOptions{Validator: func(ctx workflow.Context, use LinkUse) error {
+			if st.Ended != "" || !workflow.Now(ctx).Before(st.Link.ExpiresAt) {
+				return ErrLinkEnded
+			}
+			if subtle.ConstantTimeCompare([]byte(LinkKeyHash(use.Key)), []byte(link.KeyHash)) != 1 {
+				return ErrLinkKey
+			}
+			if use.User == "" {
+				return errors.New("link needs a user")
+			}
+			return nil
+		}})
+	if err != nil {
+		return st, err
+	}
+
+	revokeChan := workflow.GetSignalChannel(ctx, LinkRevokeSignal)
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		for {
+			var actor string
+			revokeChan.Receive(ctx, &actor)
+			// Only the owner gets this far; cmd checks ..
+			end(LinkRevoked, actor)
+		}
+	})
+	// Revoked + the last view end it early ..
+	live, err := workflow.AwaitWithTimeout(ctx, st.Link.ExpiresAt.Sub(workflow.Now(ctx)), func() bool {
+		return st.Ended != ""
+	})
+	if err != nil {
+		return st, err
+	}
+	if !live {
+		end(LinkExpired, "")
+	}
+	// Let a view in flight finish before the tuple goes ..
+	if err := workflow.Await(ctx, func() bool { return workflow.AllHandlersFinished(ctx) }); err != nil {
+		return st, err
+	}
+
+	if err := workflow.ExecuteActivity(ctx, a.RevokeAccessActivity, change).Get(ctx, nil); err != nil {
+		return st, err
+	}
+	audit(ctx, "link."+st.Ended, st.EndedBy, map[string]string{"views": strconv.Itoa(st.Views)})
+	logger.Info("ShareLinkWorkflow ended", "Link", link.ID, "Why", st.Ended)
+	return st, nil
+}
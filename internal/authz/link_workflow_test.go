@@ -0,0 +1,131 @@
+package authz
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/testsuite"
+	"testing"
+	"time"
+)
+
+type linkRecorder struct {
+	audit   []AuditEvent
+	granted []AccessChange
+	revoked []AccessChange
+}
+
+func linkEnv(t *testing.T) (*testsuite.TestWorkflowEnvironment, *linkRecorder) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+
+	var a *Activities
+	env.RegisterActivity(a)
+	rec := &linkRecorder{}
+	env.OnActivity(a.RecordAuditActivity, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, e AuditEvent) error {
+			rec.audit = append(rec.audit, e)
+			return nil
+		})
+	env.OnActivity(a.GrantAccessActivity, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, c AccessChange) error {
+			rec.granted = append(rec.granted, c)
+			return nil
+		})
+	env.OnActivity(a.RevokeAccessActivity, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, c AccessChange) error {
+			rec.revoked = append(rec.revoked, c)
+			return nil
+		})
+	return env, rec
+}
+
+func testLink(maxViews int) ShareLink {
+	return ShareLink{
+		ID:        "abc123",
+		OrgID:     "GopherLab",
+		DocID:     "public/readme.doc",
+		Owner:     "mleow",
+		Relation:  "viewer",
+		ExpiresAt: time.Now().Add(time.Hour),
+		KeyHash:   LinkKeyHash("s3cret"),
+		MaxViews:  maxViews,
+	}
+}
+
+// linkUseResult implements the SDK's update callbacks; rejected is the
+// validator refusing the view ..
+type linkUseResult struct {
+	state    LinkState
+	rejected error
+}
+
+func (r *linkUseResult) Accept()          {}
+func (r *linkUseResult) Reject(err error) { r.rejected = err }
+func (r *linkUseResult) Complete(v interface{}, err error) {
+	if st, ok := v.(LinkState); ok && err == nil {
+		r.state = st
+	}
+}
+
+func useLink(env *testsuite.TestWorkflowEnvironment, id string, use LinkUse, out *linkUseResult) {
+	env.UpdateWorkflow(LinkUseUpdate, id, out, use)
+}
+
+func TestShareLinkExhausted(t *testing.T) {
+	env, rec := linkEnv(t)
+	var first, wrong, second, third linkUseResult
+	env.RegisterDelayedCallback(func() {
+		useLink(env, "1", LinkUse{User: "bob", Key: "s3cret"}, &first)
+	}, time.Minute)
+	env.RegisterDelayedCallback(func() {
+		useLink(env, "2", LinkUse{User: "bob", Key: "guess"}, &wrong)
+	}, time.Minute*2)
+	env.RegisterDelayedCallback(func() {
+		useLink(env, "3", LinkUse{User: "alice", Key: "s3cret"}, &second)
+	}, time.Minute*3)
+	env.RegisterDelayedCallback(func() {
+		useLink(env, "4", LinkUse{User: "alice", Key: "s3cret"}, &third)
+	}, time.Minute*3)
+	env.ExecuteWorkflow(ShareLinkWorkflow, testLink(2))
+
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+	var st LinkState
+	require.NoError(t, env.GetWorkflowResult(&st))
+
+	assert.NoError(t, first.rejected)
+	assert.Equal(t, 1, first.state.Views)
+	assert.ErrorContains(t, wrong.rejected, ErrLinkKey.Error())
+	assert.NoError(t, second.rejected)
+	assert.Equal(t, LinkExhausted, second.state.Ended)
+	assert.ErrorContains(t, third.rejected, ErrLinkEnded.Error())
+
+	assert.Equal(t, 2, st.Views)
+	assert.Equal(t, LinkExhausted, st.Ended)
+	want := AccessChange{User: "link:abc123#holder", Relation: "viewer", Document: "public/readme.doc"}
+	assert.Equal(t, []AccessChange{want}, rec.granted)
+	assert.Equal(t, []AccessChange{want}, rec.revoked)
+	assert.Equal(t, []string{"link.created", "link.used", "link.used", "link.exhausted"}, auditActions(rec.audit))
+}
+
+func TestShareLinkRevokedAndExpired(t *testing.T) {
+	env, rec := linkEnv(t)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(LinkRevokeSignal, "mleow")
+	}, time.Minute*5)
+	env.ExecuteWorkflow(ShareLinkWorkflow, testLink(0))
+	var st LinkState
+	require.NoError(t, env.GetWorkflowResult(&st))
+	assert.Equal(t, LinkRevoked, st.Ended)
+	assert.Equal(t, "mleow", st.EndedBy)
+	assert.Len(t, rec.revoked, 1)
+
+	env, rec = linkEnv(t)
+	env.ExecuteWorkflow(ShareLinkWorkflow, testLink(0))
+	require.NoError(t, env.GetWorkflowResult(&st))
+	assert.Equal(t, LinkExpired, st.Ended)
+	assert.Len(t, rec.revoked, 1)
+	assert.Equal(t, []string{"link.created", "link.expired"}, auditActions(rec.audit))
+}
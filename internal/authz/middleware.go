@@ -0,0 +1,175 @@
+package authz
+
+import (
+	"app/internal/identity"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// HTTP middleware; a route says which relation it needs on which document
+// instead of every handler remembering to check. Runs behind the session or
+// API key middleware which put the caller in the context ..
+//	mux.Handle("/docs/{id...}", guard.Require("viewer", DocumentFromPath("id"))(h))
+
+var (
+	ErrNoCaller   = errors.New("no caller on the request")
+	ErrNoDocument = errors.New("no document on the request")
+)
+
+// Checker is the check the middleware makes; AuthStore is one ..
+type Checker interface {
+	CheckWithMFA(ctx context.Context, user, relation, document string, recentMFA bool) (bool, error)
+}
+
+var _ Checker = AuthStore{}
+
+// ObjectFunc is the document ID a request is about ..
+type ObjectFunc func(r *http.Request) (string, error)
+
+// DocumentFromPath is the {name} wildcard of the route; IDs have slashes so
+// register with {name...} ..
+func DocumentFromPath(name string) ObjectFunc {
+	return func(r *http.Request) (string, error) {
+		if id := r.PathValue(name); id != "" {
+			return id, nil
+		}
+		return "", ErrNoDocument
+	}
+}
+
+// DocumentFromQuery is a query or form value e.g. ?doc=secret/x.doc ..
+func DocumentFromQuery(name string) ObjectFunc {
+	return func(r *http.Request) (string, error) {
+		if id := r.FormValue(name); id != "" {
+			return id, nil
+		}
+		return "", ErrNoDocument
+	}
+}
+
+// Decision is what the middleware decided; handlers + logs get it from the
+// request context ..
+type Decision struct {
+	Caller   string
+	Relation string
+	Document string
+	Allowed  bool
+	// StepUp is denied now but a recent MFA would allow it ..
+	StepUp bool
+	// Err is why no check was made or it failed; denied either way ..
+	Err error
+	At  time.Time
+}
+
+type decisionKey struct{}
+
+func WithDecision(ctx context.Context, d Decision) context.Context {
+	return context.WithValue(ctx, decisionKey{}, d)
+}
+
+// DecisionFrom is the decision on the request, if it went through Require ..
+func DecisionFrom(ctx context.Context) (Decision, bool) {
+	d, ok := ctx.Value(decisionKey{}).(Decision)
+	return d, ok
+}
+
+// Authorizer makes the checks for Require; zero hooks get plain text errors
+// and no logging ..
+type Authorizer struct {
+	Checker Checker
+	// MFAMaxAge; a session stepped up within it checks with the mfa tuple ..
+	MFAMaxAge time.Duration
+	// Deny writes the refusal; DecisionStatus if unset ..
+	Deny func(w http.ResponseWriter, r *http.Request, d Decision)
+	// Log sees every decision, allowed or not ..
+	Log func(r *http.Request, d Decision)
+}
+
+// DecisionStatus is the HTTP status of a denied decision ..
+func DecisionStatus(d Decision) int {
+	switch {
+	case errors.Is(d.Err, ErrNoCaller):
+		return http.StatusUnauthorized
+	case errors.Is(d.Err, ErrNoDocument):
+		return http.StatusBadRequest
+	case d.Err != nil:
+		// Could not ask; never let it through ..
+		return http.StatusBadGateway
+	}
+	return http.StatusForbidden
+}
+
+// caller is the session's user else the API key's principal ..
+func (a Authorizer) caller(r *http.Request) (string, bool, error) {
+	if s, ok := identity.SessionFrom(r.Context()); ok && s.UserID != "" {
+		return s.UserID, a.MFAMaxAge > 0 && s.MFARecent(time.Now(), a.MFAMaxAge), nil
+	}
+	if p, ok := identity.PrincipalFrom(r.Context()); ok && p.ID != "" {
+		return p.ID, false, nil
+	}
+	return "", false, ErrNoCaller
+}
+
+// Decide checks the caller has relation on the request's document; fails
+// closed on anything but a yes ..
+func (a Authorizer) Decide(r *http.Request, relation string, object ObjectFunc) Decision {
+	d := Decision{Relation: relation, At: time.Now()}
+	var recentMFA bool
+	d.Caller, recentMFA, d.Err = a.caller(r)
+	if d.Err != nil {
+		return d
+	}
+	if d.Document, d.Err = object(r); d.Err != nil {
+		return d
+	}
+	if a.Checker == nil {
+		d.Err = errors.New("authorizer has no checker")
+		return d
+	}
+	d.Allowed, d.Err = a.Checker.CheckWithMFA(r.Context(), d.Caller, relation, d.Document, recentMFA)
+	if d.Err != nil {
+		d.Allowed = false
+		return d
+	}
+	// Would a step-up do it? Only sessions can ..
+	if !d.Allowed && !recentMFA && a.MFAMaxAge > 0 {
+		if _, ok := identity.SessionFrom(r.Context()); ok {
+			d.StepUp, _ = a.Checker.CheckWithMFA(r.Context(), d.Caller, relation, d.Document, true)
+		}
+	}
+	return d
+}
+
+// Require is middleware letting through only callers with relation on the
+// document object picks out of the request ..
+func (a Authorizer) Require(relation string, object ObjectFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d := a.Decide(r, relation, object)
+			r = r.WithContext(WithDecision(r.Context(), d))
+			if a.Log != nil {
+				a.Log(r, d)
+			}
+			if !d.Allowed {
+				if a.Deny != nil {
+					a.Deny(w, r, d)
+					return
+				}
+				msg := http.StatusText(DecisionStatus(d))
+				if d.StepUp {
+					msg = "step-up required"
+				}
+				http.Error(w, msg, DecisionStatus(d))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Handle registers h on mux behind Require ..
+func (a Authorizer) Handle(mux *http.ServeMux, pattern, relation string, object ObjectFunc, h http.Handler) {
+	mux.Handle(pattern, a.Require(relation, object)(h))
+}
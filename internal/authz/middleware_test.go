@@ -0,0 +1,105 @@
+package authz
+
+import (
+	"app/internal/identity"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeChecker allows user:relation:document; mfa ones only with recentMFA ..
+type fakeChecker struct {
+	allow map[string]bool
+	mfa   map[string]bool
+	err   error
+}
+
+func (f fakeChecker) CheckWithMFA(_ context.Context, user, relation, document string, recentMFA bool) (bool, error) {
+	key := user + ":" + relation + ":" + document
+	return f.allow[key] || (recentMFA && f.mfa[key]), f.err
+}
+
+func TestRequire(t *testing.T) {
+	checker := fakeChecker{
+		allow: map[string]bool{"bob:viewer:public/readme.doc": true, "service:k1:viewer:public/readme.doc": true},
+		mfa:   map[string]bool{"bob:viewer:secret/salary.doc": true},
+	}
+	var logged []Decision
+	guard := Authorizer{
+		Checker:   checker,
+		MFAMaxAge: time.Minute * 15,
+		Log:       func(_ *http.Request, d Decision) { logged = append(logged, d) },
+	}
+	mux := http.NewServeMux()
+	guard.Handle(mux, "GET /docs/{id...}", "viewer", DocumentFromPath("id"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, _ := DecisionFrom(r.Context())
+		w.Write([]byte(d.Caller + " " + d.Document))
+	}))
+	serve := func(path string, ctx func(context.Context) context.Context) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if ctx != nil {
+			req = req.WithContext(ctx(req.Context()))
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	asBob := func(mfaAt time.Time) func(context.Context) context.Context {
+		return func(ctx context.Context) context.Context {
+			return identity.WithSession(ctx, identity.Session{UserID: "bob", MFAAt: mfaAt})
+		}
+	}
+
+	rec := serve("/docs/public/readme.doc", asBob(time.Time{}))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "bob public/readme.doc", rec.Body.String())
+
+	// API key callers come as the principal ..
+	rec = serve("/docs/public/readme.doc", func(ctx context.Context) context.Context {
+		return identity.WithPrincipal(ctx, identity.Principal{ID: "service:k1", Kind: "service"})
+	})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	assert.Equal(t, http.StatusUnauthorized, serve("/docs/public/readme.doc", nil).Code)
+
+	rec = serve("/docs/secret/salary.doc", asBob(time.Time{}))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "step-up")
+	assert.True(t, logged[len(logged)-1].StepUp)
+	assert.Equal(t, http.StatusOK, serve("/docs/secret/salary.doc", asBob(time.Now())).Code)
+	assert.Equal(t, http.StatusForbidden, serve("/docs/secret/other.doc", asBob(time.Now())).Code)
+	assert.Len(t, logged, 6)
+}
+
+func TestRequireFailsClosed(t *testing.T) {
+	guard := Authorizer{Checker: fakeChecker{
+		allow: map[string]bool{"bob:viewer:public/readme.doc": true},
+		err:   errors.New("fga down"),
+	}}
+	var denied Decision
+	guard.Deny = func(w http.ResponseWriter, r *http.Request, d Decision) {
+		denied = d
+		w.WriteHeader(DecisionStatus(d))
+	}
+	h := guard.Require("viewer", DocumentFromQuery("doc"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler ran")
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/?doc=public/readme.doc", nil)
+	req = req.WithContext(identity.WithSession(req.Context(), identity.Session{UserID: "bob"}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.False(t, denied.Allowed)
+	assert.ErrorContains(t, denied.Err, "fga down")
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req = req.WithContext(identity.WithSession(req.Context(), identity.Session{UserID: "bob"}))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.ErrorIs(t, denied.Err, ErrNoDocument)
+}
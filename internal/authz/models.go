@@ -0,0 +1,120 @@
+package authz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	openfga "github.com/openfga/go-sdk"
+	. "github.com/openfga/go-sdk/client"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Model is one version of the authorization model; newest is what checks
+// use unless a model ID is pinned ..
+type Model struct {
+	ID            string
+	SchemaVersion string
+	// Created is from the ULID ID; zero if it does not parse ..
+	Created    time.Time
+	Types      []ModelType
+	Conditions []string
+	// JSON is the whole model as OpenFGA has it ..
+	JSON string
+}
+
+// ModelType is a type + its relations e.g. document viewer ..
+type ModelType struct {
+	Name      string
+	Relations []ModelRelation
+}
+
+// ModelRelation; DirectTypes is who can be written in directly e.g. user,
+// group#member ..
+type ModelRelation struct {
+	Name        string
+	DirectTypes []string
+}
+
+// crockford is the ULID alphabet ..
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulidTime is the millisecond timestamp in the first 10 chars of a ULID ..
+func ulidTime(id string) time.Time {
+	if len(id) != 26 {
+		return time.Time{}
+	}
+	var ms uint64
+	for _, c := range strings.ToUpper(id[:10]) {
+		i := strings.IndexRune(crockford, c)
+		if i < 0 {
+			return time.Time{}
+		}
+		ms = ms<<5 | uint64(i)
+	}
+	return time.UnixMilli(int64(ms)).UTC()
+}
+
+func toModel(m openfga.AuthorizationModel) Model {
+	model := Model{
+		ID:            m.GetId(),
+		SchemaVersion: m.GetSchemaVersion(),
+		Created:       ulidTime(m.GetId()),
+	}
+	for _, td := range m.GetTypeDefinitions() {
+		t := ModelType{Name: td.GetType()}
+		md := td.GetMetadata()
+		meta := md.GetRelations()
+		for name := range td.GetRelations() {
+			rel := ModelRelation{Name: name}
+			rm := meta[name]
+			for _, ref := range rm.GetDirectlyRelatedUserTypes() {
+				direct := ref.GetType()
+				switch {
+				case ref.GetRelation() != "":
+					direct += "#" + ref.GetRelation()
+				case ref.GetWildcard() != nil:
+					direct += ":*"
+				}
+				if ref.GetCondition() != "" {
+					direct += " with " + ref.GetCondition()
+				}
+				rel.DirectTypes = append(rel.DirectTypes, direct)
+			}
+			t.Relations = append(t.Relations, rel)
+		}
+		sort.Slice(t.Relations, func(i, j int) bool { return t.Relations[i].Name < t.Relations[j].Name })
+		model.Types = append(model.Types, t)
+	}
+	for name := range m.GetConditions() {
+		model.Conditions = append(model.Conditions, name)
+	}
+	sort.Strings(model.Conditions)
+	if b, err := json.MarshalIndent(m, "", "  "); err == nil {
+		model.JSON = string(b)
+	}
+	return model
+}
+
+// AuthorizationModels is every version of the model, newest first ..
+func (a AuthStore) AuthorizationModels(ctx context.Context) ([]Model, error) {
+	var models []Model
+	opts := ClientReadAuthorizationModelsOptions{}
+	for {
+		data, err := a.client.ReadAuthorizationModels(ctx).Options(opts).Execute()
+		if err != nil {
+			fmt.Println("ERR: ", err.Error())
+			return nil, err
+		}
+		for _, m := range data.GetAuthorizationModels() {
+			models = append(models, toModel(m))
+		}
+		token := data.GetContinuationToken()
+		if token == "" {
+			break
+		}
+		opts.ContinuationToken = openfga.PtrString(token)
+	}
+	return models, nil
+}
@@ -0,0 +1,19 @@
+package authz
+
+import (
+	"testing"
+	"time"
+)
+
+func TestULIDTime(t *testing.T) {
+	// From the ULID spec: 01ARYZ6S41 is 1469918176385 ms ..
+	got := ulidTime("01ARYZ6S41TSV4RRFFQ69G5FAV")
+	if want := time.UnixMilli(1469918176385).UTC(); !got.Equal(want) {
+		t.Fatalf("ulidTime = %v, want %v", got, want)
+	}
+	for _, bad := range []string{"", "short", "01ARYZ6S4!TSV4RRFFQ69G5FAV"} {
+		if !ulidTime(bad).IsZero() {
+			t.Errorf("ulidTime(%q) = %v, want zero", bad, ulidTime(bad))
+		}
+	}
+}
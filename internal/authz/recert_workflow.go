@@ -0,0 +1,278 @@
+package authz
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+	"time"
+)
+
+// Signal + query understood by RecertificationWorkflow ..
+const (
+	RecertSignal = "recertDecision"
+	RecertQuery  = "campaignStatus"
+)
+
+// Outcomes of a single grant in the campaign ..
+const (
+	RecertKeep    = "keep"
+	RecertRevoke  = "revoke"
+	RecertExpired = "expired" // Nobody answered; revoked at deadline ..
+)
+
+// Owners get this long to answer unless the campaign says ..
+const defaultRecertDeadline = time.Hour * 24 * 14
+
+// Grant is one non-owner viewer / editor tuple under review ..
+type Grant struct {
+	DocID    string
+	User     string
+	Relation string
+	Owner    string
+}
+
+// RecertItem is a grant plus what the owner said about it ..
+type RecertItem struct {
+	ID string // <docID>#<user>#<relation>
+	Grant
+	Outcome   string
+	DecidedBy string
+	DecidedAt time.Time
+}
+
+// RecertInput starts a campaign for the tenant ..
+type RecertInput struct {
+	OrgID    string
+	Deadline time.Duration
+}
+
+// RecertDecision is the owner keeping or revoking a grant ..
+type RecertDecision struct {
+	Actor  string
+	ItemID string
+	Keep   bool
+}
+
+// CampaignReport is what auditors get at the end ..
+type CampaignReport struct {
+	CampaignID  string
+	OrgID       string
+	StartedAt   time.Time
+	Deadline    time.Time
+	CompletedAt time.Time
+	Items       []RecertItem
+	Kept        int
+	Revoked     int
+	Expired     int
+}
+
+// SignedReport is the report as signed bytes; verify with VerifyReport ..
+type SignedReport struct {
+	Algorithm string
+	Report    []byte // JSON of CampaignReport
+	Signature []byte
+}
+
+// SignReport signs the JSON form of the report with Ed25519 ..
+func SignReport(key ed25519.PrivateKey, report CampaignReport) (SignedReport, error) {
+	b, err := json.Marshal(report)
+	if err != nil {
+		return SignedReport{}, err
+	}
+	return SignedReport{
+		Algorithm: "Ed25519",
+		Report:    b,
+		Signature: ed25519.Sign(key, b),
+	}, nil
+}
+
+// VerifyReport checks the signature before handing back the report ..
+func VerifyReport(pub ed25519.PublicKey, signed SignedReport) (CampaignReport, error) {
+	var report CampaignReport
+	if signed.Algorithm != "Ed25519" || !ed25519.Verify(pub, signed.Report, signed.Signature) {
+		return report, fmt.Errorf("report signature invalid")
+	}
+	err := json.Unmarshal(signed.Report, &report)
+	return report, err
+}
+
+func recertItemID(g Grant) string {
+	return g.DocID + "#" + g.User + "#" + g.Relation
+}
+
+// RecertificationWorkflow asks every document owner to keep or revoke each
+// non-owner grant; anything unanswered at the deadline is revoked ..
+func RecertificationWorkflow(ctx workflow.Context, input RecertInput) (SignedReport, error) {
+	logger := workflow.GetLogger(ctx)
+	campaignID := workflow.GetInfo(ctx).WorkflowExecution.ID
+	logger.Info("RecertificationWorkflow started", "CampaignID", campaignID, "OrgID", input.OrgID)
+
+	if input.Deadline <= 0 {
+		input.Deadline = defaultRecertDeadline
+	}
+	report := CampaignReport{
+		CampaignID: campaignID,
+		OrgID:      input.OrgID,
+		StartedAt:  workflow.Now(ctx),
+	}
+	report.Deadline = report.StartedAt.Add(input.Deadline)
+
+	err := workflow.SetQueryHandler(ctx, RecertQuery, func() (CampaignReport, error) {
+		return report, nil
+	})
+	if err != nil {
+		return SignedReport{}, err
+	}
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute * 2,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumAttempts:    10,
+		},
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+	var a *Activities
+
+	var grants []Grant
+	if err := workflow.ExecuteActivity(ctx, a.ListGrantsActivity, input.OrgID).Get(ctx, &grants); err != nil {
+		return SignedReport{}, err
+	}
+	index := map[string]int{}
+	owners := map[string]int{}
+	for _, g := range grants {
+		item := RecertItem{ID: recertItemID(g), Grant: g}
+		index[item.ID] = len(report.Items)
+		report.Items = append(report.Items, item)
+		owners[g.Owner]++
+	}
+	audit := func(action, actor, object string, detail map[string]string) {
+		err := workflow.ExecuteActivity(ctx, a.RecordAuditActivity, AuditEvent{
+			At:     workflow.Now(ctx),
+			OrgID:  input.OrgID,
+			Actor:  actor,
+			Action: action,
+			Object: object,
+			Detail: detail,
+		}).Get(ctx, nil)
+		if err != nil {
+			logger.Error("RecordAuditActivity failed", "Action", action, "Error", err)
+		}
+	}
+	audit("recert.started", "system", "organization:"+input.OrgID, map[string]string{
+		"campaign": campaignID,
+		"grants":   fmt.Sprint(len(report.Items)),
+	})
+	for _, owner := range sortedKeys(owners) {
+		err := workflow.ExecuteActivity(ctx, a.NotifyActivity, Notification{
+			To:      owner,
+			Subject: "Access review " + campaignID,
+			Body: fmt.Sprintf("You have %d grants to keep or revoke by %s. Unanswered grants are revoked.",
+				owners[owner], report.Deadline.Format(time.RFC1123)),
+		}).Get(ctx, nil)
+		if err != nil {
+			logger.Error("NotifyActivity failed", "Owner", owner, "Error", err)
+		}
+	}
+
+	// revoke goes straight at the tuple (source of truth for the campaign);
+	// then lets the document entity know so its state stays in step ..
+	revoke := func(item RecertItem) {
+		err := workflow.ExecuteActivity(ctx, a.RevokeAccessActivity, AccessChange{
+			User:     item.User,
+			Relation: item.Relation,
+			Document: item.DocID,
+		}).Get(ctx, nil)
+		if err != nil {
+			logger.Error("RevokeAccessActivity failed", "Item", item.ID, "Error", err)
+		}
+		_ = workflow.SignalExternalWorkflow(ctx, DocumentWorkflowID(input.OrgID, item.DocID), "", DocumentSignal, DocumentCommand{
+			Op:       OpRevoke,
+			Actor:    item.Owner,
+			User:     item.User,
+			Relation: item.Relation,
+			Reason:   "recertification " + campaignID,
+		}).Get(ctx, nil)
+		audit("recert.revoked", item.DecidedBy, "document:"+item.DocID, map[string]string{
+			"campaign": campaignID,
+			"user":     item.User,
+			"relation": item.Relation,
+			"outcome":  item.Outcome,
+		})
+	}
+
+	outstanding := len(report.Items)
+	decisionChan := workflow.GetSignalChannel(ctx, RecertSignal)
+	deadline := workflow.NewTimer(ctx, input.Deadline)
+	expired := false
+	for outstanding > 0 && !expired {
+		selector := workflow.NewSelector(ctx)
+		selector.AddReceive(decisionChan, func(c workflow.ReceiveChannel, more bool) {
+			var d RecertDecision
+			c.Receive(ctx, &d)
+			i, ok := index[d.ItemID]
+			if !ok {
+				logger.Warn("Unknown recert item", "ItemID", d.ItemID)
+				return
+			}
+			item := &report.Items[i]
+			if item.Outcome != "" || d.Actor != item.Owner {
+				logger.Warn("Recert decision refused", "ItemID", d.ItemID, "Actor", d.Actor)
+				return
+			}
+			item.DecidedBy = d.Actor
+			item.DecidedAt = workflow.Now(ctx)
+			item.Outcome = RecertKeep
+			if !d.Keep {
+				item.Outcome = RecertRevoke
+			}
+			outstanding--
+			if item.Outcome == RecertRevoke {
+				report.Revoked++
+				revoke(*item)
+			} else {
+				report.Kept++
+				audit("recert.kept", d.Actor, "document:"+item.DocID, map[string]string{
+					"campaign": campaignID,
+					"user":     item.User,
+					"relation": item.Relation,
+				})
+			}
+		})
+		selector.AddFuture(deadline, func(f workflow.Future) {
+			expired = true
+		})
+		selector.Select(ctx)
+	}
+
+	// Deadline passed; silence means revoke ..
+	for i := range report.Items {
+		item := &report.Items[i]
+		if item.Outcome != "" {
+			continue
+		}
+		item.Outcome = RecertExpired
+		item.DecidedBy = "system"
+		item.DecidedAt = workflow.Now(ctx)
+		report.Expired++
+		revoke(*item)
+	}
+	report.CompletedAt = workflow.Now(ctx)
+
+	var signed SignedReport
+	if err := workflow.ExecuteActivity(ctx, a.SignReportActivity, report).Get(ctx, &signed); err != nil {
+		return SignedReport{}, err
+	}
+	audit("recert.completed", "system", "organization:"+input.OrgID, map[string]string{
+		"campaign":  campaignID,
+		"kept":      fmt.Sprint(report.Kept),
+		"revoked":   fmt.Sprint(report.Revoked),
+		"expired":   fmt.Sprint(report.Expired),
+		"signature": fmt.Sprintf("%x", signed.Signature),
+	})
+	logger.Info("RecertificationWorkflow completed", "CampaignID", campaignID)
+	return signed, nil
+}
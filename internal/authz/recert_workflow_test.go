@@ -0,0 +1,68 @@
+package authz
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/testsuite"
+	"testing"
+	"time"
+)
+
+func TestRecertificationWorkflow(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+	a := &Activities{ReportSigner: key}
+	env.RegisterActivity(a)
+	env.OnActivity(a.ListGrantsActivity, mock.Anything, "GopherLab").Return([]Grant{
+		{DocID: "secret/secretz.doc", User: "mleow", Relation: "viewer", Owner: "bob"},
+		{DocID: "secret/secretz.doc", User: "alice", Relation: "editor", Owner: "bob"},
+		{DocID: "secret/salary.doc", User: "bob", Relation: "viewer", Owner: "mleow"},
+	}, nil)
+	env.OnActivity(a.NotifyActivity, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.RecordAuditActivity, mock.Anything, mock.Anything).Return(nil)
+	var revoked []AccessChange
+	env.OnActivity(a.RevokeAccessActivity, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, change AccessChange) error {
+			revoked = append(revoked, change)
+			return nil
+		})
+	env.OnSignalExternalWorkflow(mock.Anything, mock.Anything, "", DocumentSignal, mock.Anything).Return(nil)
+
+	decide := func(delay time.Duration, d RecertDecision) {
+		env.RegisterDelayedCallback(func() {
+			env.SignalWorkflow(RecertSignal, d)
+		}, delay)
+	}
+	decide(time.Hour, RecertDecision{Actor: "bob", ItemID: "secret/secretz.doc#mleow#viewer", Keep: true})
+	// Not the owner; ignored ..
+	decide(time.Hour*2, RecertDecision{Actor: "mleow", ItemID: "secret/secretz.doc#alice#editor", Keep: true})
+	decide(time.Hour*3, RecertDecision{Actor: "bob", ItemID: "secret/secretz.doc#alice#editor", Keep: false})
+	// salary.doc never answered ..
+
+	env.ExecuteWorkflow(RecertificationWorkflow, RecertInput{OrgID: "GopherLab", Deadline: time.Hour * 24})
+	assert.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+
+	var signed SignedReport
+	assert.NoError(t, env.GetWorkflowResult(&signed))
+	report, err := VerifyReport(pub, signed)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Kept)
+	assert.Equal(t, 1, report.Revoked)
+	assert.Equal(t, 1, report.Expired)
+	assert.Equal(t, RecertExpired, report.Items[2].Outcome)
+	assert.Equal(t, []AccessChange{
+		{User: "alice", Relation: "editor", Document: "secret/secretz.doc"},
+		{User: "bob", Relation: "viewer", Document: "secret/salary.doc"},
+	}, revoked)
+
+	// Tampering breaks the signature ..
+	signed.Report[10] ^= 1
+	_, err = VerifyReport(pub, signed)
+	assert.Error(t, err)
+}
@@ -66,6 +66,7 @@ func ActionWorkflow(ctx workflow.Context, input WFDemoInput) error {
 		return naerr
 	}
 	// Init data ..
+	ad.org = input.Name
 	ad.users = input.Users
 	ad.docs = input.Docs
 	serr := ad.setupTuples()
@@ -75,8 +76,8 @@ func ActionWorkflow(ctx workflow.Context, input WFDemoInput) error {
 	}
 	// Define signals
 	var actions Actions
-	signalChan := workflow.GetSignalChannel(ctx, "actionSignal")
-	terminateChan := workflow.GetSignalChannel(ctx, "terminateSignal")
+	signalChan := workflow.GetSignalChannel(ctx, ActionSignal)
+	terminateChan := workflow.GetSignalChannel(ctx, TerminateSignal)
 
 	// For processing clean Termination ..
 	var terminate bool
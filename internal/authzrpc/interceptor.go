@@ -0,0 +1,218 @@
+// Package authzrpc is the AuthzService gRPC server plus interceptors any
+// gRPC server can use. Methods carry an (authz.v1.policy) option saying the
+// scope + relation they need; the interceptors read it and enforce it before
+// the handler runs. No policy, no call ..
+package authzrpc
+
+//go:generate protoc -I ../../proto --go_out=. --go_opt=module=app/internal/authzrpc --go-grpc_out=. --go-grpc_opt=module=app/internal/authzrpc authz/v1/authz.proto
+
+import (
+	"app/internal/authz"
+	"app/internal/authzrpc/authzv1"
+	"app/internal/identity"
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"strings"
+	"sync"
+	"time"
+)
+
+// policies caches PolicyFor by full method ..
+var policies sync.Map
+
+// PolicyFor is the (authz.v1.policy) option of fullMethod e.g.
+// /authz.v1.AuthzService/Check. Looked up in the registered descriptors so
+// it works for any service that imports authz.proto ..
+func PolicyFor(fullMethod string) (*authzv1.Policy, bool) {
+	if p, ok := policies.Load(fullMethod); ok {
+		return p.(*authzv1.Policy), p.(*authzv1.Policy) != nil
+	}
+	p := lookupPolicy(fullMethod)
+	policies.Store(fullMethod, p)
+	return p, p != nil
+}
+
+func lookupPolicy(fullMethod string) *authzv1.Policy {
+	name := strings.Replace(strings.TrimPrefix(fullMethod, "/"), "/", ".", 1)
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil
+	}
+	md, ok := d.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil
+	}
+	opts, ok := md.Options().(*descriptorpb.MethodOptions)
+	if !ok || !proto.HasExtension(opts, authzv1.E_Policy) {
+		return nil
+	}
+	p, _ := proto.GetExtension(opts, authzv1.E_Policy).(*authzv1.Policy)
+	return p
+}
+
+// Authenticator is who is calling; an error is unauthenticated ..
+type Authenticator func(ctx context.Context) (identity.Principal, error)
+
+// APIKeys authenticates "authorization: Bearer gek_.." metadata ..
+func APIKeys(m *identity.APIKeyManager) Authenticator {
+	return func(ctx context.Context) (identity.Principal, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, v := range md.Get("authorization") {
+			if token, ok := strings.CutPrefix(v, "Bearer "); ok {
+				return m.Authenticate(ctx, strings.TrimSpace(token))
+			}
+		}
+		return identity.Principal{}, identity.ErrInvalidAPIKey
+	}
+}
+
+// Interceptor enforces method policies; Checker is asked for relations ..
+type Interceptor struct {
+	Authenticate Authenticator
+	Checker      authz.Checker
+}
+
+// authenticate is the caller if the policy needs one plus the scope ..
+func (i Interceptor) authenticate(ctx context.Context, p *authzv1.Policy) (identity.Principal, error) {
+	if i.Authenticate == nil {
+		return identity.Principal{}, status.Error(codes.Internal, "interceptor has no authenticator")
+	}
+	caller, err := i.Authenticate(ctx)
+	if err != nil {
+		if !errors.Is(err, identity.ErrInvalidAPIKey) {
+			return caller, status.Error(codes.Unavailable, "key store unavailable")
+		}
+		return caller, status.Error(codes.Unauthenticated, "invalid api key")
+	}
+	if p.GetScope() != "" && !caller.HasScope(p.GetScope()) {
+		return caller, status.Error(codes.PermissionDenied, "missing scope "+p.GetScope())
+	}
+	return caller, nil
+}
+
+// check is the relation on the document named in req; fails closed ..
+func (i Interceptor) check(ctx context.Context, p *authzv1.Policy, caller identity.Principal, req any) (authz.Decision, error) {
+	d := authz.Decision{Caller: caller.ID, Relation: p.GetRelation(), At: time.Now()}
+	msg, ok := req.(proto.Message)
+	if !ok {
+		d.Err = authz.ErrNoDocument
+		return d, status.Error(codes.Internal, "request is not a proto message")
+	}
+	field := msg.ProtoReflect().Descriptor().Fields().ByName(protoreflect.Name(p.GetDocumentField()))
+	if field == nil || field.Kind() != protoreflect.StringKind || field.IsList() {
+		d.Err = authz.ErrNoDocument
+		return d, status.Error(codes.Internal, "policy names no string field "+p.GetDocumentField())
+	}
+	d.Document = msg.ProtoReflect().Get(field).String()
+	if d.Document == "" {
+		d.Err = authz.ErrNoDocument
+		return d, status.Error(codes.InvalidArgument, p.GetDocumentField()+" is required")
+	}
+	if i.Checker == nil {
+		d.Err = errors.New("interceptor has no checker")
+		return d, status.Error(codes.Internal, d.Err.Error())
+	}
+	d.Allowed, d.Err = i.Checker.CheckWithMFA(ctx, caller.ID, d.Relation, d.Document, false)
+	if d.Err != nil {
+		d.Allowed = false
+		return d, status.Error(codes.Unavailable, "authorization unavailable")
+	}
+	if !d.Allowed {
+		return d, status.Error(codes.PermissionDenied, "caller is not "+d.Relation+" of "+d.Document)
+	}
+	return d, nil
+}
+
+// authorize is ctx with the caller + decision once the policy is met; req
+// may be nil for a stream where the policy needs no request field ..
+func (i Interceptor) authorize(ctx context.Context, fullMethod string, req any) (context.Context, error) {
+	p, ok := PolicyFor(fullMethod)
+	if !ok {
+		return ctx, status.Error(codes.PermissionDenied, "no authz policy for "+fullMethod)
+	}
+	if p.GetPublic() {
+		return ctx, nil
+	}
+	caller, err := i.authenticate(ctx, p)
+	if err != nil {
+		return ctx, err
+	}
+	ctx = identity.WithPrincipal(ctx, caller)
+	if p.GetRelation() == "" {
+		return authz.WithDecision(ctx, authz.Decision{Caller: caller.ID, Allowed: true, At: time.Now()}), nil
+	}
+	d, err := i.check(ctx, p, caller, req)
+	return authz.WithDecision(ctx, d), err
+}
+
+// Unary enforces the policy before the handler ..
+func (i Interceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := i.authorize(ctx, info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Stream enforces the policy up front; or on the first message when the
+// policy needs a field of it ..
+func (i Interceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		p, ok := PolicyFor(info.FullMethod)
+		if ok && p.GetRelation() != "" && !p.GetPublic() {
+			return handler(srv, &checkedStream{ServerStream: ss, ctx: ss.Context(), i: i, method: info.FullMethod})
+		}
+		ctx, err := i.authorize(ss.Context(), info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &checkedStream{ServerStream: ss, ctx: ctx, checked: true})
+	}
+}
+
+// checkedStream carries the authorized ctx; checks the first message in if
+// not checked yet ..
+type checkedStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	i       Interceptor
+	method  string
+	checked bool
+}
+
+func (s *checkedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *checkedStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if s.checked {
+		return nil
+	}
+	ctx, err := s.i.authorize(s.ctx, s.method, m)
+	if err != nil {
+		return err
+	}
+	s.ctx, s.checked = ctx, true
+	return nil
+}
+
+// SendMsg; nothing goes out before the check is made ..
+func (s *checkedStream) SendMsg(m any) error {
+	if !s.checked {
+		return status.Error(codes.PermissionDenied, "stream not authorized yet")
+	}
+	return s.ServerStream.SendMsg(m)
+}
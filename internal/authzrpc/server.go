@@ -0,0 +1,250 @@
+package authzrpc
+
+import (
+	"app/internal/authz"
+	"app/internal/authzrpc/authzv1"
+	"app/internal/identity"
+	"context"
+	"errors"
+	"fmt"
+	"go.temporal.io/api/serviceerror"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MaxBatchChecks is the most checks one BatchCheck takes ..
+const MaxBatchChecks = 100
+
+// Store is the checks the service wraps; AuthStore is one. Answers are
+// kept to the caller's tenant: its documents, its members ..
+type Store interface {
+	Check(ctx context.Context, user, relation, document string) (bool, error)
+	ListDocuments(ctx context.Context, user, relation string) ([]string, error)
+	CheckOrg(ctx context.Context, user, relation, org string) (bool, error)
+	DocumentInOrg(ctx context.Context, document, org string) (bool, error)
+	OrgDocuments(org string) ([]string, error)
+}
+
+// Documents takes grants + revokes; they go to the document entity so its
+// state stays right, and answer with what it did. The Gateway is one ..
+type Documents interface {
+	DocumentState(ctx context.Context, orgID, docID string) (authz.DocumentState, error)
+	CommandDocument(ctx context.Context, orgID, docID string, cmd authz.DocumentCommand) (authz.AccessEvent, error)
+}
+
+// Server is AuthzService; the interceptors have authenticated the caller and
+// checked the method's policy before any of this runs ..
+type Server struct {
+	authzv1.UnimplementedAuthzServiceServer
+	store Store
+	docs  Documents
+}
+
+func NewServer(store Store, docs Documents) *Server {
+	return &Server{store: store, docs: docs}
+}
+
+// NewGRPCServer is a grpc.Server with AuthzService behind the interceptors ..
+func NewGRPCServer(srv *Server, i Interceptor, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.ChainUnaryInterceptor(i.Unary()), grpc.ChainStreamInterceptor(i.Stream()))
+	s := grpc.NewServer(opts...)
+	authzv1.RegisterAuthzServiceServer(s, srv)
+	return s
+}
+
+// caller is who the interceptor let in ..
+func caller(ctx context.Context) (identity.Principal, error) {
+	p, ok := identity.PrincipalFrom(ctx)
+	if !ok || p.ID == "" {
+		return p, status.Error(codes.Unauthenticated, "no caller")
+	}
+	return p, nil
+}
+
+// userOr is the subject for user; the caller when empty. Asked about users
+// are plain usernames, never another type ..
+func userOr(ctx context.Context, user string) (string, error) {
+	if user != "" {
+		if err := identity.ValidUserID(user); err != nil {
+			return "", status.Error(codes.InvalidArgument, "user must be a plain username")
+		}
+		return authz.Subject(user), nil
+	}
+	p, err := caller(ctx)
+	return authz.SubjectFor(p), err
+}
+
+// asked is the subject for user within the caller's tenant; someone else
+// must be a member of it ..
+func (s *Server) asked(ctx context.Context, user string) (identity.Principal, string, error) {
+	p, err := caller(ctx)
+	if err != nil {
+		return p, "", err
+	}
+	subject, err := userOr(ctx, user)
+	if err != nil || user == "" {
+		return p, subject, err
+	}
+	member, err := s.store.CheckOrg(ctx, subject, "member", p.TenantID)
+	if err != nil {
+		fmt.Println("AUTHZ-RPC-ERR: ", err)
+		return p, "", status.Error(codes.Unavailable, "check failed")
+	}
+	if !member {
+		return p, "", status.Error(codes.PermissionDenied, "user is not a member of the caller's organization")
+	}
+	return p, subject, nil
+}
+
+func relationOr(relation string) string {
+	if relation == "" {
+		return "viewer"
+	}
+	return relation
+}
+
+func (s *Server) check(ctx context.Context, req *authzv1.CheckRequest) (*authzv1.CheckResponse, error) {
+	p, user, err := s.asked(ctx, req.GetUser())
+	if err != nil {
+		return nil, err
+	}
+	if req.GetDocument() == "" {
+		return nil, status.Error(codes.InvalidArgument, "document is required")
+	}
+	// Other tenants' documents look the same as ones that do not exist ..
+	ours, err := s.store.DocumentInOrg(ctx, req.GetDocument(), p.TenantID)
+	if err != nil {
+		fmt.Println("AUTHZ-RPC-ERR: ", err)
+		return nil, status.Error(codes.Unavailable, "check failed")
+	}
+	if !ours {
+		return nil, status.Error(codes.NotFound, "document not found")
+	}
+	allowed, err := s.store.Check(ctx, user, relationOr(req.GetRelation()), req.GetDocument())
+	if err != nil {
+		fmt.Println("AUTHZ-RPC-ERR: ", err)
+		return nil, status.Error(codes.Unavailable, "check failed")
+	}
+	return &authzv1.CheckResponse{User: authz.Subject(user), Allowed: allowed}, nil
+}
+
+func (s *Server) Check(ctx context.Context, req *authzv1.CheckRequest) (*authzv1.CheckResponse, error) {
+	return s.check(ctx, req)
+}
+
+func (s *Server) BatchCheck(ctx context.Context, req *authzv1.BatchCheckRequest) (*authzv1.BatchCheckResponse, error) {
+	if len(req.GetChecks()) > MaxBatchChecks {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d checks", MaxBatchChecks)
+	}
+	out := &authzv1.BatchCheckResponse{Results: make([]*authzv1.CheckResponse, 0, len(req.GetChecks()))}
+	for _, c := range req.GetChecks() {
+		res, err := s.check(ctx, c)
+		if err != nil {
+			// Denied on error; says why ..
+			res = &authzv1.CheckResponse{User: authz.Subject(c.GetUser()), Error: status.Convert(err).Message()}
+		}
+		out.Results = append(out.Results, res)
+	}
+	return out, nil
+}
+
+func (s *Server) ListObjects(req *authzv1.ListObjectsRequest, stream grpc.ServerStreamingServer[authzv1.ListObjectsResponse]) error {
+	p, user, err := s.asked(stream.Context(), req.GetUser())
+	if err != nil {
+		return err
+	}
+	docs, err := s.store.ListDocuments(stream.Context(), user, relationOr(req.GetRelation()))
+	if err != nil {
+		fmt.Println("AUTHZ-RPC-ERR: ", err)
+		return status.Error(codes.Unavailable, "list failed")
+	}
+	// Only the caller's tenant's; a user may see documents elsewhere too ..
+	orgDocs, err := s.store.OrgDocuments(p.TenantID)
+	if err != nil {
+		fmt.Println("AUTHZ-RPC-ERR: ", err)
+		return status.Error(codes.Unavailable, "list failed")
+	}
+	ours := make(map[string]bool, len(orgDocs))
+	for _, doc := range orgDocs {
+		ours[doc] = true
+	}
+	for _, doc := range docs {
+		if !ours[doc] {
+			continue
+		}
+		if err := stream.Send(&authzv1.ListObjectsResponse{Document: doc}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// command applies cmd to a document in the caller's tenant as an admin
+// share or revoke. A key owns no documents; it acts for its issuer, who
+// must still be an admin of the tenant, and only for its members ..
+func (s *Server) command(ctx context.Context, doc string, cmd authz.DocumentCommand) error {
+	if doc == "" || cmd.User == "" {
+		return status.Error(codes.InvalidArgument, "document and user are required")
+	}
+	p, _, err := s.asked(ctx, cmd.User)
+	if err != nil {
+		return err
+	}
+	admin := false
+	if p.IssuedBy != "" {
+		admin, err = s.store.CheckOrg(ctx, p.IssuedBy, "admin", p.TenantID)
+		if err != nil {
+			fmt.Println("AUTHZ-RPC-ERR: ", err)
+			return status.Error(codes.Unavailable, "check failed")
+		}
+	}
+	if !admin {
+		return status.Error(codes.PermissionDenied, "the key's issuer is not an admin of its organization")
+	}
+	st, err := s.docs.DocumentState(ctx, p.TenantID, doc)
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) || (err == nil && !st.Created) {
+		return status.Error(codes.NotFound, "document not found")
+	}
+	if err != nil {
+		fmt.Println("AUTHZ-RPC-ERR: ", err)
+		return status.Error(codes.Unavailable, "unable to reach document")
+	}
+	cmd.Actor = p.ID
+	event, err := s.docs.CommandDocument(ctx, p.TenantID, doc, cmd)
+	if err != nil {
+		fmt.Println("AUTHZ-RPC-ERR: ", err)
+		return status.Error(codes.Unavailable, "unable to reach document")
+	}
+	if !event.Accepted {
+		return status.Error(codes.FailedPrecondition, event.Detail)
+	}
+	return nil
+}
+
+func (s *Server) Grant(ctx context.Context, req *authzv1.GrantRequest) (*authzv1.GrantResponse, error) {
+	err := s.command(ctx, req.GetDocument(), authz.DocumentCommand{
+		Op:       authz.OpAdminShare,
+		User:     req.GetUser(),
+		Relation: req.GetRelation(),
+		Reason:   req.GetReason(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &authzv1.GrantResponse{Status: "granted"}, nil
+}
+
+func (s *Server) Revoke(ctx context.Context, req *authzv1.RevokeRequest) (*authzv1.RevokeResponse, error) {
+	err := s.command(ctx, req.GetDocument(), authz.DocumentCommand{
+		Op:       authz.OpAdminRevoke,
+		User:     req.GetUser(),
+		Relation: req.GetRelation(),
+		Reason:   req.GetReason(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &authzv1.RevokeResponse{Status: "revoked"}, nil
+}
@@ -0,0 +1,272 @@
+package authzrpc
+
+import (
+	"app/internal/authz"
+	"app/internal/authzrpc/authzv1"
+	"app/internal/identity"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/api/serviceerror"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	"testing"
+)
+
+// fakeStore allows user:relation:document; orgs is doc -> org, members and
+// admins subject:org. down fails everything ..
+type fakeStore struct {
+	allow   map[string]bool
+	orgs    map[string]string
+	members map[string]bool
+	admins  map[string]bool
+	down    bool
+}
+
+func (f *fakeStore) Check(_ context.Context, user, relation, document string) (bool, error) {
+	if f.down {
+		return false, errors.New("fga down")
+	}
+	return f.allow[authz.Subject(user)+":"+relation+":"+document], nil
+}
+
+func (f *fakeStore) CheckWithMFA(ctx context.Context, user, relation, document string, _ bool) (bool, error) {
+	return f.Check(ctx, user, relation, document)
+}
+
+func (f *fakeStore) ListDocuments(_ context.Context, user, relation string) ([]string, error) {
+	if f.down {
+		return nil, errors.New("fga down")
+	}
+	return []string{"crab/secret.doc", "public/plan.doc", "public/readme.doc"}, nil
+}
+
+func (f *fakeStore) CheckOrg(_ context.Context, user, relation, org string) (bool, error) {
+	if f.down {
+		return false, errors.New("fga down")
+	}
+	switch relation {
+	case "member":
+		return f.members[authz.Subject(user)+":"+org], nil
+	case "admin":
+		return f.admins[authz.Subject(user)+":"+org], nil
+	}
+	return false, nil
+}
+
+func (f *fakeStore) DocumentInOrg(_ context.Context, document, org string) (bool, error) {
+	if f.down {
+		return false, errors.New("fga down")
+	}
+	return f.orgs[document] == org, nil
+}
+
+func (f *fakeStore) OrgDocuments(org string) ([]string, error) {
+	if f.down {
+		return nil, errors.New("fga down")
+	}
+	var docs []string
+	for doc, o := range f.orgs {
+		if o == org {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+// fakeDocs has owners by org/doc; commands are accepted bar revoking nobody ..
+type fakeDocs struct {
+	owners map[string]string
+	org    string
+	sent   []authz.DocumentCommand
+}
+
+func (f *fakeDocs) DocumentState(_ context.Context, orgID, docID string) (authz.DocumentState, error) {
+	owner, ok := f.owners[orgID+"/"+docID]
+	if !ok {
+		return authz.DocumentState{}, serviceerror.NewNotFound("no such workflow")
+	}
+	return authz.DocumentState{OrgID: orgID, Doc: authz.Document{ID: docID, Owner: owner}, Created: true}, nil
+}
+
+func (f *fakeDocs) CommandDocument(_ context.Context, orgID, docID string, cmd authz.DocumentCommand) (authz.AccessEvent, error) {
+	f.org = orgID
+	f.sent = append(f.sent, cmd)
+	accepted := cmd.Op != authz.OpAdminRevoke || cmd.User != "nobody"
+	return authz.AccessEvent{Op: cmd.Op, Actor: cmd.Actor, User: cmd.User, Accepted: accepted, Detail: "no grant"}, nil
+}
+
+type harness struct {
+	client authzv1.AuthzServiceClient
+	store  *fakeStore
+	docs   *fakeDocs
+	keys   *identity.APIKeyManager
+}
+
+// newHarness is the real server + interceptors over an in-memory listener ..
+func newHarness(t *testing.T) *harness {
+	h := &harness{
+		store: &fakeStore{
+			allow: map[string]bool{},
+			orgs: map[string]string{
+				"public/plan.doc":   "GopherLab",
+				"public/readme.doc": "GopherLab",
+				"crab/secret.doc":   "CrabLab",
+			},
+			members: map[string]bool{"user:bob:GopherLab": true},
+			admins:  map[string]bool{},
+		},
+		docs: &fakeDocs{owners: map[string]string{}},
+		keys: identity.NewAPIKeyManager(identity.NewMemoryStore()),
+	}
+	lis := bufconn.Listen(1 << 20)
+	srv := NewGRPCServer(NewServer(h.store, h.docs), Interceptor{Authenticate: APIKeys(h.keys), Checker: h.store})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	h.client = authzv1.NewAuthzServiceClient(conn)
+	return h
+}
+
+// as is ctx with a fresh key carrying scopes; and its service ID ..
+func (h *harness) as(t *testing.T, scopes ...string) (context.Context, string) {
+	token, key, err := h.keys.Issue(context.Background(), "GopherLab", "test", "mleow", scopes)
+	require.NoError(t, err)
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token), "service:" + key.ID
+}
+
+func TestPolicyFor(t *testing.T) {
+	p, ok := PolicyFor(authzv1.AuthzService_Grant_FullMethodName)
+	require.True(t, ok)
+	assert.Equal(t, identity.ScopeAuthzGrant, p.GetScope())
+	// The issuer's admin check is the server's; a key owns no documents ..
+	assert.Empty(t, p.GetRelation())
+	_, ok = PolicyFor("/authz.v1.AuthzService/Nope")
+	assert.False(t, ok)
+	_, ok = PolicyFor("/grpc.health.v1.Health/Check")
+	assert.False(t, ok)
+}
+
+func TestCheckEndToEnd(t *testing.T) {
+	h := newHarness(t)
+	_, err := h.client.Check(context.Background(), &authzv1.CheckRequest{Document: "public/readme.doc"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx, svc := h.as(t, identity.ScopeAuthzCheck)
+	h.store.allow[svc+":viewer:public/readme.doc"] = true
+	h.store.allow["user:bob:editor:public/readme.doc"] = true
+
+	res, err := h.client.Check(ctx, &authzv1.CheckRequest{Document: "public/readme.doc"})
+	require.NoError(t, err)
+	assert.True(t, res.GetAllowed())
+	assert.Equal(t, svc, res.GetUser())
+
+	batch, err := h.client.BatchCheck(ctx, &authzv1.BatchCheckRequest{Checks: []*authzv1.CheckRequest{
+		{User: "bob", Relation: "editor", Document: "public/readme.doc"},
+		{User: "bob", Relation: "owner", Document: "public/readme.doc"},
+		{User: "bob"},
+	}})
+	require.NoError(t, err)
+	require.Len(t, batch.GetResults(), 3)
+	assert.True(t, batch.GetResults()[0].GetAllowed())
+	assert.False(t, batch.GetResults()[1].GetAllowed())
+	assert.Equal(t, "document is required", batch.GetResults()[2].GetError())
+
+	stream, err := h.client.ListObjects(ctx, &authzv1.ListObjectsRequest{User: "bob"})
+	require.NoError(t, err)
+	var docs []string
+	for {
+		r, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		docs = append(docs, r.GetDocument())
+	}
+	assert.Equal(t, []string{"public/plan.doc", "public/readme.doc"}, docs)
+
+	// Nothing about other tenants; their documents or their users ..
+	h.store.allow["user:carol:viewer:crab/secret.doc"] = true
+	h.store.allow["user:bob:viewer:crab/secret.doc"] = true
+	_, err = h.client.Check(ctx, &authzv1.CheckRequest{User: "bob", Document: "crab/secret.doc"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = h.client.Check(ctx, &authzv1.CheckRequest{User: "carol", Document: "public/readme.doc"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	stream, err = h.client.ListObjects(ctx, &authzv1.ListObjectsRequest{User: "carol"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Fails closed ..
+	h.store.down = true
+	_, err = h.client.Check(ctx, &authzv1.CheckRequest{Document: "public/readme.doc"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	stream, err = h.client.ListObjects(ctx, &authzv1.ListObjectsRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestGrantPolicy(t *testing.T) {
+	h := newHarness(t)
+	grant := &authzv1.GrantRequest{Document: "svc/report.doc", User: "bob", Relation: "viewer"}
+	h.docs.owners["GopherLab/svc/report.doc"] = "mleow"
+
+	// Scope first; a check key cannot grant ..
+	ctx, svc := h.as(t, identity.ScopeAuthzCheck)
+	h.store.admins["user:mleow:GopherLab"] = true
+	_, err := h.client.Grant(ctx, grant)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Then the key's issuer; mleow issued it but is no longer an admin ..
+	h.store.admins["user:mleow:GopherLab"] = false
+	ctx, svc = h.as(t, identity.ScopeAuthzGrant)
+	_, err = h.client.Grant(ctx, grant)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = h.client.Revoke(ctx, &authzv1.RevokeRequest{User: "bob"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	h.store.admins["user:mleow:GopherLab"] = true
+
+	// Only plain usernames, and only the tenant's members ..
+	_, err = h.client.Grant(ctx, &authzv1.GrantRequest{Document: "svc/report.doc", User: "group:x#member"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = h.client.Grant(ctx, &authzv1.GrantRequest{Document: "svc/report.doc", User: "eve"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// The entity has to exist in the caller's tenant ..
+	_, err = h.client.Grant(ctx, &authzv1.GrantRequest{Document: "crab/secret.doc", User: "bob"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	h.docs.owners["CrabLab/crab/secret.doc"] = "crab"
+	_, err = h.client.Grant(ctx, &authzv1.GrantRequest{Document: "crab/secret.doc", User: "bob"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Empty(t, h.docs.sent)
+
+	res, err := h.client.Grant(ctx, grant)
+	require.NoError(t, err)
+	assert.Equal(t, "granted", res.GetStatus())
+	rres, err := h.client.Revoke(ctx, &authzv1.RevokeRequest{Document: "svc/report.doc", User: "bob", Relation: "viewer"})
+	require.NoError(t, err)
+	assert.Equal(t, "revoked", rres.GetStatus())
+	// Refused by the entity comes back as such ..
+	h.store.members["user:nobody:GopherLab"] = true
+	_, err = h.client.Revoke(ctx, &authzv1.RevokeRequest{Document: "svc/report.doc", User: "nobody"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	assert.Equal(t, "GopherLab", h.docs.org)
+	require.Len(t, h.docs.sent, 3)
+	assert.Equal(t, authz.DocumentCommand{Op: authz.OpAdminShare, Actor: svc, User: "bob", Relation: "viewer"}, h.docs.sent[0])
+	assert.Equal(t, authz.OpAdminRevoke, h.docs.sent[1].Op)
+	assert.Equal(t, svc, h.docs.sent[1].Actor)
+}
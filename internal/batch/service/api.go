@@ -11,6 +11,8 @@ type ScriptExecutionInput struct {
     ScriptPath    string `json:"scriptPath"`    
     ExecutorCmd   string `json:"executorCmd"`   
     NexusPath     string `json:"nexusPath"`     
+    // NotifyOnFailure is the user told when the script fails; empty tells no one
+    NotifyOnFailure string `json:"notifyOnFailure,omitempty"`
 }
 
 // ScriptExecutionResult represents the result of script execution
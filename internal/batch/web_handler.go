@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"app/internal/batch/service"
+	"app/internal/identity"
 	"go.temporal.io/sdk/client"
 )
 
@@ -16,6 +17,8 @@ var templateFS embed.FS
 type WebHandler struct {
 	client    client.Client
 	templates *template.Template
+	// No login here; double-submit cookie keeps other sites from posting ..
+	csrf identity.CSRFCookie
 }
 
 // NewWebHandler creates a new web handler
@@ -34,12 +37,15 @@ func NewWebHandler(c client.Client) (*WebHandler, error) {
 // RegisterRoutes registers the web handler routes
 func (h *WebHandler) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/execute", h.handleExecute)
-	mux.HandleFunc("/execute/submit", h.handleSubmit)
+	mux.Handle("/execute/submit", h.csrf.Protect(http.HandlerFunc(h.handleSubmit)))
 	mux.HandleFunc("/execute/status/", h.handleStatus)
 }
 
 func (h *WebHandler) handleExecute(w http.ResponseWriter, r *http.Request) {
-	h.templates.ExecuteTemplate(w, "execute.html", nil)
+	h.templates.ExecuteTemplate(w, "execute.html", map[string]interface{}{
+		"CSRFField": identity.CSRFField,
+		"CSRFToken": h.csrf.Token(w, r),
+	})
 }
 
 func (h *WebHandler) handleSubmit(w http.ResponseWriter, r *http.Request) {
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"app/internal/batch/service"
+	"app/internal/notify"
 	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/workflow"
 )
@@ -65,8 +66,46 @@ func ExecuteBatchScript(ctx workflow.Context, input service.ScriptExecutionInput
 	var result service.ScriptExecutionResult
 	err := workflow.ExecuteActivity(ctx, ExecuteScript, input).Get(ctx, &result)
 	if err != nil {
+		notifyFailure(ctx, input, err.Error())
 		return nil, err
 	}
+	if !result.Success {
+		notifyFailure(ctx, input, result.ErrorMessage)
+	}
 
 	return &result, nil
 }
+
+// notifyFailure tells input.NotifyOnFailure the script failed; the script's
+// result stands whether or not that gets through
+func notifyFailure(ctx workflow.Context, input service.ScriptExecutionInput, reason string) {
+	if input.NotifyOnFailure == "" {
+		return
+	}
+	// Runs started before the notification existed replay without it
+	if workflow.GetVersion(ctx, "notify-on-failure", workflow.DefaultVersion, 1) == workflow.DefaultVersion {
+		return
+	}
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 30 * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts:    5,
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2.0,
+		},
+	})
+	var a *notify.Activities
+	err := workflow.ExecuteActivity(ctx, a.SendNotificationActivity, notify.Message{
+		To:   input.NotifyOnFailure,
+		Kind: notify.KindBatchFailed,
+		Data: map[string]string{
+			"script":   input.ScriptPath,
+			"function": input.APIFunction,
+			"workflow": workflow.GetInfo(ctx).WorkflowExecution.ID,
+			"error":    reason,
+		},
+	}).Get(ctx, nil)
+	if err != nil {
+		workflow.GetLogger(ctx).Error("Failure notification failed", "error", err)
+	}
+}
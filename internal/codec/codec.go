@@ -0,0 +1,158 @@
+// Package codec encrypts every Temporal payload before it leaves the process
+// so workflow inputs, results + signals are ciphertext in history. Shared by
+// every client + worker and by the codec server the Temporal UI decodes with ..
+package codec
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/sdk/converter"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"strings"
+)
+
+// Payload metadata of an encrypted payload ..
+const (
+	MetadataEncoding = "binary/encrypted"
+	MetadataKeyID    = "encryption-key-id"
+)
+
+var (
+	ErrNoKeys     = errors.New("payload codec has no keys")
+	ErrUnknownKey = errors.New("payload encrypted with an unknown key")
+)
+
+// Keyring is the AES-256 keys by ID; Current encrypts, any of them decrypt.
+// Rotate by adding a new key as Current and keeping the old ones until
+// history written with them has aged out ..
+type Keyring struct {
+	Current string
+	Keys    map[string][]byte
+}
+
+// ParseKeyring is "id:base64key,id:base64key"; the first is current ..
+func ParseKeyring(s string) (Keyring, error) {
+	kr := Keyring{Keys: map[string][]byte{}}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, enc, ok := strings.Cut(part, ":")
+		if !ok || id == "" {
+			return kr, fmt.Errorf("payload key %q is not id:base64", part)
+		}
+		key, err := base64.StdEncoding.DecodeString(enc)
+		if err != nil {
+			return kr, fmt.Errorf("payload key %s: %w", id, err)
+		}
+		if len(key) != 32 {
+			return kr, fmt.Errorf("payload key %s is %d bytes; want 32", id, len(key))
+		}
+		if _, dup := kr.Keys[id]; dup {
+			return kr, fmt.Errorf("payload key %s given twice", id)
+		}
+		kr.Keys[id] = key
+		if kr.Current == "" {
+			kr.Current = id
+		}
+	}
+	if kr.Current == "" {
+		return kr, ErrNoKeys
+	}
+	return kr, nil
+}
+
+// Codec is the converter.PayloadCodec; AES-256-GCM over the whole original
+// payload, metadata included ..
+type Codec struct {
+	keys map[string]cipher.AEAD
+	// current is the ID new payloads are encrypted with ..
+	current string
+}
+
+var _ converter.PayloadCodec = (*Codec)(nil)
+
+func NewCodec(kr Keyring) (*Codec, error) {
+	if _, ok := kr.Keys[kr.Current]; !ok {
+		return nil, ErrNoKeys
+	}
+	c := &Codec{keys: map[string]cipher.AEAD{}, current: kr.Current}
+	for id, key := range kr.Keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("payload key %s: %w", id, err)
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.keys[id] = gcm
+	}
+	return c, nil
+}
+
+// DataConverter is the SDK default converter with payloads encrypted ..
+func DataConverter(c *Codec) converter.DataConverter {
+	return converter.NewCodecDataConverter(converter.GetDefaultDataConverter(), c)
+}
+
+func (c *Codec) Encode(payloads []*commonpb.Payload) ([]*commonpb.Payload, error) {
+	gcm := c.keys[c.current]
+	out := make([]*commonpb.Payload, len(payloads))
+	for i, p := range payloads {
+		plain, err := proto.Marshal(p)
+		if err != nil {
+			return payloads, err
+		}
+		nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plain)+gcm.Overhead())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return payloads, err
+		}
+		out[i] = &commonpb.Payload{
+			Metadata: map[string][]byte{
+				converter.MetadataEncoding: []byte(MetadataEncoding),
+				MetadataKeyID:              []byte(c.current),
+			},
+			// Key ID as AAD; swapping the metadata does not decrypt ..
+			Data: gcm.Seal(nonce, nonce, plain, []byte(c.current)),
+		}
+	}
+	return out, nil
+}
+
+// Decode; payloads from before encryption was on come back as they are ..
+func (c *Codec) Decode(payloads []*commonpb.Payload) ([]*commonpb.Payload, error) {
+	out := make([]*commonpb.Payload, len(payloads))
+	for i, p := range payloads {
+		if string(p.GetMetadata()[converter.MetadataEncoding]) != MetadataEncoding {
+			out[i] = p
+			continue
+		}
+		id := string(p.GetMetadata()[MetadataKeyID])
+		gcm, ok := c.keys[id]
+		if !ok {
+			return payloads, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+		}
+		data := p.GetData()
+		if len(data) < gcm.NonceSize() {
+			return payloads, errors.New("encrypted payload too short")
+		}
+		plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(id))
+		if err != nil {
+			return payloads, fmt.Errorf("payload key %s: %w", id, err)
+		}
+		decoded := &commonpb.Payload{}
+		if err := proto.Unmarshal(plain, decoded); err != nil {
+			return payloads, err
+		}
+		out[i] = decoded
+	}
+	return out, nil
+}
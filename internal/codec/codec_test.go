@@ -0,0 +1,137 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/sdk/converter"
+	"google.golang.org/protobuf/encoding/protojson"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newKey(t *testing.T) string {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+type secretInput struct {
+	Name    string
+	Content string
+}
+
+func TestCodecRoundTripAndRotation(t *testing.T) {
+	k1, k2 := newKey(t), newKey(t)
+	kr, err := ParseKeyring("k1:" + k1)
+	require.NoError(t, err)
+	c1, err := NewCodec(kr)
+	require.NoError(t, err)
+	dc := DataConverter(c1)
+
+	p, err := dc.ToPayload(secretInput{Name: "GopherLab", Content: "Lotsa Moolah!!"})
+	require.NoError(t, err)
+	assert.Equal(t, MetadataEncoding, string(p.Metadata[converter.MetadataEncoding]))
+	assert.Equal(t, "k1", string(p.Metadata[MetadataKeyID]))
+	assert.NotContains(t, string(p.Data), "Moolah")
+
+	// Rotated; k2 writes, k1 still reads what is already in history ..
+	kr, err = ParseKeyring("k2:" + k2 + ", k1:" + k1)
+	require.NoError(t, err)
+	c2, err := NewCodec(kr)
+	require.NoError(t, err)
+	var got secretInput
+	require.NoError(t, DataConverter(c2).FromPayload(p, &got))
+	assert.Equal(t, "Lotsa Moolah!!", got.Content)
+	p2, err := DataConverter(c2).ToPayload("next")
+	require.NoError(t, err)
+	assert.Equal(t, "k2", string(p2.Metadata[MetadataKeyID]))
+	_, err = c1.Decode([]*commonpb.Payload{p2})
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	// Claiming another key ID does not get it decrypted ..
+	p.Metadata[MetadataKeyID] = []byte("k2")
+	_, err = c2.Decode([]*commonpb.Payload{p})
+	assert.Error(t, err)
+
+	// Written before encryption; passes through ..
+	plain, err := converter.GetDefaultDataConverter().ToPayload("old")
+	require.NoError(t, err)
+	var s string
+	require.NoError(t, dc.FromPayload(plain, &s))
+	assert.Equal(t, "old", s)
+}
+
+func TestParseKeyring(t *testing.T) {
+	_, err := ParseKeyring("")
+	assert.ErrorIs(t, err, ErrNoKeys)
+	_, err = ParseKeyring("k1:" + base64.StdEncoding.EncodeToString([]byte("short")))
+	assert.Error(t, err)
+	k := newKey(t)
+	_, err = ParseKeyring("k1:" + k + ",k1:" + k)
+	assert.Error(t, err)
+}
+
+func TestFromEnvFailsClosed(t *testing.T) {
+	t.Setenv("PAYLOAD_KEYS", "")
+	t.Setenv("PAYLOAD_DEV_KEY", "")
+	_, err := FromEnv()
+	assert.ErrorIs(t, err, ErrNoKeys)
+
+	t.Setenv("PAYLOAD_DEV_KEY", "1")
+	c, err := FromEnv()
+	require.NoError(t, err)
+	assert.NotNil(t, c)
+
+	t.Setenv("PAYLOAD_KEYS", "k1:"+newKey(t))
+	t.Setenv("PAYLOAD_DEV_KEY", "")
+	_, err = FromEnv()
+	assert.NoError(t, err)
+}
+
+func TestServer(t *testing.T) {
+	kr, err := ParseKeyring("k1:" + newKey(t))
+	require.NoError(t, err)
+	c, err := NewCodec(kr)
+	require.NoError(t, err)
+	p, err := DataConverter(c).ToPayload("Secretz")
+	require.NoError(t, err)
+	body, err := protojson.Marshal(&commonpb.Payloads{Payloads: []*commonpb.Payload{p}})
+	require.NoError(t, err)
+
+	srv := Server(c, ServerOptions{Tokens: []string{"operator"}, Origins: []string{"http://localhost:8233"}})
+	post := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Origin", "http://localhost:8233")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, post("/decode", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, post("/decode", "guess").Code)
+	assert.Equal(t, http.StatusNotFound, post("/encode", "operator").Code)
+
+	rec := post("/decode", "operator")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "http://localhost:8233", rec.Header().Get("Access-Control-Allow-Origin"))
+	var out struct {
+		Payloads []struct {
+			Data string `json:"data"`
+		} `json:"payloads"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+	require.Len(t, out.Payloads, 1)
+	data, err := base64.StdEncoding.DecodeString(out.Payloads[0].Data)
+	require.NoError(t, err)
+	assert.Equal(t, `"Secretz"`, string(data))
+}
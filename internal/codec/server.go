@@ -0,0 +1,94 @@
+package codec
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"go.temporal.io/sdk/converter"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// devKeyring keeps local runs working across processes + restarts; the key
+// is in the source so it protects nothing ..
+func devKeyring() Keyring {
+	key := sha256.Sum256([]byte("app dev payload key; not for production"))
+	return Keyring{Current: "dev", Keys: map[string][]byte{"dev": key[:]}}
+}
+
+// FromEnv is the codec from PAYLOAD_KEYS (see ParseKeyring). Unset is an
+// error; the dev key only when PAYLOAD_DEV_KEY=1 asks for it ..
+func FromEnv() (*Codec, error) {
+	spec := os.Getenv("PAYLOAD_KEYS")
+	if spec == "" {
+		if os.Getenv("PAYLOAD_DEV_KEY") != "1" {
+			return nil, fmt.Errorf("%w: set PAYLOAD_KEYS (PAYLOAD_DEV_KEY=1 for local runs)", ErrNoKeys)
+		}
+		fmt.Println("PAYLOAD_DEV_KEY set; Temporal payloads use the dev key!!")
+		return NewCodec(devKeyring())
+	}
+	kr, err := ParseKeyring(spec)
+	if err != nil {
+		return nil, err
+	}
+	return NewCodec(kr)
+}
+
+// ServerOptions for the codec server the Temporal UI calls ..
+type ServerOptions struct {
+	// Tokens operators send as Bearer; none means nobody can decode ..
+	Tokens []string
+	// Origins allowed to call from a browser e.g. http://localhost:8233
+	Origins []string
+}
+
+// Server only decodes; encoding would let callers forge payloads. Operators
+// authenticate with a bearer token ..
+func Server(c *Codec, opts ServerOptions) http.Handler {
+	decode := converter.NewPayloadCodecHTTPHandler(c)
+	sums := make([][32]byte, 0, len(opts.Tokens))
+	for _, t := range opts.Tokens {
+		if t != "" {
+			sums = append(sums, sha256.Sum256([]byte(t)))
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" {
+			for _, allowed := range opts.Origins {
+				if origin == allowed {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+					w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Namespace")
+					w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+					w.Header().Add("Vary", "Origin")
+				}
+			}
+		}
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if !strings.HasSuffix(r.URL.Path, "/decode") {
+			http.NotFound(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !validToken(sums, token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		decode.ServeHTTP(w, r)
+	})
+}
+
+// validToken; compares hashes so the length does not leak either ..
+func validToken(sums [][32]byte, token string) bool {
+	sum := sha256.Sum256([]byte(token))
+	ok := 0
+	for _, s := range sums {
+		ok |= subtle.ConstantTimeCompare(s[:], sum[:])
+	}
+	return ok == 1
+}
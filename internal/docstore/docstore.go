@@ -0,0 +1,83 @@
+// Package docstore keeps document content out of Temporal: every version
+// ever written and who wrote it, soft deletes, and ETags so two editors do
+// not overwrite each other. Workflows + activities only carry document IDs ..
+package docstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("document not found")
+	ErrExists   = errors.New("document already exists")
+	// ErrDeleted is soft deleted; its versions are still there ..
+	ErrDeleted = errors.New("document deleted")
+	// ErrPrecondition is an If-Match that is not the current ETag ..
+	ErrPrecondition = errors.New("document changed; etag does not match")
+)
+
+// Document is the latest version plus who made it and when ..
+type Document struct {
+	ID    string
+	OrgID string
+	// Content + Author are of the latest Version ..
+	Content   string
+	Version   int
+	ETag      string
+	Author    string
+	CreatedBy string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// Zero unless soft deleted ..
+	DeletedBy string
+	DeletedAt time.Time
+}
+
+// Deleted is soft deleted ..
+func (d Document) Deleted() bool {
+	return !d.DeletedAt.IsZero()
+}
+
+// Version is one immutable write ..
+type Version struct {
+	DocID     string
+	Version   int
+	ETag      string
+	Content   string
+	Author    string
+	CreatedAt time.Time
+}
+
+// DocumentStore is where content lives; IDs are never reused, not even
+// after a delete ..
+type DocumentStore interface {
+	// Create is version 1; ErrExists if the ID was ever used ..
+	Create(ctx context.Context, orgID, id, content, author string) (Document, error)
+	// Get is the latest version; ErrDeleted (with the document) if deleted ..
+	Get(ctx context.Context, id string) (Document, error)
+	// Update adds a version; ifMatch is the ETag it was read at, empty to
+	// not care. ErrPrecondition if someone got there first ..
+	Update(ctx context.Context, id, content, author, ifMatch string) (Document, error)
+	// Delete is soft; history stays for audit ..
+	Delete(ctx context.Context, id, author, ifMatch string) error
+	// Versions oldest first ..
+	Versions(ctx context.Context, id string) ([]Version, error)
+	Version(ctx context.Context, id string, version int) (Version, error)
+}
+
+// ETag is strong and quoted as it goes in the header; changes with every
+// version even if the content goes back to what it was ..
+func ETag(id string, version int, content string) string {
+	sum := sha256.Sum256([]byte(id + "\x00" + strconv.Itoa(version) + "\x00" + content))
+	return `"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+// etagMatches; empty or * is unconditional ..
+func etagMatches(ifMatch, etag string) bool {
+	return ifMatch == "" || ifMatch == "*" || ifMatch == etag
+}
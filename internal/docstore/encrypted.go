@@ -0,0 +1,316 @@
+package docstore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Envelope encryption: each document has its own data key (DEK) that seals
+// every version of its content; the DEK is kept wrapped by its tenant's KEK
+// in the KMS. Rotating a KEK only re-wraps DEKs, and that happens as they
+// are next used; the versions themselves are never rewritten. Destroying a
+// tenant's KEK makes all of its content unreadable (crypto-shredding) ..
+
+// sealedPrefix marks sealed content; anything else was written before
+// encryption was on and is handed back as is ..
+const sealedPrefix = "enc:v1:"
+
+// DataKey is a document's DEK wrapped by its tenant's KEK version ..
+type DataKey struct {
+	DocID      string
+	OrgID      string
+	KeyVersion int
+	Wrapped    []byte
+}
+
+// KeyStore is where the wrapped DEKs live; next to the content but useless
+// without the KMS ..
+type KeyStore interface {
+	// CreateKey; ErrExists if the document already has one ..
+	CreateKey(ctx context.Context, k DataKey) error
+	GetKey(ctx context.Context, docID string) (DataKey, error)
+	// RewrapKey swaps in k only if it is still wrapped at version from;
+	// losing that race is fine, the other writer re-wrapped it ..
+	RewrapKey(ctx context.Context, k DataKey, from int) error
+}
+
+// EncryptedStore seals content on the way into a DocumentStore and opens it
+// on the way out; ETags + versions are the inner store's ..
+type EncryptedStore struct {
+	inner DocumentStore
+	keys  KeyStore
+	kms   KMS
+}
+
+func NewEncryptedStore(inner DocumentStore, keys KeyStore, kms KMS) *EncryptedStore {
+	return &EncryptedStore{inner: inner, keys: keys, kms: kms}
+}
+
+// Rotate gives the tenant a new KEK; DEKs move to it as they are read ..
+func (s *EncryptedStore) Rotate(ctx context.Context, orgID string) (int, error) {
+	return s.kms.Rotate(ctx, orgID)
+}
+
+// Shred destroys the tenant's KEK; its documents can never be read again ..
+func (s *EncryptedStore) Shred(ctx context.Context, orgID string) error {
+	return s.kms.Destroy(ctx, orgID)
+}
+
+// newKey makes + stores a DEK for a document that has none ..
+func (s *EncryptedStore) newKey(ctx context.Context, orgID, docID string) ([]byte, error) {
+	dek := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return nil, err
+	}
+	version, wrapped, err := s.kms.Wrap(ctx, orgID, dek)
+	if err != nil {
+		return nil, err
+	}
+	err = s.keys.CreateKey(ctx, DataKey{DocID: docID, OrgID: orgID, KeyVersion: version, Wrapped: wrapped})
+	if err != nil {
+		return nil, err
+	}
+	return dek, nil
+}
+
+// dataKey unwraps the document's DEK; re-wrapping it under the current KEK
+// if that has been rotated since ..
+func (s *EncryptedStore) dataKey(ctx context.Context, docID string) ([]byte, error) {
+	k, err := s.keys.GetKey(ctx, docID)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := s.kms.Unwrap(ctx, k.OrgID, k.KeyVersion, k.Wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if current, err := s.kms.Current(ctx, k.OrgID); err == nil && current > k.KeyVersion {
+		// Lazy re-wrap; a failure here only means it happens next time ..
+		if version, wrapped, err := s.kms.Wrap(ctx, k.OrgID, dek); err == nil {
+			from := k.KeyVersion
+			k.KeyVersion, k.Wrapped = version, wrapped
+			if err := s.keys.RewrapKey(ctx, k, from); err != nil {
+				fmt.Println("DOCSTORE: unable to re-wrap key of", docID, "ERR:", err)
+			}
+		}
+	}
+	return dek, nil
+}
+
+// writeKey is the DEK to seal new content with; documents written before
+// encryption was on get theirs now ..
+func (s *EncryptedStore) writeKey(ctx context.Context, orgID, docID string) ([]byte, error) {
+	dek, err := s.dataKey(ctx, docID)
+	if errors.Is(err, ErrNotFound) {
+		return s.newKey(ctx, orgID, docID)
+	}
+	return dek, err
+}
+
+func sealContent(dek []byte, docID, content string) (string, error) {
+	sealed, err := seal(dek, []byte(content), []byte(docID))
+	if err != nil {
+		return "", err
+	}
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openContent; the DocID as AAD stops content being swapped between docs ..
+func (s *EncryptedStore) openContent(ctx context.Context, docID, content string) (string, error) {
+	if !strings.HasPrefix(content, sealedPrefix) {
+		return content, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(content, sealedPrefix))
+	if err != nil {
+		return "", err
+	}
+	dek, err := s.dataKey(ctx, docID)
+	if err != nil {
+		return "", err
+	}
+	plain, err := open(dek, sealed, []byte(docID))
+	return string(plain), err
+}
+
+func (s *EncryptedStore) Create(ctx context.Context, orgID, id, content, author string) (Document, error) {
+	if orgID == "" {
+		return Document{}, ErrNoTenant
+	}
+	dek, err := s.newKey(ctx, orgID, id)
+	if errors.Is(err, ErrExists) {
+		// Left behind by a create that failed after the key went in ..
+		if _, gerr := s.inner.Get(ctx, id); !errors.Is(gerr, ErrNotFound) {
+			return Document{}, ErrExists
+		}
+		dek, err = s.dataKey(ctx, id)
+	}
+	if err != nil {
+		return Document{}, err
+	}
+	sealed, err := sealContent(dek, id, content)
+	if err != nil {
+		return Document{}, err
+	}
+	d, err := s.inner.Create(ctx, orgID, id, sealed, author)
+	if err != nil {
+		return d, err
+	}
+	d.Content = content
+	return d, nil
+}
+
+func (s *EncryptedStore) Get(ctx context.Context, id string) (Document, error) {
+	d, err := s.inner.Get(ctx, id)
+	if err != nil {
+		// Deleted content is not handed out ..
+		d.Content = ""
+		return d, err
+	}
+	d.Content, err = s.openContent(ctx, id, d.Content)
+	return d, err
+}
+
+func (s *EncryptedStore) Update(ctx context.Context, id, content, author, ifMatch string) (Document, error) {
+	cur, err := s.inner.Get(ctx, id)
+	if err != nil {
+		cur.Content = ""
+		return cur, err
+	}
+	dek, err := s.writeKey(ctx, cur.OrgID, id)
+	if err != nil {
+		return Document{}, err
+	}
+	sealed, err := sealContent(dek, id, content)
+	if err != nil {
+		return Document{}, err
+	}
+	d, err := s.inner.Update(ctx, id, sealed, author, ifMatch)
+	if err != nil {
+		d.Content = ""
+		return d, err
+	}
+	d.Content = content
+	return d, nil
+}
+
+func (s *EncryptedStore) Delete(ctx context.Context, id, author, ifMatch string) error {
+	return s.inner.Delete(ctx, id, author, ifMatch)
+}
+
+func (s *EncryptedStore) Versions(ctx context.Context, id string) ([]Version, error) {
+	versions, err := s.inner.Versions(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	for i := range versions {
+		if versions[i].Content, err = s.openContent(ctx, id, versions[i].Content); err != nil {
+			return nil, err
+		}
+	}
+	return versions, nil
+}
+
+func (s *EncryptedStore) Version(ctx context.Context, id string, version int) (Version, error) {
+	v, err := s.inner.Version(ctx, id, version)
+	if err != nil {
+		return v, err
+	}
+	v.Content, err = s.openContent(ctx, id, v.Content)
+	return v, err
+}
+
+// FileKeyStore is the KeyStore for dev; a file per document under dir ..
+type FileKeyStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewFileKeyStore(dir string) (*FileKeyStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileKeyStore{dir: dir}, nil
+}
+
+func (f *FileKeyStore) path(docID string) string {
+	return filepath.Join(f.dir, url.PathEscape(docID)+".json")
+}
+
+func (f *FileKeyStore) CreateKey(ctx context.Context, k DataKey) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, err := json.Marshal(k)
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(f.path(k.DocID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if errors.Is(err, fs.ErrExist) {
+		return ErrExists
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(b); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func (f *FileKeyStore) GetKey(ctx context.Context, docID string) (DataKey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.read(docID)
+}
+
+func (f *FileKeyStore) read(docID string) (DataKey, error) {
+	var k DataKey
+	b, err := os.ReadFile(f.path(docID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return k, ErrNotFound
+	}
+	if err != nil {
+		return k, err
+	}
+	return k, json.Unmarshal(b, &k)
+}
+
+func (f *FileKeyStore) RewrapKey(ctx context.Context, k DataKey, from int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cur, err := f.read(k.DocID)
+	if err != nil {
+		return err
+	}
+	if cur.KeyVersion != from {
+		return nil
+	}
+	b, err := json.Marshal(k)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(f.dir, "key-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(k.DocID))
+}
@@ -0,0 +1,98 @@
+package docstore
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestEncryptedStore(t *testing.T) (*EncryptedStore, *FileStore, *FileKeyStore) {
+	dir := t.TempDir()
+	inner, err := NewFileStore(filepath.Join(dir, "documents"))
+	require.NoError(t, err)
+	keys, err := NewFileKeyStore(filepath.Join(dir, "keys"))
+	require.NoError(t, err)
+	kms, err := NewLocalKMS(filepath.Join(dir, "kms"))
+	require.NoError(t, err)
+	return NewEncryptedStore(inner, keys, kms), inner, keys
+}
+
+func TestEncryptedStoreAtRest(t *testing.T) {
+	ctx := context.Background()
+	s, inner, _ := newTestEncryptedStore(t)
+
+	d, err := s.Create(ctx, "GopherLab", "secret/salary.doc", "Lotsa Moolah!!", "mleow")
+	require.NoError(t, err)
+	assert.Equal(t, "Lotsa Moolah!!", d.Content)
+	_, err = s.Update(ctx, "secret/salary.doc", "Even more", "mleow", d.ETag)
+	require.NoError(t, err)
+
+	// Nothing readable underneath ..
+	raw, err := inner.Versions(ctx, "secret/salary.doc")
+	require.NoError(t, err)
+	for _, v := range raw {
+		assert.True(t, strings.HasPrefix(v.Content, sealedPrefix))
+		assert.NotContains(t, v.Content, "Moolah")
+	}
+	versions, err := s.Versions(ctx, "secret/salary.doc")
+	require.NoError(t, err)
+	assert.Equal(t, "Lotsa Moolah!!", versions[0].Content)
+	assert.Equal(t, "Even more", versions[1].Content)
+
+	_, err = s.Create(ctx, "", "secret/x.doc", "x", "mleow")
+	assert.ErrorIs(t, err, ErrNoTenant)
+	_, err = s.Create(ctx, "GopherLab", "secret/salary.doc", "again", "bob")
+	assert.ErrorIs(t, err, ErrExists)
+}
+
+func TestEncryptedStoreRotateAndShred(t *testing.T) {
+	ctx := context.Background()
+	s, _, keys := newTestEncryptedStore(t)
+	_, err := s.Create(ctx, "GopherLab", "secret/salary.doc", "Lotsa Moolah!!", "mleow")
+	require.NoError(t, err)
+	_, err = s.Create(ctx, "CrabLab", "secret/crab.doc", "Pincers", "bob")
+	require.NoError(t, err)
+
+	version, err := s.Rotate(ctx, "GopherLab")
+	require.NoError(t, err)
+	assert.Equal(t, 2, version)
+	k, err := keys.GetKey(ctx, "secret/salary.doc")
+	require.NoError(t, err)
+	assert.Equal(t, 1, k.KeyVersion, "re-wrap waits for the next read")
+	d, err := s.Get(ctx, "secret/salary.doc")
+	require.NoError(t, err)
+	assert.Equal(t, "Lotsa Moolah!!", d.Content)
+	k, err = keys.GetKey(ctx, "secret/salary.doc")
+	require.NoError(t, err)
+	assert.Equal(t, 2, k.KeyVersion)
+
+	require.NoError(t, s.Shred(ctx, "GopherLab"))
+	_, err = s.Get(ctx, "secret/salary.doc")
+	assert.ErrorIs(t, err, ErrKeyDestroyed)
+	_, err = s.Create(ctx, "GopherLab", "secret/new.doc", "x", "mleow")
+	assert.ErrorIs(t, err, ErrKeyDestroyed)
+	// Other tenants are untouched ..
+	d, err = s.Get(ctx, "secret/crab.doc")
+	require.NoError(t, err)
+	assert.Equal(t, "Pincers", d.Content)
+}
+
+func TestEncryptedStoreReadsPlaintext(t *testing.T) {
+	ctx := context.Background()
+	s, inner, _ := newTestEncryptedStore(t)
+	// Written before encryption was on ..
+	d, err := inner.Create(ctx, "GopherLab", "public/welcome.doc", "All Open!", "")
+	require.NoError(t, err)
+	got, err := s.Get(ctx, "public/welcome.doc")
+	require.NoError(t, err)
+	assert.Equal(t, "All Open!", got.Content)
+	// First write seals it ..
+	_, err = s.Update(ctx, "public/welcome.doc", "Still open", "bob", d.ETag)
+	require.NoError(t, err)
+	raw, err := inner.Get(ctx, "public/welcome.doc")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(raw.Content, sealedPrefix))
+}
@@ -0,0 +1,257 @@
+package docstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileStore is for dev; a directory per document with a header file and one
+// file per version. Versions are created exclusively and never rewritten ..
+// <root>/<escaped id>/document.json
+// <root>/<escaped id>/v000001.json
+type FileStore struct {
+	root string
+	// One process only; serialises read-modify-write of the header ..
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// NewFileStore uses root; created if missing ..
+func NewFileStore(root string) (*FileStore, error) {
+	if err := os.MkdirAll(root, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{root: root, now: time.Now}, nil
+}
+
+// fileHeader is document.json; content is only in the versions ..
+type fileHeader struct {
+	ID        string
+	OrgID     string
+	Version   int
+	CreatedBy string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedBy string
+	DeletedAt time.Time
+}
+
+// dir; IDs have slashes so escape them into one path segment ..
+func (s *FileStore) dir(id string) string {
+	return filepath.Join(s.root, url.PathEscape(id))
+}
+
+func versionFile(version int) string {
+	return fmt.Sprintf("v%06d.json", version)
+}
+
+func (s *FileStore) readHeader(id string) (fileHeader, error) {
+	var h fileHeader
+	b, err := os.ReadFile(filepath.Join(s.dir(id), "document.json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return h, ErrNotFound
+	}
+	if err != nil {
+		return h, err
+	}
+	return h, json.Unmarshal(b, &h)
+}
+
+// writeHeader replaces it in one go; never half written ..
+func (s *FileStore) writeHeader(h fileHeader) error {
+	b, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir(h.ID), "document-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(s.dir(h.ID), "document.json"))
+}
+
+func (s *FileStore) readVersion(id string, version int) (Version, error) {
+	var v Version
+	b, err := os.ReadFile(filepath.Join(s.dir(id), versionFile(version)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return v, ErrNotFound
+	}
+	if err != nil {
+		return v, err
+	}
+	return v, json.Unmarshal(b, &v)
+}
+
+// writeVersion fails if that version is already there ..
+func (s *FileStore) writeVersion(v Version) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir(v.DocID), versionFile(v.Version)), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *FileStore) document(h fileHeader) (Document, error) {
+	v, err := s.readVersion(h.ID, h.Version)
+	if err != nil {
+		return Document{}, err
+	}
+	d := Document{
+		ID:        h.ID,
+		OrgID:     h.OrgID,
+		Content:   v.Content,
+		Version:   v.Version,
+		ETag:      v.ETag,
+		Author:    v.Author,
+		CreatedBy: h.CreatedBy,
+		CreatedAt: h.CreatedAt,
+		UpdatedAt: h.UpdatedAt,
+		DeletedBy: h.DeletedBy,
+		DeletedAt: h.DeletedAt,
+	}
+	if d.Deleted() {
+		return d, ErrDeleted
+	}
+	return d, nil
+}
+
+func (s *FileStore) Create(ctx context.Context, orgID, id, content, author string) (Document, error) {
+	if id == "" {
+		return Document{}, ErrNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Mkdir(s.dir(id), 0o700); errors.Is(err, fs.ErrExist) {
+		return Document{}, ErrExists
+	} else if err != nil {
+		return Document{}, err
+	}
+	now := s.now().UTC()
+	v := Version{DocID: id, Version: 1, ETag: ETag(id, 1, content), Content: content, Author: author, CreatedAt: now}
+	if err := s.writeVersion(v); err != nil {
+		return Document{}, err
+	}
+	h := fileHeader{ID: id, OrgID: orgID, Version: 1, CreatedBy: author, CreatedAt: now, UpdatedAt: now}
+	if err := s.writeHeader(h); err != nil {
+		return Document{}, err
+	}
+	return s.document(h)
+}
+
+func (s *FileStore) Get(ctx context.Context, id string) (Document, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, err := s.readHeader(id)
+	if err != nil {
+		return Document{}, err
+	}
+	return s.document(h)
+}
+
+func (s *FileStore) Update(ctx context.Context, id, content, author, ifMatch string) (Document, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, err := s.readHeader(id)
+	if err != nil {
+		return Document{}, err
+	}
+	cur, err := s.document(h)
+	if err != nil {
+		return cur, err
+	}
+	if !etagMatches(ifMatch, cur.ETag) {
+		return cur, ErrPrecondition
+	}
+	now := s.now().UTC()
+	v := Version{DocID: id, Version: h.Version + 1, Content: content, Author: author, CreatedAt: now}
+	v.ETag = ETag(id, v.Version, content)
+	if err := s.writeVersion(v); err != nil {
+		return Document{}, err
+	}
+	h.Version = v.Version
+	h.UpdatedAt = now
+	if err := s.writeHeader(h); err != nil {
+		return Document{}, err
+	}
+	return s.document(h)
+}
+
+func (s *FileStore) Delete(ctx context.Context, id, author, ifMatch string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, err := s.readHeader(id)
+	if err != nil {
+		return err
+	}
+	cur, err := s.document(h)
+	if err != nil {
+		return err
+	}
+	if !etagMatches(ifMatch, cur.ETag) {
+		return ErrPrecondition
+	}
+	h.DeletedBy = author
+	h.DeletedAt = s.now().UTC()
+	return s.writeHeader(h)
+}
+
+func (s *FileStore) Versions(ctx context.Context, id string) ([]Version, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := os.ReadDir(s.dir(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []Version
+	for _, e := range entries {
+		var n int
+		if !strings.HasPrefix(e.Name(), "v") {
+			continue
+		}
+		if _, err := fmt.Sscanf(e.Name(), "v%06d.json", &n); err != nil {
+			continue
+		}
+		v, err := s.readVersion(id, n)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+func (s *FileStore) Version(ctx context.Context, id string, version int) (Version, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readVersion(id, version)
+}
@@ -0,0 +1,82 @@
+package docstore
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func newTestFileStore(t *testing.T) (*FileStore, *time.Time) {
+	s, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	now := time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func TestFileStoreVersions(t *testing.T) {
+	ctx := context.Background()
+	s, now := newTestFileStore(t)
+
+	_, err := s.Get(ctx, "secret/salary.doc")
+	assert.ErrorIs(t, err, ErrNotFound)
+	d, err := s.Create(ctx, "GopherLab", "secret/salary.doc", "v1", "mleow")
+	require.NoError(t, err)
+	assert.Equal(t, 1, d.Version)
+	assert.Equal(t, "mleow", d.Author)
+	_, err = s.Create(ctx, "GopherLab", "secret/salary.doc", "again", "bob")
+	assert.ErrorIs(t, err, ErrExists)
+
+	// Two editors read v1; the second to write loses ..
+	*now = now.Add(time.Minute)
+	d2, err := s.Update(ctx, "secret/salary.doc", "v2", "bob", d.ETag)
+	require.NoError(t, err)
+	assert.Equal(t, 2, d2.Version)
+	assert.NotEqual(t, d.ETag, d2.ETag)
+	assert.Equal(t, "mleow", d2.CreatedBy)
+	assert.Equal(t, "bob", d2.Author)
+	_, err = s.Update(ctx, "secret/salary.doc", "lost", "alice", d.ETag)
+	assert.ErrorIs(t, err, ErrPrecondition)
+	// Same content back is still a new version + ETag ..
+	d3, err := s.Update(ctx, "secret/salary.doc", "v1", "mleow", "")
+	require.NoError(t, err)
+	assert.NotEqual(t, d.ETag, d3.ETag)
+
+	got, err := s.Get(ctx, "secret/salary.doc")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", got.Content)
+	assert.Equal(t, 3, got.Version)
+
+	versions, err := s.Versions(ctx, "secret/salary.doc")
+	require.NoError(t, err)
+	if assert.Len(t, versions, 3) {
+		assert.Equal(t, []string{"mleow", "bob", "mleow"}, []string{versions[0].Author, versions[1].Author, versions[2].Author})
+		assert.Equal(t, "v2", versions[1].Content)
+	}
+	v, err := s.Version(ctx, "secret/salary.doc", 2)
+	require.NoError(t, err)
+	assert.Equal(t, d2.ETag, v.ETag)
+}
+
+func TestFileStoreSoftDelete(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestFileStore(t)
+	d, err := s.Create(ctx, "GopherLab", "public/plan.doc", "plan", "bob")
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, s.Delete(ctx, "public/plan.doc", "bob", `"stale"`), ErrPrecondition)
+	require.NoError(t, s.Delete(ctx, "public/plan.doc", "bob", d.ETag))
+	got, err := s.Get(ctx, "public/plan.doc")
+	assert.ErrorIs(t, err, ErrDeleted)
+	assert.Equal(t, "bob", got.DeletedBy)
+	_, err = s.Update(ctx, "public/plan.doc", "more", "bob", "")
+	assert.ErrorIs(t, err, ErrDeleted)
+	// IDs are not reused; history is still there ..
+	_, err = s.Create(ctx, "GopherLab", "public/plan.doc", "new", "bob")
+	assert.ErrorIs(t, err, ErrExists)
+	versions, err := s.Versions(ctx, "public/plan.doc")
+	require.NoError(t, err)
+	assert.Len(t, versions, 1)
+}
@@ -0,0 +1,214 @@
+package docstore
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrKeyDestroyed is a tenant that has been crypto-shredded; nothing
+	// under its key can be read again ..
+	ErrKeyDestroyed = errors.New("tenant key destroyed")
+	ErrNoTenant     = errors.New("tenant is required")
+)
+
+// KMS holds a key-encryption key (KEK) per tenant; it only ever wraps +
+// unwraps data keys, the KEKs never leave it. Versions start at 1; old
+// versions still unwrap until the tenant is destroyed ..
+type KMS interface {
+	// Wrap with the tenant's current KEK; made on first use ..
+	Wrap(ctx context.Context, tenant string, key []byte) (version int, wrapped []byte, err error)
+	Unwrap(ctx context.Context, tenant string, version int, wrapped []byte) ([]byte, error)
+	// Current KEK version; 0 if the tenant has none yet ..
+	Current(ctx context.Context, tenant string) (int, error)
+	// Rotate makes a new current KEK; data keys move over lazily ..
+	Rotate(ctx context.Context, tenant string) (int, error)
+	// Destroy drops every KEK version of the tenant for good ..
+	Destroy(ctx context.Context, tenant string) error
+}
+
+// LocalKMS is for dev; a file of raw KEKs per tenant. Anyone who can read
+// the directory can read every document ..
+type LocalKMS struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewLocalKMS keeps tenant keys under dir; created if missing ..
+func NewLocalKMS(dir string) (*LocalKMS, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &LocalKMS{dir: dir}, nil
+}
+
+// tenantKeys is the file of one tenant; Keys by version ..
+type tenantKeys struct {
+	Current   int
+	Keys      map[string][]byte
+	Destroyed time.Time
+}
+
+func (k *LocalKMS) path(tenant string) string {
+	return filepath.Join(k.dir, url.PathEscape(tenant)+".json")
+}
+
+func (k *LocalKMS) load(tenant string) (tenantKeys, error) {
+	var t tenantKeys
+	if tenant == "" {
+		return t, ErrNoTenant
+	}
+	b, err := os.ReadFile(k.path(tenant))
+	if errors.Is(err, fs.ErrNotExist) {
+		return tenantKeys{Keys: map[string][]byte{}}, nil
+	}
+	if err != nil {
+		return t, err
+	}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return t, err
+	}
+	if !t.Destroyed.IsZero() {
+		return t, ErrKeyDestroyed
+	}
+	return t, nil
+}
+
+func (k *LocalKMS) save(tenant string, t tenantKeys) error {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(k.dir, "kek-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), k.path(tenant))
+}
+
+// rotate adds a fresh KEK as the current version ..
+func (k *LocalKMS) rotate(tenant string, t tenantKeys) (tenantKeys, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return t, err
+	}
+	t.Current++
+	t.Keys[strconv.Itoa(t.Current)] = key
+	return t, k.save(tenant, t)
+}
+
+// kekAAD ties a wrapped key to the tenant + version it was wrapped for ..
+func kekAAD(tenant string, version int) []byte {
+	return []byte(tenant + "\x00" + strconv.Itoa(version))
+}
+
+func (k *LocalKMS) Wrap(ctx context.Context, tenant string, key []byte) (int, []byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	t, err := k.load(tenant)
+	if err != nil {
+		return 0, nil, err
+	}
+	if t.Current == 0 {
+		if t, err = k.rotate(tenant, t); err != nil {
+			return 0, nil, err
+		}
+	}
+	wrapped, err := seal(t.Keys[strconv.Itoa(t.Current)], key, kekAAD(tenant, t.Current))
+	return t.Current, wrapped, err
+}
+
+func (k *LocalKMS) Unwrap(ctx context.Context, tenant string, version int, wrapped []byte) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	t, err := k.load(tenant)
+	if err != nil {
+		return nil, err
+	}
+	kek, ok := t.Keys[strconv.Itoa(version)]
+	if !ok {
+		return nil, fmt.Errorf("tenant %s has no key version %d", tenant, version)
+	}
+	return open(kek, wrapped, kekAAD(tenant, version))
+}
+
+func (k *LocalKMS) Current(ctx context.Context, tenant string) (int, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	t, err := k.load(tenant)
+	return t.Current, err
+}
+
+func (k *LocalKMS) Rotate(ctx context.Context, tenant string) (int, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	t, err := k.load(tenant)
+	if err != nil {
+		return 0, err
+	}
+	t, err = k.rotate(tenant, t)
+	return t.Current, err
+}
+
+// Destroy leaves a marker so the tenant does not quietly get a new KEK ..
+func (k *LocalKMS) Destroy(ctx context.Context, tenant string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, err := k.load(tenant); err != nil {
+		return err
+	}
+	return k.save(tenant, tenantKeys{Destroyed: time.Now().UTC()})
+}
+
+// seal is AES-256-GCM; the nonce goes in front ..
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(key, sealed, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed data too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
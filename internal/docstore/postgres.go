@@ -0,0 +1,240 @@
+package docstore
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+// PostgresStore keeps a row per document and a row per version; versions
+// are only ever inserted. Writers lock the document row so versions stay
+// in order ..
+type PostgresStore struct {
+	pool *pgxpool.Pool
+	now  func() time.Time
+}
+
+// NewPostgresStore creates documents + document_versions if needed ..
+func NewPostgresStore(ctx context.Context, pool *pgxpool.Pool) (*PostgresStore, error) {
+	for _, ddl := range []string{
+		`CREATE TABLE IF NOT EXISTS documents (
+			id         TEXT PRIMARY KEY,
+			org_id     TEXT NOT NULL,
+			version    INTEGER NOT NULL,
+			created_by TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL,
+			deleted_by TEXT NOT NULL DEFAULT '',
+			deleted_at TIMESTAMPTZ
+		)`,
+		`CREATE TABLE IF NOT EXISTS document_versions (
+			doc_id     TEXT NOT NULL REFERENCES documents (id),
+			version    INTEGER NOT NULL,
+			etag       TEXT NOT NULL,
+			content    TEXT NOT NULL,
+			author     TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (doc_id, version)
+		)`,
+	} {
+		if _, err := pool.Exec(ctx, ddl); err != nil {
+			return nil, err
+		}
+	}
+	return &PostgresStore{pool: pool, now: time.Now}, nil
+}
+
+const pgUniqueViolation = "23505"
+
+func mapPGError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+		return ErrExists
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+const documentSelect = `SELECT d.id, d.org_id, v.content, d.version, v.etag, v.author,
+	d.created_by, d.created_at, d.updated_at, d.deleted_by, d.deleted_at
+	FROM documents d JOIN document_versions v ON v.doc_id = d.id AND v.version = d.version
+	WHERE d.id = $1`
+
+func scanDocument(row pgx.Row) (Document, error) {
+	var d Document
+	var deletedAt *time.Time
+	err := row.Scan(&d.ID, &d.OrgID, &d.Content, &d.Version, &d.ETag, &d.Author,
+		&d.CreatedBy, &d.CreatedAt, &d.UpdatedAt, &d.DeletedBy, &deletedAt)
+	if err != nil {
+		return d, mapPGError(err)
+	}
+	if deletedAt != nil {
+		d.DeletedAt = *deletedAt
+		return d, ErrDeleted
+	}
+	return d, nil
+}
+
+func (p *PostgresStore) Create(ctx context.Context, orgID, id, content, author string) (Document, error) {
+	if id == "" {
+		return Document{}, ErrNotFound
+	}
+	now := p.now().UTC()
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return Document{}, err
+	}
+	defer tx.Rollback(ctx)
+	_, err = tx.Exec(ctx, `INSERT INTO documents (id, org_id, version, created_by, created_at, updated_at)
+		VALUES ($1, $2, 1, $3, $4, $4)`, id, orgID, author, now)
+	if err != nil {
+		return Document{}, mapPGError(err)
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO document_versions (doc_id, version, etag, content, author, created_at)
+		VALUES ($1, 1, $2, $3, $4, $5)`, id, ETag(id, 1, content), content, author, now)
+	if err != nil {
+		return Document{}, mapPGError(err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Document{}, err
+	}
+	return p.Get(ctx, id)
+}
+
+func (p *PostgresStore) Get(ctx context.Context, id string) (Document, error) {
+	return scanDocument(p.pool.QueryRow(ctx, documentSelect, id))
+}
+
+// locked is the current document with its row locked for the tx ..
+func locked(ctx context.Context, tx pgx.Tx, id, ifMatch string) (Document, error) {
+	d, err := scanDocument(tx.QueryRow(ctx, documentSelect+` FOR UPDATE OF d`, id))
+	if err != nil {
+		return d, err
+	}
+	if !etagMatches(ifMatch, d.ETag) {
+		return d, ErrPrecondition
+	}
+	return d, nil
+}
+
+func (p *PostgresStore) Update(ctx context.Context, id, content, author, ifMatch string) (Document, error) {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return Document{}, err
+	}
+	defer tx.Rollback(ctx)
+	cur, err := locked(ctx, tx, id, ifMatch)
+	if err != nil {
+		return cur, err
+	}
+	now := p.now().UTC()
+	next := cur.Version + 1
+	_, err = tx.Exec(ctx, `INSERT INTO document_versions (doc_id, version, etag, content, author, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`, id, next, ETag(id, next, content), content, author, now)
+	if err != nil {
+		return Document{}, mapPGError(err)
+	}
+	if _, err := tx.Exec(ctx, `UPDATE documents SET version = $2, updated_at = $3 WHERE id = $1`, id, next, now); err != nil {
+		return Document{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Document{}, err
+	}
+	return p.Get(ctx, id)
+}
+
+func (p *PostgresStore) Delete(ctx context.Context, id, author, ifMatch string) error {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := locked(ctx, tx, id, ifMatch); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `UPDATE documents SET deleted_by = $2, deleted_at = $3 WHERE id = $1`, id, author, p.now().UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+const versionColumns = `doc_id, version, etag, content, author, created_at`
+
+func scanVersion(row pgx.Row) (Version, error) {
+	var v Version
+	err := row.Scan(&v.DocID, &v.Version, &v.ETag, &v.Content, &v.Author, &v.CreatedAt)
+	return v, mapPGError(err)
+}
+
+func (p *PostgresStore) Versions(ctx context.Context, id string) ([]Version, error) {
+	rows, err := p.pool.Query(ctx, `SELECT `+versionColumns+` FROM document_versions WHERE doc_id = $1 ORDER BY version`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Version
+	for rows.Next() {
+		v, err := scanVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, ErrNotFound
+	}
+	return out, nil
+}
+
+func (p *PostgresStore) Version(ctx context.Context, id string, version int) (Version, error) {
+	return scanVersion(p.pool.QueryRow(ctx, `SELECT `+versionColumns+` FROM document_versions
+		WHERE doc_id = $1 AND version = $2`, id, version))
+}
+
+// PostgresKeyStore keeps the wrapped DEKs in document_keys ..
+type PostgresKeyStore struct {
+	pool *pgxpool.Pool
+}
+
+// NewPostgresKeyStore creates document_keys if needed; no reference to
+// documents as the key goes in before its document does ..
+func NewPostgresKeyStore(ctx context.Context, pool *pgxpool.Pool) (*PostgresKeyStore, error) {
+	_, err := pool.Exec(ctx, `CREATE TABLE IF NOT EXISTS document_keys (
+		doc_id      TEXT PRIMARY KEY,
+		org_id      TEXT NOT NULL,
+		key_version INTEGER NOT NULL,
+		wrapped     BYTEA NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresKeyStore{pool: pool}, nil
+}
+
+func (p *PostgresKeyStore) CreateKey(ctx context.Context, k DataKey) error {
+	_, err := p.pool.Exec(ctx, `INSERT INTO document_keys (doc_id, org_id, key_version, wrapped)
+		VALUES ($1, $2, $3, $4)`, k.DocID, k.OrgID, k.KeyVersion, k.Wrapped)
+	return mapPGError(err)
+}
+
+func (p *PostgresKeyStore) GetKey(ctx context.Context, docID string) (DataKey, error) {
+	var k DataKey
+	err := p.pool.QueryRow(ctx, `SELECT doc_id, org_id, key_version, wrapped FROM document_keys
+		WHERE doc_id = $1`, docID).Scan(&k.DocID, &k.OrgID, &k.KeyVersion, &k.Wrapped)
+	return k, mapPGError(err)
+}
+
+func (p *PostgresKeyStore) RewrapKey(ctx context.Context, k DataKey, from int) error {
+	_, err := p.pool.Exec(ctx, `UPDATE document_keys SET key_version = $2, wrapped = $3
+		WHERE doc_id = $1 AND key_version = $4`, k.DocID, k.KeyVersion, k.Wrapped, from)
+	return err
+}
@@ -0,0 +1,345 @@
+package identity
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes an API key can carry ..
+const (
+	ScopeAuthzCheck    = "authz:check"
+	ScopeAuthzGrant    = "authz:grant"
+	ScopeBatchExecute  = "batch:execute"
+	ScopeSCIMProvision = "scim:provision"
+	// Document API; read is list + get, write the rest ..
+	ScopeDocumentsRead  = "documents:read"
+	ScopeDocumentsWrite = "documents:write"
+)
+
+// Every key starts with this so leaked ones are easy to grep / scan for ..
+const APIKeyPrefix = "gek_"
+
+// ErrInvalidAPIKey is unknown, revoked, expired or just wrong ..
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// Don't write last used on every request ..
+const apiKeyTouchInterval = time.Minute
+
+// APIKey is the stored record; the secret itself is never kept ..
+type APIKey struct {
+	ID         string
+	TenantID   string
+	Name       string
+	Scopes     []string
+	Hash       string // sha256 of the secret, hex ..
+	CreatedBy  string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time // Zero is never; set when rotated out ..
+	RevokedAt  time.Time
+	LastUsedAt time.Time
+	// RotatedFrom is the key this one replaced ..
+	RotatedFrom string
+}
+
+// Active is not revoked and not past its expiry ..
+func (k APIKey) Active(now time.Time) bool {
+	return k.RevokedAt.IsZero() && (k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt))
+}
+
+// Principal is who is calling; a logged in user or a service behind an API key ..
+type Principal struct {
+	TenantID string
+	// ID is what goes in tuples + workflow commands; service:<keyID> for keys ..
+	ID     string
+	Kind   string // user or service
+	Scopes []string
+	// Impersonator is the support user really behind ID, if any ..
+	Impersonator string
+	// IssuedBy is the user who issued (or last rotated) a key; what the key
+	// may delegate is checked against what they still hold ..
+	IssuedBy string
+}
+
+// HasScope; users are not scoped ..
+func (p Principal) HasScope(scope string) bool {
+	if p.Kind == "user" {
+		return true
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type principalCtxKey struct{}
+
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalCtxKey{}, p)
+}
+
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalCtxKey{}).(Principal)
+	return p, ok
+}
+
+// APIKeyManager issues and checks tenant API keys kept in a Store.
+// Token format: gek_<keyID>_<secret>; the keyID finds the record ..
+type APIKeyManager struct {
+	store Store
+	// Serialises the per tenant index updates ..
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+func NewAPIKeyManager(store Store) *APIKeyManager {
+	return &APIKeyManager{store: store, now: time.Now}
+}
+
+func apiKeyRecord(id string) string {
+	return "apikey:" + id
+}
+
+func apiKeyIndex(tenant string) string {
+	return "apikeys:" + tenant
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func newKeyID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// parseToken splits out the key ID; the secret may itself have '_' in it ..
+func parseToken(token string) (id, secret string, ok bool) {
+	rest, found := strings.CutPrefix(token, APIKeyPrefix)
+	if !found {
+		return "", "", false
+	}
+	id, secret, ok = strings.Cut(rest, "_")
+	return id, secret, ok && id != "" && secret != ""
+}
+
+func (m *APIKeyManager) load(ctx context.Context, id string) (APIKey, error) {
+	var k APIKey
+	b, err := m.store.Get(ctx, apiKeyRecord(id))
+	if errors.Is(err, ErrNotFound) {
+		return k, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return k, err
+	}
+	err = json.Unmarshal(b, &k)
+	return k, err
+}
+
+func (m *APIKeyManager) save(ctx context.Context, k APIKey) error {
+	b, err := json.Marshal(k)
+	if err != nil {
+		return err
+	}
+	return m.store.Set(ctx, apiKeyRecord(k.ID), b, 0)
+}
+
+func (m *APIKeyManager) index(ctx context.Context, tenant string) ([]string, error) {
+	var ids []string
+	b, err := m.store.Get(ctx, apiKeyIndex(tenant))
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &ids)
+	return ids, err
+}
+
+func (m *APIKeyManager) addToIndex(ctx context.Context, tenant, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids, err := m.index(ctx, tenant)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(append(ids, id))
+	if err != nil {
+		return err
+	}
+	return m.store.Set(ctx, apiKeyIndex(tenant), b, 0)
+}
+
+// Issue makes a new key; the token is only ever returned here ..
+func (m *APIKeyManager) Issue(ctx context.Context, tenant, name, createdBy string, scopes []string) (string, APIKey, error) {
+	if tenant == "" {
+		return "", APIKey{}, fmt.Errorf("api key needs a tenant")
+	}
+	secret := randomString(32)
+	k := APIKey{
+		ID:        newKeyID(),
+		TenantID:  tenant,
+		Name:      name,
+		Scopes:    append([]string(nil), scopes...),
+		Hash:      hashSecret(secret),
+		CreatedBy: createdBy,
+		CreatedAt: m.now(),
+	}
+	sort.Strings(k.Scopes)
+	if err := m.save(ctx, k); err != nil {
+		return "", APIKey{}, err
+	}
+	if err := m.addToIndex(ctx, tenant, k.ID); err != nil {
+		return "", APIKey{}, err
+	}
+	return APIKeyPrefix + k.ID + "_" + secret, k, nil
+}
+
+// Rotate issues a replacement with the same scopes; the old key keeps
+// working for grace so clients can roll over (zero revokes it now) ..
+func (m *APIKeyManager) Rotate(ctx context.Context, tenant, id, actor string, grace time.Duration) (string, APIKey, error) {
+	old, err := m.Get(ctx, tenant, id)
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	if !old.Active(m.now()) {
+		return "", APIKey{}, ErrInvalidAPIKey
+	}
+	token, k, err := m.Issue(ctx, old.TenantID, old.Name, actor, old.Scopes)
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	k.RotatedFrom = old.ID
+	if err := m.save(ctx, k); err != nil {
+		return "", APIKey{}, err
+	}
+	if grace <= 0 {
+		old.RevokedAt = m.now()
+	} else if until := m.now().Add(grace); old.ExpiresAt.IsZero() || until.Before(old.ExpiresAt) {
+		old.ExpiresAt = until
+	}
+	if err := m.save(ctx, old); err != nil {
+		return "", APIKey{}, err
+	}
+	return token, k, nil
+}
+
+// Revoke takes effect on the next request ..
+func (m *APIKeyManager) Revoke(ctx context.Context, tenant, id string) error {
+	k, err := m.Get(ctx, tenant, id)
+	if err != nil {
+		return err
+	}
+	if k.RevokedAt.IsZero() {
+		k.RevokedAt = m.now()
+	}
+	return m.save(ctx, k)
+}
+
+// Get only finds keys in the tenant asked about ..
+func (m *APIKeyManager) Get(ctx context.Context, tenant, id string) (APIKey, error) {
+	k, err := m.load(ctx, id)
+	if err != nil {
+		return APIKey{}, err
+	}
+	if k.TenantID != tenant {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+	return k, nil
+}
+
+// List is every key the tenant ever had; newest first ..
+func (m *APIKeyManager) List(ctx context.Context, tenant string) ([]APIKey, error) {
+	ids, err := m.index(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]APIKey, 0, len(ids))
+	for _, id := range ids {
+		k, err := m.load(ctx, id)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+// Authenticate turns a bearer token into the service principal behind it ..
+func (m *APIKeyManager) Authenticate(ctx context.Context, token string) (Principal, error) {
+	id, secret, ok := parseToken(token)
+	if !ok {
+		return Principal{}, ErrInvalidAPIKey
+	}
+	k, err := m.load(ctx, id)
+	if err != nil {
+		return Principal{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(k.Hash)) != 1 {
+		return Principal{}, ErrInvalidAPIKey
+	}
+	now := m.now()
+	if !k.Active(now) {
+		return Principal{}, ErrInvalidAPIKey
+	}
+	if now.Sub(k.LastUsedAt) > apiKeyTouchInterval {
+		k.LastUsedAt = now
+		// Best effort; a failed write should not fail the call ..
+		_ = m.save(ctx, k)
+	}
+	return Principal{
+		TenantID: k.TenantID,
+		ID:       "service:" + k.ID,
+		Kind:     "service",
+		Scopes:   k.Scopes,
+		IssuedBy: k.CreatedBy,
+	}, nil
+}
+
+// Require needs a bearer API key carrying scope ..
+func (m *APIKeyManager) Require(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			http.Error(w, "missing bearer token", http.StatusUnauthorized)
+			return
+		}
+		p, err := m.Authenticate(r.Context(), strings.TrimSpace(token))
+		if err != nil {
+			if !errors.Is(err, ErrInvalidAPIKey) {
+				http.Error(w, "key store unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+			http.Error(w, "invalid api key", http.StatusUnauthorized)
+			return
+		}
+		if !p.HasScope(scope) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="insufficient_scope", scope="`+scope+`"`)
+			http.Error(w, "missing scope "+scope, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+	})
+}
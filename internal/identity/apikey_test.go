@@ -0,0 +1,153 @@
+package identity
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/api/common/v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAPIKeys() (*APIKeyManager, *sessionClock) {
+	clock := &sessionClock{t: time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore()
+	store.now = clock.now
+	m := NewAPIKeyManager(store)
+	m.now = clock.now
+	return m, clock
+}
+
+func TestAPIKeyIssueAndAuthenticate(t *testing.T) {
+	m, clock := newTestAPIKeys()
+	ctx := context.Background()
+
+	token, key, err := m.Issue(ctx, "GopherLab", "ci", "mleow", []string{ScopeBatchExecute, ScopeAuthzCheck})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(token, APIKeyPrefix+key.ID+"_"))
+	assert.NotContains(t, key.Hash, strings.TrimPrefix(token, APIKeyPrefix+key.ID+"_"))
+
+	p, err := m.Authenticate(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, "GopherLab", p.TenantID)
+	assert.Equal(t, "service:"+key.ID, p.ID)
+	assert.Equal(t, "mleow", p.IssuedBy)
+	assert.True(t, p.HasScope(ScopeAuthzCheck))
+	assert.False(t, p.HasScope(ScopeAuthzGrant))
+
+	got, err := m.Get(ctx, "GopherLab", key.ID)
+	require.NoError(t, err)
+	assert.Equal(t, clock.t, got.LastUsedAt)
+
+	// Right ID, wrong secret ..
+	_, err = m.Authenticate(ctx, APIKeyPrefix+key.ID+"_nope")
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+	_, err = m.Authenticate(ctx, "not-a-key")
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+
+	// Other tenants can not see or revoke it ..
+	_, err = m.Get(ctx, "CrabLab", key.ID)
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+	assert.Error(t, m.Revoke(ctx, "CrabLab", key.ID))
+	keys, err := m.List(ctx, "CrabLab")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	require.NoError(t, m.Revoke(ctx, "GopherLab", key.ID))
+	_, err = m.Authenticate(ctx, token)
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+}
+
+func TestAPIKeyRotateWithGrace(t *testing.T) {
+	m, clock := newTestAPIKeys()
+	ctx := context.Background()
+	oldToken, old, err := m.Issue(ctx, "GopherLab", "ci", "mleow", []string{ScopeAuthzGrant})
+	require.NoError(t, err)
+
+	clock.t = clock.t.Add(time.Hour)
+	newToken, rotated, err := m.Rotate(ctx, "GopherLab", old.ID, "bob", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, old.ID, rotated.RotatedFrom)
+	assert.Equal(t, []string{ScopeAuthzGrant}, rotated.Scopes)
+
+	// Both work during the grace period ..
+	_, err = m.Authenticate(ctx, oldToken)
+	assert.NoError(t, err)
+	_, err = m.Authenticate(ctx, newToken)
+	assert.NoError(t, err)
+
+	clock.t = clock.t.Add(time.Hour + time.Second)
+	_, err = m.Authenticate(ctx, oldToken)
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+	_, err = m.Authenticate(ctx, newToken)
+	assert.NoError(t, err)
+
+	keys, err := m.List(ctx, "GopherLab")
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, rotated.ID, keys[0].ID)
+}
+
+func TestAPIKeyRequireScope(t *testing.T) {
+	m, _ := newTestAPIKeys()
+	token, _, err := m.Issue(context.Background(), "GopherLab", "ci", "mleow", []string{ScopeAuthzCheck})
+	require.NoError(t, err)
+
+	var seen Principal
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = PrincipalFrom(r.Context())
+	})
+	call := func(h http.Handler, auth string) int {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/check", nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusUnauthorized, call(m.Require(ScopeAuthzCheck, ok), ""))
+	assert.Equal(t, http.StatusUnauthorized, call(m.Require(ScopeAuthzCheck, ok), "Bearer gek_0_bad"))
+	assert.Equal(t, http.StatusForbidden, call(m.Require(ScopeAuthzGrant, ok), "Bearer "+token))
+	assert.Equal(t, http.StatusOK, call(m.Require(ScopeAuthzCheck, ok), "Bearer "+token))
+	assert.Equal(t, "GopherLab", seen.TenantID)
+}
+
+type testHeader map[string]*common.Payload
+
+func (h testHeader) Set(key string, value *common.Payload) { h[key] = value }
+
+func (h testHeader) Get(key string) (*common.Payload, bool) {
+	p, ok := h[key]
+	return p, ok
+}
+
+func (h testHeader) ForEachKey(handler func(string, *common.Payload) error) error {
+	for k, v := range h {
+		if err := handler(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestPrincipalPropagator(t *testing.T) {
+	prop := PrincipalPropagator{}
+	want := Principal{TenantID: "GopherLab", ID: "service:abc", Kind: "service", Scopes: []string{ScopeAuthzGrant}}
+
+	h := testHeader{}
+	require.NoError(t, prop.Inject(WithPrincipal(context.Background(), want), h))
+	ctx, err := prop.Extract(context.Background(), h)
+	require.NoError(t, err)
+	got, ok := PrincipalFrom(ctx)
+	require.True(t, ok)
+	assert.Equal(t, want, got)
+
+	// Nothing to carry; nothing set ..
+	empty := testHeader{}
+	require.NoError(t, prop.Inject(context.Background(), empty))
+	assert.Empty(t, empty)
+}
@@ -0,0 +1,37 @@
+package identity
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParsePrefixes("10.0.0.0/8, 127.0.0.1")
+	require.NoError(t, err)
+	_, err = ParsePrefixes("10.0.0.0/33")
+	assert.Error(t, err)
+
+	for _, tc := range []struct {
+		name, remote string
+		xff          []string
+		want         string
+	}{
+		{"direct", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"untrusted peer can't claim an address", "203.0.113.7:5000", []string{"10.1.1.1"}, "203.0.113.7"},
+		{"behind proxy", "10.0.0.2:5000", []string{"198.51.100.9"}, "198.51.100.9"},
+		{"spoofed left of real client", "10.0.0.2:5000", []string{"1.2.3.4, 198.51.100.9, 10.0.0.3"}, "198.51.100.9"},
+		{"header per hop", "127.0.0.1:5000", []string{"1.2.3.4", "198.51.100.9"}, "198.51.100.9"},
+		{"junk in chain", "10.0.0.2:5000", []string{"nope, 10.0.0.3"}, "10.0.0.3"},
+		{"mapped v4", "[::ffff:203.0.113.7]:5000", nil, "203.0.113.7"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tc.remote
+		for _, h := range tc.xff {
+			r.Header.Add("X-Forwarded-For", h)
+		}
+		assert.Equal(t, tc.want, ClientIP(r, trusted).String(), tc.name)
+	}
+}
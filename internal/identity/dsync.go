@@ -0,0 +1,209 @@
+package identity
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Directory Sync events we act on (WorkOS names) ..
+const (
+	DirectoryUserCreated      = "dsync.user.created"
+	DirectoryUserUpdated      = "dsync.user.updated"
+	DirectoryUserDeleted      = "dsync.user.deleted"
+	DirectoryGroupUserAdded   = "dsync.group.user_added"
+	DirectoryGroupUserRemoved = "dsync.group.user_removed"
+)
+
+// DirectorySignatureHeader carries t=<unix ms>, v1=<hex hmac> ..
+const DirectorySignatureHeader = "WorkOS-Signature"
+
+// Webhooks older (or newer) than this are replays ..
+const defaultWebhookTolerance = 3 * time.Minute
+
+// Directory webhook bodies are small ..
+const maxWebhookBody = 1 << 20
+
+// ErrInvalidSignature is a webhook that is unsigned, badly signed or stale ..
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// DirectoryEvent is one webhook delivery; Data depends on Event ..
+type DirectoryEvent struct {
+	ID        string          `json:"id"`
+	Event     string          `json:"event"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type DirectoryEmail struct {
+	Primary bool   `json:"primary"`
+	Type    string `json:"type"`
+	Value   string `json:"value"`
+}
+
+// DirectoryUser is the data of the dsync.user.* events ..
+type DirectoryUser struct {
+	ID               string                 `json:"id"`
+	DirectoryID      string                 `json:"directory_id"`
+	OrganizationID   string                 `json:"organization_id"`
+	IdPID            string                 `json:"idp_id"`
+	Username         string                 `json:"username"`
+	FirstName        string                 `json:"first_name"`
+	LastName         string                 `json:"last_name"`
+	Emails           []DirectoryEmail       `json:"emails"`
+	State            string                 `json:"state"` // active, inactive, suspended
+	CustomAttributes map[string]interface{} `json:"custom_attributes"`
+}
+
+// DirectoryGroupMembership is the data of dsync.group.user_* ..
+type DirectoryGroupMembership struct {
+	DirectoryID string        `json:"directory_id"`
+	User        DirectoryUser `json:"user"`
+	Group       struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"group"`
+}
+
+// UserID is what tuples use; same rule as the SSO logins (no @domain) ..
+func (u DirectoryUser) UserID() string {
+	id := u.Username
+	if id == "" {
+		id = u.PrimaryEmail()
+	}
+	local, _, _ := strings.Cut(id, "@")
+	return local
+}
+
+func (u DirectoryUser) PrimaryEmail() string {
+	for _, e := range u.Emails {
+		if e.Primary {
+			return e.Value
+		}
+	}
+	if len(u.Emails) > 0 {
+		return u.Emails[0].Value
+	}
+	return ""
+}
+
+// Attribute is a custom attribute as a string; "" if missing ..
+func (u DirectoryUser) Attribute(name string) string {
+	switch v := u.CustomAttributes[name].(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		// Manager comes through as an object ..
+		for _, k := range []string{"email", "value", "id"} {
+			if s, ok := v[k].(string); ok {
+				return s
+			}
+		}
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+// Active is false for suspended / deprovisioned users ..
+func (u DirectoryUser) Active() bool {
+	return u.State == "" || u.State == "active"
+}
+
+// SignWebhook is the header value for body at t; for tests + replaying events ..
+func SignWebhook(secret, body []byte, t time.Time) string {
+	ts := strconv.FormatInt(t.UnixMilli(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ", v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the signature header over body and that it is fresh ..
+func VerifyWebhook(secret []byte, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	if len(secret) == 0 {
+		return ErrInvalidSignature
+	}
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+	ms, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.UnixMilli(ms)); age > tolerance || age < -tolerance {
+		return ErrInvalidSignature
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// DirectoryWebhook receives Directory Sync events. Handle errors become a 500
+// so the sender retries; handlers must cope with the same event ID twice ..
+type DirectoryWebhook struct {
+	Secret    []byte
+	Tolerance time.Duration
+	Handle    func(ctx context.Context, ev DirectoryEvent) error
+	now       func() time.Time
+}
+
+func (h *DirectoryWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	now, tolerance := time.Now(), h.Tolerance
+	if h.now != nil {
+		now = h.now()
+	}
+	if tolerance <= 0 {
+		tolerance = defaultWebhookTolerance
+	}
+	if err := VerifyWebhook(h.Secret, r.Header.Get(DirectorySignatureHeader), body, now, tolerance); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var ev DirectoryEvent
+	if err := json.Unmarshal(body, &ev); err != nil || ev.Event == "" {
+		http.Error(w, "bad event", http.StatusBadRequest)
+		return
+	}
+	if err := h.Handle(r.Context(), ev); err != nil {
+		fmt.Println("DSYNC-ERR: ", ev.ID, ev.Event, err)
+		http.Error(w, "unable to process event", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
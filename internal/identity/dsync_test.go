@@ -0,0 +1,81 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testDirectoryEvent = `{"id":"event_01","event":"dsync.user.updated","created_at":"2024-06-01T10:00:00Z","data":{
+	"id":"directory_user_01","organization_id":"org_01","username":"bob@gopherlab.example","state":"active",
+	"emails":[{"primary":false,"type":"home","value":"b@home.example"},{"primary":true,"type":"work","value":"bob@gopherlab.example"}],
+	"custom_attributes":{"department":"finance","manager":{"email":"mleow@gopherlab.example"}}}}`
+
+func TestVerifyWebhook(t *testing.T) {
+	secret := []byte("whsec")
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	body := []byte(testDirectoryEvent)
+	header := SignWebhook(secret, body, now)
+
+	assert.NoError(t, VerifyWebhook(secret, header, body, now.Add(time.Minute), time.Minute*3))
+	cases := map[string]error{
+		"stale":      VerifyWebhook(secret, header, body, now.Add(time.Minute*4), time.Minute*3),
+		"future":     VerifyWebhook(secret, header, body, now.Add(-time.Minute*4), time.Minute*3),
+		"tampered":   VerifyWebhook(secret, header, []byte(strings.Replace(testDirectoryEvent, "finance", "admins", 1)), now, time.Minute*3),
+		"wrong key":  VerifyWebhook([]byte("other"), header, body, now, time.Minute*3),
+		"no key":     VerifyWebhook(nil, header, body, now, time.Minute*3),
+		"unsigned":   VerifyWebhook(secret, "", body, now, time.Minute*3),
+		"bad header": VerifyWebhook(secret, "t=abc, v1=00", body, now, time.Minute*3),
+	}
+	for name, err := range cases {
+		assert.ErrorIs(t, err, ErrInvalidSignature, name)
+	}
+}
+
+func TestDirectoryWebhook(t *testing.T) {
+	secret := []byte("whsec")
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	var got []DirectoryEvent
+	fail := false
+	h := &DirectoryWebhook{
+		Secret: secret,
+		Handle: func(ctx context.Context, ev DirectoryEvent) error {
+			if fail {
+				return errors.New("temporal down")
+			}
+			got = append(got, ev)
+			return nil
+		},
+		now: func() time.Time { return now },
+	}
+	post := func(header string) int {
+		r := httptest.NewRequest(http.MethodPost, "/webhooks/dsync", strings.NewReader(testDirectoryEvent))
+		r.Header.Set(DirectorySignatureHeader, header)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, post(SignWebhook(secret, []byte(testDirectoryEvent), now)))
+	assert.Equal(t, http.StatusUnauthorized, post(SignWebhook([]byte("other"), []byte(testDirectoryEvent), now)))
+	fail = true
+	assert.Equal(t, http.StatusInternalServerError, post(SignWebhook(secret, []byte(testDirectoryEvent), now)))
+
+	require.Len(t, got, 1)
+	assert.Equal(t, DirectoryUserUpdated, got[0].Event)
+	var u DirectoryUser
+	require.NoError(t, json.Unmarshal(got[0].Data, &u))
+	assert.Equal(t, "bob", u.UserID())
+	assert.Equal(t, "bob@gopherlab.example", u.PrimaryEmail())
+	assert.Equal(t, "finance", u.Attribute("department"))
+	assert.Equal(t, "mleow@gopherlab.example", u.Attribute("manager"))
+	assert.Equal(t, "", u.Attribute("title"))
+	assert.True(t, u.Active())
+}
@@ -0,0 +1,45 @@
+// Package identity handles who the caller is: SSO login, sessions and API keys ..
+package identity
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// ErrInvalidUserID is a username that would not stay user:<ID> in a tuple ..
+var ErrInvalidUserID = errors.New("invalid user id")
+
+// ValidUserID refuses IDs carrying a type, relation or wildcard of their own
+// e.g. service:x, user:* or group:acme/admins#member; whatever an IdP, SCIM
+// or the directory sends must be checked before it becomes a user ..
+func ValidUserID(id string) error {
+	if id == "" || strings.ContainsAny(id, ":#*") || strings.TrimSpace(id) != id {
+		return ErrInvalidUserID
+	}
+	return nil
+}
+
+// User is the identity handed back by any login provider ..
+type User struct {
+	// ID is the stable username used in tuples e.g. user:<ID>
+	ID      string
+	Subject string // Provider's own subject ..
+	Email   string
+	Name    string
+	Groups  []string
+	// Provider it came from e.g. oidc, workos, saml:<tenant>
+	Provider string
+	// DeviceTrusted is the IdP's device posture (managed / compliant) at login ..
+	DeviceTrusted bool
+}
+
+// randomString is URL safe random; n bytes of entropy ..
+func randomString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,276 @@
+package identity
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MockUser is someone the mock IdP will happily log in ..
+type MockUser struct {
+	Username string
+	Email    string
+	Name     string
+	Groups   []string
+	// DeviceTrusted goes out as the device_trusted claim ..
+	DeviceTrusted bool
+}
+
+// MockIdP is an in-process OIDC provider for offline demo + tests.
+// It does auth code + PKCE properly so the relying party is exercised
+// for real; it just never asks for a password ..
+type MockIdP struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+
+	mu    sync.Mutex
+	users map[string]MockUser
+	key   *rsa.PrivateKey
+	kid   string
+	// Previous key stays published for a while after rotation ..
+	oldKeys map[string]*rsa.PublicKey
+	codes   map[string]mockCode
+	now     func() time.Time
+	// TokenTTL defaults to 5 mins; tests shorten it ..
+	TokenTTL time.Duration
+}
+
+type mockCode struct {
+	user        MockUser
+	clientID    string
+	redirectURI string
+	nonce       string
+	challenge   string
+	expires     time.Time
+}
+
+// NewMockIdP serves under issuer; mount it there with http.StripPrefix if it has a path ..
+func NewMockIdP(issuer, clientID, clientSecret string, users ...MockUser) *MockIdP {
+	m := &MockIdP{
+		issuer:       strings.TrimSuffix(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		users:        map[string]MockUser{},
+		oldKeys:      map[string]*rsa.PublicKey{},
+		codes:        map[string]mockCode{},
+		now:          time.Now,
+		TokenTTL:     5 * time.Minute,
+	}
+	for _, u := range users {
+		m.users[u.Username] = u
+	}
+	m.RotateKey()
+	return m
+}
+
+// Issuer is what the relying party should be configured with ..
+func (m *MockIdP) Issuer() string {
+	return m.issuer
+}
+
+// UserIDs maps each mock user's sub back to their username, for
+// OIDCConfig.UserIDs ..
+func (m *MockIdP) UserIDs() map[string]string {
+	ids := map[string]string{}
+	for name := range m.users {
+		ids["mock|"+name] = name
+	}
+	return ids
+}
+
+// RotateKey starts signing with a fresh key; old one is still in the JWKS ..
+func (m *MockIdP) RotateKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.key != nil {
+		m.oldKeys[m.kid] = &m.key.PublicKey
+	}
+	m.key = key
+	m.kid = randomString(8)
+}
+
+func (m *MockIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		writeJSON(w, map[string]interface{}{
+			"issuer":                                m.issuer,
+			"authorization_endpoint":                m.issuer + "/authorize",
+			"token_endpoint":                        m.issuer + "/token",
+			"jwks_uri":                              m.issuer + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"code_challenge_methods_supported":      []string{"S256"},
+		})
+	case "/jwks":
+		m.mu.Lock()
+		set := jwks{Keys: []jwk{newJWK(m.kid, &m.key.PublicKey)}}
+		for kid, pub := range m.oldKeys {
+			set.Keys = append(set.Keys, newJWK(kid, pub))
+		}
+		m.mu.Unlock()
+		writeJSON(w, set)
+	case "/authorize":
+		m.authorize(w, r)
+	case "/token":
+		m.token(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// authorize picks the user from login_hint; else shows who you can be ..
+func (m *MockIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" || q.Get("client_id") != m.clientID {
+		http.Error(w, "unsupported request", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "PKCE S256 required", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		http.Error(w, "bad redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	user, ok := m.users[q.Get("login_hint")]
+	m.mu.Unlock()
+	if !ok {
+		m.picker(w, r)
+		return
+	}
+
+	code := randomString(24)
+	m.mu.Lock()
+	m.codes[code] = mockCode{
+		user:        user,
+		clientID:    m.clientID,
+		redirectURI: redirect.String(),
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		expires:     m.now().Add(time.Minute),
+	}
+	m.mu.Unlock()
+	back := redirect.Query()
+	back.Set("code", code)
+	back.Set("state", q.Get("state"))
+	redirect.RawQuery = back.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (m *MockIdP) picker(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	names := make([]string, 0, len(m.users))
+	for name := range m.users {
+		names = append(names, name)
+	}
+	m.mu.Unlock()
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("<html><body><h3>Mock IdP: who are you?</h3><div>")
+	for _, name := range names {
+		q := r.URL.Query()
+		q.Set("login_hint", name)
+		fmt.Fprintf(&b, `<a href="%s/authorize?%s">Login %s</a><br/>`,
+			html.EscapeString(m.issuer), html.EscapeString(q.Encode()), html.EscapeString(name))
+	}
+	b.WriteString("</div></body></html>")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, b.String())
+}
+
+func (m *MockIdP) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != m.clientID || clientSecret != m.clientSecret {
+		tokenError(w, "invalid_client")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type")
+		return
+	}
+
+	// Codes are single use; gone even when the rest fails ..
+	m.mu.Lock()
+	code, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+	key, kid := m.key, m.kid
+	m.mu.Unlock()
+	switch {
+	case !ok || m.now().After(code.expires):
+		tokenError(w, "invalid_grant")
+		return
+	case code.redirectURI != r.PostForm.Get("redirect_uri"):
+		tokenError(w, "invalid_grant")
+		return
+	case pkceChallenge(r.PostForm.Get("code_verifier")) != code.challenge:
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	now := m.now()
+	idToken, err := signRS256(key, kid, map[string]interface{}{
+		"iss":                m.issuer,
+		"sub":                "mock|" + code.user.Username,
+		"aud":                code.clientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(m.TokenTTL).Unix(),
+		"nonce":              code.nonce,
+		"email":              code.user.Email,
+		"name":               code.user.Name,
+		"preferred_username": code.user.Username,
+		"groups":             code.user.Groups,
+		"device_trusted":     code.user.DeviceTrusted,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"access_token": randomString(24),
+		"token_type":   "Bearer",
+		"expires_in":   int(m.TokenTTL.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(v)
+}
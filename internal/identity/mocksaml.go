@@ -0,0 +1,293 @@
+package identity
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"html"
+	"io"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MockSAMLIdP is a local SAML IdP stand-in for the demo + tests.
+// It signs real assertions; it just never asks for a password ..
+type MockSAMLIdP struct {
+	entityID string
+	ssoURL   string
+	key      *rsa.PrivateKey
+	cert     *x509.Certificate
+
+	mu    sync.Mutex
+	users map[string]MockUser
+	now   func() time.Time
+}
+
+// NewMockSAMLIdP serves /sso under baseURL; mount with http.StripPrefix ..
+func NewMockSAMLIdP(baseURL string, users ...MockUser) *MockSAMLIdP {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	key, cert := newSelfSignedCert("mock-saml-idp")
+	m := &MockSAMLIdP{
+		entityID: baseURL + "/metadata",
+		ssoURL:   baseURL + "/sso",
+		key:      key,
+		cert:     cert,
+		users:    map[string]MockUser{},
+		now:      time.Now,
+	}
+	for _, u := range users {
+		m.users[u.Username] = u
+	}
+	return m
+}
+
+// newSelfSignedCert is good enough for signing SAML; nobody chains these ..
+func newSelfSignedCert(cn string) (*rsa.PrivateKey, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+	return key, cert
+}
+
+// NewSAMLSigningKey is an SP key + cert for signing AuthnRequests ..
+func NewSAMLSigningKey(cn string) (*rsa.PrivateKey, *x509.Certificate) {
+	return newSelfSignedCert(cn)
+}
+
+// Metadata is the IdP EntityDescriptor a tenant admin would import ..
+func (m *MockSAMLIdP) Metadata() []byte {
+	doc := etree.NewDocument()
+	ed := doc.CreateElement("md:EntityDescriptor")
+	ed.CreateAttr("xmlns:md", samlMetadataNS)
+	ed.CreateAttr("entityID", m.entityID)
+	idp := ed.CreateElement("md:IDPSSODescriptor")
+	idp.CreateAttr("protocolSupportEnumeration", samlProtocolNS)
+	kd := idp.CreateElement("md:KeyDescriptor")
+	kd.CreateAttr("use", "signing")
+	ki := kd.CreateElement("ds:KeyInfo")
+	ki.CreateAttr("xmlns:ds", dsig.Namespace)
+	ki.CreateElement("ds:X509Data").CreateElement("ds:X509Certificate").
+		SetText(base64.StdEncoding.EncodeToString(m.cert.Raw))
+	sso := idp.CreateElement("md:SingleSignOnService")
+	sso.CreateAttr("Binding", samlRedirectBinding)
+	sso.CreateAttr("Location", m.ssoURL)
+	doc.Indent(2)
+	b, _ := doc.WriteToBytes()
+	return b
+}
+
+func (m *MockSAMLIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/metadata":
+		w.Header().Set("Content-Type", "application/samlmetadata+xml")
+		w.Write(m.Metadata())
+	case "/sso":
+		m.sso(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// sso takes the redirect binding AuthnRequest; answers with an auto POST
+// to the SP's ACS ..
+func (m *MockSAMLIdP) sso(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	deflated, err := base64.StdEncoding.DecodeString(q.Get("SAMLRequest"))
+	if err != nil {
+		http.Error(w, "bad SAMLRequest", http.StatusBadRequest)
+		return
+	}
+	raw, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		http.Error(w, "bad SAMLRequest", http.StatusBadRequest)
+		return
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil || doc.Root() == nil {
+		http.Error(w, "bad SAMLRequest", http.StatusBadRequest)
+		return
+	}
+	req := doc.Root()
+	acs := req.SelectAttrValue("AssertionConsumerServiceURL", "")
+	issuer := ""
+	if el := req.FindElement("./Issuer"); el != nil {
+		issuer = el.Text()
+	}
+	if acs == "" || issuer == "" {
+		http.Error(w, "AuthnRequest needs ACS + Issuer", http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	user, ok := m.users[q.Get("login_hint")]
+	m.mu.Unlock()
+	if !ok {
+		m.picker(w, r)
+		return
+	}
+	resp, err := m.response(samlResponseOptions{
+		User:         user,
+		InResponseTo: req.SelectAttrValue("ID", ""),
+		ACSURL:       acs,
+		Audience:     issuer,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<html><body onload="document.forms[0].submit()"><form method="post" action="%s">`+
+		`<input type="hidden" name="SAMLResponse" value="%s"/><input type="hidden" name="RelayState" value="%s"/>`+
+		`<noscript><button type="submit">Continue</button></noscript></form></body></html>`,
+		html.EscapeString(acs), html.EscapeString(resp), html.EscapeString(q.Get("RelayState")))
+}
+
+func (m *MockSAMLIdP) picker(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	names := make([]string, 0, len(m.users))
+	for name := range m.users {
+		names = append(names, name)
+	}
+	m.mu.Unlock()
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString("<html><body><h3>Mock SAML IdP: who are you?</h3><div>")
+	for _, name := range names {
+		q := r.URL.Query()
+		q.Set("login_hint", name)
+		fmt.Fprintf(&b, `<a href="%s?%s">Login %s</a><br/>`,
+			html.EscapeString(m.ssoURL), html.EscapeString(q.Encode()), html.EscapeString(name))
+	}
+	b.WriteString("</div></body></html>")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, b.String())
+}
+
+// samlResponseOptions lets tests break one thing at a time ..
+type samlResponseOptions struct {
+	User         MockUser
+	InResponseTo string
+	ACSURL       string
+	Audience     string
+	IssuedAt     time.Time
+	Lifetime     time.Duration
+	// SignResponse signs the whole Response rather than just the Assertion ..
+	SignResponse bool
+	Unsigned     bool
+}
+
+func (m *MockSAMLIdP) response(o samlResponseOptions) (string, error) {
+	if o.IssuedAt.IsZero() {
+		o.IssuedAt = m.now()
+	}
+	if o.Lifetime <= 0 {
+		o.Lifetime = 5 * time.Minute
+	}
+	instant := o.IssuedAt.UTC().Format(time.RFC3339)
+	until := o.IssuedAt.Add(o.Lifetime).UTC().Format(time.RFC3339)
+
+	doc := etree.NewDocument()
+	resp := doc.CreateElement("samlp:Response")
+	resp.CreateAttr("xmlns:samlp", samlProtocolNS)
+	resp.CreateAttr("xmlns:saml", samlAssertionNS)
+	resp.CreateAttr("ID", "_"+randomString(20))
+	resp.CreateAttr("Version", "2.0")
+	resp.CreateAttr("IssueInstant", instant)
+	resp.CreateAttr("Destination", o.ACSURL)
+	resp.CreateAttr("InResponseTo", o.InResponseTo)
+	resp.CreateElement("saml:Issuer").SetText(m.entityID)
+	resp.CreateElement("samlp:Status").CreateElement("samlp:StatusCode").CreateAttr("Value", samlStatusSuccess)
+
+	a := etree.NewElement("saml:Assertion")
+	a.CreateAttr("xmlns:saml", samlAssertionNS)
+	a.CreateAttr("ID", "_"+randomString(20))
+	a.CreateAttr("Version", "2.0")
+	a.CreateAttr("IssueInstant", instant)
+	a.CreateElement("saml:Issuer").SetText(m.entityID)
+	subj := a.CreateElement("saml:Subject")
+	nameID := subj.CreateElement("saml:NameID")
+	nameID.CreateAttr("Format", samlNameIDFormat)
+	nameID.SetText(o.User.Email)
+	sc := subj.CreateElement("saml:SubjectConfirmation")
+	sc.CreateAttr("Method", samlBearer)
+	scd := sc.CreateElement("saml:SubjectConfirmationData")
+	scd.CreateAttr("InResponseTo", o.InResponseTo)
+	scd.CreateAttr("NotOnOrAfter", until)
+	scd.CreateAttr("Recipient", o.ACSURL)
+	cond := a.CreateElement("saml:Conditions")
+	cond.CreateAttr("NotBefore", instant)
+	cond.CreateAttr("NotOnOrAfter", until)
+	cond.CreateElement("saml:AudienceRestriction").CreateElement("saml:Audience").SetText(o.Audience)
+	as := a.CreateElement("saml:AttributeStatement")
+	attr := func(name string, values ...string) {
+		el := as.CreateElement("saml:Attribute")
+		el.CreateAttr("Name", name)
+		for _, v := range values {
+			el.CreateElement("saml:AttributeValue").SetText(v)
+		}
+	}
+	attr("uid", o.User.Username)
+	attr("email", o.User.Email)
+	attr("displayName", o.User.Name)
+	if len(o.User.Groups) > 0 {
+		attr("groups", o.User.Groups...)
+	}
+
+	signer := dsig.NewDefaultSigningContext(mockKeyStore{m.key, m.cert.Raw})
+	if !o.Unsigned && !o.SignResponse {
+		signed, err := signer.SignEnveloped(a)
+		if err != nil {
+			return "", err
+		}
+		a = signed
+	}
+	resp.AddChild(a)
+	if !o.Unsigned && o.SignResponse {
+		signed, err := signer.SignEnveloped(resp)
+		if err != nil {
+			return "", err
+		}
+		doc.SetRoot(signed)
+	}
+	b, err := doc.WriteToBytes()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+type mockKeyStore struct {
+	key  *rsa.PrivateKey
+	cert []byte
+}
+
+func (k mockKeyStore) GetKeyPair() (*rsa.PrivateKey, []byte, error) {
+	return k.key, k.cert, nil
+}
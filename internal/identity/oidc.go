@@ -0,0 +1,411 @@
+package identity
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider is anything that can log a user in via the browser.
+// OIDC is the default; WorkOS AuthKit and the like plug in as adapters ..
+type Provider interface {
+	Name() string
+	// AuthURL is where the browser goes to log in ..
+	AuthURL(state, nonce, codeChallenge string) string
+	// Exchange swaps the callback code for a verified user ..
+	Exchange(ctx context.Context, code, codeVerifier, nonce string) (User, error)
+}
+
+// ErrInvalidToken covers anything wrong with the ID token ..
+var ErrInvalidToken = errors.New("invalid id token")
+
+// OIDCConfig is what the relying party needs to know about itself ..
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// HTTPClient defaults to one with a sane timeout ..
+	HTTPClient *http.Client
+	// JWKSCacheTTL is how long keys are trusted before re-fetching ..
+	JWKSCacheTTL time.Duration
+	// UserIDs maps an IdP sub to the user ID tuples use; unmapped
+	// subjects are used as is ..
+	UserIDs map[string]string
+}
+
+// OIDCProvider is an authorization code + PKCE relying party.
+// Discovery and JWKS are fetched lazily so the IdP can come up after us ..
+type OIDCProvider struct {
+	cfg OIDCConfig
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey
+	keysAt    time.Time
+	now       func() time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewOIDCProvider sets defaults; nothing is fetched until first use ..
+func NewOIDCProvider(cfg OIDCConfig) *OIDCProvider {
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "profile", "email"}
+	}
+	if cfg.JWKSCacheTTL <= 0 {
+		cfg.JWKSCacheTTL = time.Hour
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	return &OIDCProvider{cfg: cfg, now: time.Now}
+}
+
+func (p *OIDCProvider) Name() string {
+	return "oidc"
+}
+
+func (p *OIDCProvider) getDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	d := p.discovery
+	p.mu.Unlock()
+	if d != nil {
+		return d, nil
+	}
+	d = &oidcDiscovery{}
+	if err := p.getJSON(ctx, p.cfg.Issuer+"/.well-known/openid-configuration", d); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer mismatch %q", d.Issuer)
+	}
+	p.mu.Lock()
+	p.discovery = d
+	p.mu.Unlock()
+	return d, nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// AuthURL needs discovery; falls back to the conventional path if the IdP is not up yet ..
+func (p *OIDCProvider) AuthURL(state, nonce, codeChallenge string) string {
+	endpoint := p.cfg.Issuer + "/authorize"
+	if d, err := p.getDiscovery(context.Background()); err == nil {
+		endpoint = d.AuthorizationEndpoint
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	return endpoint + "?" + q.Encode()
+}
+
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (User, error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return User{}, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"client_secret": {p.cfg.ClientSecret},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return User{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := p.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return User{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return User{}, fmt.Errorf("oidc token exchange: %s", resp.Status)
+	}
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return User{}, err
+	}
+	claims, err := p.VerifyIDToken(ctx, tokens.IDToken, nonce)
+	if err != nil {
+		return User{}, err
+	}
+	user := claims.User()
+	if id, ok := p.cfg.UserIDs[user.Subject]; ok {
+		user.ID = id
+	}
+	return user, nil
+}
+
+// IDTokenClaims are the claims we care about ..
+type IDTokenClaims struct {
+	Issuer            string          `json:"iss"`
+	Subject           string          `json:"sub"`
+	Audience          json.RawMessage `json:"aud"`
+	Expiry            int64           `json:"exp"`
+	IssuedAt          int64           `json:"iat"`
+	Nonce             string          `json:"nonce"`
+	Email             string          `json:"email"`
+	Name              string          `json:"name"`
+	PreferredUsername string          `json:"preferred_username"`
+	Groups            []string        `json:"groups"`
+	// Device posture from the IdP (e.g. Okta device assurance) ..
+	DeviceTrusted bool `json:"device_trusted"`
+}
+
+// User maps claims; the ID is sub, never preferred_username, which the
+// user can usually change at the IdP ..
+func (c IDTokenClaims) User() User {
+	return User{
+		ID:       c.Subject,
+		Subject:  c.Subject,
+		Email:    c.Email,
+		Name:     c.Name,
+		Groups:   c.Groups,
+		Provider: "oidc",
+		// Absent claim is untrusted ..
+		DeviceTrusted: c.DeviceTrusted,
+	}
+}
+
+func (c IDTokenClaims) hasAudience(clientID string) bool {
+	var one string
+	if json.Unmarshal(c.Audience, &one) == nil {
+		return one == clientID
+	}
+	var many []string
+	if json.Unmarshal(c.Audience, &many) == nil {
+		for _, aud := range many {
+			if aud == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// VerifyIDToken checks signature (RS256 only), issuer, audience, expiry and nonce ..
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, raw, nonce string) (IDTokenClaims, error) {
+	var claims IDTokenClaims
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return claims, ErrInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return claims, ErrInvalidToken
+	}
+	if header.Alg != "RS256" {
+		return claims, fmt.Errorf("%w: alg %q not allowed", ErrInvalidToken, header.Alg)
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return claims, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, ErrInvalidToken
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return claims, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return claims, ErrInvalidToken
+	}
+	now := p.now()
+	// A little slack for clock skew ..
+	skew := int64(60)
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != p.cfg.Issuer:
+		return claims, fmt.Errorf("%w: issuer", ErrInvalidToken)
+	case !claims.hasAudience(p.cfg.ClientID):
+		return claims, fmt.Errorf("%w: audience", ErrInvalidToken)
+	case claims.Expiry+skew < now.Unix():
+		return claims, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case claims.IssuedAt-skew > now.Unix():
+		return claims, fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	case nonce != "" && claims.Nonce != nonce:
+		return claims, fmt.Errorf("%w: nonce", ErrInvalidToken)
+	case claims.Subject == "":
+		return claims, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+// Unknown kids can force a refresh; but not more often than this ..
+const jwksMinRefresh = time.Minute
+
+// key comes from the JWKS cache; an unknown kid forces a refresh (IdP rotated) ..
+func (p *OIDCProvider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	loaded := p.keys != nil
+	age := p.now().Sub(p.keysAt)
+	p.mu.Unlock()
+	if ok && age < p.cfg.JWKSCacheTTL {
+		return key, nil
+	}
+	if !ok && loaded && age < jwksMinRefresh {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+	if err := p.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key, ok = p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+	return key, nil
+}
+
+func (p *OIDCProvider) refreshKeys(ctx context.Context) error {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return err
+	}
+	var set jwks
+	if err := p.getJSON(ctx, d.JWKSURI, &set); err != nil {
+		return fmt.Errorf("oidc jwks: %w", err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		pub, err := k.rsaPublicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.keysAt = p.now()
+	p.mu.Unlock()
+	return nil
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func newJWK(kid string, pub *rsa.PublicKey) jwk {
+	return jwk{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}
+}
+
+func (k jwk) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// signRS256 builds a compact JWT; used by the mock IdP ..
+func signRS256(key *rsa.PrivateKey, kid string, claims interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signing := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signing))
+	sig, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signing + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// NewPKCE returns a verifier and its S256 challenge ..
+func NewPKCE() (verifier, challenge string) {
+	verifier = randomString(32)
+	return verifier, pkceChallenge(verifier)
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
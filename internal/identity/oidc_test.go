@@ -0,0 +1,186 @@
+package identity
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type testIdP struct {
+	idp    *MockIdP
+	server *httptest.Server
+	rp     *RelyingParty
+	oidc   *OIDCProvider
+}
+
+// newTestIdP mounts the mock IdP under /idp like cmd/authz does ..
+func newTestIdP(t *testing.T) *testIdP {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	idp := NewMockIdP(server.URL+"/idp", "demo", "s3cret",
+		MockUser{Username: "bob", Email: "bob@example.com", Name: "Bob", Groups: []string{"finance"}, DeviceTrusted: true})
+	mux.Handle("/idp/", http.StripPrefix("/idp", idp))
+	p := NewOIDCProvider(OIDCConfig{
+		Issuer:       idp.Issuer(),
+		ClientID:     "demo",
+		ClientSecret: "s3cret",
+		RedirectURL:  server.URL + "/callback",
+		UserIDs:      idp.UserIDs(),
+	})
+	return &testIdP{idp: idp, server: server, rp: NewRelyingParty(p), oidc: p}
+}
+
+// login drives the browser: start, pick user at the IdP, then callback ..
+func (ti *testIdP) login(t *testing.T, username string) (User, string, error) {
+	start := httptest.NewRecorder()
+	ti.rp.StartLogin(start, httptest.NewRequest(http.MethodGet, "/login", nil), "/demo/")
+	require.Equal(t, http.StatusFound, start.Code)
+	authURL, err := url.Parse(start.Header().Get("Location"))
+	require.NoError(t, err)
+	q := authURL.Query()
+	q.Set("login_hint", username)
+	authURL.RawQuery = q.Encode()
+
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := noFollow.Get(authURL.String())
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	callback := httptest.NewRequest(http.MethodGet, resp.Header.Get("Location"), nil)
+	for _, c := range start.Result().Cookies() {
+		callback.AddCookie(c)
+	}
+	return ti.rp.Callback(httptest.NewRecorder(), callback)
+}
+
+func TestOIDCLoginWithMockIdP(t *testing.T) {
+	ti := newTestIdP(t)
+	user, returnTo, err := ti.login(t, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, "/demo/", returnTo)
+	assert.Equal(t, "bob", user.ID)
+	assert.Equal(t, "mock|bob", user.Subject)
+	assert.Equal(t, "bob@example.com", user.Email)
+	assert.Equal(t, []string{"finance"}, user.Groups)
+	assert.True(t, user.DeviceTrusted)
+}
+
+func TestIDTokenUserIsSubject(t *testing.T) {
+	user := IDTokenClaims{Subject: "00u1a2b3", PreferredUsername: "mleow"}.User()
+	assert.Equal(t, "00u1a2b3", user.ID)
+}
+
+func TestOIDCCallbackNeedsMatchingState(t *testing.T) {
+	ti := newTestIdP(t)
+	req := httptest.NewRequest(http.MethodGet, "/callback?code=x&state=forged", nil)
+	req.AddCookie(&http.Cookie{Name: loginStateCookie, Value: "forged"})
+	_, _, err := ti.rp.Callback(httptest.NewRecorder(), req)
+	assert.ErrorIs(t, err, ErrLoginState)
+}
+
+func TestMockIdPRejectsWrongVerifier(t *testing.T) {
+	ti := newTestIdP(t)
+	_, challenge := NewPKCE()
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	u := ti.idp.Issuer() + "/authorize?" + url.Values{
+		"response_type": {"code"}, "client_id": {"demo"}, "redirect_uri": {ti.server.URL + "/callback"},
+		"state": {"s"}, "nonce": {"n"}, "login_hint": {"bob"},
+		"code_challenge": {challenge}, "code_challenge_method": {"S256"},
+	}.Encode()
+	resp, err := noFollow.Get(u)
+	require.NoError(t, err)
+	resp.Body.Close()
+	back, _ := url.Parse(resp.Header.Get("Location"))
+
+	other, _ := NewPKCE()
+	_, err = ti.oidc.Exchange(context.Background(), back.Query().Get("code"), other, "n")
+	assert.Error(t, err)
+}
+
+func TestVerifyIDTokenChecks(t *testing.T) {
+	ti := newTestIdP(t)
+	now := time.Now()
+	good := map[string]interface{}{
+		"iss": ti.idp.Issuer(), "sub": "mock|bob", "aud": "demo", "nonce": "n1",
+		"iat": now.Unix(), "exp": now.Add(time.Minute).Unix(),
+	}
+	with := func(k string, v interface{}) map[string]interface{} {
+		c := map[string]interface{}{}
+		for key, val := range good {
+			c[key] = val
+		}
+		c[k] = v
+		return c
+	}
+	sign := func(claims map[string]interface{}) string {
+		ti.idp.mu.Lock()
+		defer ti.idp.mu.Unlock()
+		raw, err := signRS256(ti.idp.key, ti.idp.kid, claims)
+		require.NoError(t, err)
+		return raw
+	}
+	ctx := context.Background()
+
+	_, err := ti.oidc.VerifyIDToken(ctx, sign(good), "n1")
+	assert.NoError(t, err)
+	// Audience may also be a list ..
+	_, err = ti.oidc.VerifyIDToken(ctx, sign(with("aud", []string{"other", "demo"})), "n1")
+	assert.NoError(t, err)
+
+	for name, raw := range map[string]string{
+		"nonce":    sign(good),
+		"audience": sign(with("aud", "someone-else")),
+		"issuer":   sign(with("iss", "https://evil.example.com")),
+		"expired":  sign(with("exp", now.Add(-time.Hour).Unix())),
+	} {
+		nonce := "n1"
+		if name == "nonce" {
+			nonce = "n2"
+		}
+		_, err := ti.oidc.VerifyIDToken(ctx, raw, nonce)
+		assert.ErrorIs(t, err, ErrInvalidToken, name)
+	}
+
+	// Signed by a key the IdP never published ..
+	rogue, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ti.idp.mu.Lock()
+	kid := ti.idp.kid
+	ti.idp.mu.Unlock()
+	forged, err := signRS256(rogue, kid, good)
+	require.NoError(t, err)
+	_, err = ti.oidc.VerifyIDToken(ctx, forged, "n1")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestOIDCPicksUpRotatedKey(t *testing.T) {
+	ti := newTestIdP(t)
+	_, _, err := ti.login(t, "bob")
+	require.NoError(t, err)
+
+	// New kid is not cached; refresh is rate limited so move the clock on ..
+	ti.idp.RotateKey()
+	ti.oidc.now = func() time.Time { return time.Now().Add(2 * jwksMinRefresh) }
+	user, _, err := ti.login(t, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, "bob", user.ID)
+
+	// Unknown kid within the refresh window is refused without hitting the IdP ..
+	ti.idp.RotateKey()
+	_, _, err = ti.login(t, "bob")
+	assert.True(t, errors.Is(err, ErrInvalidToken))
+}
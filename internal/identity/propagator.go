@@ -0,0 +1,82 @@
+package identity
+
+import (
+	"context"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/workflow"
+)
+
+// Temporal header the principal rides in ..
+const principalHeader = "identity-principal"
+
+// PrincipalPropagator carries the calling Principal from an HTTP request
+// into workflow starts / signals; and on into activities.
+// Register it on the client via client.Options.ContextPropagators ..
+type PrincipalPropagator struct {
+	// DataConverter for the header; headers skip the client's codec so pass
+	// it here too. Default converter if nil ..
+	DataConverter converter.DataConverter
+}
+
+func (pp PrincipalPropagator) converter() converter.DataConverter {
+	if pp.DataConverter == nil {
+		return converter.GetDefaultDataConverter()
+	}
+	return pp.DataConverter
+}
+
+var _ workflow.ContextPropagator = PrincipalPropagator{}
+
+func (pp PrincipalPropagator) Inject(ctx context.Context, hw workflow.HeaderWriter) error {
+	p, ok := PrincipalFrom(ctx)
+	if !ok {
+		return nil
+	}
+	payload, err := pp.converter().ToPayload(p)
+	if err != nil {
+		return err
+	}
+	hw.Set(principalHeader, payload)
+	return nil
+}
+
+func (pp PrincipalPropagator) InjectFromWorkflow(ctx workflow.Context, hw workflow.HeaderWriter) error {
+	p, ok := PrincipalFromWorkflow(ctx)
+	if !ok {
+		return nil
+	}
+	payload, err := pp.converter().ToPayload(p)
+	if err != nil {
+		return err
+	}
+	hw.Set(principalHeader, payload)
+	return nil
+}
+
+func (pp PrincipalPropagator) Extract(ctx context.Context, hr workflow.HeaderReader) (context.Context, error) {
+	if payload, ok := hr.Get(principalHeader); ok {
+		var p Principal
+		if err := pp.converter().FromPayload(payload, &p); err != nil {
+			return ctx, err
+		}
+		ctx = WithPrincipal(ctx, p)
+	}
+	return ctx, nil
+}
+
+func (pp PrincipalPropagator) ExtractToWorkflow(ctx workflow.Context, hr workflow.HeaderReader) (workflow.Context, error) {
+	if payload, ok := hr.Get(principalHeader); ok {
+		var p Principal
+		if err := pp.converter().FromPayload(payload, &p); err != nil {
+			return ctx, err
+		}
+		ctx = workflow.WithValue(ctx, principalCtxKey{}, p)
+	}
+	return ctx, nil
+}
+
+// PrincipalFromWorkflow is who started / signalled the workflow, if known ..
+func PrincipalFromWorkflow(ctx workflow.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalCtxKey{}).(Principal)
+	return p, ok
+}
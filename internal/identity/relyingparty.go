@@ -0,0 +1,110 @@
+package identity
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// How long the browser has between starting login and coming back ..
+const loginTimeout = 10 * time.Minute
+
+const loginStateCookie = "login_state"
+
+// ErrLoginState is a callback we did not start; or started too long ago ..
+var ErrLoginState = errors.New("unknown or expired login state")
+
+// pendingLogin is what we keep server side between redirect + callback ..
+type pendingLogin struct {
+	nonce    string
+	verifier string
+	returnTo string
+	expires  time.Time
+}
+
+// RelyingParty runs the browser side of login against any Provider.
+// PKCE verifier + nonce never leave the server; the state cookie binds
+// the callback to the browser that started it ..
+type RelyingParty struct {
+	provider Provider
+
+	mu      sync.Mutex
+	pending map[string]pendingLogin
+	now     func() time.Time
+}
+
+func NewRelyingParty(p Provider) *RelyingParty {
+	return &RelyingParty{
+		provider: p,
+		pending:  map[string]pendingLogin{},
+		now:      time.Now,
+	}
+}
+
+// Provider is the one we log in against ..
+func (rp *RelyingParty) Provider() Provider {
+	return rp.provider
+}
+
+// StartLogin redirects to the provider; returnTo is where to land afterwards ..
+func (rp *RelyingParty) StartLogin(w http.ResponseWriter, r *http.Request, returnTo string) {
+	state := randomString(24)
+	nonce := randomString(24)
+	verifier, challenge := NewPKCE()
+
+	rp.mu.Lock()
+	now := rp.now()
+	// Drop abandoned logins ..
+	for k, v := range rp.pending {
+		if now.After(v.expires) {
+			delete(rp.pending, k)
+		}
+	}
+	rp.pending[state] = pendingLogin{
+		nonce:    nonce,
+		verifier: verifier,
+		returnTo: returnTo,
+		expires:  now.Add(loginTimeout),
+	}
+	rp.mu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginStateCookie,
+		Value:    state,
+		Path:     "/",
+		MaxAge:   int(loginTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, rp.provider.AuthURL(state, nonce, challenge), http.StatusFound)
+}
+
+// Callback completes login; returns the user and where they wanted to go ..
+func (rp *RelyingParty) Callback(w http.ResponseWriter, r *http.Request) (User, string, error) {
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		return User{}, "", errors.New("login failed: " + e)
+	}
+	state := q.Get("state")
+	c, err := r.Cookie(loginStateCookie)
+	if err != nil || state == "" || c.Value != state {
+		return User{}, "", ErrLoginState
+	}
+	// One shot; replaying the callback gets nothing ..
+	rp.mu.Lock()
+	pl, ok := rp.pending[state]
+	delete(rp.pending, state)
+	rp.mu.Unlock()
+	http.SetCookie(w, &http.Cookie{Name: loginStateCookie, Path: "/", MaxAge: -1})
+	if !ok || rp.now().After(pl.expires) {
+		return User{}, "", ErrLoginState
+	}
+
+	user, err := rp.provider.Exchange(r.Context(), q.Get("code"), pl.verifier, pl.nonce)
+	if err != nil {
+		return User{}, "", err
+	}
+	return user, pl.returnTo, nil
+}
@@ -0,0 +1,418 @@
+package identity
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SAML namespaces + the bits of the spec we use ..
+const (
+	samlProtocolNS  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	samlAssertionNS = "urn:oasis:names:tc:SAML:2.0:assertion"
+	samlMetadataNS  = "urn:oasis:names:tc:SAML:2.0:metadata"
+
+	samlRedirectBinding = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	samlPOSTBinding     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	samlStatusSuccess   = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	samlBearer          = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	samlSigAlgRSA256    = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	samlNameIDFormat    = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+)
+
+// ErrInvalidAssertion covers anything wrong with what the IdP posted back ..
+var ErrInvalidAssertion = errors.New("invalid saml response")
+
+// IdPMetadata is what we need out of the IdP's EntityDescriptor ..
+type IdPMetadata struct {
+	EntityID string
+	// SSOURL takes AuthnRequests via the HTTP-Redirect binding ..
+	SSOURL string
+	Certs  []*x509.Certificate
+}
+
+type samlEntityDescriptor struct {
+	XMLName          xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID         string   `xml:"entityID,attr"`
+	IDPSSODescriptor *struct {
+		KeyDescriptors []struct {
+			Use  string `xml:"use,attr"`
+			Cert string `xml:"KeyInfo>X509Data>X509Certificate"`
+		} `xml:"KeyDescriptor"`
+		SingleSignOnServices []struct {
+			Binding  string `xml:"Binding,attr"`
+			Location string `xml:"Location,attr"`
+		} `xml:"SingleSignOnService"`
+	} `xml:"IDPSSODescriptor"`
+}
+
+// ParseIdPMetadata imports what the tenant's IdP admin hands us ..
+func ParseIdPMetadata(raw []byte) (IdPMetadata, error) {
+	var ed samlEntityDescriptor
+	if err := xml.Unmarshal(raw, &ed); err != nil {
+		return IdPMetadata{}, fmt.Errorf("saml metadata: %w", err)
+	}
+	if ed.IDPSSODescriptor == nil || ed.EntityID == "" {
+		return IdPMetadata{}, fmt.Errorf("saml metadata: not an IdP EntityDescriptor")
+	}
+	md := IdPMetadata{EntityID: ed.EntityID}
+	for _, s := range ed.IDPSSODescriptor.SingleSignOnServices {
+		if s.Binding == samlRedirectBinding {
+			md.SSOURL = s.Location
+		}
+	}
+	for _, kd := range ed.IDPSSODescriptor.KeyDescriptors {
+		if kd.Use != "" && kd.Use != "signing" {
+			continue
+		}
+		der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(kd.Cert), ""))
+		if err != nil {
+			return IdPMetadata{}, fmt.Errorf("saml metadata: %w", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return IdPMetadata{}, fmt.Errorf("saml metadata: %w", err)
+		}
+		md.Certs = append(md.Certs, cert)
+	}
+	switch {
+	case md.SSOURL == "":
+		return IdPMetadata{}, fmt.Errorf("saml metadata: no HTTP-Redirect SingleSignOnService")
+	case len(md.Certs) == 0:
+		return IdPMetadata{}, fmt.Errorf("saml metadata: no signing certificate")
+	}
+	return md, nil
+}
+
+// SAMLAttributeMap names the IdP attributes we read; defaults suit most IdPs ..
+type SAMLAttributeMap struct {
+	Username string // Empty uses the NameID ..
+	Email    string
+	Name     string
+	Groups   string
+}
+
+// SAMLConfig is one tenant's SP setup ..
+type SAMLConfig struct {
+	TenantID string
+	// EntityID + ACSURL are ours; unique per tenant ..
+	EntityID string
+	ACSURL   string
+	IdP      IdPMetadata
+	// Key + Cert sign our AuthnRequests ..
+	Key        *rsa.PrivateKey
+	Cert       *x509.Certificate
+	Attributes SAMLAttributeMap
+	ClockSkew  time.Duration
+}
+
+// SAMLServiceProvider validates IdP responses for one tenant ..
+type SAMLServiceProvider struct {
+	cfg SAMLConfig
+	now func() time.Time
+
+	// RelayState -> outstanding AuthnRequest; one shot ..
+	mu      sync.Mutex
+	pending map[string]samlPending
+}
+
+type samlPending struct {
+	requestID string
+	returnTo  string
+	expires   time.Time
+}
+
+func NewSAMLServiceProvider(cfg SAMLConfig) *SAMLServiceProvider {
+	if cfg.ClockSkew <= 0 {
+		cfg.ClockSkew = 90 * time.Second
+	}
+	a := &cfg.Attributes
+	if a.Email == "" {
+		a.Email = "email"
+	}
+	if a.Name == "" {
+		a.Name = "displayName"
+	}
+	if a.Groups == "" {
+		a.Groups = "groups"
+	}
+	return &SAMLServiceProvider{cfg: cfg, now: time.Now, pending: map[string]samlPending{}}
+}
+
+// StartLogin sends the browser to the IdP. The ACS POST is cross site so
+// no cookie comes back with it; RelayState finds the request instead ..
+func (sp *SAMLServiceProvider) StartLogin(w http.ResponseWriter, r *http.Request, returnTo string) {
+	relay := randomString(24)
+	u, requestID, err := sp.AuthnRequestURL(relay)
+	if err != nil {
+		http.Error(w, "unable to start login", http.StatusInternalServerError)
+		return
+	}
+	sp.mu.Lock()
+	now := sp.now()
+	for k, v := range sp.pending {
+		if now.After(v.expires) {
+			delete(sp.pending, k)
+		}
+	}
+	sp.pending[relay] = samlPending{requestID: requestID, returnTo: returnTo, expires: now.Add(loginTimeout)}
+	sp.mu.Unlock()
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+// ACS handles the IdP's POST; returns the user and where they wanted to go ..
+func (sp *SAMLServiceProvider) ACS(r *http.Request) (User, string, error) {
+	if r.Method != http.MethodPost {
+		return User{}, "", samlError("ACS wants POST")
+	}
+	relay := r.PostFormValue("RelayState")
+	sp.mu.Lock()
+	p, ok := sp.pending[relay]
+	delete(sp.pending, relay)
+	sp.mu.Unlock()
+	if !ok || sp.now().After(p.expires) {
+		return User{}, "", ErrLoginState
+	}
+	user, err := sp.ParseResponse(r.PostFormValue("SAMLResponse"), p.requestID)
+	return user, p.returnTo, err
+}
+
+// Config is for display; e.g. the admin page ..
+func (sp *SAMLServiceProvider) Config() SAMLConfig {
+	return sp.cfg
+}
+
+// Metadata is our SP EntityDescriptor for the IdP admin to import ..
+func (sp *SAMLServiceProvider) Metadata() []byte {
+	doc := etree.NewDocument()
+	ed := doc.CreateElement("md:EntityDescriptor")
+	ed.CreateAttr("xmlns:md", samlMetadataNS)
+	ed.CreateAttr("entityID", sp.cfg.EntityID)
+	sso := ed.CreateElement("md:SPSSODescriptor")
+	sso.CreateAttr("AuthnRequestsSigned", "true")
+	sso.CreateAttr("WantAssertionsSigned", "true")
+	sso.CreateAttr("protocolSupportEnumeration", samlProtocolNS)
+	if sp.cfg.Cert != nil {
+		kd := sso.CreateElement("md:KeyDescriptor")
+		kd.CreateAttr("use", "signing")
+		ki := kd.CreateElement("ds:KeyInfo")
+		ki.CreateAttr("xmlns:ds", dsig.Namespace)
+		ki.CreateElement("ds:X509Data").CreateElement("ds:X509Certificate").
+			SetText(base64.StdEncoding.EncodeToString(sp.cfg.Cert.Raw))
+	}
+	sso.CreateElement("md:NameIDFormat").SetText(samlNameIDFormat)
+	acs := sso.CreateElement("md:AssertionConsumerService")
+	acs.CreateAttr("Binding", samlPOSTBinding)
+	acs.CreateAttr("Location", sp.cfg.ACSURL)
+	acs.CreateAttr("index", "1")
+	doc.Indent(2)
+	b, _ := doc.WriteToBytes()
+	return b
+}
+
+// AuthnRequestURL is where to send the browser; signed HTTP-Redirect
+// binding. Keep the request ID to check InResponseTo on the way back ..
+func (sp *SAMLServiceProvider) AuthnRequestURL(relayState string) (string, string, error) {
+	id := "_" + randomString(20)
+	doc := etree.NewDocument()
+	req := doc.CreateElement("samlp:AuthnRequest")
+	req.CreateAttr("xmlns:samlp", samlProtocolNS)
+	req.CreateAttr("xmlns:saml", samlAssertionNS)
+	req.CreateAttr("ID", id)
+	req.CreateAttr("Version", "2.0")
+	req.CreateAttr("IssueInstant", sp.now().UTC().Format(time.RFC3339))
+	req.CreateAttr("Destination", sp.cfg.IdP.SSOURL)
+	req.CreateAttr("AssertionConsumerServiceURL", sp.cfg.ACSURL)
+	req.CreateAttr("ProtocolBinding", samlPOSTBinding)
+	req.CreateElement("saml:Issuer").SetText(sp.cfg.EntityID)
+	policy := req.CreateElement("samlp:NameIDPolicy")
+	policy.CreateAttr("Format", samlNameIDFormat)
+	policy.CreateAttr("AllowCreate", "true")
+	raw, err := doc.WriteToBytes()
+	if err != nil {
+		return "", "", err
+	}
+
+	var buf bytes.Buffer
+	fw, _ := flate.NewWriter(&buf, flate.BestCompression)
+	fw.Write(raw)
+	fw.Close()
+
+	// Signature is over the query in this exact order ..
+	q := "SAMLRequest=" + url.QueryEscape(base64.StdEncoding.EncodeToString(buf.Bytes()))
+	if relayState != "" {
+		q += "&RelayState=" + url.QueryEscape(relayState)
+	}
+	if sp.cfg.Key != nil {
+		q += "&SigAlg=" + url.QueryEscape(samlSigAlgRSA256)
+		digest := sha256.Sum256([]byte(q))
+		sig, err := rsa.SignPKCS1v15(rand.Reader, sp.cfg.Key, crypto.SHA256, digest[:])
+		if err != nil {
+			return "", "", err
+		}
+		q += "&Signature=" + url.QueryEscape(base64.StdEncoding.EncodeToString(sig))
+	}
+	sep := "?"
+	if strings.Contains(sp.cfg.IdP.SSOURL, "?") {
+		sep = "&"
+	}
+	return sp.cfg.IdP.SSOURL + sep + q, id, nil
+}
+
+func samlError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidAssertion, fmt.Sprintf(format, args...))
+}
+
+func (sp *SAMLServiceProvider) validator() *dsig.ValidationContext {
+	return dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: sp.cfg.IdP.Certs})
+}
+
+// ParseResponse checks the base64 SAMLResponse from the ACS POST and maps it
+// to a User. Only ever reads from the element whose signature checked out;
+// never from the raw document (signature wrapping) ..
+func (sp *SAMLServiceProvider) ParseResponse(samlResponse, requestID string) (User, error) {
+	raw, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil {
+		return User{}, samlError("not base64")
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil {
+		return User{}, samlError("not xml")
+	}
+	root := doc.Root()
+	if root == nil || root.Tag != "Response" || root.NamespaceURI() != samlProtocolNS {
+		return User{}, samlError("not a Response")
+	}
+	if n := len(root.FindElements("./Assertion")); n != 1 {
+		return User{}, samlError("want exactly one assertion; got %d", n)
+	}
+
+	// Either the whole response is signed; or the assertion is ..
+	var assertion *etree.Element
+	response, err := sp.validator().Validate(root)
+	switch {
+	case err == nil:
+		assertion = response.FindElement("./Assertion")
+	case errors.Is(err, dsig.ErrMissingSignature):
+		response = root
+		assertion, err = sp.validator().Validate(root.FindElement("./Assertion"))
+		if err != nil {
+			return User{}, samlError("assertion signature: %v", err)
+		}
+	default:
+		return User{}, samlError("response signature: %v", err)
+	}
+	if assertion == nil || assertion.NamespaceURI() != samlAssertionNS {
+		return User{}, samlError("no assertion")
+	}
+
+	// Response envelope ..
+	if d := response.SelectAttrValue("Destination", ""); d != "" && d != sp.cfg.ACSURL {
+		return User{}, samlError("destination %q", d)
+	}
+	if irt := response.SelectAttrValue("InResponseTo", ""); irt != requestID {
+		return User{}, samlError("InResponseTo %q", irt)
+	}
+	if iss := response.FindElement("./Issuer"); iss != nil && iss.Text() != sp.cfg.IdP.EntityID {
+		return User{}, samlError("response issuer %q", iss.Text())
+	}
+	if st := response.FindElement("./Status/StatusCode"); st == nil || st.SelectAttrValue("Value", "") != samlStatusSuccess {
+		return User{}, samlError("status not success")
+	}
+
+	// Assertion ..
+	now := sp.now()
+	skew := sp.cfg.ClockSkew
+	if iss := assertion.FindElement("./Issuer"); iss == nil || iss.Text() != sp.cfg.IdP.EntityID {
+		return User{}, samlError("assertion issuer")
+	}
+	nameID := assertion.FindElement("./Subject/NameID")
+	if nameID == nil || strings.TrimSpace(nameID.Text()) == "" {
+		return User{}, samlError("no subject")
+	}
+	bearerOK := false
+	for _, sc := range assertion.FindElements("./Subject/SubjectConfirmation") {
+		data := sc.FindElement("./SubjectConfirmationData")
+		if sc.SelectAttrValue("Method", "") != samlBearer || data == nil {
+			continue
+		}
+		notOnOrAfter, err := time.Parse(time.RFC3339, data.SelectAttrValue("NotOnOrAfter", ""))
+		if err != nil || !now.Before(notOnOrAfter.Add(skew)) {
+			continue
+		}
+		if data.SelectAttrValue("Recipient", "") != sp.cfg.ACSURL || data.SelectAttrValue("InResponseTo", "") != requestID {
+			continue
+		}
+		bearerOK = true
+	}
+	if !bearerOK {
+		return User{}, samlError("no valid bearer subject confirmation")
+	}
+	cond := assertion.FindElement("./Conditions")
+	if cond == nil {
+		return User{}, samlError("no conditions")
+	}
+	if nb := cond.SelectAttrValue("NotBefore", ""); nb != "" {
+		t, err := time.Parse(time.RFC3339, nb)
+		if err != nil || now.Add(skew).Before(t) {
+			return User{}, samlError("not yet valid")
+		}
+	}
+	t, err := time.Parse(time.RFC3339, cond.SelectAttrValue("NotOnOrAfter", ""))
+	if err != nil || !now.Before(t.Add(skew)) {
+		return User{}, samlError("expired")
+	}
+	audienceOK := false
+	for _, aud := range cond.FindElements("./AudienceRestriction/Audience") {
+		if strings.TrimSpace(aud.Text()) == sp.cfg.EntityID {
+			audienceOK = true
+		}
+	}
+	if !audienceOK {
+		return User{}, samlError("audience")
+	}
+
+	attrs := map[string][]string{}
+	for _, a := range assertion.FindElements("./AttributeStatement/Attribute") {
+		name := a.SelectAttrValue("Name", "")
+		for _, v := range a.FindElements("./AttributeValue") {
+			attrs[name] = append(attrs[name], strings.TrimSpace(v.Text()))
+		}
+	}
+	first := func(name string) string {
+		if vs := attrs[name]; len(vs) > 0 {
+			return vs[0]
+		}
+		return ""
+	}
+	user := User{
+		ID:       strings.TrimSpace(nameID.Text()),
+		Subject:  strings.TrimSpace(nameID.Text()),
+		Email:    first(sp.cfg.Attributes.Email),
+		Name:     first(sp.cfg.Attributes.Name),
+		Groups:   attrs[sp.cfg.Attributes.Groups],
+		Provider: "saml:" + sp.cfg.TenantID,
+	}
+	if sp.cfg.Attributes.Username != "" {
+		if v := first(sp.cfg.Attributes.Username); v != "" {
+			user.ID = v
+		}
+	}
+	// Whole NameID; the mailbox name alone is anyone's at another domain ..
+	return user, nil
+}
@@ -0,0 +1,176 @@
+package identity
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testSPEntityID = "http://localhost:8888/demo/saml/acme/metadata"
+	testACSURL     = "http://localhost:8888/demo/saml/acme/acs"
+)
+
+var testSAMLUser = MockUser{Username: "alice", Email: "alice@acme.example", Name: "Alice", Groups: []string{"finance", "admins"}}
+
+func newTestSAML(t *testing.T) (*MockSAMLIdP, *SAMLServiceProvider) {
+	idp := NewMockSAMLIdP("http://idp.acme.example", testSAMLUser)
+	md, err := ParseIdPMetadata(idp.Metadata())
+	require.NoError(t, err)
+	key, cert := NewSAMLSigningKey("sp")
+	sp := NewSAMLServiceProvider(SAMLConfig{
+		TenantID: "acme",
+		EntityID: testSPEntityID,
+		ACSURL:   testACSURL,
+		IdP:      md,
+		Key:      key,
+		Cert:     cert,
+	})
+	return idp, sp
+}
+
+func validResponse() samlResponseOptions {
+	return samlResponseOptions{User: testSAMLUser, InResponseTo: "_req1", ACSURL: testACSURL, Audience: testSPEntityID}
+}
+
+func TestSAMLMetadataImport(t *testing.T) {
+	idp, _ := newTestSAML(t)
+	md, err := ParseIdPMetadata(idp.Metadata())
+	require.NoError(t, err)
+	assert.Equal(t, "http://idp.acme.example/metadata", md.EntityID)
+	assert.Equal(t, "http://idp.acme.example/sso", md.SSOURL)
+	require.Len(t, md.Certs, 1)
+	assert.True(t, md.Certs[0].Equal(idp.cert))
+
+	_, err = ParseIdPMetadata([]byte(`<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="x"/>`))
+	assert.Error(t, err)
+}
+
+func TestSAMLAuthnRequestIsSigned(t *testing.T) {
+	_, sp := newTestSAML(t)
+	u, id, err := sp.AuthnRequestURL("/demo/")
+	require.NoError(t, err)
+	parsed, err := url.Parse(u)
+	require.NoError(t, err)
+	assert.Equal(t, "/sso", parsed.Path)
+
+	// Re-create the signed string from the raw query; order matters ..
+	rawQuery := parsed.RawQuery
+	signed := rawQuery[:strings.Index(rawQuery, "&Signature=")]
+	sig, err := base64.StdEncoding.DecodeString(parsed.Query().Get("Signature"))
+	require.NoError(t, err)
+	digest := sha256.Sum256([]byte(signed))
+	assert.NoError(t, rsa.VerifyPKCS1v15(sp.cfg.Cert.PublicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], sig))
+
+	deflated, err := base64.StdEncoding.DecodeString(parsed.Query().Get("SAMLRequest"))
+	require.NoError(t, err)
+	raw, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `ID="`+id+`"`)
+	assert.Contains(t, string(raw), testACSURL)
+}
+
+func TestSAMLParseResponse(t *testing.T) {
+	idp, sp := newTestSAML(t)
+
+	for _, signResponse := range []bool{false, true} {
+		o := validResponse()
+		o.SignResponse = signResponse
+		resp, err := idp.response(o)
+		require.NoError(t, err)
+		user, err := sp.ParseResponse(resp, "_req1")
+		require.NoError(t, err, "signResponse=%v", signResponse)
+		assert.Equal(t, "alice@acme.example", user.ID)
+		assert.Equal(t, "alice@acme.example", user.Email)
+		assert.Equal(t, "Alice", user.Name)
+		assert.Equal(t, []string{"finance", "admins"}, user.Groups)
+		assert.Equal(t, "saml:acme", user.Provider)
+	}
+}
+
+func TestSAMLRejectsBadResponses(t *testing.T) {
+	idp, sp := newTestSAML(t)
+	other := NewMockSAMLIdP("http://idp.acme.example", testSAMLUser)
+
+	cases := map[string]func() (string, error){
+		"unsigned": func() (string, error) {
+			o := validResponse()
+			o.Unsigned = true
+			return idp.response(o)
+		},
+		"wrong audience": func() (string, error) {
+			o := validResponse()
+			o.Audience = "http://someone-else.example"
+			return idp.response(o)
+		},
+		"wrong request": func() (string, error) {
+			o := validResponse()
+			o.InResponseTo = "_other"
+			return idp.response(o)
+		},
+		"expired": func() (string, error) {
+			o := validResponse()
+			o.IssuedAt = time.Now().Add(-time.Hour)
+			return idp.response(o)
+		},
+		"untrusted signer": func() (string, error) {
+			return other.response(validResponse())
+		},
+		"tampered": func() (string, error) {
+			resp, err := idp.response(validResponse())
+			if err != nil {
+				return "", err
+			}
+			raw, _ := base64.StdEncoding.DecodeString(resp)
+			raw = bytes.Replace(raw, []byte("alice@acme.example</saml:NameID>"), []byte("bob@acme.example</saml:NameID>"), 1)
+			return base64.StdEncoding.EncodeToString(raw), nil
+		},
+	}
+	for name, build := range cases {
+		resp, err := build()
+		require.NoError(t, err, name)
+		_, err = sp.ParseResponse(resp, "_req1")
+		assert.ErrorIs(t, err, ErrInvalidAssertion, name)
+	}
+}
+
+func TestSAMLLoginThroughMockIdP(t *testing.T) {
+	idp, sp := newTestSAML(t)
+	u, id, err := sp.AuthnRequestURL("/demo/")
+	require.NoError(t, err)
+	parsed, _ := url.Parse(u)
+
+	rec := httptest.NewRecorder()
+	idp.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sso?"+parsed.RawQuery+"&login_hint=alice", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	m := regexp.MustCompile(`name="SAMLResponse" value="([^"]+)"`).FindStringSubmatch(rec.Body.String())
+	require.Len(t, m, 2)
+
+	user, err := sp.ParseResponse(m[1], id)
+	require.NoError(t, err)
+	assert.Equal(t, "alice@acme.example", user.ID)
+}
+
+func TestSAMLUsernameAttribute(t *testing.T) {
+	idp, sp := newTestSAML(t)
+	sp.cfg.Attributes.Username = "uid"
+	resp, err := idp.response(validResponse())
+	require.NoError(t, err)
+	user, err := sp.ParseResponse(resp, "_req1")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", user.ID)
+	assert.Equal(t, "alice@acme.example", user.Subject)
+}
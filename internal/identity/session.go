@@ -0,0 +1,406 @@
+package identity
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrNoSession is no cookie, a tampered one, or one that timed out ..
+var ErrNoSession = errors.New("no valid session")
+
+// ErrImpersonation is an impersonation that is never allowed e.g. of
+// yourself, from inside another one, or without a ticket ..
+var ErrImpersonation = errors.New("impersonation not allowed")
+
+// Impersonation never outlives this; support gets a fresh ticket after ..
+const MaxImpersonation = time.Hour
+
+// Session is what we know about a logged in browser; lives server side ..
+type Session struct {
+	ID        string `json:"-"`
+	UserID    string
+	Email     string
+	Provider  string
+	CSRFToken string
+	CreatedAt time.Time
+	LastSeen  time.Time
+	// MFAAt is the last step-up in this session; zero if never ..
+	MFAAt time.Time
+	// DeviceTrusted is the device posture at login; feeds context checks ..
+	DeviceTrusted bool
+	// Impersonator is the support user acting as UserID; empty normally ..
+	Impersonator string
+	// Ticket is the support case the impersonation is for ..
+	Ticket string
+	// ImpersonationWrite lets unsafe requests through; read-only otherwise ..
+	ImpersonationWrite bool
+	// ImpersonationEnds; the session is gone after, whatever the timeouts ..
+	ImpersonationEnds time.Time
+}
+
+// Impersonating is support acting as the user ..
+func (s Session) Impersonating() bool {
+	return s.Impersonator != ""
+}
+
+// MFARecent is a step-up within the last maxAge ..
+func (s Session) MFARecent(now time.Time, maxAge time.Duration) bool {
+	return !s.MFAAt.IsZero() && now.Sub(s.MFAAt) <= maxAge
+}
+
+// SessionConfig; zero values get sane defaults ..
+type SessionConfig struct {
+	CookieName string
+	// Key signs the cookie so junk never reaches the store ..
+	Key []byte
+	// IdleTimeout since last request; AbsoluteTimeout since login ..
+	IdleTimeout     time.Duration
+	AbsoluteTimeout time.Duration
+	// Secure cookies; turn on behind TLS ..
+	Secure bool
+	// LoginURL is where Require sends browsers without a session ..
+	LoginURL string
+}
+
+// SessionManager issues opaque session IDs backed by a Store.
+// The cookie carries the ID plus an HMAC; the store is keyed by a hash
+// of the ID so a leaked store does not hand out live cookies ..
+type SessionManager struct {
+	store Store
+	cfg   SessionConfig
+	now   func() time.Time
+}
+
+func NewSessionManager(store Store, cfg SessionConfig) *SessionManager {
+	if cfg.CookieName == "" {
+		cfg.CookieName = "session"
+	}
+	if len(cfg.Key) == 0 {
+		cfg.Key = []byte(randomString(32))
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = 30 * time.Minute
+	}
+	if cfg.AbsoluteTimeout <= 0 {
+		cfg.AbsoluteTimeout = 12 * time.Hour
+	}
+	if cfg.LoginURL == "" {
+		cfg.LoginURL = "/"
+	}
+	return &SessionManager{store: store, cfg: cfg, now: time.Now}
+}
+
+func (m *SessionManager) storeKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return "session:" + base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (m *SessionManager) sign(id string) string {
+	mac := hmac.New(sha256.New, m.cfg.Key)
+	mac.Write([]byte(id))
+	return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (m *SessionManager) unsign(value string) (string, bool) {
+	id, _, ok := strings.Cut(value, ".")
+	if !ok || id == "" {
+		return "", false
+	}
+	return id, hmac.Equal([]byte(m.sign(id)), []byte(value))
+}
+
+// ttl is whichever of idle, absolute or the impersonation runs out first ..
+func (m *SessionManager) ttl(s Session) time.Duration {
+	ttl := m.cfg.IdleTimeout
+	if left := s.CreatedAt.Add(m.cfg.AbsoluteTimeout).Sub(m.now()); left < ttl {
+		ttl = left
+	}
+	if s.Impersonating() {
+		if left := s.ImpersonationEnds.Sub(m.now()); left < ttl {
+			ttl = left
+		}
+	}
+	return ttl
+}
+
+func (m *SessionManager) save(ctx context.Context, s Session) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	ttl := m.ttl(s)
+	if ttl <= 0 {
+		return m.store.Delete(ctx, m.storeKey(s.ID))
+	}
+	return m.store.Set(ctx, m.storeKey(s.ID), b, ttl)
+}
+
+func (m *SessionManager) setCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.cfg.CookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   m.cfg.Secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Create starts a session for a freshly verified login. Any session the
+// browser already had is destroyed; the ID always rotates on login ..
+func (m *SessionManager) Create(ctx context.Context, w http.ResponseWriter, r *http.Request, user User) (Session, error) {
+	if err := ValidUserID(user.ID); err != nil {
+		return Session{}, err
+	}
+	m.destroy(ctx, r)
+	now := m.now()
+	s := Session{
+		ID:        randomString(32),
+		UserID:    user.ID,
+		Email:     user.Email,
+		Provider:  user.Provider,
+		CSRFToken: randomString(32),
+		// Posture is as of login; a new device means a new login ..
+		DeviceTrusted: user.DeviceTrusted,
+		CreatedAt:     now,
+		LastSeen:      now,
+	}
+	if err := m.save(ctx, s); err != nil {
+		return Session{}, err
+	}
+	// Browser cookie lives as long as it possibly could; the server decides ..
+	m.setCookie(w, m.sign(s.ID), int(m.cfg.AbsoluteTimeout.Seconds()))
+	return s, nil
+}
+
+// Impersonation is what support asked for; TTL is capped at MaxImpersonation ..
+type Impersonation struct {
+	Ticket     string
+	AllowWrite bool
+	TTL        time.Duration
+}
+
+// Impersonate swaps the support user's session for one as target; the
+// caller has checked actor may. The support session is dropped; stopping
+// means logging in again as yourself ..
+func (m *SessionManager) Impersonate(ctx context.Context, w http.ResponseWriter, r *http.Request, actor Session, target User, imp Impersonation) (Session, error) {
+	if actor.Impersonating() || ValidUserID(target.ID) != nil || target.ID == actor.UserID || strings.TrimSpace(imp.Ticket) == "" {
+		return Session{}, ErrImpersonation
+	}
+	ttl := imp.TTL
+	if ttl <= 0 || ttl > MaxImpersonation {
+		ttl = MaxImpersonation
+	}
+	m.destroy(ctx, r)
+	now := m.now()
+	s := Session{
+		ID:        randomString(32),
+		UserID:    target.ID,
+		Email:     target.Email,
+		Provider:  "impersonation",
+		CSRFToken: randomString(32),
+		// Still support's device; never the user's MFA ..
+		DeviceTrusted:      actor.DeviceTrusted,
+		Impersonator:       actor.UserID,
+		Ticket:             imp.Ticket,
+		ImpersonationWrite: imp.AllowWrite,
+		ImpersonationEnds:  now.Add(ttl),
+		CreatedAt:          now,
+		LastSeen:           now,
+	}
+	if err := m.save(ctx, s); err != nil {
+		return Session{}, err
+	}
+	m.setCookie(w, m.sign(s.ID), int(ttl.Seconds()))
+	return s, nil
+}
+
+// Load returns the live session and bumps its idle timer ..
+func (m *SessionManager) Load(ctx context.Context, r *http.Request) (Session, error) {
+	c, err := r.Cookie(m.cfg.CookieName)
+	if err != nil {
+		return Session{}, ErrNoSession
+	}
+	id, ok := m.unsign(c.Value)
+	if !ok {
+		return Session{}, ErrNoSession
+	}
+	b, err := m.store.Get(ctx, m.storeKey(id))
+	if errors.Is(err, ErrNotFound) {
+		return Session{}, ErrNoSession
+	}
+	if err != nil {
+		return Session{}, err
+	}
+	var s Session
+	if err := json.Unmarshal(b, &s); err != nil {
+		return Session{}, err
+	}
+	s.ID = id
+	now := m.now()
+	// Store TTLs are not exact (KV rounds up) so check here too ..
+	if now.Sub(s.LastSeen) > m.cfg.IdleTimeout || now.Sub(s.CreatedAt) > m.cfg.AbsoluteTimeout ||
+		(s.Impersonating() && !now.Before(s.ImpersonationEnds)) {
+		_ = m.store.Delete(ctx, m.storeKey(id))
+		return Session{}, ErrNoSession
+	}
+	// Only write back once in a while; saves a store round trip per request ..
+	if now.Sub(s.LastSeen) > m.cfg.IdleTimeout/10 {
+		s.LastSeen = now
+		if err := m.save(ctx, s); err != nil {
+			return Session{}, err
+		}
+	}
+	return s, nil
+}
+
+func (m *SessionManager) destroy(ctx context.Context, r *http.Request) {
+	c, err := r.Cookie(m.cfg.CookieName)
+	if err != nil {
+		return
+	}
+	if id, ok := m.unsign(c.Value); ok {
+		_ = m.store.Delete(ctx, m.storeKey(id))
+	}
+}
+
+// MarkMFA records a step-up just now; the caller has verified the code ..
+func (m *SessionManager) MarkMFA(ctx context.Context, s Session) (Session, error) {
+	s.MFAAt = m.now()
+	s.LastSeen = s.MFAAt
+	return s, m.save(ctx, s)
+}
+
+// Destroy is logout; the session is gone server side, not just the cookie ..
+func (m *SessionManager) Destroy(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	m.destroy(ctx, r)
+	m.setCookie(w, "", -1)
+}
+
+type sessionCtxKey struct{}
+
+// WithSession is what Require does once the session checks out ..
+func WithSession(ctx context.Context, s Session) context.Context {
+	return context.WithValue(ctx, sessionCtxKey{}, s)
+}
+
+// SessionFrom gets the session put there by Require ..
+func SessionFrom(ctx context.Context) (Session, bool) {
+	s, ok := ctx.Value(sessionCtxKey{}).(Session)
+	return s, ok
+}
+
+// Require needs a live session; and a matching CSRF token on anything
+// that is not a safe method ..
+func (m *SessionManager) Require(next http.Handler) http.Handler {
+	return m.require(next, true)
+}
+
+// RequireAPI is Require for JSON APIs; 401 instead of off to the login page ..
+func (m *SessionManager) RequireAPI(next http.Handler) http.Handler {
+	return m.require(next, false)
+}
+
+func (m *SessionManager) require(next http.Handler, redirect bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := m.Load(r.Context(), r)
+		if err != nil {
+			if !errors.Is(err, ErrNoSession) {
+				http.Error(w, "session store unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			if redirect && isSafeMethod(r.Method) {
+				http.Redirect(w, r, m.cfg.LoginURL, http.StatusFound)
+				return
+			}
+			http.Error(w, "not logged in", http.StatusUnauthorized)
+			return
+		}
+		if !CheckCSRF(r, s.CSRFToken) {
+			http.Error(w, "invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithSession(r.Context(), s)))
+	})
+}
+
+// Where the CSRF token may come from; header for htmx / fetch ..
+const (
+	CSRFHeader = "X-CSRF-Token"
+	CSRFField  = "csrf_token"
+)
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// CheckCSRF passes safe methods; anything else must echo the token back ..
+func CheckCSRF(r *http.Request, want string) bool {
+	if isSafeMethod(r.Method) {
+		return true
+	}
+	got := r.Header.Get(CSRFHeader)
+	if got == "" {
+		got = r.PostFormValue(CSRFField)
+	}
+	return want != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// CSRFCookie is double-submit CSRF for pages without a login session
+// (e.g. the batch UI); the token lives in a cookie the page echoes back ..
+type CSRFCookie struct {
+	Name   string
+	Secure bool
+}
+
+func (c CSRFCookie) name() string {
+	if c.Name == "" {
+		return "csrf"
+	}
+	return c.Name
+}
+
+// Token returns the browser's token; issuing one if it has none ..
+func (c CSRFCookie) Token(w http.ResponseWriter, r *http.Request) string {
+	if ck, err := r.Cookie(c.name()); err == nil && len(ck.Value) >= 32 {
+		return ck.Value
+	}
+	token := randomString(32)
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.name(),
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   c.Secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token
+}
+
+// Protect refuses unsafe requests whose token does not match the cookie ..
+func (c CSRFCookie) Protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want := ""
+		if ck, err := r.Cookie(c.name()); err == nil {
+			want = ck.Value
+		}
+		if !CheckCSRF(r, want) {
+			http.Error(w, "invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
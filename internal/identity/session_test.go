@@ -0,0 +1,288 @@
+package identity
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type sessionClock struct {
+	t time.Time
+}
+
+func (c *sessionClock) now() time.Time { return c.t }
+
+func newTestSessions(t *testing.T) (*SessionManager, *MemoryStore, *sessionClock) {
+	clock := &sessionClock{t: time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore()
+	store.now = clock.now
+	m := NewSessionManager(store, SessionConfig{
+		Key:             []byte("test-key"),
+		IdleTimeout:     30 * time.Minute,
+		AbsoluteTimeout: 8 * time.Hour,
+		LoginURL:        "/login",
+	})
+	m.now = clock.now
+	return m, store, clock
+}
+
+func sessionCookie(t *testing.T, rec *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "session" {
+			return c
+		}
+	}
+	t.Fatal("no session cookie")
+	return nil
+}
+
+func requestWith(method, target string, c *http.Cookie) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	if c != nil {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	m, _, clock := newTestSessions(t)
+	ctx := context.Background()
+
+	rec := httptest.NewRecorder()
+	s, err := m.Create(ctx, rec, requestWith(http.MethodGet, "/", nil), User{ID: "bob", Provider: "oidc"})
+	require.NoError(t, err)
+	cookie := sessionCookie(t, rec)
+	assert.True(t, cookie.HttpOnly)
+	assert.NotContains(t, cookie.Value, "bob")
+
+	got, err := m.Load(ctx, requestWith(http.MethodGet, "/", cookie))
+	require.NoError(t, err)
+	assert.Equal(t, "bob", got.UserID)
+	assert.Equal(t, s.CSRFToken, got.CSRFToken)
+
+	// User IDs that would read as typed FGA subjects never get a session ..
+	_, err = m.Create(ctx, httptest.NewRecorder(), requestWith(http.MethodGet, "/", nil), User{ID: "service:k1", Provider: "oidc"})
+	assert.ErrorIs(t, err, ErrInvalidUserID)
+
+	// Tampered cookie never gets as far as the store ..
+	forged := *cookie
+	forged.Value = strings.Replace(cookie.Value, ".", "x.", 1)
+	_, err = m.Load(ctx, requestWith(http.MethodGet, "/", &forged))
+	assert.ErrorIs(t, err, ErrNoSession)
+
+	// Idle timeout ..
+	clock.t = clock.t.Add(31 * time.Minute)
+	_, err = m.Load(ctx, requestWith(http.MethodGet, "/", cookie))
+	assert.ErrorIs(t, err, ErrNoSession)
+}
+
+func TestSessionAbsoluteTimeout(t *testing.T) {
+	m, _, clock := newTestSessions(t)
+	ctx := context.Background()
+	rec := httptest.NewRecorder()
+	_, err := m.Create(ctx, rec, requestWith(http.MethodGet, "/", nil), User{ID: "bob"})
+	require.NoError(t, err)
+	cookie := sessionCookie(t, rec)
+
+	// Active all day long still gets logged out at 8h ..
+	for i := 0; i < 31; i++ {
+		clock.t = clock.t.Add(15 * time.Minute)
+		_, err = m.Load(ctx, requestWith(http.MethodGet, "/", cookie))
+		require.NoError(t, err, "at %d", i)
+	}
+	clock.t = clock.t.Add(16 * time.Minute)
+	_, err = m.Load(ctx, requestWith(http.MethodGet, "/", cookie))
+	assert.ErrorIs(t, err, ErrNoSession)
+}
+
+func TestSessionRotatesOnLoginAndLogoutIsServerSide(t *testing.T) {
+	m, _, _ := newTestSessions(t)
+	ctx := context.Background()
+
+	first := httptest.NewRecorder()
+	_, err := m.Create(ctx, first, requestWith(http.MethodGet, "/", nil), User{ID: "bob"})
+	require.NoError(t, err)
+	old := sessionCookie(t, first)
+
+	second := httptest.NewRecorder()
+	_, err = m.Create(ctx, second, requestWith(http.MethodGet, "/", old), User{ID: "mleow"})
+	require.NoError(t, err)
+	current := sessionCookie(t, second)
+	assert.NotEqual(t, old.Value, current.Value)
+	_, err = m.Load(ctx, requestWith(http.MethodGet, "/", old))
+	assert.ErrorIs(t, err, ErrNoSession)
+
+	// A copy of the cookie is useless after logout ..
+	m.Destroy(ctx, httptest.NewRecorder(), requestWith(http.MethodPost, "/logout", current))
+	_, err = m.Load(ctx, requestWith(http.MethodGet, "/", current))
+	assert.ErrorIs(t, err, ErrNoSession)
+}
+
+func TestSessionImpersonate(t *testing.T) {
+	m, _, clock := newTestSessions(t)
+	ctx := context.Background()
+	rec := httptest.NewRecorder()
+	support, err := m.Create(ctx, rec, requestWith(http.MethodGet, "/", nil), User{ID: "alice", DeviceTrusted: true})
+	require.NoError(t, err)
+	own := sessionCookie(t, rec)
+
+	bob := User{ID: "bob", Email: "bob@example.com"}
+	for _, tc := range []struct {
+		actor  Session
+		target User
+		imp    Impersonation
+	}{
+		{support, bob, Impersonation{}},
+		{support, User{ID: "alice"}, Impersonation{Ticket: "SUP-1"}},
+		{Session{UserID: "alice", Impersonator: "carol"}, bob, Impersonation{Ticket: "SUP-1"}},
+	} {
+		_, err := m.Impersonate(ctx, httptest.NewRecorder(), requestWith(http.MethodPost, "/", own), tc.actor, tc.target, tc.imp)
+		assert.ErrorIs(t, err, ErrImpersonation)
+	}
+
+	rec = httptest.NewRecorder()
+	_, err = m.Impersonate(ctx, rec, requestWith(http.MethodPost, "/", own), support, bob, Impersonation{Ticket: "SUP-1", TTL: 24 * time.Hour})
+	require.NoError(t, err)
+	// Support's own session is gone; no way back but logging in ..
+	_, err = m.Load(ctx, requestWith(http.MethodGet, "/", own))
+	assert.ErrorIs(t, err, ErrNoSession)
+
+	cookie := sessionCookie(t, rec)
+	got, err := m.Load(ctx, requestWith(http.MethodGet, "/", cookie))
+	require.NoError(t, err)
+	assert.True(t, got.Impersonating())
+	assert.Equal(t, "bob", got.UserID)
+	assert.Equal(t, "alice", got.Impersonator)
+	assert.Equal(t, "SUP-1", got.Ticket)
+	assert.False(t, got.ImpersonationWrite)
+	assert.True(t, got.DeviceTrusted)
+
+	// Capped at MaxImpersonation even while active ..
+	for i := 0; i < 3; i++ {
+		clock.t = clock.t.Add(15 * time.Minute)
+		_, err = m.Load(ctx, requestWith(http.MethodGet, "/", cookie))
+		require.NoError(t, err, "at %d", i)
+	}
+	clock.t = clock.t.Add(16 * time.Minute)
+	_, err = m.Load(ctx, requestWith(http.MethodGet, "/", cookie))
+	assert.ErrorIs(t, err, ErrNoSession)
+}
+
+func TestRequireChecksCSRF(t *testing.T) {
+	m, _, _ := newTestSessions(t)
+	rec := httptest.NewRecorder()
+	s, err := m.Create(context.Background(), rec, requestWith(http.MethodGet, "/", nil), User{ID: "bob"})
+	require.NoError(t, err)
+	cookie := sessionCookie(t, rec)
+
+	h := m.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := SessionFrom(r.Context())
+		assert.True(t, ok)
+		io.WriteString(w, got.UserID)
+	}))
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusFound, serve(requestWith(http.MethodGet, "/", nil)).Code)
+	assert.Equal(t, "bob", serve(requestWith(http.MethodGet, "/", cookie)).Body.String())
+
+	post := func(form url.Values) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(cookie)
+		return r
+	}
+	assert.Equal(t, http.StatusForbidden, serve(post(url.Values{"action": {"kil"}})).Code)
+	assert.Equal(t, http.StatusForbidden, serve(post(url.Values{CSRFField: {"guess"}})).Code)
+	assert.Equal(t, http.StatusOK, serve(post(url.Values{CSRFField: {s.CSRFToken}})).Code)
+
+	byHeader := requestWith(http.MethodPost, "/", cookie)
+	byHeader.Header.Set(CSRFHeader, s.CSRFToken)
+	assert.Equal(t, http.StatusOK, serve(byHeader).Code)
+
+	// APIs get told, not sent to a login page ..
+	h = m.RequireAPI(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	assert.Equal(t, http.StatusUnauthorized, serve(requestWith(http.MethodGet, "/", nil)).Code)
+	assert.Equal(t, http.StatusForbidden, serve(requestWith(http.MethodPost, "/", cookie)).Code)
+	assert.Equal(t, http.StatusOK, serve(requestWith(http.MethodGet, "/", cookie)).Code)
+}
+
+func TestCSRFCookieDoubleSubmit(t *testing.T) {
+	var csrf CSRFCookie
+	rec := httptest.NewRecorder()
+	token := csrf.Token(rec, requestWith(http.MethodGet, "/", nil))
+	cookie := rec.Result().Cookies()[0]
+
+	h := csrf.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	r := requestWith(http.MethodPost, "/execute/submit", cookie)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	r = requestWith(http.MethodPost, "/execute/submit", cookie)
+	r.Header.Set(CSRFHeader, token)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestCloudflareKVStore(t *testing.T) {
+	var mu sync.Mutex
+	kv := map[string]string{}
+	var lastTTL string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		prefix := "/accounts/acct/storage/kv/namespaces/ns/values/"
+		if !strings.HasPrefix(r.URL.EscapedPath(), prefix) {
+			http.NotFound(w, r)
+			return
+		}
+		key, _ := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), prefix))
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			b, _ := io.ReadAll(r.Body)
+			kv[key] = string(b)
+			lastTTL = r.URL.Query().Get("expiration_ttl")
+		case http.MethodGet:
+			v, ok := kv[key]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			io.WriteString(w, v)
+		case http.MethodDelete:
+			delete(kv, key)
+		}
+	}))
+	defer server.Close()
+
+	store := NewCloudflareKVStore(CloudflareKVConfig{
+		BaseURL: server.URL, AccountID: "acct", NamespaceID: "ns", APIToken: "tok",
+	})
+	ctx := context.Background()
+	require.NoError(t, store.Set(ctx, "session:a/b", []byte("v1"), 10*time.Second))
+	// KV will not go under a minute ..
+	assert.Equal(t, "60", lastTTL)
+	v, err := store.Get(ctx, "session:a/b")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(v))
+	require.NoError(t, store.Delete(ctx, "session:a/b"))
+	_, err = store.Get(ctx, "session:a/b")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,225 @@
+package identity
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is a key that is missing or has expired ..
+var ErrNotFound = errors.New("not found")
+
+// Store is the KV that sessions (and later API keys) live in.
+// Values expire on their own after ttl ..
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// MemoryStore is for the demo + tests; lost on restart ..
+type MemoryStore struct {
+	mu    sync.Mutex
+	items map[string]memoryItem
+	now   func() time.Time
+}
+
+type memoryItem struct {
+	value   []byte
+	expires time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{items: map[string]memoryItem{}, now: time.Now}
+}
+
+func (m *MemoryStore) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, ok := m.items[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if !item.expires.IsZero() && m.now().After(item.expires) {
+		delete(m.items, key)
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), item.value...), nil
+}
+
+func (m *MemoryStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item := memoryItem{value: append([]byte(nil), value...)}
+	if ttl > 0 {
+		item.expires = m.now().Add(ttl)
+	}
+	m.items[key] = item
+	return nil
+}
+
+func (m *MemoryStore) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.items, key)
+	return nil
+}
+
+// PostgresStore keeps values in one table; expired rows are ignored and
+// swept on write ..
+type PostgresStore struct {
+	pool  *pgxpool.Pool
+	table string
+}
+
+// NewPostgresStore creates the table if needed ..
+func NewPostgresStore(ctx context.Context, pool *pgxpool.Pool, table string) (*PostgresStore, error) {
+	if table == "" {
+		table = "identity_kv"
+	}
+	s := &PostgresStore{pool: pool, table: pgx.Identifier{table}.Sanitize()}
+	_, err := pool.Exec(ctx, `CREATE TABLE IF NOT EXISTS `+s.table+` (
+		key        TEXT PRIMARY KEY,
+		value      BYTEA NOT NULL,
+		expires_at TIMESTAMPTZ
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *PostgresStore) Get(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	err := s.pool.QueryRow(ctx, `SELECT value FROM `+s.table+
+		` WHERE key = $1 AND (expires_at IS NULL OR expires_at > now())`, key).Scan(&value)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+func (s *PostgresStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	var expires *time.Time
+	if ttl > 0 {
+		t := time.Now().Add(ttl)
+		expires = &t
+	}
+	_, err := s.pool.Exec(ctx, `INSERT INTO `+s.table+` (key, value, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at`,
+		key, value, expires)
+	if err != nil {
+		return err
+	}
+	// Opportunistic sweep; cheap with few rows ..
+	_, _ = s.pool.Exec(ctx, `DELETE FROM `+s.table+` WHERE expires_at < now()`)
+	return nil
+}
+
+func (s *PostgresStore) Delete(ctx context.Context, key string) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM `+s.table+` WHERE key = $1`, key)
+	return err
+}
+
+// Cloudflare KV will not expire anything sooner than this ..
+const cloudflareMinTTL = 60 * time.Second
+
+// CloudflareKVConfig points at one Workers KV namespace ..
+type CloudflareKVConfig struct {
+	// BaseURL defaults to https://api.cloudflare.com/client/v4
+	BaseURL     string
+	AccountID   string
+	NamespaceID string
+	APIToken    string
+	HTTPClient  *http.Client
+}
+
+// CloudflareKVStore talks to the Workers KV REST API. KV is eventually
+// consistent across the edge; a deleted session can linger up to a minute ..
+type CloudflareKVStore struct {
+	cfg CloudflareKVConfig
+}
+
+func NewCloudflareKVStore(cfg CloudflareKVConfig) *CloudflareKVStore {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = "https://api.cloudflare.com/client/v4"
+	}
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &CloudflareKVStore{cfg: cfg}
+}
+
+func (s *CloudflareKVStore) valueURL(key string) string {
+	return fmt.Sprintf("%s/accounts/%s/storage/kv/namespaces/%s/values/%s", s.cfg.BaseURL,
+		url.PathEscape(s.cfg.AccountID), url.PathEscape(s.cfg.NamespaceID), url.PathEscape(key))
+}
+
+func (s *CloudflareKVStore) do(ctx context.Context, method, u string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.cfg.APIToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	return s.cfg.HTTPClient.Do(req)
+}
+
+func (s *CloudflareKVStore) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.valueURL(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, ErrNotFound
+	default:
+		return nil, fmt.Errorf("cloudflare kv get: %s", resp.Status)
+	}
+}
+
+func (s *CloudflareKVStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	u := s.valueURL(key)
+	if ttl > 0 {
+		if ttl < cloudflareMinTTL {
+			ttl = cloudflareMinTTL
+		}
+		u += "?expiration_ttl=" + fmt.Sprint(int64(ttl.Seconds()))
+	}
+	resp, err := s.do(ctx, http.MethodPut, u, value)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cloudflare kv put: %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *CloudflareKVStore) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.valueURL(key), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("cloudflare kv delete: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package identity
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TOTP as every authenticator app does it (RFC 6238): SHA1, 6 digits, 30s ..
+const (
+	totpDigits = 6
+	totpPeriod = 30 * time.Second
+	// Steps either side of now that still count; phones drift ..
+	totpSkew = 1
+	// Recovery codes handed out on enrollment; each works once ..
+	recoveryCodeCount = 10
+	// 6 digits are guessable given enough tries; lock out after a few ..
+	mfaMaxFailures = 5
+	mfaLockout     = 5 * time.Minute
+)
+
+var (
+	// ErrInvalidMFACode is a wrong, reused or expired code ..
+	ErrInvalidMFACode = errors.New("invalid mfa code")
+	ErrMFANotEnrolled = errors.New("mfa not enrolled")
+	ErrMFAEnrolled    = errors.New("mfa already enrolled")
+	ErrMFALocked      = errors.New("mfa locked; too many failures")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// hotp is RFC 4226; TOTP is this with the time step as the counter ..
+func hotp(key []byte, counter uint64, digits int) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, code%mod)
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod/time.Second)
+}
+
+// NewTOTPSecret is 160 random bits, base32 as the apps want it ..
+func NewTOTPSecret() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return totpEncoding.EncodeToString(b)
+}
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	return totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+}
+
+// TOTPCode is the code for t; for tests + the mock IdP ..
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, uint64(totpStep(t)), totpDigits), nil
+}
+
+// ValidateTOTP gives back the step the code matched so callers can refuse
+// the same code twice ..
+func ValidateTOTP(secret, code string, t time.Time) (int64, bool) {
+	key, err := decodeTOTPSecret(secret)
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	now := totpStep(t)
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(hotp(key, uint64(step), totpDigits)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPURI is the otpauth:// link the QR code carries ..
+func TOTPURI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(int(totpPeriod/time.Second)))
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + v.Encode()
+}
+
+// mfaRecord is a user's factor; only hashes of the recovery codes are kept ..
+type mfaRecord struct {
+	Secret    string
+	Confirmed bool
+	// LastStep is the last TOTP step used; a code only works once ..
+	LastStep   int64
+	Recovery   []string
+	EnrolledAt time.Time
+	// Failures in a row; LockedUntil once too many ..
+	Failures    int
+	LockedUntil time.Time
+}
+
+// MFAManager enrolls and checks TOTP factors kept in a Store ..
+type MFAManager struct {
+	store  Store
+	issuer string
+	// Serialises read-modify-write of a record (replay + recovery codes) ..
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+func NewMFAManager(store Store, issuer string) *MFAManager {
+	return &MFAManager{store: store, issuer: issuer, now: time.Now}
+}
+
+func mfaKey(user string) string {
+	return "mfa:" + user
+}
+
+func (m *MFAManager) load(ctx context.Context, user string) (mfaRecord, error) {
+	var rec mfaRecord
+	b, err := m.store.Get(ctx, mfaKey(user))
+	if errors.Is(err, ErrNotFound) {
+		return rec, ErrMFANotEnrolled
+	}
+	if err != nil {
+		return rec, err
+	}
+	err = json.Unmarshal(b, &rec)
+	return rec, err
+}
+
+func (m *MFAManager) save(ctx context.Context, user string, rec mfaRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return m.store.Set(ctx, mfaKey(user), b, 0)
+}
+
+// Enrolled is a confirmed factor ..
+func (m *MFAManager) Enrolled(ctx context.Context, user string) (bool, error) {
+	rec, err := m.load(ctx, user)
+	if errors.Is(err, ErrMFANotEnrolled) {
+		return false, nil
+	}
+	return rec.Confirmed, err
+}
+
+// Enroll starts (or restarts) enrollment; nothing counts until Confirm.
+// Returns the secret + otpauth URI to show once ..
+func (m *MFAManager) Enroll(ctx context.Context, user, account string) (string, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec, err := m.load(ctx, user)
+	if err != nil && !errors.Is(err, ErrMFANotEnrolled) {
+		return "", "", err
+	}
+	if rec.Confirmed {
+		return "", "", ErrMFAEnrolled
+	}
+	rec = mfaRecord{Secret: NewTOTPSecret()}
+	if err := m.save(ctx, user, rec); err != nil {
+		return "", "", err
+	}
+	if account == "" {
+		account = user
+	}
+	return rec.Secret, TOTPURI(m.issuer, account, rec.Secret), nil
+}
+
+// Confirm proves the app has the secret; the recovery codes are only ever
+// returned here ..
+func (m *MFAManager) Confirm(ctx context.Context, user, code string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec, err := m.load(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if rec.Confirmed {
+		return nil, ErrMFAEnrolled
+	}
+	step, ok := ValidateTOTP(rec.Secret, code, m.now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+	codes := make([]string, recoveryCodeCount)
+	rec.Recovery = make([]string, recoveryCodeCount)
+	for i := range codes {
+		codes[i] = newRecoveryCode()
+		rec.Recovery[i] = hashSecret(codes[i])
+	}
+	rec.Confirmed = true
+	rec.LastStep = step
+	rec.EnrolledAt = m.now()
+	return codes, m.save(ctx, user, rec)
+}
+
+// newRecoveryCode is xxxxx-xxxxx; easy to read out over the phone ..
+func newRecoveryCode() string {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	s := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+	return s[:5] + "-" + s[5:]
+}
+
+// Verify takes a TOTP code or one of the recovery codes (used up) ..
+func (m *MFAManager) Verify(ctx context.Context, user, code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec, err := m.load(ctx, user)
+	if err != nil {
+		return err
+	}
+	if !rec.Confirmed {
+		return ErrMFANotEnrolled
+	}
+	now := m.now()
+	if now.Before(rec.LockedUntil) {
+		return ErrMFALocked
+	}
+	if step, ok := ValidateTOTP(rec.Secret, code, now); ok && step > rec.LastStep {
+		rec.LastStep = step
+		rec.Failures = 0
+		return m.save(ctx, user, rec)
+	}
+	h := hashSecret(strings.ToLower(strings.TrimSpace(code)))
+	for i, r := range rec.Recovery {
+		if subtle.ConstantTimeCompare([]byte(r), []byte(h)) == 1 {
+			rec.Recovery = append(rec.Recovery[:i], rec.Recovery[i+1:]...)
+			rec.Failures = 0
+			return m.save(ctx, user, rec)
+		}
+	}
+	rec.Failures++
+	if rec.Failures >= mfaMaxFailures {
+		rec.Failures = 0
+		rec.LockedUntil = now.Add(mfaLockout)
+	}
+	if err := m.save(ctx, user, rec); err != nil {
+		return err
+	}
+	return ErrInvalidMFACode
+}
+
+// RecoveryCodesLeft; time to enroll again when it runs out ..
+func (m *MFAManager) RecoveryCodesLeft(ctx context.Context, user string) (int, error) {
+	rec, err := m.load(ctx, user)
+	if err != nil {
+		return 0, err
+	}
+	return len(rec.Recovery), nil
+}
+
+// Reset drops the factor e.g. a lost phone, after an admin checked who it is ..
+func (m *MFAManager) Reset(ctx context.Context, user string) error {
+	return m.store.Delete(ctx, mfaKey(user))
+}
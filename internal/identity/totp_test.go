@@ -0,0 +1,104 @@
+package identity
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHOTPVectors(t *testing.T) {
+	// RFC 6238 appendix B, SHA1 ..
+	key := []byte("12345678901234567890")
+	for unix, want := range map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1234567890:  "89005924",
+		20000000000: "65353130",
+	} {
+		assert.Equal(t, want, hotp(key, uint64(totpStep(time.Unix(unix, 0))), 8), "t=%d", unix)
+	}
+	code, err := TOTPCode(totpEncoding.EncodeToString(key), time.Unix(59, 0))
+	require.NoError(t, err)
+	assert.Equal(t, "287082", code)
+}
+
+func TestMFAEnrollAndVerify(t *testing.T) {
+	ctx := context.Background()
+	clock := &sessionClock{t: time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)}
+	m := NewMFAManager(NewMemoryStore(), "GopherLab")
+	m.now = clock.now
+
+	secret, uri, err := m.Enroll(ctx, "bob", "bob@gopherlab.example")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/GopherLab:bob@gopherlab.example?"))
+	assert.Contains(t, uri, "secret="+secret)
+	enrolled, err := m.Enrolled(ctx, "bob")
+	require.NoError(t, err)
+	assert.False(t, enrolled, "not until confirmed")
+	assert.ErrorIs(t, m.Verify(ctx, "bob", "000000"), ErrMFANotEnrolled)
+
+	_, err = m.Confirm(ctx, "bob", "000000")
+	assert.ErrorIs(t, err, ErrInvalidMFACode)
+	code, _ := TOTPCode(secret, clock.t)
+	recovery, err := m.Confirm(ctx, "bob", code)
+	require.NoError(t, err)
+	assert.Len(t, recovery, recoveryCodeCount)
+	_, _, err = m.Enroll(ctx, "bob", "")
+	assert.ErrorIs(t, err, ErrMFAEnrolled)
+
+	// Same code again is a replay ..
+	assert.ErrorIs(t, m.Verify(ctx, "bob", code), ErrInvalidMFACode)
+	clock.t = clock.t.Add(totpPeriod)
+	code, _ = TOTPCode(secret, clock.t)
+	assert.NoError(t, m.Verify(ctx, "bob", code))
+	// Drifted phone, one step behind, but not older codes ..
+	clock.t = clock.t.Add(totpPeriod * 2)
+	old, _ := TOTPCode(secret, clock.t.Add(-totpPeriod))
+	assert.NoError(t, m.Verify(ctx, "bob", old))
+	clock.t = clock.t.Add(totpPeriod * 3)
+	old, _ = TOTPCode(secret, clock.t.Add(-totpPeriod*2))
+	assert.ErrorIs(t, m.Verify(ctx, "bob", old), ErrInvalidMFACode)
+
+	// Recovery codes work once each ..
+	assert.NoError(t, m.Verify(ctx, "bob", strings.ToUpper(recovery[3])))
+	assert.ErrorIs(t, m.Verify(ctx, "bob", recovery[3]), ErrInvalidMFACode)
+	left, err := m.RecoveryCodesLeft(ctx, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, recoveryCodeCount-1, left)
+
+	// Guessing gets locked out; even the right code waits ..
+	assert.NoError(t, m.Verify(ctx, "bob", recovery[1]))
+	for i := 0; i < mfaMaxFailures; i++ {
+		assert.ErrorIs(t, m.Verify(ctx, "bob", "000000"), ErrInvalidMFACode)
+	}
+	assert.ErrorIs(t, m.Verify(ctx, "bob", recovery[0]), ErrMFALocked)
+	clock.t = clock.t.Add(mfaLockout)
+	assert.NoError(t, m.Verify(ctx, "bob", recovery[0]))
+
+	require.NoError(t, m.Reset(ctx, "bob"))
+	enrolled, err = m.Enrolled(ctx, "bob")
+	require.NoError(t, err)
+	assert.False(t, enrolled)
+}
+
+func TestSessionMarkMFA(t *testing.T) {
+	m, _, clock := newTestSessions(t)
+	ctx := context.Background()
+	rec := httptest.NewRecorder()
+	s, err := m.Create(ctx, rec, requestWith(http.MethodGet, "/", nil), User{ID: "bob"})
+	require.NoError(t, err)
+	assert.False(t, s.MFARecent(clock.t, 10*time.Minute))
+
+	clock.t = clock.t.Add(time.Minute)
+	_, err = m.MarkMFA(ctx, s)
+	require.NoError(t, err)
+	got, err := m.Load(ctx, requestWith(http.MethodGet, "/", sessionCookie(t, rec)))
+	require.NoError(t, err)
+	assert.True(t, got.MFARecent(clock.t, 10*time.Minute))
+	assert.False(t, got.MFARecent(clock.t.Add(11*time.Minute), 10*time.Minute))
+}
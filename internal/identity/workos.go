@@ -0,0 +1,108 @@
+package identity
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// WorkOSConfig for the AuthKit hosted login ..
+type WorkOSConfig struct {
+	// BaseURL defaults to https://api.workos.com
+	BaseURL     string
+	ClientID    string
+	APIKey      string
+	RedirectURL string
+	HTTPClient  *http.Client
+}
+
+// WorkOSProvider adapts WorkOS AuthKit User Management to Provider.
+// The user comes back from a server-to-server authenticate call made
+// with our API key; no ID token to verify so nonce is not used ..
+type WorkOSProvider struct {
+	cfg WorkOSConfig
+}
+
+func NewWorkOSProvider(cfg WorkOSConfig) *WorkOSProvider {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = "https://api.workos.com"
+	}
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &WorkOSProvider{cfg: cfg}
+}
+
+func (p *WorkOSProvider) Name() string {
+	return "workos"
+}
+
+func (p *WorkOSProvider) AuthURL(state, nonce, codeChallenge string) string {
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"provider":              {"authkit"},
+		"state":                 {state},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	return p.cfg.BaseURL + "/user_management/authorize?" + q.Encode()
+}
+
+func (p *WorkOSProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (User, error) {
+	body, err := json.Marshal(map[string]string{
+		"client_id":     p.cfg.ClientID,
+		"client_secret": p.cfg.APIKey,
+		"grant_type":    "authorization_code",
+		"code":          code,
+		"code_verifier": codeVerifier,
+	})
+	if err != nil {
+		return User{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		p.cfg.BaseURL+"/user_management/authenticate", bytes.NewReader(body))
+	if err != nil {
+		return User{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return User{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return User{}, fmt.Errorf("workos authenticate: %s", resp.Status)
+	}
+	var out struct {
+		User struct {
+			ID        string `json:"id"`
+			Email     string `json:"email"`
+			FirstName string `json:"first_name"`
+			LastName  string `json:"last_name"`
+		} `json:"user"`
+		OrganizationID string `json:"organization_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return User{}, err
+	}
+	if out.User.ID == "" || out.User.Email == "" {
+		return User{}, fmt.Errorf("workos authenticate: no user")
+	}
+	// Tuples are keyed by the mailbox name; same as the OIDC path ..
+	id, _, _ := strings.Cut(out.User.Email, "@")
+	return User{
+		ID:       id,
+		Subject:  out.User.ID,
+		Email:    out.User.Email,
+		Name:     strings.TrimSpace(out.User.FirstName + " " + out.User.LastName),
+		Provider: "workos",
+	}, nil
+}
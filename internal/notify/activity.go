@@ -0,0 +1,35 @@
+package notify
+
+import (
+	"context"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+)
+
+// ErrTypePermanent is the application error type of a send not worth
+// retrying ..
+const ErrTypePermanent = "NotificationPermanent"
+
+// Activities is the Temporal side; register on any worker that notifies ..
+type Activities struct {
+	Service *Service
+}
+
+// SendNotificationActivity sends m ..
+func (a *Activities) SendNotificationActivity(ctx context.Context, m Message) error {
+	return Deliver(ctx, a.Service, m)
+}
+
+// Deliver is Send from inside an activity. Without a Key the activity's own
+// identity is it; retries keep it, so a retry only sends what failed ..
+func Deliver(ctx context.Context, s *Service, m Message) error {
+	if m.Key == "" {
+		info := activity.GetInfo(ctx)
+		m.Key = info.WorkflowExecution.ID + "/" + info.WorkflowExecution.RunID + "/" + info.ActivityID
+	}
+	err := s.Send(ctx, m)
+	if IsPermanent(err) {
+		return temporal.NewNonRetryableApplicationError(err.Error(), ErrTypePermanent, err)
+	}
+	return err
+}
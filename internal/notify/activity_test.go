@@ -0,0 +1,51 @@
+package notify
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+	"testing"
+	"time"
+)
+
+// notifyWorkflow sends one message with Temporal retrying it ..
+func notifyWorkflow(ctx workflow.Context, m Message) error {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Second * 10,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval: time.Second,
+			MaximumAttempts: 3,
+		},
+	})
+	var a *Activities
+	return workflow.ExecuteActivity(ctx, a.SendNotificationActivity, m).Get(ctx, nil)
+}
+
+func TestSendNotificationActivity(t *testing.T) {
+	s, email, hook := testService(t)
+	ts := testsuite.WorkflowTestSuite{}
+	env := ts.NewTestWorkflowEnvironment()
+	env.RegisterActivity(&Activities{Service: s})
+
+	// Webhook fails once; the retry is the same activity so the same key and
+	// email is not sent again ..
+	hook.fail = []error{errors.New("503")}
+	env.ExecuteWorkflow(notifyWorkflow, Message{To: "bob", Subject: "s", Body: "b"})
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+	require.Len(t, email.sent, 1)
+	assert.Len(t, hook.sent, 1)
+	assert.Equal(t, email.sent[0].Key, hook.sent[0].Key)
+
+	// Permanent is not retried ..
+	env = ts.NewTestWorkflowEnvironment()
+	env.RegisterActivity(&Activities{Service: s})
+	env.ExecuteWorkflow(notifyWorkflow, Message{Key: "k", To: "bob", Kind: KindGrantExpiring})
+	var appErr *temporal.ApplicationError
+	require.True(t, errors.As(env.GetWorkflowError(), &appErr))
+	assert.True(t, appErr.NonRetryable())
+	assert.Equal(t, ErrTypePermanent, appErr.Type())
+}
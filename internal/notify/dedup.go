@@ -0,0 +1,75 @@
+package notify
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrInFlight is a key someone else is sending right now; try again later ..
+var ErrInFlight = errors.New("notification is being sent")
+
+// Deduper remembers which keys went out. Claim before sending; then Done on
+// success or Release so a retry can have another go ..
+type Deduper interface {
+	// Claim is true if the key is the caller's to send; false once sent ..
+	Claim(key string) (bool, error)
+	Done(key string)
+	Release(key string)
+}
+
+// MemoryDeduper keeps keys for TTL; longer than Temporal retries a
+// notification for. Only dedupes within the process ..
+type MemoryDeduper struct {
+	TTL time.Duration
+	// Now is for tests; time.Now if nil ..
+	Now func() time.Time
+
+	mu       sync.Mutex
+	sent     map[string]time.Time
+	inFlight map[string]bool
+}
+
+func NewMemoryDeduper(ttl time.Duration) *MemoryDeduper {
+	return &MemoryDeduper{TTL: ttl, sent: map[string]time.Time{}, inFlight: map[string]bool{}}
+}
+
+func (m *MemoryDeduper) now() time.Time {
+	if m.Now != nil {
+		return m.Now()
+	}
+	return time.Now()
+}
+
+func (m *MemoryDeduper) Claim(key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	// Forget what is past the TTL while here ..
+	for k, at := range m.sent {
+		if now.Sub(at) > m.TTL {
+			delete(m.sent, k)
+		}
+	}
+	if _, ok := m.sent[key]; ok {
+		return false, nil
+	}
+	if m.inFlight[key] {
+		return false, ErrInFlight
+	}
+	m.inFlight[key] = true
+	return true, nil
+}
+
+func (m *MemoryDeduper) Done(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.inFlight, key)
+	m.sent[key] = m.now()
+}
+
+func (m *MemoryDeduper) Release(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.inFlight, key)
+}
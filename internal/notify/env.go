@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// dedupTTL; well past how long Temporal keeps retrying a notification ..
+const dedupTTL = 24 * time.Hour
+
+// LoadPreferences reads a JSON list of preferences ..
+func LoadPreferences(path string) (*MemoryPreferences, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var prefs []Preference
+	if err := json.Unmarshal(b, &prefs); err != nil {
+		return nil, err
+	}
+	return NewMemoryPreferences(prefs...), nil
+}
+
+// FromEnv is the Service the env configures; just the log without any.
+// NOTIFY_SMTP_ADDR, NOTIFY_SMTP_FROM [NOTIFY_SMTP_USER, NOTIFY_SMTP_PASSWORD]
+// NOTIFY_WEBHOOK_SECRET signs generic webhooks; slack needs nothing
+// NOTIFY_PREFERENCES is a JSON file of per-user preferences
+// NOTIFY_DEFAULT_CHANNELS for everyone else e.g. log,email ..
+func FromEnv() (*Service, error) {
+	tmpl, err := NewTemplates(DefaultTemplates)
+	if err != nil {
+		return nil, err
+	}
+	s := &Service{
+		Channels: map[string]Channel{
+			ChannelLog:   LogChannel{},
+			ChannelSlack: Slack{},
+		},
+		Templates:   tmpl,
+		Dedup:       NewMemoryDeduper(dedupTTL),
+		Preferences: NewMemoryPreferences(),
+		Default:     Preference{Channels: []string{ChannelLog}},
+	}
+	if addr := os.Getenv("NOTIFY_SMTP_ADDR"); addr != "" {
+		ch := SMTP{Addr: addr, From: os.Getenv("NOTIFY_SMTP_FROM")}
+		if user := os.Getenv("NOTIFY_SMTP_USER"); user != "" {
+			host := addr
+			if i := strings.LastIndex(addr, ":"); i >= 0 {
+				host = addr[:i]
+			}
+			ch.Auth = smtp.PlainAuth("", user, os.Getenv("NOTIFY_SMTP_PASSWORD"), host)
+		}
+		s.Channels[ChannelEmail] = ch
+	}
+	if secret := os.Getenv("NOTIFY_WEBHOOK_SECRET"); secret != "" {
+		s.Channels[ChannelWebhook] = Webhook{Secret: []byte(secret)}
+	}
+	if path := os.Getenv("NOTIFY_PREFERENCES"); path != "" {
+		prefs, err := LoadPreferences(path)
+		if err != nil {
+			return nil, err
+		}
+		s.Preferences = prefs
+	}
+	if chans := os.Getenv("NOTIFY_DEFAULT_CHANNELS"); chans != "" {
+		s.Default.Channels = strings.Split(chans, ",")
+	}
+	return s, nil
+}
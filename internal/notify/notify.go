@@ -0,0 +1,263 @@
+// Package notify gets messages to people over the channels they picked:
+// email, signed webhooks or a chat incoming-webhook. Called from Temporal
+// activities; Temporal does the retrying, Key keeps a retry from sending
+// twice ..
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Channel names as used in preferences ..
+const (
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+	ChannelSlack   = "slack"
+	ChannelLog     = "log"
+)
+
+var (
+	ErrNoChannels = errors.New("no channels for notification")
+	ErrNoAddress  = errors.New("no address for channel")
+)
+
+// Message is one notification to a user. With a Kind the templates make the
+// subject + body from Data; without one Subject + Body go out as is ..
+type Message struct {
+	// Key is unique per message; the same Key is never sent twice on a
+	// channel ..
+	Key     string
+	To      string
+	Kind    string
+	Data    map[string]string
+	Subject string
+	Body    string
+}
+
+// Delivery is a rendered message on its way out of one channel ..
+type Delivery struct {
+	Key     string
+	Kind    string
+	User    string
+	Address string
+	Subject string
+	Body    string
+	Data    map[string]string
+}
+
+// Channel delivers; errors are retried unless Permanent ..
+type Channel interface {
+	Send(ctx context.Context, d Delivery) error
+}
+
+// Preference is how a user wants to hear about things ..
+type Preference struct {
+	User string `json:"user"`
+	// Channels for every kind not in Kinds ..
+	Channels []string `json:"channels"`
+	// Kinds overrides Channels per kind; an empty list mutes the kind ..
+	Kinds map[string][]string `json:"kinds,omitempty"`
+	// Addresses per channel e.g. email -> bob@example.com, slack -> hook URL ..
+	Addresses map[string]string `json:"addresses,omitempty"`
+}
+
+// ChannelsFor is where kind goes ..
+func (p Preference) ChannelsFor(kind string) []string {
+	if chans, ok := p.Kinds[kind]; ok {
+		return chans
+	}
+	return p.Channels
+}
+
+// Preferences looks up a user's; ok false if they never set any ..
+type Preferences interface {
+	Preference(ctx context.Context, user string) (Preference, bool, error)
+}
+
+// MemoryPreferences is Preferences in a map ..
+type MemoryPreferences struct {
+	mu    sync.RWMutex
+	prefs map[string]Preference
+}
+
+func NewMemoryPreferences(prefs ...Preference) *MemoryPreferences {
+	m := &MemoryPreferences{prefs: map[string]Preference{}}
+	for _, p := range prefs {
+		m.prefs[p.User] = p
+	}
+	return m
+}
+
+func (m *MemoryPreferences) Preference(ctx context.Context, user string) (Preference, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.prefs[user]
+	return p, ok, nil
+}
+
+// Set replaces the user's preference ..
+func (m *MemoryPreferences) Set(p Preference) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prefs[p.User] = p
+}
+
+// permanentError is a failure a retry will not fix ..
+type permanentError struct {
+	err error
+}
+
+func (p permanentError) Error() string { return p.err.Error() }
+func (p permanentError) Unwrap() error { return p.err }
+
+// Permanent marks err as not worth retrying e.g. a 4xx from a webhook ..
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// IsPermanent is whether err was marked Permanent ..
+func IsPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+// Service sends messages; zero Dedup sends on every call ..
+type Service struct {
+	Channels    map[string]Channel
+	Preferences Preferences
+	Templates   *Templates
+	Dedup       Deduper
+	// Default is for users without a preference; User is filled in ..
+	Default Preference
+}
+
+// preference is the user's or the default with their name on it ..
+func (s *Service) preference(ctx context.Context, user string) (Preference, error) {
+	if s.Preferences != nil {
+		p, ok, err := s.Preferences.Preference(ctx, user)
+		if err != nil {
+			return p, err
+		}
+		if ok {
+			return p, nil
+		}
+	}
+	p := s.Default
+	p.User = user
+	return p, nil
+}
+
+// Send delivers m on each of the user's channels for its kind. Channels
+// that already took this Key are skipped; the error is Permanent only when
+// nothing failed that a retry could fix ..
+func (s *Service) Send(ctx context.Context, m Message) error {
+	if m.Key == "" {
+		return Permanent(errors.New("notification has no key"))
+	}
+	subject, body := m.Subject, m.Body
+	if m.Kind != "" && s.Templates != nil && s.Templates.Has(m.Kind) {
+		var err error
+		if subject, body, err = s.Templates.Render(m.Kind, m.Data); err != nil {
+			return Permanent(err)
+		}
+	}
+	pref, err := s.preference(ctx, m.To)
+	if err != nil {
+		return err
+	}
+	chans := pref.ChannelsFor(m.Kind)
+	if _, set := pref.Kinds[m.Kind]; set && len(chans) == 0 {
+		// Muted ..
+		return nil
+	}
+	if len(chans) == 0 {
+		return Permanent(fmt.Errorf("%w: %s", ErrNoChannels, m.To))
+	}
+
+	var errs []error
+	retry := false
+	for _, name := range sortedUnique(chans) {
+		err := s.sendOn(ctx, name, pref, Delivery{
+			Key:     m.Key,
+			Kind:    m.Kind,
+			User:    m.To,
+			Address: pref.Addresses[name],
+			Subject: subject,
+			Body:    body,
+			Data:    m.Data,
+		})
+		if err == nil {
+			continue
+		}
+		var p permanentError
+		if errors.As(err, &p) {
+			err = p.err
+		} else {
+			retry = true
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", name, err))
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	if retry {
+		return errors.Join(errs...)
+	}
+	return Permanent(errors.Join(errs...))
+}
+
+// sendOn is one channel once per Key ..
+func (s *Service) sendOn(ctx context.Context, name string, pref Preference, d Delivery) error {
+	ch, ok := s.Channels[name]
+	if !ok {
+		return Permanent(fmt.Errorf("unknown channel %q", name))
+	}
+	if d.Address == "" && name != ChannelLog {
+		return Permanent(fmt.Errorf("%w %s: %s", ErrNoAddress, name, pref.User))
+	}
+	key := d.Key + "/" + name
+	if s.Dedup != nil {
+		mine, err := s.Dedup.Claim(key)
+		if err != nil || !mine {
+			return err
+		}
+	}
+	if err := ch.Send(ctx, d); err != nil {
+		if s.Dedup != nil {
+			s.Dedup.Release(key)
+		}
+		return err
+	}
+	if s.Dedup != nil {
+		s.Dedup.Done(key)
+	}
+	return nil
+}
+
+func sortedUnique(names []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, n := range names {
+		if !seen[n] {
+			seen[n] = true
+			out = append(out, n)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// LogChannel prints; for when nothing else is set up ..
+type LogChannel struct{}
+
+func (LogChannel) Send(ctx context.Context, d Delivery) error {
+	fmt.Println("NOTIFY:", d.User, "SUBJECT:", d.Subject, "BODY:", d.Body)
+	return nil
+}
@@ -0,0 +1,140 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// fakeChannel records deliveries; fails while fail has errors left ..
+type fakeChannel struct {
+	sent []Delivery
+	fail []error
+}
+
+func (f *fakeChannel) Send(ctx context.Context, d Delivery) error {
+	if len(f.fail) > 0 {
+		err := f.fail[0]
+		f.fail = f.fail[1:]
+		return err
+	}
+	f.sent = append(f.sent, d)
+	return nil
+}
+
+func testService(t *testing.T) (*Service, *fakeChannel, *fakeChannel) {
+	t.Helper()
+	tmpl, err := NewTemplates(DefaultTemplates)
+	require.NoError(t, err)
+	email, hook := &fakeChannel{}, &fakeChannel{}
+	return &Service{
+		Channels:  map[string]Channel{ChannelEmail: email, ChannelWebhook: hook, ChannelLog: LogChannel{}},
+		Templates: tmpl,
+		Dedup:     NewMemoryDeduper(dedupTTL),
+		Preferences: NewMemoryPreferences(Preference{
+			User:      "bob",
+			Channels:  []string{ChannelEmail, ChannelWebhook},
+			Kinds:     map[string][]string{KindAccessReminder: nil, KindBatchFailed: {ChannelWebhook}},
+			Addresses: map[string]string{ChannelEmail: "bob@gopherlab.example", ChannelWebhook: "https://hooks.example/bob"},
+		}),
+		Default: Preference{Channels: []string{ChannelLog}},
+	}, email, hook
+}
+
+func TestServiceSend(t *testing.T) {
+	s, email, hook := testService(t)
+	ctx := context.Background()
+
+	require.NoError(t, s.Send(ctx, Message{Key: "k1", To: "bob", Kind: KindAccessRequested,
+		Data: map[string]string{"doc": "secret/x.doc", "requester": "alice", "reason": "audit"}}))
+	require.Len(t, email.sent, 1)
+	require.Len(t, hook.sent, 1)
+	assert.Equal(t, "Access requested: secret/x.doc", email.sent[0].Subject)
+	assert.Equal(t, "alice asks for access to secret/x.doc: audit", email.sent[0].Body)
+	assert.Equal(t, "bob@gopherlab.example", email.sent[0].Address)
+	assert.Equal(t, "https://hooks.example/bob", hook.sent[0].Address)
+
+	// Same key again is a no-op ..
+	require.NoError(t, s.Send(ctx, Message{Key: "k1", To: "bob", Kind: KindAccessRequested,
+		Data: map[string]string{"doc": "secret/x.doc", "requester": "alice", "reason": "audit"}}))
+	assert.Len(t, email.sent, 1)
+
+	// Muted kind; per kind channel ..
+	require.NoError(t, s.Send(ctx, Message{Key: "k2", To: "bob", Kind: KindAccessReminder,
+		Data: map[string]string{"count": "1", "approver": "bob", "items": "x"}}))
+	require.NoError(t, s.Send(ctx, Message{Key: "k3", To: "bob", Kind: KindBatchFailed,
+		Data: map[string]string{"script": "s.py", "function": "f", "workflow": "wf", "error": "boom"}}))
+	assert.Len(t, email.sent, 1)
+	assert.Len(t, hook.sent, 2)
+
+	// No preference; the default. No kind; as given ..
+	require.NoError(t, s.Send(ctx, Message{Key: "k4", To: "mleow", Subject: "hi", Body: "there"}))
+}
+
+func TestTemplatesAccessDecided(t *testing.T) {
+	tmpl, err := NewTemplates(DefaultTemplates)
+	require.NoError(t, err)
+
+	// Rejections have no end date; that is not missing data ..
+	subject, body, err := tmpl.Render(KindAccessDecided, map[string]string{"doc": "secret/x.doc", "owner": "bob", "decision": "rejected"})
+	require.NoError(t, err)
+	assert.Equal(t, "Access rejected: secret/x.doc", subject)
+	assert.Equal(t, "bob rejected your request for secret/x.doc.", body)
+
+	_, body, err = tmpl.Render(KindAccessDecided, map[string]string{"doc": "secret/x.doc", "owner": "bob", "decision": "approved", "until": "Mon, 19 Oct 2026 15:00:00 UTC"})
+	require.NoError(t, err)
+	assert.Equal(t, "bob approved your request for secret/x.doc. Access ends Mon, 19 Oct 2026 15:00:00 UTC.", body)
+
+	_, _, err = tmpl.Render(KindAccessDecided, map[string]string{"doc": "secret/x.doc", "decision": "approved"})
+	assert.Error(t, err)
+}
+
+func TestServiceSendRetry(t *testing.T) {
+	s, email, hook := testService(t)
+	ctx := context.Background()
+	msg := Message{Key: "k1", To: "bob", Subject: "s", Body: "b"}
+
+	// Webhook down; email got it and the retry must not send it again ..
+	hook.fail = []error{errors.New("503")}
+	err := s.Send(ctx, msg)
+	require.Error(t, err)
+	assert.False(t, IsPermanent(err))
+	require.NoError(t, s.Send(ctx, msg))
+	assert.Len(t, email.sent, 1)
+	assert.Len(t, hook.sent, 1)
+
+	// Only permanent failures; no point retrying ..
+	hook.fail = []error{Permanent(errors.New("410"))}
+	err = s.Send(ctx, Message{Key: "k2", To: "bob", Subject: "s", Body: "b"})
+	assert.True(t, IsPermanent(err))
+	// Mixed; retry for the one that can get better ..
+	email.fail = []error{errors.New("timeout")}
+	hook.fail = []error{Permanent(errors.New("410"))}
+	err = s.Send(ctx, Message{Key: "k3", To: "bob", Subject: "s", Body: "b"})
+	assert.Error(t, err)
+	assert.False(t, IsPermanent(err))
+
+	// Missing template data, no key, unknown channel ..
+	assert.True(t, IsPermanent(s.Send(ctx, Message{Key: "k4", To: "bob", Kind: KindAccessRequested})))
+	assert.True(t, IsPermanent(s.Send(ctx, Message{To: "bob", Subject: "s"})))
+	s.Default.Channels = []string{"pager"}
+	assert.True(t, IsPermanent(s.Send(ctx, Message{Key: "k5", To: "mleow", Subject: "s"})))
+}
+
+func TestMemoryDeduper(t *testing.T) {
+	d := NewMemoryDeduper(dedupTTL)
+	mine, err := d.Claim("k")
+	require.NoError(t, err)
+	assert.True(t, mine)
+	_, err = d.Claim("k")
+	assert.ErrorIs(t, err, ErrInFlight)
+	d.Release("k")
+	mine, _ = d.Claim("k")
+	assert.True(t, mine)
+	d.Done("k")
+	mine, err = d.Claim("k")
+	assert.NoError(t, err)
+	assert.False(t, mine)
+}
@@ -0,0 +1,161 @@
+package notify
+
+import (
+	"bufio"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+)
+
+// SinkMessage is one email the sink took ..
+type SinkMessage struct {
+	From string
+	To   []string
+	// Data is the message as sent, headers + body ..
+	Data string
+}
+
+// Header is a header of the message; "" if it does not parse ..
+func (m SinkMessage) Header(name string) string {
+	msg, err := mail.ReadMessage(strings.NewReader(m.Data))
+	if err != nil {
+		return ""
+	}
+	return msg.Header.Get(name)
+}
+
+// SMTPSink is an in-process SMTP server keeping what it is sent; for tests
+// + the demo when no relay is set. No TLS, no auth, no relaying ..
+type SMTPSink struct {
+	ln net.Listener
+	// Reject makes RCPT TO fail with this reply e.g. "450 mailbox busy" ..
+	Reject string
+
+	mu       sync.Mutex
+	messages []SinkMessage
+	wg       sync.WaitGroup
+}
+
+// NewSMTPSink listens on addr e.g. 127.0.0.1:0 ..
+func NewSMTPSink(addr string) (*SMTPSink, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &SMTPSink{ln: ln}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr is where to point SMTP at ..
+func (s *SMTPSink) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Messages so far ..
+func (s *SMTPSink) Messages() []SinkMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SinkMessage(nil), s.messages...)
+}
+
+func (s *SMTPSink) Close() error {
+	err := s.ln.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *SMTPSink) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.session(conn)
+		}()
+	}
+}
+
+func (s *SMTPSink) session(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	reply := func(line string) bool {
+		_, err := conn.Write([]byte(line + "\r\n"))
+		return err == nil
+	}
+	if !reply("220 sink ESMTP") {
+		return
+	}
+	var msg SinkMessage
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			reply("250-sink\r\n250 8BITMIME")
+		case "HELO":
+			reply("250 sink")
+		case "MAIL":
+			msg = SinkMessage{From: addrArg(arg)}
+			reply("250 ok")
+		case "RCPT":
+			if s.Reject != "" {
+				reply(s.Reject)
+				continue
+			}
+			msg.To = append(msg.To, addrArg(arg))
+			reply("250 ok")
+		case "DATA":
+			if !reply("354 end with .") {
+				return
+			}
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" || l == ".\n" {
+					break
+				}
+				// Undo the dot-stuffing ..
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			msg.Data = data.String()
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			reply("250 queued")
+		case "RSET":
+			msg = SinkMessage{}
+			reply("250 ok")
+		case "NOOP":
+			reply("250 ok")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// addrArg is the address in FROM:<a@b> / TO:<a@b> ..
+func addrArg(arg string) string {
+	_, a, _ := strings.Cut(arg, ":")
+	a = strings.TrimSpace(a)
+	if i := strings.IndexByte(a, ' '); i >= 0 {
+		a = a[:i]
+	}
+	return strings.Trim(a, "<>")
+}
@@ -0,0 +1,138 @@
+package notify
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// SMTP sends email; STARTTLS when the server offers it. Key becomes the
+// Message-ID so mail systems can spot a duplicate too ..
+type SMTP struct {
+	// Addr is host:port ..
+	Addr string
+	From string
+	// Auth is optional; net/smtp refuses PLAIN without TLS except to localhost ..
+	Auth smtp.Auth
+	// Hostname for EHLO + Message-IDs; localhost if empty ..
+	Hostname string
+	// TLS is for STARTTLS; ServerName is the Addr host if nil ..
+	TLS *tls.Config
+}
+
+func (s SMTP) hostname() string {
+	if s.Hostname != "" {
+		return s.Hostname
+	}
+	return "localhost"
+}
+
+// messageID is stable for a key ..
+func (s SMTP) messageID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "<" + hex.EncodeToString(sum[:16]) + "@" + s.hostname() + ">"
+}
+
+// headerSafe; a newline in a header is someone else's header ..
+func headerSafe(values ...string) error {
+	for _, v := range values {
+		if strings.ContainsAny(v, "\r\n") {
+			return Permanent(errors.New("newline in mail header"))
+		}
+	}
+	return nil
+}
+
+// Compose is the message as sent; CRLF line ends ..
+func (s SMTP) Compose(d Delivery, now time.Time) ([]byte, error) {
+	if err := headerSafe(s.From, d.Address, d.Subject); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	b.WriteString("From: " + s.From + "\r\n")
+	b.WriteString("To: " + d.Address + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", d.Subject) + "\r\n")
+	b.WriteString("Date: " + now.Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("Message-ID: " + s.messageID(d.Key) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	body := strings.ReplaceAll(d.Body, "\r\n", "\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String()), nil
+}
+
+func (s SMTP) Send(ctx context.Context, d Delivery) error {
+	msg, err := s.Compose(d, time.Now())
+	if err != nil {
+		return err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	host, _, _ := net.SplitHostPort(s.Addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return smtpError(err)
+	}
+	defer c.Close()
+	if err := c.Hello(s.hostname()); err != nil {
+		return smtpError(err)
+	}
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		cfg := s.TLS
+		if cfg == nil {
+			cfg = &tls.Config{ServerName: host}
+		}
+		if err := c.StartTLS(cfg); err != nil {
+			return smtpError(err)
+		}
+	}
+	if s.Auth != nil {
+		if err := c.Auth(s.Auth); err != nil {
+			return smtpError(err)
+		}
+	}
+	if err := c.Mail(s.From); err != nil {
+		return smtpError(err)
+	}
+	if err := c.Rcpt(d.Address); err != nil {
+		return smtpError(err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return smtpError(err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return smtpError(err)
+	}
+	if err := w.Close(); err != nil {
+		return smtpError(err)
+	}
+	return c.Quit()
+}
+
+// smtpError; 5xx replies will not get better with a retry ..
+func smtpError(err error) error {
+	var tp *textproto.Error
+	if errors.As(err, &tp) && tp.Code >= 500 {
+		return Permanent(fmt.Errorf("smtp %d: %s", tp.Code, tp.Msg))
+	}
+	return err
+}
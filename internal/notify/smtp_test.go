@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSMTPToSink(t *testing.T) {
+	sink, err := NewSMTPSink("127.0.0.1:0")
+	require.NoError(t, err)
+	defer sink.Close()
+
+	ch := SMTP{Addr: sink.Addr(), From: "authz@gopherlab.example", Hostname: "gopherlab.example"}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	d := Delivery{Key: "wf/run/7", User: "bob", Address: "bob@gopherlab.example",
+		Subject: "Access ending: x.doc", Body: "Your access ends soon.\n.hidden dot line"}
+	require.NoError(t, ch.Send(ctx, d))
+
+	msgs := sink.Messages()
+	require.Len(t, msgs, 1)
+	assert.Equal(t, "authz@gopherlab.example", msgs[0].From)
+	assert.Equal(t, []string{"bob@gopherlab.example"}, msgs[0].To)
+	assert.Equal(t, "Access ending: x.doc", msgs[0].Header("Subject"))
+	assert.Equal(t, ch.messageID("wf/run/7"), msgs[0].Header("Message-Id"))
+	assert.Contains(t, msgs[0].Data, "\r\n.hidden dot line")
+
+	// Header injection ..
+	d.Subject = "x\r\nBcc: eve@example.com"
+	assert.True(t, IsPermanent(ch.Send(ctx, d)))
+	assert.Len(t, sink.Messages(), 1)
+}
+
+func TestSMTPReplies(t *testing.T) {
+	sink, err := NewSMTPSink("127.0.0.1:0")
+	require.NoError(t, err)
+	defer sink.Close()
+	ch := SMTP{Addr: sink.Addr(), From: "authz@gopherlab.example"}
+	d := Delivery{Key: "k", Address: "bob@gopherlab.example", Subject: "s", Body: "b"}
+
+	sink.Reject = "450 mailbox busy"
+	err = ch.Send(context.Background(), d)
+	require.Error(t, err)
+	assert.False(t, IsPermanent(err))
+
+	sink.Reject = "550 no such user"
+	err = ch.Send(context.Background(), d)
+	assert.True(t, IsPermanent(err))
+	assert.True(t, strings.Contains(err.Error(), "550"))
+	assert.Empty(t, sink.Messages())
+}
@@ -0,0 +1,68 @@
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Kinds the workflows send ..
+const (
+	KindAccessRequested = "access.requested"
+	KindAccessReminder  = "access.reminder"
+	KindAccessDecided   = "access.decided"
+	KindGrantExpiring   = "grant.expiring"
+	KindBatchFailed     = "batch.failed"
+)
+
+// DefaultTemplates are the messages for the kinds above; text, one line of
+// subject then the body. Missing data is an error, not "<no value>"; keys
+// that may be left out go through index ..
+var DefaultTemplates = map[string]string{
+	KindAccessRequested: `Access requested: {{.doc}}
+{{.requester}} asks for access to {{.doc}}: {{.reason}}`,
+	KindAccessReminder: `Reminder: {{.count}} access requests waiting
+Still waiting on {{.approver}}: {{.items}}`,
+	KindAccessDecided: `Access {{.decision}}: {{.doc}}
+{{.owner}} {{.decision}} your request for {{.doc}}.{{with index . "until"}} Access ends {{.}}.{{end}}`,
+	KindGrantExpiring: `Access ending: {{.doc}}
+Your access to {{.doc}} ends {{.until}}. Ask {{.owner}} if you still need it.`,
+	KindBatchFailed: `Batch failed: {{.script}}
+{{.script}} ({{.function}}) failed in {{.workflow}}: {{.error}}`,
+}
+
+// Templates renders kinds into subject + body ..
+type Templates struct {
+	t *template.Template
+}
+
+// NewTemplates parses kind -> "subject\nbody" ..
+func NewTemplates(texts map[string]string) (*Templates, error) {
+	root := template.New("").Option("missingkey=error")
+	for kind, text := range texts {
+		if _, err := root.New(kind).Parse(text); err != nil {
+			return nil, fmt.Errorf("template %s: %w", kind, err)
+		}
+	}
+	return &Templates{t: root}, nil
+}
+
+// Has is whether there is a template for kind ..
+func (t *Templates) Has(kind string) bool {
+	return t.t.Lookup(kind) != nil
+}
+
+// Render is kind filled in with data ..
+func (t *Templates) Render(kind string, data map[string]string) (string, string, error) {
+	var sb strings.Builder
+	// missingkey only works on maps of interface{} ..
+	values := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		values[k] = v
+	}
+	if err := t.t.ExecuteTemplate(&sb, kind, values); err != nil {
+		return "", "", fmt.Errorf("template %s: %w", kind, err)
+	}
+	subject, body, _ := strings.Cut(sb.String(), "\n")
+	return strings.TrimSpace(subject), strings.TrimSpace(body), nil
+}
@@ -0,0 +1,110 @@
+package notify
+
+import (
+	"app/internal/identity"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Webhook headers; the signature is identity.SignWebhook's t=<unix ms>,
+// v1=<hex hmac> so receivers check it with identity.VerifyWebhook ..
+const (
+	WebhookSignatureHeader = "Notify-Signature"
+	IdempotencyKeyHeader   = "Idempotency-Key"
+)
+
+// WebhookPayload is what a generic webhook gets ..
+type WebhookPayload struct {
+	ID      string            `json:"id"`
+	Kind    string            `json:"kind,omitempty"`
+	User    string            `json:"user"`
+	Subject string            `json:"subject"`
+	Body    string            `json:"body"`
+	Data    map[string]string `json:"data,omitempty"`
+	SentAt  time.Time         `json:"sent_at"`
+}
+
+// Webhook POSTs signed JSON to the user's URL; the Key goes along as the
+// Idempotency-Key + id ..
+type Webhook struct {
+	Secret []byte
+	Client *http.Client
+}
+
+func (h Webhook) Send(ctx context.Context, d Delivery) error {
+	body, err := json.Marshal(WebhookPayload{
+		ID:      d.Key,
+		Kind:    d.Kind,
+		User:    d.User,
+		Subject: d.Subject,
+		Body:    d.Body,
+		Data:    d.Data,
+		SentAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		return Permanent(err)
+	}
+	if len(h.Secret) == 0 {
+		return Permanent(fmt.Errorf("webhook has no signing secret"))
+	}
+	return post(ctx, h.Client, d.Address, body, map[string]string{
+		WebhookSignatureHeader: identity.SignWebhook(h.Secret, body, time.Now()),
+		IdempotencyKeyHeader:   d.Key,
+	})
+}
+
+// SlackPayload is an incoming-webhook message; Slack, Mattermost, Rocket.Chat
+// and friends take it ..
+type SlackPayload struct {
+	Text string `json:"text"`
+}
+
+// Slack posts to the user's incoming-webhook URL; the URL is the secret ..
+type Slack struct {
+	Client *http.Client
+}
+
+func (s Slack) Send(ctx context.Context, d Delivery) error {
+	body, err := json.Marshal(SlackPayload{Text: "*" + d.Subject + "*\n" + d.Body})
+	if err != nil {
+		return Permanent(err)
+	}
+	return post(ctx, s.Client, d.Address, body, nil)
+}
+
+// post is a JSON POST; 4xx but 408 + 429 will not get better with a retry ..
+func post(ctx context.Context, client *http.Client, url string, body []byte, headers map[string]string) error {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("webhook %s: %s", req.URL.Host, resp.Status)
+	switch {
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests:
+		return err
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return Permanent(err)
+	}
+	return err
+}
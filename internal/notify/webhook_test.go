@@ -0,0 +1,70 @@
+package notify
+
+import (
+	"app/internal/identity"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookSigned(t *testing.T) {
+	secret := []byte("whsec")
+	var got WebhookPayload
+	var key string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := identity.VerifyWebhook(secret, r.Header.Get(WebhookSignatureHeader), body, time.Now(), time.Minute); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		key = r.Header.Get(IdempotencyKeyHeader)
+		json.Unmarshal(body, &got)
+	}))
+	defer srv.Close()
+
+	d := Delivery{Key: "wf/run/3", Kind: KindAccessDecided, User: "alice", Address: srv.URL,
+		Subject: "Access approved: x.doc", Body: "bob approved", Data: map[string]string{"doc": "x.doc"}}
+	require.NoError(t, Webhook{Secret: secret}.Send(context.Background(), d))
+	assert.Equal(t, "wf/run/3", key)
+	assert.Equal(t, "wf/run/3", got.ID)
+	assert.Equal(t, "alice", got.User)
+	assert.Equal(t, "x.doc", got.Data["doc"])
+
+	// Wrong secret is a 401; retrying will not fix it ..
+	err := Webhook{Secret: []byte("other")}.Send(context.Background(), d)
+	assert.True(t, IsPermanent(err))
+	assert.True(t, IsPermanent(Webhook{}.Send(context.Background(), d)))
+}
+
+func TestSlackAndStatuses(t *testing.T) {
+	status := http.StatusOK
+	var got SlackPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	d := Delivery{Key: "k", Address: srv.URL, Subject: "Batch failed: s.py", Body: "boom"}
+
+	require.NoError(t, Slack{}.Send(context.Background(), d))
+	assert.Equal(t, "*Batch failed: s.py*\nboom", got.Text)
+
+	for code, permanent := range map[int]bool{
+		http.StatusNotFound:            true,
+		http.StatusTooManyRequests:     false,
+		http.StatusServiceUnavailable:  false,
+		http.StatusRequestTimeout:      false,
+		http.StatusInternalServerError: false,
+	} {
+		status = code
+		err := Slack{}.Send(context.Background(), d)
+		require.Error(t, err, code)
+		assert.Equal(t, permanent, IsPermanent(err), code)
+	}
+}
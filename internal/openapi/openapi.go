@@ -0,0 +1,196 @@
+// Package openapi builds an OpenAPI 3.1 document from the same route table
+// the handlers are served from, so the spec can not drift from the code.
+// Schemas come from the Go request / response types by reflection ..
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Security schemes every operation accepts; bearer API key or the session ..
+const (
+	BearerAuth = "bearerAuth"
+	CookieAuth = "cookieAuth"
+)
+
+// Param is a path, query or header parameter ..
+type Param struct {
+	Name        string
+	In          string // path, query or header
+	Description string
+	Required    bool
+}
+
+// Operation is one route; the zero Request is no body ..
+type Operation struct {
+	Method  string
+	Path    string // e.g. /api/v1/documents/{id}
+	ID      string
+	Summary string
+	// Scope an API key needs; sessions are not scoped ..
+	Scope    string
+	Params   []Param
+	Request  interface{}
+	Response interface{}
+	// Status on success; 200 if zero ..
+	Status int
+	// Errors it can answer with; all have the Error body ..
+	Errors []int
+}
+
+// Info is the spec's title + version ..
+type Info struct {
+	Title       string
+	Version     string
+	Description string
+}
+
+// ErrorBody is what every error response carries ..
+type ErrorBody struct {
+	Error string `json:"error"`
+}
+
+// Spec is the OpenAPI document for ops; ready for json.Marshal ..
+func Spec(info Info, cookieName string, ops []Operation) map[string]interface{} {
+	paths := map[string]interface{}{}
+	for _, op := range ops {
+		item, _ := paths[op.Path].(map[string]interface{})
+		if item == nil {
+			item = map[string]interface{}{}
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = operation(op)
+	}
+	return map[string]interface{}{
+		"openapi": "3.1.0",
+		"info": map[string]interface{}{
+			"title":       info.Title,
+			"version":     info.Version,
+			"description": info.Description,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"Error": Schema(reflect.TypeOf(ErrorBody{})),
+			},
+			"securitySchemes": map[string]interface{}{
+				BearerAuth: map[string]interface{}{"type": "http", "scheme": "bearer"},
+				CookieAuth: map[string]interface{}{"type": "apiKey", "in": "cookie", "name": cookieName},
+			},
+		},
+	}
+}
+
+func operation(op Operation) map[string]interface{} {
+	out := map[string]interface{}{
+		"operationId": op.ID,
+		"summary":     op.Summary,
+	}
+	scopes := []string{}
+	if op.Scope != "" {
+		scopes = append(scopes, op.Scope)
+	}
+	out["security"] = []interface{}{
+		map[string]interface{}{BearerAuth: scopes},
+		map[string]interface{}{CookieAuth: []string{}},
+	}
+	if len(op.Params) > 0 {
+		params := make([]interface{}, 0, len(op.Params))
+		for _, p := range op.Params {
+			params = append(params, map[string]interface{}{
+				"name":        p.Name,
+				"in":          p.In,
+				"description": p.Description,
+				"required":    p.Required || p.In == "path",
+				"schema":      map[string]interface{}{"type": "string"},
+			})
+		}
+		out["parameters"] = params
+	}
+	if op.Request != nil {
+		out["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  jsonContent(Schema(reflect.TypeOf(op.Request))),
+		}
+	}
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	ok := map[string]interface{}{"description": http.StatusText(status)}
+	if op.Response != nil {
+		ok["content"] = jsonContent(Schema(reflect.TypeOf(op.Response)))
+	}
+	responses := map[string]interface{}{strconv.Itoa(status): ok}
+	errs := append([]int(nil), op.Errors...)
+	sort.Ints(errs)
+	for _, code := range errs {
+		responses[strconv.Itoa(code)] = map[string]interface{}{
+			"description": http.StatusText(code),
+			"content":     jsonContent(map[string]interface{}{"$ref": "#/components/schemas/Error"}),
+		}
+	}
+	out["responses"] = responses
+	return out
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Schema is the JSON schema of t as encoding/json would write it ..
+func Schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return map[string]interface{}{"type": "array", "items": Schema(t.Elem())}
+	case t.Kind() == reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": Schema(t.Elem())}
+	case t.Kind() == reflect.Struct:
+		props := map[string]interface{}{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = Schema(f.Type)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		s := map[string]interface{}{"type": "object", "properties": props}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	}
+	// interface{} and anything odd; say nothing about it ..
+	return map[string]interface{}{}
+}
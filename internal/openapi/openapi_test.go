@@ -0,0 +1,64 @@
+package openapi
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testDoc struct {
+	ID      string            `json:"id"`
+	Tags    []string          `json:"tags,omitempty"`
+	Size    int64             `json:"size"`
+	At      time.Time         `json:"at"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Skipped string            `json:"-"`
+	hidden  string
+}
+
+func TestSchema(t *testing.T) {
+	s := Schema(reflect.TypeOf(&testDoc{}))
+	assert.Equal(t, "object", s["type"])
+	assert.Equal(t, []string{"id", "size", "at"}, s["required"])
+	props := s["properties"].(map[string]interface{})
+	assert.Len(t, props, 5)
+	assert.Equal(t, map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}, props["tags"])
+	assert.Equal(t, map[string]interface{}{"type": "string", "format": "date-time"}, props["at"])
+	assert.Equal(t, "integer", props["size"].(map[string]interface{})["type"])
+}
+
+func TestSpec(t *testing.T) {
+	spec := Spec(Info{Title: "Test", Version: "1"}, "session", []Operation{
+		{Method: http.MethodGet, Path: "/docs/{id}", ID: "getDoc", Scope: "docs:read",
+			Params: []Param{{Name: "id", In: "path"}}, Response: testDoc{}, Errors: []int{404, 403}},
+		{Method: http.MethodPut, Path: "/docs/{id}", ID: "putDoc", Request: testDoc{}, Status: http.StatusAccepted},
+	})
+	b, err := json.Marshal(spec)
+	require.NoError(t, err)
+	var got struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			OperationID string                            `json:"operationId"`
+			Security    []map[string][]string             `json:"security"`
+			Parameters  []map[string]interface{}          `json:"parameters"`
+			RequestBody map[string]interface{}            `json:"requestBody"`
+			Responses   map[string]map[string]interface{} `json:"responses"`
+		} `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(b, &got))
+	assert.Equal(t, "3.1.0", got.OpenAPI)
+	get := got.Paths["/docs/{id}"]["get"]
+	assert.Equal(t, "getDoc", get.OperationID)
+	assert.Equal(t, []string{"docs:read"}, get.Security[0][BearerAuth])
+	assert.Equal(t, true, get.Parameters[0]["required"])
+	assert.Contains(t, get.Responses, "200")
+	assert.Contains(t, get.Responses, "403")
+	assert.Contains(t, get.Responses, "404")
+	put := got.Paths["/docs/{id}"]["put"]
+	assert.NotNil(t, put.RequestBody)
+	assert.Contains(t, put.Responses, "202")
+}
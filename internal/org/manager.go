@@ -0,0 +1,393 @@
+package org
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Invitations are good for a week unless told otherwise ..
+const defaultInviteTTL = 7 * 24 * time.Hour
+
+// Manager is the org lifecycle; it checks the actor's role, writes the tuples
+// and then the record. Tuples go first so a retry after a failed save is
+// harmless (writes of existing tuples are ignored) ..
+type Manager struct {
+	repo      Repository
+	relations Relations
+	secret    []byte
+	InviteTTL time.Duration
+	// Serialises role changes; last owner checks need it ..
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// NewManager; an empty secret gets a random one, so links die with the process ..
+func NewManager(repo Repository, relations Relations, secret []byte) *Manager {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(err)
+		}
+	}
+	return &Manager{repo: repo, relations: relations, secret: secret, InviteTTL: defaultInviteTTL, now: time.Now}
+}
+
+// Role is the user's role in the org; "" if not a member ..
+func (m *Manager) Role(ctx context.Context, orgID, user string) (string, error) {
+	mem, err := m.repo.GetMember(ctx, orgID, user)
+	if errors.Is(err, ErrNotFound) {
+		return "", nil
+	}
+	return mem.Role, err
+}
+
+// requireRole is the actor holding at least role ..
+func (m *Manager) requireRole(ctx context.Context, orgID, actor, role string) (Membership, error) {
+	mem, err := m.repo.GetMember(ctx, orgID, actor)
+	if errors.Is(err, ErrNotFound) {
+		return mem, fmt.Errorf("%w: %s is not in %s", ErrForbidden, actor, orgID)
+	}
+	if err != nil {
+		return mem, err
+	}
+	if roleRank[mem.Role] < roleRank[role] {
+		return mem, fmt.Errorf("%w: %s needs %s in %s", ErrForbidden, actor, role, orgID)
+	}
+	return mem, nil
+}
+
+func (m *Manager) owners(ctx context.Context, orgID string) (int, error) {
+	members, err := m.repo.ListMembers(ctx, orgID)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, mem := range members {
+		if mem.Role == RoleOwner {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// CreateOrg makes the org with owner as its first owner ..
+func (m *Manager) CreateOrg(ctx context.Context, id, name, owner string) (Organization, error) {
+	if id == "" || owner == "" || strings.ContainsAny(id, ":#/ ") {
+		return Organization{}, fmt.Errorf("org needs an ID without ':#/ ' and an owner")
+	}
+	if name == "" {
+		name = id
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o := Organization{ID: id, Name: name, CreatedBy: owner, CreatedAt: m.now()}
+	if _, err := m.repo.GetOrg(ctx, id); err == nil {
+		return Organization{}, ErrConflict
+	}
+	if err := m.relations.AddOrgRole(id, owner, RoleOwner); err != nil {
+		return Organization{}, err
+	}
+	if err := m.repo.CreateOrg(ctx, o); err != nil {
+		return Organization{}, err
+	}
+	return o, m.repo.PutMember(ctx, Membership{OrgID: id, User: owner, Role: RoleOwner, InvitedBy: owner, JoinedAt: o.CreatedAt})
+}
+
+// EnsureMember adds user with role unless already in; for the directory +
+// seeding, where the IdP is the authority so there is no actor ..
+func (m *Manager) EnsureMember(ctx context.Context, orgID, user, role string) error {
+	if !ValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.repo.GetOrg(ctx, orgID); err != nil {
+		return err
+	}
+	if _, err := m.repo.GetMember(ctx, orgID, user); err == nil {
+		return nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if err := m.relations.AddOrgRole(orgID, user, role); err != nil {
+		return err
+	}
+	return m.repo.PutMember(ctx, Membership{OrgID: orgID, User: user, Role: role, JoinedAt: m.now()})
+}
+
+// Invite offers email a role; only owners can invite owners. The token is
+// only ever returned here; it is what goes in the email ..
+func (m *Manager) Invite(ctx context.Context, orgID, actor, email, role string) (Invitation, string, error) {
+	if !ValidRole(role) {
+		return Invitation{}, "", fmt.Errorf("unknown role %q", role)
+	}
+	if !strings.Contains(email, "@") {
+		return Invitation{}, "", fmt.Errorf("invitation needs an email")
+	}
+	need := RoleAdmin
+	if role == RoleOwner {
+		need = RoleOwner
+	}
+	if _, err := m.requireRole(ctx, orgID, actor, need); err != nil {
+		return Invitation{}, "", err
+	}
+	now := m.now()
+	inv := Invitation{
+		ID:        newID(),
+		OrgID:     orgID,
+		Email:     strings.ToLower(strings.TrimSpace(email)),
+		Role:      role,
+		InvitedBy: actor,
+		Status:    InvitePending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(m.InviteTTL),
+	}
+	if err := m.repo.CreateInvitation(ctx, inv); err != nil {
+		return Invitation{}, "", err
+	}
+	return inv, signInvitation(m.secret, inv.ID, inv.ExpiresAt), nil
+}
+
+// Invitation is the pending invitation behind a token e.g. to show who is
+// inviting before accepting ..
+func (m *Manager) Invitation(ctx context.Context, token string) (Invitation, error) {
+	id, err := verifyInvitation(m.secret, token, m.now())
+	if err != nil {
+		return Invitation{}, err
+	}
+	inv, err := m.repo.GetInvitation(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return inv, ErrInvalidInvitation
+	}
+	if err != nil {
+		return inv, err
+	}
+	if inv.Status != InvitePending || !m.now().Before(inv.ExpiresAt) {
+		return inv, ErrInvalidInvitation
+	}
+	return inv, nil
+}
+
+// decide loads the invitation for the user with that (verified) email; a
+// forwarded link is no good to anyone else ..
+func (m *Manager) decide(ctx context.Context, token, user, email string) (Invitation, error) {
+	inv, err := m.Invitation(ctx, token)
+	if err != nil {
+		return inv, err
+	}
+	if user == "" || !strings.EqualFold(inv.Email, strings.TrimSpace(email)) {
+		return inv, fmt.Errorf("%w: invitation is for %s", ErrForbidden, inv.Email)
+	}
+	inv.DecidedBy = user
+	inv.DecidedAt = m.now()
+	return inv, nil
+}
+
+// Accept joins the org; someone already in keeps the higher of the two roles ..
+func (m *Manager) Accept(ctx context.Context, token, user, email string) (Membership, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inv, err := m.decide(ctx, token, user, email)
+	if err != nil {
+		return Membership{}, err
+	}
+	mem, err := m.repo.GetMember(ctx, inv.OrgID, user)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		mem = Membership{OrgID: inv.OrgID, User: user, InvitedBy: inv.InvitedBy, JoinedAt: inv.DecidedAt}
+	case err != nil:
+		return Membership{}, err
+	}
+	if roleRank[inv.Role] > roleRank[mem.Role] {
+		if err := m.relations.AddOrgRole(inv.OrgID, user, inv.Role); err != nil {
+			return Membership{}, err
+		}
+		old := mem.Role
+		mem.Role = inv.Role
+		if err := m.repo.PutMember(ctx, mem); err != nil {
+			return Membership{}, err
+		}
+		m.dropRole(inv.OrgID, user, old)
+	}
+	inv.Status = InviteAccepted
+	return mem, m.repo.UpdateInvitation(ctx, inv)
+}
+
+// Decline turns the invitation down; the token is dead after ..
+func (m *Manager) Decline(ctx context.Context, token, user, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inv, err := m.decide(ctx, token, user, email)
+	if err != nil {
+		return err
+	}
+	inv.Status = InviteDeclined
+	return m.repo.UpdateInvitation(ctx, inv)
+}
+
+// RevokeInvitation withdraws a pending invitation ..
+func (m *Manager) RevokeInvitation(ctx context.Context, orgID, actor, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.requireRole(ctx, orgID, actor, RoleAdmin); err != nil {
+		return err
+	}
+	inv, err := m.repo.GetInvitation(ctx, id)
+	if err != nil {
+		return err
+	}
+	if inv.OrgID != orgID {
+		return ErrNotFound
+	}
+	if inv.Status != InvitePending {
+		return fmt.Errorf("invitation already %s", inv.Status)
+	}
+	inv.Status = InviteRevoked
+	inv.DecidedBy = actor
+	inv.DecidedAt = m.now()
+	return m.repo.UpdateInvitation(ctx, inv)
+}
+
+// SetRole changes a member's role; admins manage members + admins, only
+// owners touch owners ..
+func (m *Manager) SetRole(ctx context.Context, orgID, actor, user, role string) error {
+	if !ValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mem, err := m.repo.GetMember(ctx, orgID, user)
+	if err != nil {
+		return err
+	}
+	if mem.Role == role {
+		return nil
+	}
+	need := RoleAdmin
+	if role == RoleOwner || mem.Role == RoleOwner {
+		need = RoleOwner
+	}
+	if _, err := m.requireRole(ctx, orgID, actor, need); err != nil {
+		return err
+	}
+	if mem.Role == RoleOwner {
+		if n, err := m.owners(ctx, orgID); err != nil {
+			return err
+		} else if n <= 1 {
+			return ErrLastOwner
+		}
+	}
+	if err := m.relations.AddOrgRole(orgID, user, role); err != nil {
+		return err
+	}
+	old := mem.Role
+	mem.Role = role
+	if err := m.repo.PutMember(ctx, mem); err != nil {
+		return err
+	}
+	m.dropRole(orgID, user, old)
+	return nil
+}
+
+// RemoveMember takes user out of the org; anyone can leave, admins remove
+// members + admins, only owners remove owners ..
+func (m *Manager) RemoveMember(ctx context.Context, orgID, actor, user string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mem, err := m.repo.GetMember(ctx, orgID, user)
+	if err != nil {
+		return err
+	}
+	if actor != user {
+		need := RoleAdmin
+		if mem.Role == RoleOwner {
+			need = RoleOwner
+		}
+		if _, err := m.requireRole(ctx, orgID, actor, need); err != nil {
+			return err
+		}
+	}
+	return m.remove(ctx, mem)
+}
+
+// Evict is RemoveMember for the directory / deprovisioning; no actor, but
+// the last owner still stays ..
+func (m *Manager) Evict(ctx context.Context, orgID, user string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mem, err := m.repo.GetMember(ctx, orgID, user)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return m.remove(ctx, mem)
+}
+
+func (m *Manager) remove(ctx context.Context, mem Membership) error {
+	if mem.Role == RoleOwner {
+		if n, err := m.owners(ctx, mem.OrgID); err != nil {
+			return err
+		} else if n <= 1 {
+			return ErrLastOwner
+		}
+	}
+	if err := m.relations.RemoveOrgRole(mem.OrgID, mem.User, mem.Role); err != nil {
+		// Already gone is fine; the record is what we fix up ..
+		fmt.Println("ORG-TUPLE-ERR: ", mem.OrgID, mem.User, mem.Role, err)
+	}
+	return m.repo.DeleteMember(ctx, mem.OrgID, mem.User)
+}
+
+// dropRole removes the tuple of a role just replaced ..
+func (m *Manager) dropRole(orgID, user, role string) {
+	if role == "" {
+		return
+	}
+	if err := m.relations.RemoveOrgRole(orgID, user, role); err != nil {
+		fmt.Println("ORG-TUPLE-ERR: ", orgID, user, role, err)
+	}
+}
+
+// Members of the org, owners first ..
+func (m *Manager) Members(ctx context.Context, orgID string) ([]Membership, error) {
+	members, err := m.repo.ListMembers(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		if roleRank[members[i].Role] != roleRank[members[j].Role] {
+			return roleRank[members[i].Role] > roleRank[members[j].Role]
+		}
+		return members[i].User < members[j].User
+	})
+	return members, nil
+}
+
+// Invitations of the org still waiting for an answer ..
+func (m *Manager) Invitations(ctx context.Context, orgID string) ([]Invitation, error) {
+	all, err := m.repo.ListInvitations(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	now := m.now()
+	var pending []Invitation
+	for _, inv := range all {
+		if inv.Status == InvitePending && now.Before(inv.ExpiresAt) {
+			pending = append(pending, inv)
+		}
+	}
+	return pending, nil
+}
+
+// Orgs the user belongs to ..
+func (m *Manager) Orgs(ctx context.Context, user string) ([]Membership, error) {
+	return m.repo.ListMemberships(ctx, user)
+}
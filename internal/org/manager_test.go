@@ -0,0 +1,149 @@
+package org
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"testing"
+	"time"
+)
+
+// fakeRelations is the OpenFGA side; org|user|role ..
+type fakeRelations map[string]bool
+
+func (f fakeRelations) AddOrgRole(org, user, role string) error {
+	f[org+"|"+user+"|"+role] = true
+	return nil
+}
+
+func (f fakeRelations) RemoveOrgRole(org, user, role string) error {
+	delete(f, org+"|"+user+"|"+role)
+	return nil
+}
+
+func (f fakeRelations) tuples() []string {
+	var out []string
+	for k := range f {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func testManager(t *testing.T) (*Manager, fakeRelations, *time.Time) {
+	rel := fakeRelations{}
+	m := NewManager(NewMemoryRepository(), rel, []byte("invite-secret"))
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	_, err := m.CreateOrg(context.Background(), "GopherLab", "Gopher Lab", "mleow")
+	require.NoError(t, err)
+	return m, rel, &now
+}
+
+func TestInvitationFlow(t *testing.T) {
+	ctx := context.Background()
+	m, rel, now := testManager(t)
+
+	_, _, err := m.Invite(ctx, "GopherLab", "bob", "alice@gopherlab.example", RoleMember)
+	assert.ErrorIs(t, err, ErrForbidden, "non-members can not invite")
+
+	inv, token, err := m.Invite(ctx, "GopherLab", "mleow", "Alice@GopherLab.example", RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, "alice@gopherlab.example", inv.Email)
+
+	got, err := m.Invitation(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, inv.ID, got.ID)
+
+	// Forwarded link; someone else's login ..
+	_, err = m.Accept(ctx, token, "mallory", "mallory@gopherlab.example")
+	assert.ErrorIs(t, err, ErrForbidden)
+	_, err = m.Accept(ctx, token[:len(token)-2]+"xx", "alice", "alice@gopherlab.example")
+	assert.ErrorIs(t, err, ErrInvalidInvitation)
+
+	mem, err := m.Accept(ctx, token, "alice", "alice@gopherlab.example")
+	require.NoError(t, err)
+	assert.Equal(t, RoleAdmin, mem.Role)
+	assert.Equal(t, "mleow", mem.InvitedBy)
+	// Single use ..
+	_, err = m.Accept(ctx, token, "alice", "alice@gopherlab.example")
+	assert.ErrorIs(t, err, ErrInvalidInvitation)
+
+	// Admins invite members, not owners ..
+	_, _, err = m.Invite(ctx, "GopherLab", "alice", "carol@gopherlab.example", RoleOwner)
+	assert.ErrorIs(t, err, ErrForbidden)
+	_, token, err = m.Invite(ctx, "GopherLab", "alice", "carol@gopherlab.example", RoleMember)
+	require.NoError(t, err)
+	require.NoError(t, m.Decline(ctx, token, "carol", "carol@gopherlab.example"))
+	_, err = m.Accept(ctx, token, "carol", "carol@gopherlab.example")
+	assert.ErrorIs(t, err, ErrInvalidInvitation)
+
+	// Expired ..
+	_, token, err = m.Invite(ctx, "GopherLab", "alice", "dave@gopherlab.example", RoleMember)
+	require.NoError(t, err)
+	*now = now.Add(defaultInviteTTL + time.Minute)
+	_, err = m.Accept(ctx, token, "dave", "dave@gopherlab.example")
+	assert.ErrorIs(t, err, ErrInvalidInvitation)
+
+	pending, err := m.Invitations(ctx, "GopherLab")
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+	assert.Equal(t, []string{"GopherLab|alice|admin", "GopherLab|mleow|owner"}, rel.tuples())
+}
+
+func TestRolesAndRemoval(t *testing.T) {
+	ctx := context.Background()
+	m, rel, _ := testManager(t)
+	require.NoError(t, m.EnsureMember(ctx, "GopherLab", "bob", RoleMember))
+	require.NoError(t, m.EnsureMember(ctx, "GopherLab", "alice", RoleMember))
+	// Already in; the directory can not demote ..
+	require.NoError(t, m.EnsureMember(ctx, "GopherLab", "mleow", RoleMember))
+
+	assert.ErrorIs(t, m.SetRole(ctx, "GopherLab", "bob", "alice", RoleAdmin), ErrForbidden)
+	require.NoError(t, m.SetRole(ctx, "GopherLab", "mleow", "alice", RoleAdmin))
+	// Admins do not touch owners ..
+	assert.ErrorIs(t, m.SetRole(ctx, "GopherLab", "alice", "mleow", RoleMember), ErrForbidden)
+	assert.ErrorIs(t, m.SetRole(ctx, "GopherLab", "alice", "bob", RoleOwner), ErrForbidden)
+	assert.ErrorIs(t, m.SetRole(ctx, "GopherLab", "mleow", "mleow", RoleAdmin), ErrLastOwner)
+	assert.ErrorIs(t, m.RemoveMember(ctx, "GopherLab", "mleow", "mleow"), ErrLastOwner)
+
+	require.NoError(t, m.RemoveMember(ctx, "GopherLab", "alice", "bob"))
+	assert.ErrorIs(t, m.RemoveMember(ctx, "GopherLab", "alice", "bob"), ErrNotFound)
+	// Leaving is always allowed ..
+	require.NoError(t, m.RemoveMember(ctx, "GopherLab", "alice", "alice"))
+	require.NoError(t, m.Evict(ctx, "GopherLab", "alice"))
+
+	members, err := m.Members(ctx, "GopherLab")
+	require.NoError(t, err)
+	if assert.Len(t, members, 1) {
+		assert.Equal(t, RoleOwner, members[0].Role)
+	}
+	assert.Equal(t, []string{"GopherLab|mleow|owner"}, rel.tuples())
+}
+
+func TestInvitationToken(t *testing.T) {
+	secret := []byte("invite-secret")
+	exp := time.Date(2024, 6, 8, 10, 0, 0, 0, time.UTC)
+	token := signInvitation(secret, "abc123", exp)
+
+	id, err := verifyInvitation(secret, token, exp.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, "abc123", id)
+
+	cases := map[string]string{
+		"expired":   token,
+		"wrong key": signInvitation([]byte("other"), "abc123", exp),
+		"extended":  "abc123.9999999999." + token[len(token)-43:],
+		"other id":  "abc124" + token[6:],
+		"junk":      "abc123",
+	}
+	for name, tok := range cases {
+		now := exp.Add(-time.Hour)
+		if name == "expired" {
+			now = exp
+		}
+		_, err := verifyInvitation(secret, tok, now)
+		assert.ErrorIs(t, err, ErrInvalidInvitation, name)
+	}
+}
@@ -0,0 +1,148 @@
+package org
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// MemoryRepository is for the demo + tests; lost on restart ..
+type MemoryRepository struct {
+	mu          sync.Mutex
+	orgs        map[string]Organization
+	members     map[string]map[string]Membership
+	invitations map[string]Invitation
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		orgs:        map[string]Organization{},
+		members:     map[string]map[string]Membership{},
+		invitations: map[string]Invitation{},
+	}
+}
+
+func (r *MemoryRepository) CreateOrg(ctx context.Context, o Organization) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.orgs[o.ID]; ok {
+		return ErrConflict
+	}
+	r.orgs[o.ID] = o
+	return nil
+}
+
+func (r *MemoryRepository) GetOrg(ctx context.Context, id string) (Organization, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	o, ok := r.orgs[id]
+	if !ok {
+		return Organization{}, ErrNotFound
+	}
+	return o, nil
+}
+
+func (r *MemoryRepository) PutMember(ctx context.Context, m Membership) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.orgs[m.OrgID]; !ok {
+		return ErrNotFound
+	}
+	if r.members[m.OrgID] == nil {
+		r.members[m.OrgID] = map[string]Membership{}
+	}
+	r.members[m.OrgID][m.User] = m
+	return nil
+}
+
+func (r *MemoryRepository) GetMember(ctx context.Context, orgID, user string) (Membership, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.members[orgID][user]
+	if !ok {
+		return Membership{}, ErrNotFound
+	}
+	return m, nil
+}
+
+func (r *MemoryRepository) DeleteMember(ctx context.Context, orgID, user string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.members[orgID][user]; !ok {
+		return ErrNotFound
+	}
+	delete(r.members[orgID], user)
+	return nil
+}
+
+func (r *MemoryRepository) ListMembers(ctx context.Context, orgID string) ([]Membership, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]Membership, 0, len(r.members[orgID]))
+	for _, m := range r.members[orgID] {
+		out = append(out, m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].User < out[j].User })
+	return out, nil
+}
+
+func (r *MemoryRepository) ListMemberships(ctx context.Context, user string) ([]Membership, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []Membership
+	for _, members := range r.members {
+		if m, ok := members[user]; ok {
+			out = append(out, m)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].OrgID < out[j].OrgID })
+	return out, nil
+}
+
+func (r *MemoryRepository) CreateInvitation(ctx context.Context, inv Invitation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.invitations[inv.ID]; ok {
+		return ErrConflict
+	}
+	r.invitations[inv.ID] = inv
+	return nil
+}
+
+func (r *MemoryRepository) GetInvitation(ctx context.Context, id string) (Invitation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	inv, ok := r.invitations[id]
+	if !ok {
+		return Invitation{}, ErrNotFound
+	}
+	return inv, nil
+}
+
+func (r *MemoryRepository) UpdateInvitation(ctx context.Context, inv Invitation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cur, ok := r.invitations[inv.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	// Same as Postgres; decided once ..
+	if cur.Status != InvitePending {
+		return ErrConflict
+	}
+	r.invitations[inv.ID] = inv
+	return nil
+}
+
+func (r *MemoryRepository) ListInvitations(ctx context.Context, orgID string) ([]Invitation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []Invitation
+	for _, inv := range r.invitations {
+		if inv.OrgID == orgID {
+			out = append(out, inv)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
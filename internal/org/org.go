@@ -0,0 +1,100 @@
+// Package org is the tenants themselves: organizations, who is in them with
+// which role, and the invitations that bring people in. Membership is mirrored
+// into OpenFGA organization tuples so every document check is scoped by it ..
+package org
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Org roles; each one includes the ones below it ..
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+var roleRank = map[string]int{
+	RoleMember: 1,
+	RoleAdmin:  2,
+	RoleOwner:  3,
+}
+
+// ValidRole is one of owner, admin, member ..
+func ValidRole(role string) bool {
+	return roleRank[role] > 0
+}
+
+// Invitation states ..
+const (
+	InvitePending  = "pending"
+	InviteAccepted = "accepted"
+	InviteDeclined = "declined"
+	InviteRevoked  = "revoked"
+)
+
+var (
+	ErrNotFound  = errors.New("org: not found")
+	ErrConflict  = errors.New("org: already exists")
+	ErrForbidden = errors.New("org: not allowed")
+	// ErrInvalidInvitation is a token that is forged, expired or already used ..
+	ErrInvalidInvitation = errors.New("org: invalid invitation")
+	// ErrLastOwner; an org always keeps at least one owner ..
+	ErrLastOwner = errors.New("org: last owner")
+)
+
+type Organization struct {
+	ID        string
+	Name      string
+	CreatedBy string
+	CreatedAt time.Time
+}
+
+// Membership is a user in an org; User is the tuple ID (no @domain) ..
+type Membership struct {
+	OrgID     string
+	User      string
+	Role      string
+	InvitedBy string
+	JoinedAt  time.Time
+}
+
+// Invitation is an offer to join; the token handed out only carries its ID ..
+type Invitation struct {
+	ID        string
+	OrgID     string
+	Email     string
+	Role      string
+	InvitedBy string
+	Status    string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// DecidedBy is the user who accepted / declined, or the admin who revoked ..
+	DecidedBy string
+	DecidedAt time.Time
+}
+
+// Repository keeps orgs, memberships and invitations ..
+type Repository interface {
+	CreateOrg(ctx context.Context, o Organization) error
+	GetOrg(ctx context.Context, id string) (Organization, error)
+	// PutMember adds or replaces the membership ..
+	PutMember(ctx context.Context, m Membership) error
+	GetMember(ctx context.Context, orgID, user string) (Membership, error)
+	DeleteMember(ctx context.Context, orgID, user string) error
+	ListMembers(ctx context.Context, orgID string) ([]Membership, error)
+	// ListMemberships is every org the user is in ..
+	ListMemberships(ctx context.Context, user string) ([]Membership, error)
+	CreateInvitation(ctx context.Context, inv Invitation) error
+	GetInvitation(ctx context.Context, id string) (Invitation, error)
+	UpdateInvitation(ctx context.Context, inv Invitation) error
+	ListInvitations(ctx context.Context, orgID string) ([]Invitation, error)
+}
+
+// Relations writes the organization tuples; authz.AuthStore is one ..
+type Relations interface {
+	AddOrgRole(org, user, role string) error
+	RemoveOrgRole(org, user, role string) error
+}
@@ -0,0 +1,209 @@
+package org
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+// PostgresRepository keeps orgs, members and invitations in plain tables;
+// deleting an org takes its members + invitations with it ..
+type PostgresRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewPostgresRepository creates orgs, org_members + org_invitations if needed ..
+func NewPostgresRepository(ctx context.Context, pool *pgxpool.Pool) (*PostgresRepository, error) {
+	for _, ddl := range []string{
+		`CREATE TABLE IF NOT EXISTS orgs (
+			id         TEXT PRIMARY KEY,
+			name       TEXT NOT NULL,
+			created_by TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS org_members (
+			org_id     TEXT NOT NULL REFERENCES orgs (id) ON DELETE CASCADE,
+			username   TEXT NOT NULL,
+			role       TEXT NOT NULL CHECK (role IN ('owner', 'admin', 'member')),
+			invited_by TEXT NOT NULL,
+			joined_at  TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (org_id, username)
+		)`,
+		`CREATE INDEX IF NOT EXISTS org_members_username ON org_members (username)`,
+		`CREATE TABLE IF NOT EXISTS org_invitations (
+			id         TEXT PRIMARY KEY,
+			org_id     TEXT NOT NULL REFERENCES orgs (id) ON DELETE CASCADE,
+			email      TEXT NOT NULL,
+			role       TEXT NOT NULL,
+			invited_by TEXT NOT NULL,
+			status     TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL,
+			decided_by TEXT NOT NULL DEFAULT '',
+			decided_at TIMESTAMPTZ
+		)`,
+		`CREATE INDEX IF NOT EXISTS org_invitations_org ON org_invitations (org_id, created_at)`,
+	} {
+		if _, err := pool.Exec(ctx, ddl); err != nil {
+			return nil, err
+		}
+	}
+	return &PostgresRepository{pool: pool}, nil
+}
+
+// Unique + foreign key violations ..
+const (
+	pgUniqueViolation     = "23505"
+	pgForeignKeyViolation = "23503"
+)
+
+func mapPGError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case pgUniqueViolation:
+			return ErrConflict
+		case pgForeignKeyViolation:
+			return ErrNotFound
+		}
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (p *PostgresRepository) CreateOrg(ctx context.Context, o Organization) error {
+	_, err := p.pool.Exec(ctx, `INSERT INTO orgs (id, name, created_by, created_at) VALUES ($1, $2, $3, $4)`,
+		o.ID, o.Name, o.CreatedBy, o.CreatedAt)
+	return mapPGError(err)
+}
+
+func (p *PostgresRepository) GetOrg(ctx context.Context, id string) (Organization, error) {
+	var o Organization
+	err := p.pool.QueryRow(ctx, `SELECT id, name, created_by, created_at FROM orgs WHERE id = $1`, id).
+		Scan(&o.ID, &o.Name, &o.CreatedBy, &o.CreatedAt)
+	return o, mapPGError(err)
+}
+
+func (p *PostgresRepository) PutMember(ctx context.Context, m Membership) error {
+	_, err := p.pool.Exec(ctx, `INSERT INTO org_members (org_id, username, role, invited_by, joined_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (org_id, username) DO UPDATE SET role = EXCLUDED.role`,
+		m.OrgID, m.User, m.Role, m.InvitedBy, m.JoinedAt)
+	return mapPGError(err)
+}
+
+const memberColumns = `org_id, username, role, invited_by, joined_at`
+
+func scanMember(row pgx.Row) (Membership, error) {
+	var m Membership
+	err := row.Scan(&m.OrgID, &m.User, &m.Role, &m.InvitedBy, &m.JoinedAt)
+	return m, err
+}
+
+func (p *PostgresRepository) GetMember(ctx context.Context, orgID, user string) (Membership, error) {
+	m, err := scanMember(p.pool.QueryRow(ctx, `SELECT `+memberColumns+` FROM org_members
+		WHERE org_id = $1 AND username = $2`, orgID, user))
+	return m, mapPGError(err)
+}
+
+func (p *PostgresRepository) DeleteMember(ctx context.Context, orgID, user string) error {
+	tag, err := p.pool.Exec(ctx, `DELETE FROM org_members WHERE org_id = $1 AND username = $2`, orgID, user)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *PostgresRepository) listMembers(ctx context.Context, where string, arg string) ([]Membership, error) {
+	rows, err := p.pool.Query(ctx, `SELECT `+memberColumns+` FROM org_members WHERE `+where+` ORDER BY org_id, username`, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := []Membership{}
+	for rows.Next() {
+		m, err := scanMember(rows)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+func (p *PostgresRepository) ListMembers(ctx context.Context, orgID string) ([]Membership, error) {
+	return p.listMembers(ctx, "org_id = $1", orgID)
+}
+
+func (p *PostgresRepository) ListMemberships(ctx context.Context, user string) ([]Membership, error) {
+	return p.listMembers(ctx, "username = $1", user)
+}
+
+const invitationColumns = `id, org_id, email, role, invited_by, status, created_at, expires_at, decided_by, decided_at`
+
+func scanInvitation(row pgx.Row) (Invitation, error) {
+	var inv Invitation
+	var decided *time.Time
+	err := row.Scan(&inv.ID, &inv.OrgID, &inv.Email, &inv.Role, &inv.InvitedBy, &inv.Status,
+		&inv.CreatedAt, &inv.ExpiresAt, &inv.DecidedBy, &decided)
+	if decided != nil {
+		inv.DecidedAt = *decided
+	}
+	return inv, err
+}
+
+func (p *PostgresRepository) CreateInvitation(ctx context.Context, inv Invitation) error {
+	_, err := p.pool.Exec(ctx, `INSERT INTO org_invitations (id, org_id, email, role, invited_by, status, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		inv.ID, inv.OrgID, inv.Email, inv.Role, inv.InvitedBy, inv.Status, inv.CreatedAt, inv.ExpiresAt)
+	return mapPGError(err)
+}
+
+func (p *PostgresRepository) GetInvitation(ctx context.Context, id string) (Invitation, error) {
+	inv, err := scanInvitation(p.pool.QueryRow(ctx, `SELECT `+invitationColumns+` FROM org_invitations WHERE id = $1`, id))
+	return inv, mapPGError(err)
+}
+
+// UpdateInvitation only ever moves it out of pending; a second decision
+// racing the first is ErrConflict ..
+func (p *PostgresRepository) UpdateInvitation(ctx context.Context, inv Invitation) error {
+	var decided *time.Time
+	if !inv.DecidedAt.IsZero() {
+		decided = &inv.DecidedAt
+	}
+	tag, err := p.pool.Exec(ctx, `UPDATE org_invitations SET status = $2, decided_by = $3, decided_at = $4
+		WHERE id = $1 AND status = 'pending'`, inv.ID, inv.Status, inv.DecidedBy, decided)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (p *PostgresRepository) ListInvitations(ctx context.Context, orgID string) ([]Invitation, error) {
+	rows, err := p.pool.Query(ctx, `SELECT `+invitationColumns+` FROM org_invitations
+		WHERE org_id = $1 ORDER BY created_at`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	invitations := []Invitation{}
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+	return invitations, rows.Err()
+}
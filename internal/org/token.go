@@ -0,0 +1,49 @@
+package org
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Invitation tokens are <inviteID>.<expiry unix>.<hmac>; the signature keeps
+// IDs from being guessed and the expiry checkable before any lookup ..
+
+func newID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func signInvitation(secret []byte, id string, expires time.Time) string {
+	payload := id + "." + strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyInvitation gives back the invitation ID of a good, unexpired token ..
+func verifyInvitation(secret []byte, token string, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(secret) == 0 || len(parts) != 3 || parts[0] == "" {
+		return "", ErrInvalidInvitation
+	}
+	exp, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", ErrInvalidInvitation
+	}
+	if !hmac.Equal([]byte(signInvitation(secret, parts[0], time.Unix(exp, 0))), []byte(token)) {
+		return "", ErrInvalidInvitation
+	}
+	if !now.Before(time.Unix(exp, 0)) {
+		return "", ErrInvalidInvitation
+	}
+	return parts[0], nil
+}
@@ -0,0 +1,353 @@
+package scim
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Filter is a parsed SCIM filter (RFC 7644 3.4.2.2); matched against the
+// resource's JSON form so it works on users + groups alike ..
+type Filter interface {
+	Match(doc map[string]interface{}) bool
+}
+
+// ParseFilter understands eq ne co sw ew gt ge lt le pr, and/or/not and
+// parentheses; attribute paths may be dotted (name.familyName, emails.value) ..
+func ParseFilter(s string) (Filter, error) {
+	toks, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{toks: toks}
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.toks) {
+		return nil, badRequest("invalidFilter", "unexpected %q", p.toks[p.pos].text)
+	}
+	return f, nil
+}
+
+type token struct {
+	text   string
+	quoted bool
+}
+
+func tokenize(s string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')':
+			toks = append(toks, token{text: string(c)})
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, badRequest("invalidFilter", "unterminated string")
+			}
+			var v string
+			if err := json.Unmarshal([]byte(s[i:j+1]), &v); err != nil {
+				return nil, badRequest("invalidFilter", "bad string %s", s[i:j+1])
+			}
+			toks = append(toks, token{text: v, quoted: true})
+			i = j + 1
+		default:
+			j := i
+			for j < len(s) && s[j] != ' ' && s[j] != '(' && s[j] != ')' {
+				j++
+			}
+			toks = append(toks, token{text: s[i:j]})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+type filterParser struct {
+	toks []token
+	pos  int
+}
+
+func (p *filterParser) peekWord(word string) bool {
+	return p.pos < len(p.toks) && !p.toks[p.pos].quoted && strings.EqualFold(p.toks[p.pos].text, word)
+}
+
+func (p *filterParser) next() (token, error) {
+	if p.pos >= len(p.toks) {
+		return token{}, badRequest("invalidFilter", "filter ends early")
+	}
+	t := p.toks[p.pos]
+	p.pos++
+	return t, nil
+}
+
+func (p *filterParser) or() (Filter, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekWord("or") {
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = orFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) and() (Filter, error) {
+	left, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekWord("and") {
+		p.pos++
+		right, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		left = andFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) factor() (Filter, error) {
+	if p.peekWord("not") {
+		p.pos++
+		inner, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		return notFilter{inner}, nil
+	}
+	if p.peekWord("(") {
+		p.pos++
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peekWord(")") {
+			return nil, badRequest("invalidFilter", "missing )")
+		}
+		p.pos++
+		return inner, nil
+	}
+	attr, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if attr.quoted {
+		return nil, badRequest("invalidFilter", "expected attribute, got %q", attr.text)
+	}
+	opTok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	op := strings.ToLower(opTok.text)
+	cmp := compareFilter{path: attributePath(attr.text), op: op}
+	switch op {
+	case "pr":
+		return cmp, nil
+	case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+	default:
+		return nil, badRequest("invalidFilter", "unknown operator %q", opTok.text)
+	}
+	v, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	cmp.value = v.text
+	if !v.quoted {
+		switch lower := strings.ToLower(v.text); {
+		case lower == "true" || lower == "false":
+			cmp.value = lower
+		case lower == "null":
+			cmp.null = true
+		default:
+			if _, err := strconv.ParseFloat(v.text, 64); err != nil {
+				return nil, badRequest("invalidFilter", "bad value %q", v.text)
+			}
+		}
+	}
+	return cmp, nil
+}
+
+// attributePath splits name.familyName; a schema URN prefix is dropped ..
+func attributePath(s string) []string {
+	if strings.HasPrefix(strings.ToLower(s), "urn:") {
+		if i := strings.LastIndex(s, ":"); i >= 0 {
+			s = s[i+1:]
+		}
+	}
+	return strings.Split(s, ".")
+}
+
+type andFilter struct{ left, right Filter }
+
+func (f andFilter) Match(doc map[string]interface{}) bool {
+	return f.left.Match(doc) && f.right.Match(doc)
+}
+
+type orFilter struct{ left, right Filter }
+
+func (f orFilter) Match(doc map[string]interface{}) bool {
+	return f.left.Match(doc) || f.right.Match(doc)
+}
+
+type notFilter struct{ inner Filter }
+
+func (f notFilter) Match(doc map[string]interface{}) bool {
+	return !f.inner.Match(doc)
+}
+
+type compareFilter struct {
+	path  []string
+	op    string
+	value string
+	null  bool
+}
+
+func (f compareFilter) Match(doc map[string]interface{}) bool {
+	values := resolve(doc, f.path)
+	if f.op == "pr" || f.null {
+		present := false
+		for _, v := range values {
+			if s, ok := v.(string); !ok || s != "" {
+				present = true
+			}
+		}
+		if f.null && f.op == "ne" {
+			return present
+		}
+		if f.null {
+			return !present
+		}
+		return present
+	}
+	if f.op == "ne" {
+		return !compareFilter{path: f.path, op: "eq", value: f.value}.Match(doc)
+	}
+	// Multi valued: any one matching will do ..
+	for _, v := range values {
+		if f.compare(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f compareFilter) compare(v interface{}) bool {
+	if n, ok := v.(float64); ok {
+		want, err := strconv.ParseFloat(f.value, 64)
+		if err != nil {
+			return false
+		}
+		switch f.op {
+		case "eq":
+			return n == want
+		case "gt":
+			return n > want
+		case "ge":
+			return n >= want
+		case "lt":
+			return n < want
+		case "le":
+			return n <= want
+		}
+		return false
+	}
+	var got string
+	switch t := v.(type) {
+	case string:
+		got = t
+	case bool:
+		got = strconv.FormatBool(t)
+	default:
+		return false
+	}
+	// Everything we store is caseExact=false ..
+	got, want := strings.ToLower(got), strings.ToLower(f.value)
+	switch f.op {
+	case "eq":
+		return got == want
+	case "co":
+		return strings.Contains(got, want)
+	case "sw":
+		return strings.HasPrefix(got, want)
+	case "ew":
+		return strings.HasSuffix(got, want)
+	// Timestamps are RFC3339 so string order works ..
+	case "gt":
+		return got > want
+	case "ge":
+		return got >= want
+	case "lt":
+		return got < want
+	case "le":
+		return got <= want
+	}
+	return false
+}
+
+// resolve walks path through maps + arrays; a bare multi valued attribute
+// (emails eq "x") compares against each element's value ..
+func resolve(v interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		switch t := v.(type) {
+		case []interface{}:
+			var out []interface{}
+			for _, e := range t {
+				out = append(out, resolve(e, nil)...)
+			}
+			return out
+		case map[string]interface{}:
+			if inner, ok := lookup(t, "value"); ok {
+				return []interface{}{inner}
+			}
+			// Complex attribute; only pr means anything ..
+			return []interface{}{t}
+		case nil:
+			return nil
+		}
+		return []interface{}{v}
+	}
+	switch t := v.(type) {
+	case []interface{}:
+		var out []interface{}
+		for _, e := range t {
+			out = append(out, resolve(e, path)...)
+		}
+		return out
+	case map[string]interface{}:
+		inner, ok := lookup(t, path[0])
+		if !ok {
+			return nil
+		}
+		return resolve(inner, path[1:])
+	}
+	return nil
+}
+
+// lookup is a case insensitive key lookup; SCIM attribute names are ..
+func lookup(m map[string]interface{}, name string) (interface{}, bool) {
+	if v, ok := m[name]; ok {
+		return v, true
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,63 @@
+package scim
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestParseFilter(t *testing.T) {
+	doc := toMap(User{
+		UserName: "Alice@acme.example",
+		Name:     &Name{GivenName: "Alice", FamilyName: "Smith"},
+		Emails:   []Email{{Value: "alice@acme.example", Type: "work"}, {Value: "al@home.example", Type: "home"}},
+		Active:   true,
+		Meta:     Meta{Created: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+	})
+	cases := map[string]bool{
+		`userName eq "alice@acme.example"`:                         true,
+		`USERNAME Eq "ALICE@ACME.EXAMPLE"`:                         true,
+		`userName ne "alice@acme.example"`:                         false,
+		`name.familyName co "mit"`:                                 true,
+		`userName sw "bob"`:                                        false,
+		`emails.value ew "home.example"`:                           true,
+		`emails eq "al@home.example"`:                              true,
+		`active eq true`:                                           true,
+		`active eq false`:                                          false,
+		`externalId pr`:                                            false,
+		`name pr and not (active eq false)`:                        true,
+		`userName eq "bob" or name.givenName eq "alice"`:           true,
+		`userName eq "bob" or (active eq true and title pr)`:       false,
+		`urn:ietf:params:scim:schemas:core:2.0:User:userName pr`:   true,
+		`meta.created gt "2000-01-01T00:00:00Z"`:                   true,
+		`meta.created lt "2000-01-01T00:00:00Z"`:                   false,
+		`emails.type eq "work" and emails.value sw "alice"`:        true,
+		`displayName eq null`:                                      true,
+		`not (emails.value co "@acme") or userName eq "carol"`:     false,
+		`name.givenName eq "Alice" and name.familyName eq "Smith"`: true,
+	}
+	for expr, want := range cases {
+		f, err := ParseFilter(expr)
+		require.NoError(t, err, expr)
+		assert.Equal(t, want, f.Match(doc), expr)
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, expr := range []string{
+		`userName`,
+		`userName zz "x"`,
+		`userName eq "x`,
+		`(userName eq "x"`,
+		`userName eq "x" and`,
+		`userName eq bob`,
+		`"userName" eq "x"`,
+	} {
+		_, err := ParseFilter(expr)
+		var e *Error
+		if assert.ErrorAs(t, err, &e, expr) {
+			assert.Equal(t, "invalidFilter", e.ScimType, expr)
+		}
+	}
+}
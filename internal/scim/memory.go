@@ -0,0 +1,150 @@
+package scim
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryRepository is for the demo + tests; lost on restart ..
+type MemoryRepository struct {
+	mu     sync.Mutex
+	users  map[string]map[string]User
+	groups map[string]map[string]Group
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{users: map[string]map[string]User{}, groups: map[string]map[string]Group{}}
+}
+
+func (m *MemoryRepository) CreateUser(ctx context.Context, tenant string, u User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.putUser(tenant, u, true)
+}
+
+func (m *MemoryRepository) ReplaceUser(ctx context.Context, tenant string, u User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.putUser(tenant, u, false)
+}
+
+func (m *MemoryRepository) putUser(tenant string, u User, create bool) error {
+	users := m.users[tenant]
+	if users == nil {
+		users = map[string]User{}
+		m.users[tenant] = users
+	}
+	if _, ok := users[u.ID]; ok == create {
+		if create {
+			return ErrConflict
+		}
+		return ErrNotFound
+	}
+	for id, other := range users {
+		if id != u.ID && strings.EqualFold(other.UserName, u.UserName) {
+			return ErrConflict
+		}
+	}
+	users[u.ID] = u
+	return nil
+}
+
+func (m *MemoryRepository) GetUser(ctx context.Context, tenant, id string) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[tenant][id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return u, nil
+}
+
+func (m *MemoryRepository) DeleteUser(ctx context.Context, tenant, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[tenant][id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.users[tenant], id)
+	return nil
+}
+
+func (m *MemoryRepository) ListUsers(ctx context.Context, tenant string) ([]User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	users := make([]User, 0, len(m.users[tenant]))
+	for _, u := range m.users[tenant] {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Meta.Created.Before(users[j].Meta.Created) })
+	return users, nil
+}
+
+func (m *MemoryRepository) CreateGroup(ctx context.Context, tenant string, g Group) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.putGroup(tenant, g, true)
+}
+
+func (m *MemoryRepository) ReplaceGroup(ctx context.Context, tenant string, g Group) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.putGroup(tenant, g, false)
+}
+
+func (m *MemoryRepository) putGroup(tenant string, g Group, create bool) error {
+	groups := m.groups[tenant]
+	if groups == nil {
+		groups = map[string]Group{}
+		m.groups[tenant] = groups
+	}
+	if _, ok := groups[g.ID]; ok == create {
+		if create {
+			return ErrConflict
+		}
+		return ErrNotFound
+	}
+	for id, other := range groups {
+		if id != g.ID && strings.EqualFold(other.DisplayName, g.DisplayName) {
+			return ErrConflict
+		}
+	}
+	g.Members = append([]Member(nil), g.Members...)
+	groups[g.ID] = g
+	return nil
+}
+
+func (m *MemoryRepository) GetGroup(ctx context.Context, tenant, id string) (Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g, ok := m.groups[tenant][id]
+	if !ok {
+		return Group{}, ErrNotFound
+	}
+	g.Members = append([]Member(nil), g.Members...)
+	return g, nil
+}
+
+func (m *MemoryRepository) DeleteGroup(ctx context.Context, tenant, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.groups[tenant][id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.groups[tenant], id)
+	return nil
+}
+
+func (m *MemoryRepository) ListGroups(ctx context.Context, tenant string) ([]Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	groups := make([]Group, 0, len(m.groups[tenant]))
+	for _, g := range m.groups[tenant] {
+		g.Members = append([]Member(nil), g.Members...)
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Meta.Created.Before(groups[j].Meta.Created) })
+	return groups, nil
+}
@@ -0,0 +1,243 @@
+package scim
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// PatchOp is the body of a PATCH ..
+type PatchOp struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// patchPath is attr[filter].sub; filter + sub optional ..
+type patchPath struct {
+	attr   string
+	filter Filter
+	// eq from a simple filter; lets add create the element it names ..
+	eqAttr, eqValue string
+	sub             string
+}
+
+func parsePatchPath(s string) (patchPath, error) {
+	var p patchPath
+	if strings.HasPrefix(strings.ToLower(s), "urn:") {
+		// Core schema prefix is just noise; extensions we do not keep ..
+		for _, schema := range []string{SchemaUser, SchemaGroup} {
+			if rest, ok := strings.CutPrefix(s, schema+":"); ok {
+				s = rest
+				break
+			}
+		}
+	}
+	if i := strings.Index(s, "["); i >= 0 {
+		j := strings.LastIndex(s, "]")
+		if j < i {
+			return p, badRequest("invalidPath", "bad path %q", s)
+		}
+		f, err := ParseFilter(s[i+1 : j])
+		if err != nil {
+			return p, badRequest("invalidPath", "bad path %q", s)
+		}
+		p.attr, p.filter = s[:i], f
+		if cmp, ok := f.(compareFilter); ok && cmp.op == "eq" && len(cmp.path) == 1 {
+			p.eqAttr, p.eqValue = cmp.path[0], cmp.value
+		}
+		if rest := s[j+1:]; rest != "" {
+			p.sub = strings.TrimPrefix(rest, ".")
+		}
+		return p, nil
+	}
+	p.attr, p.sub, _ = strings.Cut(s, ".")
+	if p.attr == "" {
+		return p, badRequest("invalidPath", "bad path %q", s)
+	}
+	return p, nil
+}
+
+// applyPatch edits doc in place. Handles both the RFC shapes and what
+// Azure AD + Okta actually send (no path + object value, capitalised ops,
+// remove members with a value list) ..
+func applyPatch(doc map[string]interface{}, ops []PatchOperation) error {
+	for _, op := range ops {
+		var value interface{}
+		if len(op.Value) > 0 {
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return badRequest("invalidValue", "bad value: %v", err)
+			}
+		}
+		kind := strings.ToLower(op.Op)
+		switch kind {
+		case "add", "replace", "remove":
+		default:
+			return badRequest("invalidSyntax", "unknown op %q", op.Op)
+		}
+		if op.Path == "" {
+			if kind == "remove" {
+				return badRequest("noTarget", "remove needs a path")
+			}
+			obj, ok := value.(map[string]interface{})
+			if !ok {
+				return badRequest("invalidValue", "%s without a path needs an object", op.Op)
+			}
+			for k, v := range obj {
+				// Okta sends name.givenName style keys here too ..
+				if err := applyOne(doc, kind, k, v); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := applyOne(doc, kind, op.Path, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyOne(doc map[string]interface{}, kind, path string, value interface{}) error {
+	p, err := parsePatchPath(path)
+	if err != nil {
+		return err
+	}
+	key := keyFor(doc, p.attr)
+	if p.filter != nil {
+		return applyFiltered(doc, kind, key, p, value)
+	}
+	if p.sub != "" {
+		parent, _ := doc[key].(map[string]interface{})
+		if parent == nil {
+			if kind == "remove" {
+				return nil
+			}
+			parent = map[string]interface{}{}
+			doc[key] = parent
+		}
+		subKey := keyFor(parent, p.sub)
+		if kind == "remove" {
+			delete(parent, subKey)
+		} else {
+			parent[subKey] = value
+		}
+		return nil
+	}
+	existing, isList := doc[key].([]interface{})
+	switch kind {
+	case "remove":
+		values, ok := value.([]interface{})
+		if !ok || !isList {
+			delete(doc, key)
+			return nil
+		}
+		doc[key] = withoutValues(existing, values)
+	case "add":
+		if values, ok := value.([]interface{}); ok {
+			doc[key] = withoutValues(existing, values)
+			doc[key] = append(doc[key].([]interface{}), values...)
+			return nil
+		}
+		if isList {
+			doc[key] = append(withoutValues(existing, []interface{}{value}), value)
+			return nil
+		}
+		doc[key] = value
+	case "replace":
+		doc[key] = value
+	}
+	return nil
+}
+
+// applyFiltered is members[value eq "x"] or emails[type eq "work"].value ..
+func applyFiltered(doc map[string]interface{}, kind, key string, p patchPath, value interface{}) error {
+	list, _ := doc[key].([]interface{})
+	out := make([]interface{}, 0, len(list))
+	matched := false
+	for _, e := range list {
+		el, ok := e.(map[string]interface{})
+		if !ok || !p.filter.Match(el) {
+			out = append(out, e)
+			continue
+		}
+		matched = true
+		switch {
+		case kind == "remove" && p.sub == "":
+			continue
+		case kind == "remove":
+			delete(el, keyFor(el, p.sub))
+		case p.sub != "":
+			el[keyFor(el, p.sub)] = value
+		default:
+			if obj, ok := value.(map[string]interface{}); ok {
+				for k, v := range obj {
+					el[keyFor(el, k)] = v
+				}
+			}
+		}
+		out = append(out, el)
+	}
+	if !matched && kind != "remove" {
+		if p.eqAttr == "" {
+			return &Error{Status: 400, ScimType: "noTarget", Detail: "nothing matches " + p.attr}
+		}
+		el := map[string]interface{}{p.eqAttr: p.eqValue}
+		if p.sub != "" {
+			el[p.sub] = value
+		} else if obj, ok := value.(map[string]interface{}); ok {
+			for k, v := range obj {
+				el[k] = v
+			}
+		}
+		out = append(out, el)
+	}
+	doc[key] = out
+	return nil
+}
+
+// withoutValues drops elements whose value matches one in values ..
+func withoutValues(list, values []interface{}) []interface{} {
+	drop := map[string]bool{}
+	for _, v := range values {
+		if s := elementValue(v); s != "" {
+			drop[s] = true
+		}
+	}
+	out := make([]interface{}, 0, len(list))
+	for _, e := range list {
+		if s := elementValue(e); s != "" && drop[s] {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+func elementValue(v interface{}) string {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		s, _ := t["value"].(string)
+		return s
+	case string:
+		return t
+	}
+	return ""
+}
+
+// keyFor finds the existing key case insensitively; else uses name ..
+func keyFor(m map[string]interface{}, name string) string {
+	if _, ok := m[name]; ok {
+		return name
+	}
+	for k := range m {
+		if strings.EqualFold(k, name) {
+			return k
+		}
+	}
+	return name
+}
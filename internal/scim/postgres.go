@@ -0,0 +1,179 @@
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+// PostgresRepository keeps each resource as JSONB; the unique index on the
+// lowered name is what enforces userName / displayName uniqueness ..
+type PostgresRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewPostgresRepository creates scim_users + scim_groups if needed ..
+func NewPostgresRepository(ctx context.Context, pool *pgxpool.Pool) (*PostgresRepository, error) {
+	for _, table := range []string{"scim_users", "scim_groups"} {
+		_, err := pool.Exec(ctx, `CREATE TABLE IF NOT EXISTS `+table+` (
+			tenant   TEXT NOT NULL,
+			id       TEXT NOT NULL,
+			name     TEXT NOT NULL,
+			resource JSONB NOT NULL,
+			created  TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (tenant, id)
+		)`)
+		if err != nil {
+			return nil, err
+		}
+		_, err = pool.Exec(ctx, `CREATE UNIQUE INDEX IF NOT EXISTS `+table+`_name ON `+table+` (tenant, lower(name))`)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &PostgresRepository{pool: pool}, nil
+}
+
+// Unique violation ..
+const pgUniqueViolation = "23505"
+
+func (p *PostgresRepository) insert(ctx context.Context, table, tenant, id, name string, v interface{}, created time.Time) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = p.pool.Exec(ctx, `INSERT INTO `+table+` (tenant, id, name, resource, created) VALUES ($1, $2, $3, $4, $5)`,
+		tenant, id, name, b, created)
+	return mapPGError(err)
+}
+
+func (p *PostgresRepository) update(ctx context.Context, table, tenant, id, name string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tag, err := p.pool.Exec(ctx, `UPDATE `+table+` SET name = $3, resource = $4 WHERE tenant = $1 AND id = $2`,
+		tenant, id, name, b)
+	if err != nil {
+		return mapPGError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *PostgresRepository) get(ctx context.Context, table, tenant, id string, v interface{}) error {
+	var b []byte
+	err := p.pool.QueryRow(ctx, `SELECT resource FROM `+table+` WHERE tenant = $1 AND id = $2`, tenant, id).Scan(&b)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (p *PostgresRepository) delete(ctx context.Context, table, tenant, id string) error {
+	tag, err := p.pool.Exec(ctx, `DELETE FROM `+table+` WHERE tenant = $1 AND id = $2`, tenant, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *PostgresRepository) list(ctx context.Context, table, tenant string, each func([]byte) error) error {
+	rows, err := p.pool.Query(ctx, `SELECT resource FROM `+table+` WHERE tenant = $1 ORDER BY created, id`, tenant)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b []byte
+		if err := rows.Scan(&b); err != nil {
+			return err
+		}
+		if err := each(b); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func mapPGError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+		return ErrConflict
+	}
+	return err
+}
+
+func (p *PostgresRepository) CreateUser(ctx context.Context, tenant string, u User) error {
+	return p.insert(ctx, "scim_users", tenant, u.ID, u.UserName, u, u.Meta.Created)
+}
+
+func (p *PostgresRepository) GetUser(ctx context.Context, tenant, id string) (User, error) {
+	var u User
+	err := p.get(ctx, "scim_users", tenant, id, &u)
+	return u, err
+}
+
+func (p *PostgresRepository) ReplaceUser(ctx context.Context, tenant string, u User) error {
+	return p.update(ctx, "scim_users", tenant, u.ID, u.UserName, u)
+}
+
+func (p *PostgresRepository) DeleteUser(ctx context.Context, tenant, id string) error {
+	return p.delete(ctx, "scim_users", tenant, id)
+}
+
+func (p *PostgresRepository) ListUsers(ctx context.Context, tenant string) ([]User, error) {
+	users := []User{}
+	err := p.list(ctx, "scim_users", tenant, func(b []byte) error {
+		var u User
+		if err := json.Unmarshal(b, &u); err != nil {
+			return err
+		}
+		users = append(users, u)
+		return nil
+	})
+	return users, err
+}
+
+func (p *PostgresRepository) CreateGroup(ctx context.Context, tenant string, g Group) error {
+	return p.insert(ctx, "scim_groups", tenant, g.ID, g.DisplayName, g, g.Meta.Created)
+}
+
+func (p *PostgresRepository) GetGroup(ctx context.Context, tenant, id string) (Group, error) {
+	var g Group
+	err := p.get(ctx, "scim_groups", tenant, id, &g)
+	return g, err
+}
+
+func (p *PostgresRepository) ReplaceGroup(ctx context.Context, tenant string, g Group) error {
+	return p.update(ctx, "scim_groups", tenant, g.ID, g.DisplayName, g)
+}
+
+func (p *PostgresRepository) DeleteGroup(ctx context.Context, tenant, id string) error {
+	return p.delete(ctx, "scim_groups", tenant, id)
+}
+
+func (p *PostgresRepository) ListGroups(ctx context.Context, tenant string) ([]Group, error) {
+	groups := []Group{}
+	err := p.list(ctx, "scim_groups", tenant, func(b []byte) error {
+		var g Group
+		if err := json.Unmarshal(b, &g); err != nil {
+			return err
+		}
+		groups = append(groups, g)
+		return nil
+	})
+	return groups, err
+}
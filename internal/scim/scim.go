@@ -0,0 +1,130 @@
+// Package scim is a SCIM 2.0 (RFC 7643/7644) server per tenant; the IdP pushes
+// users + groups here and membership turns into OpenFGA group tuples ..
+package scim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Schema URNs we speak ..
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaBulkRequest           = "urn:ietf:params:scim:api:messages:2.0:BulkRequest"
+	SchemaBulkResponse          = "urn:ietf:params:scim:api:messages:2.0:BulkResponse"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// Repository errors; the server maps them to SCIM errors ..
+var (
+	ErrNotFound = errors.New("scim: resource not found")
+	ErrConflict = errors.New("scim: resource already exists")
+)
+
+// Meta is maintained by the server; clients can not set it ..
+type Meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location,omitempty"`
+}
+
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// Member is a group member, or on a user one of its groups ..
+type Member struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type User struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	UserName    string   `json:"userName"`
+	Name        *Name    `json:"name,omitempty"`
+	DisplayName string   `json:"displayName,omitempty"`
+	Emails      []Email  `json:"emails,omitempty"`
+	Active      bool     `json:"active"`
+	// Groups is read only; worked out from the groups' members ..
+	Groups []Member `json:"groups,omitempty"`
+	Meta   Meta     `json:"meta"`
+}
+
+// TupleUser is the ID used in OpenFGA tuples; same as SSO logins hand out
+// (the userName without any @domain) ..
+func (u User) TupleUser() string {
+	local, _, _ := strings.Cut(u.UserName, "@")
+	return local
+}
+
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Member `json:"members,omitempty"`
+	Meta        Meta     `json:"meta"`
+}
+
+// Repository persists one tenant's users + groups. Create assigns nothing; the
+// server fills in ID + Meta. userName and group displayName are unique per
+// tenant (case insensitive); a clash is ErrConflict ..
+type Repository interface {
+	CreateUser(ctx context.Context, tenant string, u User) error
+	GetUser(ctx context.Context, tenant, id string) (User, error)
+	ReplaceUser(ctx context.Context, tenant string, u User) error
+	DeleteUser(ctx context.Context, tenant, id string) error
+	ListUsers(ctx context.Context, tenant string) ([]User, error)
+
+	CreateGroup(ctx context.Context, tenant string, g Group) error
+	GetGroup(ctx context.Context, tenant, id string) (Group, error)
+	ReplaceGroup(ctx context.Context, tenant string, g Group) error
+	DeleteGroup(ctx context.Context, tenant, id string) error
+	ListGroups(ctx context.Context, tenant string) ([]Group, error)
+}
+
+// Provisioner is where SCIM changes turn into access. Users are tuple IDs
+// (see TupleUser); group is the SCIM displayName ..
+type Provisioner interface {
+	MembershipChanged(ctx context.Context, tenant, group string, added, removed []string) error
+	// Deprovision takes away everything the user has ..
+	Deprovision(ctx context.Context, tenant, user string) error
+}
+
+// Error is a SCIM error response ..
+type Error struct {
+	Status   int
+	ScimType string
+	Detail   string
+}
+
+func (e *Error) Error() string {
+	if e.ScimType != "" {
+		return fmt.Sprintf("scim %d %s: %s", e.Status, e.ScimType, e.Detail)
+	}
+	return fmt.Sprintf("scim %d: %s", e.Status, e.Detail)
+}
+
+func badRequest(scimType, format string, args ...interface{}) *Error {
+	return &Error{Status: http.StatusBadRequest, ScimType: scimType, Detail: fmt.Sprintf(format, args...)}
+}
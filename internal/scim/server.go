@@ -0,0 +1,728 @@
+package scim
+
+import (
+	"app/internal/identity"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits we advertise in ServiceProviderConfig ..
+const (
+	maxBulkOperations = 100
+	maxBulkPayload    = 1 << 20
+	maxFilterResults  = 200
+)
+
+// Server is the SCIM endpoint. The tenant is whoever the API key belongs to,
+// so mount it behind identity.APIKeyManager.Require(identity.ScopeSCIMProvision, ..)
+// and http.StripPrefix ..
+type Server struct {
+	repo    Repository
+	prov    Provisioner
+	baseURL string
+	now     func() time.Time
+
+	// One writer at a time; membership diffs must not interleave ..
+	mu sync.Mutex
+}
+
+// NewServer serves /Users, /Groups, /Bulk and /ServiceProviderConfig; baseURL
+// is where it is mounted e.g. http://localhost:8888/scim/v2 ..
+func NewServer(repo Repository, prov Provisioner, baseURL string) *Server {
+	return &Server{repo: repo, prov: prov, baseURL: strings.TrimSuffix(baseURL, "/"), now: time.Now}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p, ok := identity.PrincipalFrom(r.Context())
+	if !ok || p.TenantID == "" {
+		writeError(w, &Error{Status: http.StatusUnauthorized, Detail: "no tenant"})
+		return
+	}
+	if strings.Trim(r.URL.Path, "/") == "Bulk" {
+		if r.Method != http.MethodPost {
+			writeError(w, &Error{Status: http.StatusMethodNotAllowed, Detail: "POST only"})
+			return
+		}
+		s.bulk(w, r, p.TenantID)
+		return
+	}
+	s.serve(w, r, p.TenantID)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request, tenant string) {
+	resource, id, _ := strings.Cut(strings.Trim(r.URL.Path, "/"), "/")
+	if r.Method != http.MethodGet {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(io.LimitReader(r.Body, maxBulkPayload))
+		if err != nil {
+			writeError(w, badRequest("invalidSyntax", "unreadable body"))
+			return
+		}
+	}
+	ctx := r.Context()
+
+	var v interface{}
+	var err error
+	status := http.StatusOK
+	switch {
+	case resource == "ServiceProviderConfig" && id == "" && r.Method == http.MethodGet:
+		v = s.serviceProviderConfig()
+	case resource == "Users" && id == "" && r.Method == http.MethodGet:
+		v, err = s.listUsers(ctx, tenant, r)
+	case resource == "Users" && id == "" && r.Method == http.MethodPost:
+		v, err = s.createUser(ctx, tenant, body)
+		status = http.StatusCreated
+	case resource == "Users" && id != "" && r.Method == http.MethodGet:
+		v, err = s.getUser(ctx, tenant, id)
+	case resource == "Users" && id != "" && r.Method == http.MethodPut:
+		v, err = s.replaceUser(ctx, tenant, id, body)
+	case resource == "Users" && id != "" && r.Method == http.MethodPatch:
+		v, err = s.patchUser(ctx, tenant, id, body)
+	case resource == "Users" && id != "" && r.Method == http.MethodDelete:
+		err = s.deleteUser(ctx, tenant, id)
+		status = http.StatusNoContent
+	case resource == "Groups" && id == "" && r.Method == http.MethodGet:
+		v, err = s.listGroups(ctx, tenant, r)
+	case resource == "Groups" && id == "" && r.Method == http.MethodPost:
+		v, err = s.createGroup(ctx, tenant, body)
+		status = http.StatusCreated
+	case resource == "Groups" && id != "" && r.Method == http.MethodGet:
+		v, err = s.getGroup(ctx, tenant, id, r.URL.Query().Get("excludedAttributes"))
+	case resource == "Groups" && id != "" && r.Method == http.MethodPut:
+		v, err = s.replaceGroup(ctx, tenant, id, body)
+	case resource == "Groups" && id != "" && r.Method == http.MethodPatch:
+		v, err = s.patchGroup(ctx, tenant, id, body)
+	case resource == "Groups" && id != "" && r.Method == http.MethodDelete:
+		err = s.deleteGroup(ctx, tenant, id)
+		status = http.StatusNoContent
+	default:
+		err = &Error{Status: http.StatusNotFound, Detail: "no such endpoint " + r.Method + " " + r.URL.Path}
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	switch res := v.(type) {
+	case User:
+		w.Header().Set("Location", res.Meta.Location)
+	case Group:
+		w.Header().Set("Location", res.Meta.Location)
+	}
+	writeSCIM(w, status, v)
+}
+
+func writeSCIM(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(status)
+	if v != nil {
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+	case errors.Is(err, ErrNotFound):
+		e = &Error{Status: http.StatusNotFound, Detail: "resource not found"}
+	case errors.Is(err, ErrConflict):
+		e = &Error{Status: http.StatusConflict, ScimType: "uniqueness", Detail: "name already in use"}
+	default:
+		fmt.Println("SCIM-ERR: ", err)
+		e = &Error{Status: http.StatusInternalServerError, Detail: "internal error"}
+	}
+	body := map[string]interface{}{
+		"schemas": []string{SchemaError},
+		"status":  strconv.Itoa(e.Status),
+		"detail":  e.Detail,
+	}
+	if e.ScimType != "" {
+		body["scimType"] = e.ScimType
+	}
+	writeSCIM(w, e.Status, body)
+}
+
+func (s *Server) serviceProviderConfig() map[string]interface{} {
+	no := map[string]bool{"supported": false}
+	return map[string]interface{}{
+		"schemas":        []string{SchemaServiceProviderConfig},
+		"patch":          map[string]bool{"supported": true},
+		"bulk":           map[string]interface{}{"supported": true, "maxOperations": maxBulkOperations, "maxPayloadSize": maxBulkPayload},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": maxFilterResults},
+		"changePassword": no,
+		"sort":           no,
+		"etag":           no,
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "API key",
+			"description": "Tenant API key with the " + identity.ScopeSCIMProvision + " scope",
+			"primary":     true,
+		}},
+	}
+}
+
+// ListResponse is a page of resources ..
+type ListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+// page filters then slices; startIndex is 1 based ..
+func page(r *http.Request, resources []interface{}) (ListResponse, error) {
+	q := r.URL.Query()
+	if expr := q.Get("filter"); expr != "" {
+		f, err := ParseFilter(expr)
+		if err != nil {
+			return ListResponse{}, err
+		}
+		matched := resources[:0]
+		for _, res := range resources {
+			if f.Match(toMap(res)) {
+				matched = append(matched, res)
+			}
+		}
+		resources = matched
+	}
+	start, count := 1, maxFilterResults
+	if v, err := strconv.Atoi(q.Get("startIndex")); err == nil && v > 1 {
+		start = v
+	}
+	if v, err := strconv.Atoi(q.Get("count")); err == nil && v >= 0 && v < count {
+		count = v
+	}
+	out := ListResponse{Schemas: []string{SchemaListResponse}, TotalResults: len(resources), StartIndex: start, Resources: []interface{}{}}
+	if start-1 < len(resources) {
+		end := start - 1 + count
+		if end > len(resources) {
+			end = len(resources)
+		}
+		out.Resources = resources[start-1 : end]
+	}
+	out.ItemsPerPage = len(out.Resources)
+	return out, nil
+}
+
+// toMap is the JSON view filters + patches work on ..
+func toMap(v interface{}) map[string]interface{} {
+	b, _ := json.Marshal(v)
+	m := map[string]interface{}{}
+	json.Unmarshal(b, &m)
+	return m
+}
+
+func (s *Server) location(kind, id string) string {
+	return s.baseURL + "/" + kind + "/" + id
+}
+
+// decodeUser starts from active=true; absent means active ..
+func decodeUser(body []byte) (User, error) {
+	u := User{Active: true}
+	if err := json.Unmarshal(body, &u); err != nil {
+		return u, badRequest("invalidSyntax", "bad user: %v", err)
+	}
+	if u.UserName == "" {
+		return u, badRequest("invalidValue", "userName is required")
+	}
+	if identity.ValidUserID(u.TupleUser()) != nil {
+		return u, badRequest("invalidValue", "userName can not contain ':', '#', '*' or surrounding spaces")
+	}
+	u.Groups = nil
+	u.Schemas = []string{SchemaUser}
+	return u, nil
+}
+
+func (s *Server) createUser(ctx context.Context, tenant string, body []byte) (User, error) {
+	u, err := decodeUser(body)
+	if err != nil {
+		return u, err
+	}
+	now := s.now().UTC()
+	u.ID = uuid.NewString()
+	u.Meta = Meta{ResourceType: "User", Created: now, LastModified: now, Location: s.location("Users", u.ID)}
+	// No groups yet so nothing to provision ..
+	if err := s.repo.CreateUser(ctx, tenant, u); err != nil {
+		return u, err
+	}
+	return u, nil
+}
+
+func (s *Server) getUser(ctx context.Context, tenant, id string) (User, error) {
+	u, err := s.repo.GetUser(ctx, tenant, id)
+	if err != nil {
+		return u, err
+	}
+	groups, err := s.repo.ListGroups(ctx, tenant)
+	if err != nil {
+		return u, err
+	}
+	u.Groups = userGroups(groups)[u.ID]
+	return u, nil
+}
+
+func (s *Server) listUsers(ctx context.Context, tenant string, r *http.Request) (ListResponse, error) {
+	users, err := s.repo.ListUsers(ctx, tenant)
+	if err != nil {
+		return ListResponse{}, err
+	}
+	groups, err := s.repo.ListGroups(ctx, tenant)
+	if err != nil {
+		return ListResponse{}, err
+	}
+	memberOf := userGroups(groups)
+	resources := make([]interface{}, 0, len(users))
+	for _, u := range users {
+		u.Groups = memberOf[u.ID]
+		resources = append(resources, u)
+	}
+	return page(r, resources)
+}
+
+func userGroups(groups []Group) map[string][]Member {
+	memberOf := map[string][]Member{}
+	for _, g := range groups {
+		for _, m := range g.Members {
+			memberOf[m.Value] = append(memberOf[m.Value], Member{Value: g.ID, Display: g.DisplayName, Ref: g.Meta.Location})
+		}
+	}
+	return memberOf
+}
+
+func (s *Server) replaceUser(ctx context.Context, tenant, id string, body []byte) (User, error) {
+	old, err := s.repo.GetUser(ctx, tenant, id)
+	if err != nil {
+		return old, err
+	}
+	u, err := decodeUser(body)
+	if err != nil {
+		return u, err
+	}
+	return s.saveUser(ctx, tenant, old, u)
+}
+
+func (s *Server) patchUser(ctx context.Context, tenant, id string, body []byte) (User, error) {
+	old, err := s.repo.GetUser(ctx, tenant, id)
+	if err != nil {
+		return old, err
+	}
+	doc, err := patchDoc(old, body)
+	if err != nil {
+		return old, err
+	}
+	// Azure sends active as "False" ..
+	if v, ok := doc[keyFor(doc, "active")].(string); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return old, badRequest("invalidValue", "active must be a boolean")
+		}
+		doc[keyFor(doc, "active")] = b
+	}
+	b, _ := json.Marshal(doc)
+	u, err := decodeUser(b)
+	if err != nil {
+		return u, err
+	}
+	return s.saveUser(ctx, tenant, old, u)
+}
+
+func patchDoc(v interface{}, body []byte) (map[string]interface{}, error) {
+	var patch PatchOp
+	if err := json.Unmarshal(body, &patch); err != nil {
+		return nil, badRequest("invalidSyntax", "bad patch: %v", err)
+	}
+	doc := toMap(v)
+	if err := applyPatch(doc, patch.Operations); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// saveUser keeps server owned fields and turns active flips into access changes ..
+func (s *Server) saveUser(ctx context.Context, tenant string, old, u User) (User, error) {
+	u.ID, u.Meta = old.ID, old.Meta
+	u.Meta.LastModified = s.now().UTC()
+	// Tuples are keyed on it; a rename would strand them ..
+	if u.TupleUser() != old.TupleUser() {
+		return old, badRequest("mutability", "userName can not change")
+	}
+	switch {
+	case old.Active && !u.Active:
+		if err := s.prov.Deprovision(ctx, tenant, old.TupleUser()); err != nil {
+			return old, err
+		}
+	case !old.Active && u.Active:
+		groups, err := s.repo.ListGroups(ctx, tenant)
+		if err != nil {
+			return old, err
+		}
+		for _, g := range userGroups(groups)[u.ID] {
+			if err := s.prov.MembershipChanged(ctx, tenant, g.Display, []string{u.TupleUser()}, nil); err != nil {
+				return old, err
+			}
+		}
+	}
+	if err := s.repo.ReplaceUser(ctx, tenant, u); err != nil {
+		return old, err
+	}
+	return s.getUser(ctx, tenant, u.ID)
+}
+
+// deleteUser takes them out of every group first so nothing dangles ..
+func (s *Server) deleteUser(ctx context.Context, tenant, id string) error {
+	u, err := s.repo.GetUser(ctx, tenant, id)
+	if err != nil {
+		return err
+	}
+	if u.Active {
+		if err := s.prov.Deprovision(ctx, tenant, u.TupleUser()); err != nil {
+			return err
+		}
+	}
+	groups, err := s.repo.ListGroups(ctx, tenant)
+	if err != nil {
+		return err
+	}
+	for _, g := range groups {
+		kept := g.Members[:0]
+		for _, m := range g.Members {
+			if m.Value != id {
+				kept = append(kept, m)
+			}
+		}
+		if len(kept) != len(g.Members) {
+			g.Members = kept
+			if err := s.repo.ReplaceGroup(ctx, tenant, g); err != nil {
+				return err
+			}
+		}
+	}
+	return s.repo.DeleteUser(ctx, tenant, id)
+}
+
+// decodeGroup checks every member is one of the tenant's users; nested
+// groups are not supported ..
+func (s *Server) decodeGroup(ctx context.Context, tenant string, body []byte) (Group, map[string]User, error) {
+	var g Group
+	if err := json.Unmarshal(body, &g); err != nil {
+		return g, nil, badRequest("invalidSyntax", "bad group: %v", err)
+	}
+	return s.checkGroup(ctx, tenant, g)
+}
+
+func (s *Server) checkGroup(ctx context.Context, tenant string, g Group) (Group, map[string]User, error) {
+	if g.DisplayName == "" {
+		return g, nil, badRequest("invalidValue", "displayName is required")
+	}
+	g.Schemas = []string{SchemaGroup}
+	users, err := s.repo.ListUsers(ctx, tenant)
+	if err != nil {
+		return g, nil, err
+	}
+	byID := map[string]User{}
+	for _, u := range users {
+		byID[u.ID] = u
+	}
+	seen := map[string]bool{}
+	members := make([]Member, 0, len(g.Members))
+	for _, m := range g.Members {
+		u, ok := byID[m.Value]
+		if !ok {
+			return g, nil, badRequest("invalidValue", "member %q is not a user", m.Value)
+		}
+		if seen[m.Value] {
+			continue
+		}
+		seen[m.Value] = true
+		members = append(members, Member{Value: u.ID, Display: u.UserName, Ref: u.Meta.Location})
+	}
+	g.Members = members
+	return g, byID, nil
+}
+
+// nameTaken is checked up front; the repository would only catch it after
+// the tuples were already written ..
+func (s *Server) nameTaken(ctx context.Context, tenant, id, name string) error {
+	groups, err := s.repo.ListGroups(ctx, tenant)
+	if err != nil {
+		return err
+	}
+	for _, g := range groups {
+		if g.ID != id && strings.EqualFold(g.DisplayName, name) {
+			return ErrConflict
+		}
+	}
+	return nil
+}
+
+// activeMembers are the tuple users of members that can hold access ..
+func activeMembers(members []Member, users map[string]User) []string {
+	out := []string{}
+	for _, m := range members {
+		if u, ok := users[m.Value]; ok && u.Active {
+			out = append(out, u.TupleUser())
+		}
+	}
+	return out
+}
+
+func (s *Server) createGroup(ctx context.Context, tenant string, body []byte) (Group, error) {
+	g, users, err := s.decodeGroup(ctx, tenant, body)
+	if err != nil {
+		return g, err
+	}
+	if err := s.nameTaken(ctx, tenant, "", g.DisplayName); err != nil {
+		return g, err
+	}
+	now := s.now().UTC()
+	g.ID = uuid.NewString()
+	g.Meta = Meta{ResourceType: "Group", Created: now, LastModified: now, Location: s.location("Groups", g.ID)}
+	if added := activeMembers(g.Members, users); len(added) > 0 {
+		if err := s.prov.MembershipChanged(ctx, tenant, g.DisplayName, added, nil); err != nil {
+			return g, err
+		}
+	}
+	if err := s.repo.CreateGroup(ctx, tenant, g); err != nil {
+		return g, err
+	}
+	return g, nil
+}
+
+func (s *Server) getGroup(ctx context.Context, tenant, id, excluded string) (Group, error) {
+	g, err := s.repo.GetGroup(ctx, tenant, id)
+	if err == nil && strings.Contains(strings.ToLower(excluded), "members") {
+		g.Members = nil
+	}
+	return g, err
+}
+
+func (s *Server) listGroups(ctx context.Context, tenant string, r *http.Request) (ListResponse, error) {
+	groups, err := s.repo.ListGroups(ctx, tenant)
+	if err != nil {
+		return ListResponse{}, err
+	}
+	// Azure asks for groups without members; big groups are big ..
+	noMembers := strings.Contains(strings.ToLower(r.URL.Query().Get("excludedAttributes")), "members")
+	resources := make([]interface{}, 0, len(groups))
+	for _, g := range groups {
+		resources = append(resources, g)
+	}
+	list, err := page(r, resources)
+	if noMembers {
+		for i, res := range list.Resources {
+			g := res.(Group)
+			g.Members = nil
+			list.Resources[i] = g
+		}
+	}
+	return list, err
+}
+
+func (s *Server) replaceGroup(ctx context.Context, tenant, id string, body []byte) (Group, error) {
+	old, err := s.repo.GetGroup(ctx, tenant, id)
+	if err != nil {
+		return old, err
+	}
+	g, users, err := s.decodeGroup(ctx, tenant, body)
+	if err != nil {
+		return old, err
+	}
+	return s.saveGroup(ctx, tenant, old, g, users)
+}
+
+func (s *Server) patchGroup(ctx context.Context, tenant, id string, body []byte) (Group, error) {
+	old, err := s.repo.GetGroup(ctx, tenant, id)
+	if err != nil {
+		return old, err
+	}
+	doc, err := patchDoc(old, body)
+	if err != nil {
+		return old, err
+	}
+	b, _ := json.Marshal(doc)
+	g, users, err := s.decodeGroup(ctx, tenant, b)
+	if err != nil {
+		return old, err
+	}
+	return s.saveGroup(ctx, tenant, old, g, users)
+}
+
+// saveGroup diffs members (or moves all of them on a rename) ..
+func (s *Server) saveGroup(ctx context.Context, tenant string, old, g Group, users map[string]User) (Group, error) {
+	g.ID, g.Meta = old.ID, old.Meta
+	g.Meta.LastModified = s.now().UTC()
+	if g.DisplayName != old.DisplayName {
+		if err := s.nameTaken(ctx, tenant, g.ID, g.DisplayName); err != nil {
+			return old, err
+		}
+		if removed := activeMembers(old.Members, users); len(removed) > 0 {
+			if err := s.prov.MembershipChanged(ctx, tenant, old.DisplayName, nil, removed); err != nil {
+				return old, err
+			}
+		}
+		if added := activeMembers(g.Members, users); len(added) > 0 {
+			if err := s.prov.MembershipChanged(ctx, tenant, g.DisplayName, added, nil); err != nil {
+				return old, err
+			}
+		}
+	} else {
+		before, after := map[string]bool{}, map[string]bool{}
+		for _, m := range old.Members {
+			before[m.Value] = true
+		}
+		for _, m := range g.Members {
+			after[m.Value] = true
+		}
+		var added, removed []Member
+		for _, m := range g.Members {
+			if !before[m.Value] {
+				added = append(added, m)
+			}
+		}
+		for _, m := range old.Members {
+			if !after[m.Value] {
+				removed = append(removed, m)
+			}
+		}
+		addedUsers, removedUsers := activeMembers(added, users), activeMembers(removed, users)
+		if len(addedUsers) > 0 || len(removedUsers) > 0 {
+			if err := s.prov.MembershipChanged(ctx, tenant, g.DisplayName, addedUsers, removedUsers); err != nil {
+				return old, err
+			}
+		}
+	}
+	if err := s.repo.ReplaceGroup(ctx, tenant, g); err != nil {
+		return old, err
+	}
+	return g, nil
+}
+
+func (s *Server) deleteGroup(ctx context.Context, tenant, id string) error {
+	g, err := s.repo.GetGroup(ctx, tenant, id)
+	if err != nil {
+		return err
+	}
+	_, users, err := s.checkGroup(ctx, tenant, g)
+	if err != nil {
+		return err
+	}
+	if removed := activeMembers(g.Members, users); len(removed) > 0 {
+		if err := s.prov.MembershipChanged(ctx, tenant, g.DisplayName, nil, removed); err != nil {
+			return err
+		}
+	}
+	return s.repo.DeleteGroup(ctx, tenant, id)
+}
+
+// BulkRequest runs operations in order; bulkId:<x> in a later path or body
+// is swapped for the ID the earlier POST created ..
+type BulkRequest struct {
+	Schemas      []string        `json:"schemas"`
+	FailOnErrors int             `json:"failOnErrors,omitempty"`
+	Operations   []BulkOperation `json:"Operations"`
+}
+
+type BulkOperation struct {
+	Method string          `json:"method"`
+	BulkID string          `json:"bulkId,omitempty"`
+	Path   string          `json:"path"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+type BulkResult struct {
+	Method   string          `json:"method"`
+	BulkID   string          `json:"bulkId,omitempty"`
+	Location string          `json:"location,omitempty"`
+	Status   string          `json:"status"`
+	Response json.RawMessage `json:"response,omitempty"`
+}
+
+type BulkResponse struct {
+	Schemas    []string     `json:"schemas"`
+	Operations []BulkResult `json:"Operations"`
+}
+
+func (s *Server) bulk(w http.ResponseWriter, r *http.Request, tenant string) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBulkPayload))
+	if err != nil {
+		writeError(w, &Error{Status: http.StatusRequestEntityTooLarge, Detail: fmt.Sprintf("bulk payload over %d bytes", maxBulkPayload)})
+		return
+	}
+	var req BulkRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, badRequest("invalidSyntax", "bad bulk request: %v", err))
+		return
+	}
+	if len(req.Operations) > maxBulkOperations {
+		writeError(w, &Error{Status: http.StatusRequestEntityTooLarge, Detail: fmt.Sprintf("more than %d operations", maxBulkOperations)})
+		return
+	}
+	resp := BulkResponse{Schemas: []string{SchemaBulkResponse}, Operations: []BulkResult{}}
+	created := map[string]string{}
+	failures := 0
+	for _, op := range req.Operations {
+		path, data := op.Path, string(op.Data)
+		for bulkID, id := range created {
+			path = strings.ReplaceAll(path, "bulkId:"+bulkID, id)
+			data = strings.ReplaceAll(data, "bulkId:"+bulkID, id)
+		}
+		result := BulkResult{Method: op.Method, BulkID: op.BulkID}
+		rec := &bulkRecorder{header: http.Header{}}
+		sub, err := http.NewRequestWithContext(r.Context(), strings.ToUpper(op.Method), path, strings.NewReader(data))
+		if err != nil || strings.Contains(path, "bulkId:") {
+			writeError(rec, badRequest("invalidValue", "bad path %q", op.Path))
+		} else {
+			s.serve(rec, sub, tenant)
+		}
+		result.Status = strconv.Itoa(rec.status)
+		result.Location = rec.header.Get("Location")
+		if rec.status >= 400 {
+			result.Response = rec.body.Bytes()
+			failures++
+		} else if op.BulkID != "" && rec.status == http.StatusCreated {
+			var res struct{ ID string }
+			if json.Unmarshal(rec.body.Bytes(), &res) == nil {
+				created[op.BulkID] = res.ID
+			}
+		}
+		resp.Operations = append(resp.Operations, result)
+		if req.FailOnErrors > 0 && failures >= req.FailOnErrors {
+			break
+		}
+	}
+	writeSCIM(w, http.StatusOK, resp)
+}
+
+// bulkRecorder catches one operation's response ..
+type bulkRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bulkRecorder) Header() http.Header { return b.header }
+
+func (b *bulkRecorder) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (b *bulkRecorder) WriteHeader(status int) { b.status = status }
@@ -0,0 +1,211 @@
+package scim
+
+import (
+	"app/internal/identity"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// recordingProvisioner notes what would have happened to the tuples ..
+type recordingProvisioner struct {
+	calls []string
+}
+
+func (p *recordingProvisioner) MembershipChanged(ctx context.Context, tenant, group string, added, removed []string) error {
+	p.calls = append(p.calls, tenant+" "+group+" +"+strings.Join(added, ",")+" -"+strings.Join(removed, ","))
+	return nil
+}
+
+func (p *recordingProvisioner) Deprovision(ctx context.Context, tenant, user string) error {
+	p.calls = append(p.calls, tenant+" deprovision "+user)
+	return nil
+}
+
+func (p *recordingProvisioner) take() []string {
+	calls := p.calls
+	p.calls = nil
+	return calls
+}
+
+func newTestServer() (*Server, *recordingProvisioner) {
+	prov := &recordingProvisioner{}
+	return NewServer(NewMemoryRepository(), prov, "http://localhost:8888/scim/v2"), prov
+}
+
+func scimDo(t *testing.T, srv *Server, tenant, method, path, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/scim+json")
+	if tenant != "" {
+		r = r.WithContext(identity.WithPrincipal(r.Context(), identity.Principal{TenantID: tenant, ID: "service:k1", Kind: "service"}))
+	}
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, r)
+	out := map[string]interface{}{}
+	if rec.Body.Len() > 0 {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out), rec.Body.String())
+	}
+	return rec, out
+}
+
+func createUser(t *testing.T, srv *Server, userName string) string {
+	t.Helper()
+	rec, out := scimDo(t, srv, "acme", http.MethodPost, "/Users",
+		`{"schemas":["`+SchemaUser+`"],"userName":"`+userName+`","emails":[{"value":"`+userName+`","type":"work","primary":true}]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	return out["id"].(string)
+}
+
+func TestSCIMUsers(t *testing.T) {
+	srv, prov := newTestServer()
+	alice := createUser(t, srv, "alice@acme.example")
+	createUser(t, srv, "bob@acme.example")
+
+	rec, out := scimDo(t, srv, "acme", http.MethodGet, "/Users/"+alice, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/scim+json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, true, out["active"])
+	assert.Equal(t, "http://localhost:8888/scim/v2/Users/"+alice, out["meta"].(map[string]interface{})["location"])
+
+	rec, _ = scimDo(t, srv, "acme", http.MethodPost, "/Users", `{"userName":"ALICE@acme.example"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	// A userName that would read as a typed FGA subject is refused ..
+	rec, out = scimDo(t, srv, "acme", http.MethodPost, "/Users", `{"userName":"service:k1"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "invalidValue", out["scimType"])
+
+	rec, out = scimDo(t, srv, "acme", http.MethodGet, `/Users?filter=userName+eq+%22bob%40acme.example%22`, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, float64(1), out["totalResults"])
+
+	rec, out = scimDo(t, srv, "acme", http.MethodGet, "/Users?startIndex=2&count=5", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, float64(2), out["totalResults"])
+	assert.Equal(t, float64(1), out["itemsPerPage"])
+
+	// Other tenants see nothing ..
+	_, out = scimDo(t, srv, "globex", http.MethodGet, "/Users", "")
+	assert.Equal(t, float64(0), out["totalResults"])
+	rec, _ = scimDo(t, srv, "globex", http.MethodGet, "/Users/"+alice, "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Tuples are keyed on it ..
+	rec, out = scimDo(t, srv, "acme", http.MethodPut, "/Users/"+alice, `{"userName":"alicia@acme.example"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "mutability", out["scimType"])
+
+	rec, out = scimDo(t, srv, "acme", http.MethodPatch, "/Users/"+alice, `{"schemas":["`+SchemaPatchOp+`"],"Operations":[
+		{"op":"Replace","path":"name.givenName","value":"Alice"},
+		{"op":"Add","path":"emails[type eq \"home\"].value","value":"al@home.example"}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "Alice", out["name"].(map[string]interface{})["givenName"])
+	assert.Len(t, out["emails"], 2)
+	assert.Empty(t, prov.take())
+
+	rec, _ = scimDo(t, srv, "", http.MethodGet, "/Users", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestSCIMGroupMembershipDrivesTuples(t *testing.T) {
+	srv, prov := newTestServer()
+	alice := createUser(t, srv, "alice@acme.example")
+	bob := createUser(t, srv, "bob@acme.example")
+
+	rec, out := scimDo(t, srv, "acme", http.MethodPost, "/Groups",
+		`{"schemas":["`+SchemaGroup+`"],"displayName":"Finance","members":[{"value":"`+alice+`"}]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	group := out["id"].(string)
+	assert.Equal(t, []string{"acme Finance +alice -"}, prov.take())
+
+	// Azure style ..
+	rec, _ = scimDo(t, srv, "acme", http.MethodPatch, "/Groups/"+group, `{"schemas":["`+SchemaPatchOp+`"],"Operations":[
+		{"op":"Add","path":"members","value":[{"value":"`+bob+`"}]}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, []string{"acme Finance +bob -"}, prov.take())
+
+	// Okta style ..
+	rec, _ = scimDo(t, srv, "acme", http.MethodPatch, "/Groups/"+group, `{"schemas":["`+SchemaPatchOp+`"],"Operations":[
+		{"op":"remove","path":"members[value eq \"`+alice+`\"]"}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, []string{"acme Finance + -alice"}, prov.take())
+
+	rec, _ = scimDo(t, srv, "acme", http.MethodPatch, "/Groups/"+group, `{"schemas":["`+SchemaPatchOp+`"],"Operations":[
+		{"op":"add","path":"members","value":[{"value":"nobody"}]}]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Empty(t, prov.take())
+
+	_, out = scimDo(t, srv, "acme", http.MethodGet, "/Users/"+bob, "")
+	if assert.Len(t, out["groups"], 1) {
+		assert.Equal(t, "Finance", out["groups"].([]interface{})[0].(map[string]interface{})["display"])
+	}
+
+	// Rename moves everyone ..
+	rec, _ = scimDo(t, srv, "acme", http.MethodPatch, "/Groups/"+group, `{"schemas":["`+SchemaPatchOp+`"],"Operations":[
+		{"op":"replace","value":{"displayName":"Treasury"}}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, []string{"acme Finance + -bob", "acme Treasury +bob -"}, prov.take())
+
+	_, out = scimDo(t, srv, "acme", http.MethodGet, "/Groups?excludedAttributes=members", "")
+	assert.NotContains(t, out["Resources"].([]interface{})[0], "members")
+
+	rec, _ = scimDo(t, srv, "acme", http.MethodDelete, "/Groups/"+group, "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, []string{"acme Treasury + -bob"}, prov.take())
+}
+
+func TestSCIMDeactivateDeprovisions(t *testing.T) {
+	srv, prov := newTestServer()
+	alice := createUser(t, srv, "alice@acme.example")
+	scimDo(t, srv, "acme", http.MethodPost, "/Groups", `{"displayName":"Admins","members":[{"value":"`+alice+`"}]}`)
+	prov.take()
+
+	// Azure sends the boolean as a string ..
+	rec, out := scimDo(t, srv, "acme", http.MethodPatch, "/Users/"+alice, `{"schemas":["`+SchemaPatchOp+`"],"Operations":[
+		{"op":"Replace","path":"active","value":"False"}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, false, out["active"])
+	assert.Equal(t, []string{"acme deprovision alice"}, prov.take())
+
+	// Back again; group tuples come back with them ..
+	rec, _ = scimDo(t, srv, "acme", http.MethodPatch, "/Users/"+alice, `{"schemas":["`+SchemaPatchOp+`"],"Operations":[
+		{"op":"replace","value":{"active":true}}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, []string{"acme Admins +alice -"}, prov.take())
+
+	rec, _ = scimDo(t, srv, "acme", http.MethodDelete, "/Users/"+alice, "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, []string{"acme deprovision alice"}, prov.take())
+	_, out = scimDo(t, srv, "acme", http.MethodGet, "/Groups", "")
+	assert.NotContains(t, out["Resources"].([]interface{})[0], "members")
+}
+
+func TestSCIMBulk(t *testing.T) {
+	srv, prov := newTestServer()
+	rec, out := scimDo(t, srv, "acme", http.MethodPost, "/Bulk", `{"schemas":["`+SchemaBulkRequest+`"],"failOnErrors":1,"Operations":[
+		{"method":"POST","path":"/Users","bulkId":"u1","data":{"userName":"carol@acme.example"}},
+		{"method":"POST","path":"/Groups","bulkId":"g1","data":{"displayName":"Ops","members":[{"value":"bulkId:u1"}]}},
+		{"method":"PATCH","path":"/Users/bulkId:u1","data":{"Operations":[{"op":"replace","path":"displayName","value":"Carol"}]}},
+		{"method":"POST","path":"/Users","data":{"userName":"carol@acme.example"}},
+		{"method":"DELETE","path":"/Groups/bulkId:g1"}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	ops := out["Operations"].([]interface{})
+	// Stopped at the first failure ..
+	require.Len(t, ops, 4)
+	statuses := []string{}
+	for _, op := range ops {
+		statuses = append(statuses, op.(map[string]interface{})["status"].(string))
+	}
+	assert.Equal(t, []string{"201", "201", "200", "409"}, statuses)
+	assert.Contains(t, ops[1].(map[string]interface{})["location"], "/Groups/")
+	assert.Equal(t, []string{"acme Ops +carol -"}, prov.take())
+
+	rec, _ = scimDo(t, srv, "acme", http.MethodGet, "/Bulk", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}